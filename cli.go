@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/Kaese72/device-store/internal/archive"
+	"github.com/Kaese72/device-store/internal/config"
+	"github.com/Kaese72/device-store/internal/persistence/mariadb"
+)
+
+// runCommand runs a one-off subcommand instead of the API server and returns the exit code.
+// Subcommands only require the database configuration.
+func runCommand(args []string) int {
+	if err := config.Loaded.Database.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	var err error
+	switch args[0] {
+	case "export":
+		err = exportCommand(args[1:])
+	case "import":
+		err = importCommand(args[1:])
+	default:
+		err = fmt.Errorf("unknown command %q, expected one of export, import", args[0])
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	return 0
+}
+
+func exportCommand(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := flags.String("format", archive.FormatJSON, "archive format, json or ndjson")
+	includeAudits := flags.Bool("include-audits", false, "include attribute and capability trigger audits")
+	output := flags.String("o", "-", "file to write the archive to, - for stdout")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	dbPersistence, err := mariadb.NewMariadbPersistence(config.Loaded.Database)
+	if err != nil {
+		return err
+	}
+	storeArchive, err := dbPersistence.ExportArchive(context.Background(), *includeAudits)
+	if err != nil {
+		return err
+	}
+	var writer io.Writer = os.Stdout
+	if *output != "-" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		writer = file
+	}
+	return archive.Encode(writer, storeArchive, *format)
+}
+
+func importCommand(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "report what the import would do without changing anything")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: import [-dry-run] <archive file, - for stdin>")
+	}
+	var reader io.Reader = os.Stdin
+	if flags.Arg(0) != "-" {
+		file, err := os.Open(flags.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		reader = file
+	}
+	storeArchive, err := archive.Decode(reader)
+	if err != nil {
+		return err
+	}
+	dbPersistence, err := mariadb.NewMariadbPersistence(config.Loaded.Database)
+	if err != nil {
+		return err
+	}
+	report, err := dbPersistence.ImportArchive(context.Background(), storeArchive, *dryRun)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
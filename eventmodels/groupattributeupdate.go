@@ -0,0 +1,26 @@
+package eventmodels
+
+import "time"
+
+type AggregatedAttribute struct {
+	Name     string    `json:"name"`
+	Members  int       `json:"members"`
+	Any      *bool     `json:"any,omitempty"`
+	All      *bool     `json:"all,omitempty"`
+	Min      *float32  `json:"min,omitempty"`
+	Max      *float32  `json:"max,omitempty"`
+	Avg      *float32  `json:"avg,omitempty"`
+	Majority *string   `json:"majority,omitempty"`
+	Updated  time.Time `json:"updated"`
+}
+
+// GroupAttributeUpdate is sent when attributes of a member device change, or when
+// members join, leave, are deleted or are restored, with the new aggregated values
+// of the affected group attributes. Attributes no member has any longer are sent
+// with zero members.
+type GroupAttributeUpdate struct {
+	GroupID    int                   `json:"group-id"`
+	Attributes []AggregatedAttribute `json:"attributes"`
+}
+
+func (GroupAttributeUpdate) EventName() string { return "group-attribute-update" }
@@ -0,0 +1,134 @@
+package eventmodels
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// StoreEvent is a change to the devices and groups known by the store, to the aggregated
+// state of a group, or the outcome of a capability trigger running in the background. EventName identifies the kind of event, both as the AMQP message type
+// and as the SSE event name.
+type StoreEvent interface {
+	EventName() string
+}
+
+type DeviceCreated struct {
+	DeviceID         int    `json:"device-id"`
+	AdapterID        int    `json:"adapter-id"`
+	BridgeIdentifier string `json:"bridge-identifier"`
+}
+
+func (DeviceCreated) EventName() string { return "device-created" }
+
+type DeviceDeleted struct {
+	DeviceID int `json:"device-id"`
+}
+
+func (DeviceDeleted) EventName() string { return "device-deleted" }
+
+type DeviceRestored struct {
+	DeviceID int `json:"device-id"`
+}
+
+func (DeviceRestored) EventName() string { return "device-restored" }
+
+type DeviceCapabilitiesAdded struct {
+	DeviceID     int      `json:"device-id"`
+	Capabilities []string `json:"capabilities"`
+}
+
+func (DeviceCapabilitiesAdded) EventName() string { return "device-capabilities-added" }
+
+type GroupCreated struct {
+	GroupID          int    `json:"group-id"`
+	AdapterID        int    `json:"adapter-id"`
+	BridgeIdentifier string `json:"bridge-identifier"`
+	Name             string `json:"name"`
+}
+
+func (GroupCreated) EventName() string { return "group-created" }
+
+type GroupDeleted struct {
+	GroupID int `json:"group-id"`
+}
+
+func (GroupDeleted) EventName() string { return "group-deleted" }
+
+type GroupRestored struct {
+	GroupID int `json:"group-id"`
+}
+
+func (GroupRestored) EventName() string { return "group-restored" }
+
+type GroupCapabilitiesAdded struct {
+	GroupID      int      `json:"group-id"`
+	Capabilities []string `json:"capabilities"`
+}
+
+func (GroupCapabilitiesAdded) EventName() string { return "group-capabilities-added" }
+
+type GroupMembershipChanged struct {
+	GroupID          int   `json:"group-id"`
+	AddedDeviceIDs   []int `json:"added-device-ids"`
+	RemovedDeviceIDs []int `json:"removed-device-ids"`
+	AddedGroupIDs    []int `json:"added-group-ids,omitempty"`
+	RemovedGroupIDs  []int `json:"removed-group-ids,omitempty"`
+}
+
+func (GroupMembershipChanged) EventName() string { return "group-membership-changed" }
+
+// StoreEvents lists an instance of every store event, keyed by event name
+var StoreEvents = map[string]StoreEvent{
+	DeviceCreated{}.EventName():           DeviceCreated{},
+	DeviceDeleted{}.EventName():           DeviceDeleted{},
+	DeviceRestored{}.EventName():          DeviceRestored{},
+	DeviceCapabilitiesAdded{}.EventName(): DeviceCapabilitiesAdded{},
+	GroupCreated{}.EventName():            GroupCreated{},
+	GroupDeleted{}.EventName():            GroupDeleted{},
+	GroupRestored{}.EventName():           GroupRestored{},
+	GroupCapabilitiesAdded{}.EventName():  GroupCapabilitiesAdded{},
+	GroupMembershipChanged{}.EventName():  GroupMembershipChanged{},
+	GroupAttributeUpdate{}.EventName():    GroupAttributeUpdate{},
+	TriggerJobCompleted{}.EventName():     TriggerJobCompleted{},
+}
+
+// DecodeStoreEvent decodes the JSON body of a store event with the passed name
+func DecodeStoreEvent(name string, body []byte) (StoreEvent, error) {
+	var event StoreEvent
+	var err error
+	switch name {
+	case DeviceCreated{}.EventName():
+		event, err = decodeAs[DeviceCreated](body)
+	case DeviceDeleted{}.EventName():
+		event, err = decodeAs[DeviceDeleted](body)
+	case DeviceRestored{}.EventName():
+		event, err = decodeAs[DeviceRestored](body)
+	case DeviceCapabilitiesAdded{}.EventName():
+		event, err = decodeAs[DeviceCapabilitiesAdded](body)
+	case GroupCreated{}.EventName():
+		event, err = decodeAs[GroupCreated](body)
+	case GroupDeleted{}.EventName():
+		event, err = decodeAs[GroupDeleted](body)
+	case GroupRestored{}.EventName():
+		event, err = decodeAs[GroupRestored](body)
+	case GroupCapabilitiesAdded{}.EventName():
+		event, err = decodeAs[GroupCapabilitiesAdded](body)
+	case GroupMembershipChanged{}.EventName():
+		event, err = decodeAs[GroupMembershipChanged](body)
+	case GroupAttributeUpdate{}.EventName():
+		event, err = decodeAs[GroupAttributeUpdate](body)
+	case TriggerJobCompleted{}.EventName():
+		event, err = decodeAs[TriggerJobCompleted](body)
+	default:
+		return nil, fmt.Errorf("unknown store event %q", name)
+	}
+	return event, err
+}
+
+func decodeAs[T StoreEvent](body []byte) (StoreEvent, error) {
+	var event T
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, err
+	}
+	return event, nil
+}
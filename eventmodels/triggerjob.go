@@ -0,0 +1,14 @@
+package eventmodels
+
+// TriggerJobCompleted is published when a capability trigger running in the background has finished
+type TriggerJobCompleted struct {
+	JobID        int     `json:"job-id"`
+	DeviceID     *int    `json:"device-id,omitempty"`
+	GroupID      *int    `json:"group-id,omitempty"`
+	Name         string  `json:"name"`
+	Status       string  `json:"status"`
+	ErrorMessage *string `json:"error-message,omitempty"`
+	AuditID      *int    `json:"audit-id,omitempty"`
+}
+
+func (TriggerJobCompleted) EventName() string { return "trigger-job-completed" }
@@ -1,6 +1,10 @@
 package ingestmodels
 
-import "github.com/danielgtaylor/huma/v2"
+import (
+	"slices"
+
+	"github.com/danielgtaylor/huma/v2"
+)
 
 type IngestBooleanArgumentSpec struct {
 	Default *bool `json:"default,omitempty"`
@@ -18,29 +22,93 @@ type IngestTextArgumentSpec struct {
 	Max     *int    `json:"max,omitempty"`
 }
 
-type IngestArgumentSpec struct {
-	Name    string                     `json:"name"`
-	Boolean *IngestBooleanArgumentSpec `json:"boolean,omitempty"`
-	Numeric *IngestNumericArgumentSpec `json:"numeric,omitempty"`
-	Text    *IngestTextArgumentSpec    `json:"text,omitempty"`
+// IngestEnumArgumentSpec is a text argument limited to a set of values
+type IngestEnumArgumentSpec struct {
+	Values  []string `json:"values" minItems:"1"`
+	Default *string  `json:"default,omitempty"`
 }
 
-func (i IngestArgumentSpec) Resolve(ctx huma.Context, prefix *huma.PathBuffer) []error {
-	// Only one of Boolean, Numeric, or Text may be set
+// IngestIntegerArgumentSpec is a whole number argument, optionally limited to multiples of Step
+type IngestIntegerArgumentSpec struct {
+	Min     *int `json:"min,omitempty"`
+	Max     *int `json:"max,omitempty"`
+	Step    *int `json:"step,omitempty" minimum:"1"`
+	Default *int `json:"default,omitempty"`
+}
+
+// IngestColorArgumentSpec is a color argument written as a hex string, "#rrggbb"
+type IngestColorArgumentSpec struct {
+	Default *string `json:"default,omitempty" pattern:"^#[0-9a-fA-F]{6}$"`
+}
+
+// IngestDurationArgumentSpec is a duration argument, such as a transition time, in milliseconds
+type IngestDurationArgumentSpec struct {
+	Min     *int `json:"min,omitempty" minimum:"0"`
+	Max     *int `json:"max,omitempty"`
+	Default *int `json:"default,omitempty" minimum:"0"`
+}
+
+// IngestArrayArgumentSpec is a list argument whose items are all of the same kind
+type IngestArrayArgumentSpec struct {
+	Items    IngestArgumentKind `json:"items"`
+	MinItems *int               `json:"min-items,omitempty" minimum:"0"`
+	MaxItems *int               `json:"max-items,omitempty" minimum:"0"`
+}
+
+// IngestArgumentKind describes the kind of value of an argument. Exactly one of the kinds must be set.
+type IngestArgumentKind struct {
+	Boolean  *IngestBooleanArgumentSpec  `json:"boolean,omitempty"`
+	Numeric  *IngestNumericArgumentSpec  `json:"numeric,omitempty"`
+	Text     *IngestTextArgumentSpec     `json:"text,omitempty"`
+	Enum     *IngestEnumArgumentSpec     `json:"enum,omitempty"`
+	Integer  *IngestIntegerArgumentSpec  `json:"integer,omitempty"`
+	Color    *IngestColorArgumentSpec    `json:"color,omitempty"`
+	Duration *IngestDurationArgumentSpec `json:"duration,omitempty"`
+	Array    *IngestArrayArgumentSpec    `json:"array,omitempty"`
+}
+
+// validate returns what is wrong with the kind, including the kind of the items of arrays
+func (i IngestArgumentKind) validate(prefix *huma.PathBuffer) []error {
+	set := []bool{i.Boolean != nil, i.Numeric != nil, i.Text != nil, i.Enum != nil, i.Integer != nil, i.Color != nil, i.Duration != nil, i.Array != nil}
 	count := 0
-	if i.Boolean != nil {
-		count++
+	for _, isSet := range set {
+		if isSet {
+			count++
+		}
 	}
-	if i.Numeric != nil {
-		count++
+	if count != 1 {
+		return []error{&huma.ErrorDetail{
+			Message:  "Argument spec must have one and only one of boolean, numeric, text, enum, integer, color, duration, or array defined",
+			Location: prefix.String(),
+		}}
 	}
-	if i.Text != nil {
-		count++
+	errs := []error{}
+	if i.Enum != nil && i.Enum.Default != nil && !slices.Contains(i.Enum.Values, *i.Enum.Default) {
+		errs = append(errs, &huma.ErrorDetail{Message: "default must be one of the values", Location: prefix.String() + ".enum.default", Value: *i.Enum.Default})
 	}
-	if count != 1 {
-		return []error{huma.Error400BadRequest("Argument spec must have one and only one of boolean, numeric, or text defined")}
+	if i.Integer != nil && i.Integer.Min != nil && i.Integer.Max != nil && *i.Integer.Min > *i.Integer.Max {
+		errs = append(errs, &huma.ErrorDetail{Message: "min must not be greater than max", Location: prefix.String() + ".integer.min", Value: *i.Integer.Min})
 	}
-	return nil
+	if i.Duration != nil && i.Duration.Min != nil && i.Duration.Max != nil && *i.Duration.Min > *i.Duration.Max {
+		errs = append(errs, &huma.ErrorDetail{Message: "min must not be greater than max", Location: prefix.String() + ".duration.min", Value: *i.Duration.Min})
+	}
+	if i.Array != nil {
+		prefix.Push("array")
+		prefix.Push("items")
+		errs = append(errs, i.Array.Items.validate(prefix)...)
+		prefix.Pop()
+		prefix.Pop()
+	}
+	return errs
+}
+
+type IngestArgumentSpec struct {
+	Name string `json:"name"`
+	IngestArgumentKind
+}
+
+func (i IngestArgumentSpec) Resolve(ctx huma.Context, prefix *huma.PathBuffer) []error {
+	return i.validate(prefix)
 }
 
 // Make sure that we fulfil the correct interface for validation to work
@@ -1,8 +1,46 @@
 package ingestmodels
 
+import "github.com/danielgtaylor/huma/v2"
+
 type IngestDeviceCapability struct {
 	Name          string               `json:"name"`
 	ArgumentSpecs []IngestArgumentSpec `json:"argument-specs"`
+	// ArgumentJSONSchema is a JSON Schema of the arguments, used instead of one generated from ArgumentSpecs
+	ArgumentJSONSchema map[string]any `json:"argument-json-schema,omitempty" doc:"a JSON Schema describing the arguments object, overriding the schema generated from argument-specs"`
+	// ExpectedOutcome is what the device reports once the capability took effect, used to verify triggers
+	ExpectedOutcome []IngestExpectedAttribute `json:"expected-outcome,omitempty" doc:"the attribute states the device reports once the capability took effect, triggers are verified against them"`
 }
 
 type IngestDeviceCapabilityArgs map[string]any
+
+// IngestExpectedAttribute is an attribute state a device reports once a capability took effect. Exactly one of the
+// states and Argument must be set.
+type IngestExpectedAttribute struct {
+	Name    string   `json:"name" minLength:"1"`
+	Boolean *bool    `json:"boolean-state,omitempty"`
+	Numeric *float32 `json:"numeric-state,omitempty"`
+	Text    *string  `json:"string-state,omitempty"`
+	// Argument names the argument of the trigger whose value the attribute takes
+	Argument  *string `json:"argument,omitempty" doc:"the attribute takes the value of this argument of the trigger"`
+	Tolerance float32 `json:"tolerance,omitempty" minimum:"0" doc:"how far a numeric state may be from the expected one"`
+}
+
+func (i IngestExpectedAttribute) Resolve(ctx huma.Context, prefix *huma.PathBuffer) []error {
+	count := 0
+	for _, isSet := range []bool{i.Boolean != nil, i.Numeric != nil, i.Text != nil, i.Argument != nil} {
+		if isSet {
+			count++
+		}
+	}
+	if count != 1 {
+		return []error{&huma.ErrorDetail{
+			Message:  "expected attribute must have one and only one of boolean-state, numeric-state, string-state, or argument defined",
+			Location: prefix.String(),
+			Value:    i,
+		}}
+	}
+	return nil
+}
+
+// Make sure that we fulfil the correct interface for validation to work
+var _ huma.ResolverWithPath = (*IngestExpectedAttribute)(nil)
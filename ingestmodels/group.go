@@ -5,5 +5,8 @@ type IngestGroup struct {
 	AdapterId        int                     `json:"adapter-id" readonly:"true"`
 	BridgeIdentifier string                  `json:"bridge-identifier" required:"true"`
 	Capabilities     []IngestGroupCapability `json:"capabilities" required:"false"`
-	DeviceIds        []int                   `json:"device-ids" required:"false"`
+	// DeviceIds are store device IDs. Prefer DeviceBridgeIdentifiers, which do not require knowing the store IDs.
+	DeviceIds []int `json:"device-ids" required:"false"`
+	// DeviceBridgeIdentifiers are the bridge identifiers of member devices belonging to the same adapter as the group
+	DeviceBridgeIdentifiers []string `json:"device-bridge-identifiers" required:"false"`
 }
@@ -3,6 +3,8 @@ package ingestmodels
 type IngestGroupCapability struct {
 	Name          string               `json:"name"`
 	ArgumentSpecs []IngestArgumentSpec `json:"argument-specs"`
+	// ArgumentJSONSchema is a JSON Schema of the arguments, used instead of one generated from ArgumentSpecs
+	ArgumentJSONSchema map[string]any `json:"argument-json-schema,omitempty" doc:"a JSON Schema describing the arguments object, overriding the schema generated from argument-specs"`
 }
 
 type IngestGroupCapabilityArgs map[string]any
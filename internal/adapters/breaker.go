@@ -0,0 +1,192 @@
+package adapters
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Kaese72/device-store/internal/config"
+	"github.com/Kaese72/device-store/internal/logging"
+	"github.com/Kaese72/device-store/restmodels"
+	"github.com/danielgtaylor/huma/v2"
+)
+
+// Breakers keeps a circuit breaker per adapter, fed with the outcomes of capability triggers. The breaker of an
+// adapter whose recent triggers failed too often opens, failing triggers fast rather than having every one of them
+// wait for the adapter to time out. Once open for a while, a single probing trigger is let through, which closes
+// the breaker again if it succeeds.
+type Breakers struct {
+	conf config.CircuitBreakerConfig
+	now  func() time.Time
+	lock sync.Mutex
+	// breakers is keyed by adapter ID
+	breakers map[int]*breaker
+}
+
+type breaker struct {
+	// failures holds the outcomes of the most recent triggers while closed, oldest first
+	failures []bool
+	// openedAt is zero while the breaker is closed
+	openedAt    time.Time
+	probing     bool
+	lastFailure time.Time
+	lastError   string
+}
+
+func NewBreakers(conf config.CircuitBreakerConfig) *Breakers {
+	return &Breakers{
+		conf:     conf,
+		now:      time.Now,
+		breakers: map[int]*breaker{},
+	}
+}
+
+func (breakers *Breakers) get(adapterId int) *breaker {
+	adapterBreaker, ok := breakers.breakers[adapterId]
+	if !ok {
+		adapterBreaker = &breaker{}
+		breakers.breakers[adapterId] = adapterBreaker
+	}
+	return adapterBreaker
+}
+
+// Allow reports whether a capability may be triggered through an adapter, and whether the trigger probes a half-open
+// breaker. It fails with 503 if the breaker of the adapter is open, or already has a probing trigger under way.
+// The outcome of every allowed trigger must be recorded.
+func (breakers *Breakers) Allow(adapterId int) (bool, error) {
+	if !breakers.conf.Enabled {
+		return false, nil
+	}
+	breakers.lock.Lock()
+	defer breakers.lock.Unlock()
+	adapterBreaker := breakers.get(adapterId)
+	if adapterBreaker.openedAt.IsZero() {
+		return false, nil
+	}
+	retryAt := adapterBreaker.openedAt.Add(breakers.conf.OpenDuration)
+	if now := breakers.now(); now.Before(retryAt) || adapterBreaker.probing {
+		retryAfter := max(1, int(math.Ceil(retryAt.Sub(now).Seconds())))
+		return false, huma.ErrorWithHeaders(
+			huma.Error503ServiceUnavailable(fmt.Sprintf("adapter %d is unavailable, its circuit breaker is open after repeated failures", adapterId)),
+			http.Header{"Retry-After": {strconv.Itoa(retryAfter)}},
+		)
+	}
+	adapterBreaker.probing = true
+	return true, nil
+}
+
+// Record feeds the outcome of a trigger allowed by Allow to the breaker of the adapter
+func (breakers *Breakers) Record(adapterId int, probe bool, err error) {
+	if !breakers.conf.Enabled {
+		return
+	}
+	breakers.lock.Lock()
+	defer breakers.lock.Unlock()
+	adapterBreaker := breakers.get(adapterId)
+	if probe {
+		adapterBreaker.probing = false
+	}
+	if errors.Is(err, context.Canceled) {
+		// Abandoned by the caller, which says nothing about the adapter
+		return
+	}
+	failed := isAdapterFailure(err)
+	if failed {
+		adapterBreaker.lastFailure = breakers.now()
+		adapterBreaker.lastError = err.Error()
+	}
+	if probe {
+		if failed {
+			adapterBreaker.openedAt = breakers.now()
+			logging.Error(fmt.Sprintf("Circuit breaker of adapter %d stays open, probing trigger failed", adapterId), context.Background())
+		} else {
+			adapterBreaker.openedAt = time.Time{}
+			adapterBreaker.failures = nil
+			logging.Info(fmt.Sprintf("Circuit breaker of adapter %d closed", adapterId), context.Background())
+		}
+		return
+	}
+	if !adapterBreaker.openedAt.IsZero() {
+		// Triggered before the breaker opened
+		return
+	}
+	adapterBreaker.failures = append(adapterBreaker.failures, failed)
+	if len(adapterBreaker.failures) > breakers.conf.Window {
+		adapterBreaker.failures = adapterBreaker.failures[len(adapterBreaker.failures)-breakers.conf.Window:]
+	}
+	recent, failures := adapterBreaker.rate()
+	if recent >= breakers.conf.MinimumTriggers && float64(failures)/float64(recent) >= breakers.conf.FailureRate {
+		adapterBreaker.openedAt = breakers.now()
+		logging.Error(fmt.Sprintf("Circuit breaker of adapter %d opened, %d of its last %d triggers failed", adapterId, failures, recent), context.Background())
+	}
+}
+
+// rate returns the number of recent triggers and how many of them failed
+func (adapterBreaker *breaker) rate() (int, int) {
+	failures := 0
+	for _, failed := range adapterBreaker.failures {
+		if failed {
+			failures++
+		}
+	}
+	return len(adapterBreaker.failures), failures
+}
+
+// isAdapterFailure reports whether the outcome of a trigger tells that an adapter is unhealthy. Adapters refusing
+// a trigger as a bad request are doing fine, and triggers given up by their caller say nothing about the adapter.
+func isAdapterFailure(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var statusErr huma.StatusError
+	return !errors.As(err, &statusErr) || statusErr.GetStatus() >= http.StatusInternalServerError
+}
+
+// Health returns the state of the breakers of the given adapters and of every adapter triggered so far, ordered by
+// adapter ID
+func (breakers *Breakers) Health(adapterIds []int) []restmodels.AdapterHealth {
+	breakers.lock.Lock()
+	defer breakers.lock.Unlock()
+	for _, adapterId := range adapterIds {
+		breakers.get(adapterId)
+	}
+	health := []restmodels.AdapterHealth{}
+	for _, adapterId := range slices.Sorted(maps.Keys(breakers.breakers)) {
+		adapterBreaker := breakers.breakers[adapterId]
+		recent, failures := adapterBreaker.rate()
+		adapterHealth := restmodels.AdapterHealth{
+			AdapterID:      adapterId,
+			State:          restmodels.CircuitClosed,
+			RecentTriggers: recent,
+			RecentFailures: failures,
+		}
+		if recent > 0 {
+			adapterHealth.FailureRate = float64(failures) / float64(recent)
+		}
+		if !adapterBreaker.lastFailure.IsZero() {
+			lastFailure := adapterBreaker.lastFailure.UTC()
+			lastError := adapterBreaker.lastError
+			adapterHealth.LastFailure = &lastFailure
+			adapterHealth.LastError = &lastError
+		}
+		if !adapterBreaker.openedAt.IsZero() {
+			openedAt := adapterBreaker.openedAt.UTC()
+			retryAt := openedAt.Add(breakers.conf.OpenDuration)
+			adapterHealth.OpenedAt = &openedAt
+			adapterHealth.RetryAt = &retryAt
+			adapterHealth.State = restmodels.CircuitOpen
+			if !breakers.now().Before(retryAt) {
+				adapterHealth.State = restmodels.CircuitHalfOpen
+			}
+		}
+		health = append(health, adapterHealth)
+	}
+	return health
+}
@@ -0,0 +1,81 @@
+package adapters
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Kaese72/device-store/internal/config"
+	"github.com/Kaese72/device-store/restmodels"
+	"github.com/danielgtaylor/huma/v2"
+)
+
+func TestBreakers(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	breakers := NewBreakers(config.CircuitBreakerConfig{Enabled: true, Window: 4, MinimumTriggers: 3, FailureRate: 0.5, OpenDuration: time.Minute})
+	breakers.now = func() time.Time { return now }
+	adapterDown := errors.New("connection refused")
+
+	trigger := func(err error) error {
+		t.Helper()
+		probe, allowErr := breakers.Allow(7)
+		if allowErr != nil {
+			return allowErr
+		}
+		breakers.Record(7, probe, err)
+		return nil
+	}
+	expectState := func(state string) {
+		t.Helper()
+		health := breakers.Health([]int{3})
+		if len(health) != 2 || health[0].AdapterID != 3 || health[1].AdapterID != 7 {
+			t.Fatalf("expected health of adapters 3 and 7, got %+v", health)
+		}
+		if health[0].State != restmodels.CircuitClosed {
+			t.Errorf("expected untriggered adapter to be closed, got %s", health[0].State)
+		}
+		if health[1].State != state {
+			t.Errorf("expected %s, got %s", state, health[1].State)
+		}
+	}
+
+	// Bad requests, abandoned triggers and too few triggers do not open the breaker
+	for _, err := range []error{nil, adapterDown, huma.Error400BadRequest("bad arguments"), context.Canceled, nil} {
+		if err := trigger(err); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	expectState(restmodels.CircuitClosed)
+	// The window now holds failure, success, success, failure
+	if err := trigger(adapterDown); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expectState(restmodels.CircuitOpen)
+
+	var statusErr huma.StatusError
+	if err := trigger(nil); !errors.As(err, &statusErr) || statusErr.GetStatus() != http.StatusServiceUnavailable {
+		t.Fatalf("expected open breaker to fail fast with 503, got %v", err)
+	}
+
+	// A failing probe keeps the breaker open
+	now = now.Add(time.Minute)
+	expectState(restmodels.CircuitHalfOpen)
+	probe, err := breakers.Allow(7)
+	if err != nil || !probe {
+		t.Fatalf("expected a probing trigger to be allowed, got %v", err)
+	}
+	if _, err := breakers.Allow(7); err == nil {
+		t.Fatalf("expected a single probing trigger at a time")
+	}
+	breakers.Record(7, probe, adapterDown)
+	expectState(restmodels.CircuitOpen)
+
+	// A succeeding probe closes it
+	now = now.Add(time.Minute)
+	if err := trigger(nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expectState(restmodels.CircuitClosed)
+}
@@ -0,0 +1,60 @@
+package adapters
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"slices"
+	"time"
+
+	"github.com/Kaese72/device-store/internal/config"
+)
+
+// RetryPolicy decides which failed attempts at triggering a capability are retried, and how long to wait before
+// retrying them
+type RetryPolicy struct {
+	conf config.AdapterRetryConfig
+}
+
+func NewRetryPolicy(conf config.AdapterRetryConfig) RetryPolicy {
+	return RetryPolicy{conf: conf}
+}
+
+// Backoff returns the wait before a retry, counting the first retry as 1. Waits grow exponentially up to the
+// configured maximum.
+func (policy RetryPolicy) Backoff(retry int) time.Duration {
+	backoff := float64(policy.conf.InitialBackoff) * math.Pow(policy.conf.Multiplier, float64(retry-1))
+	if backoff > float64(policy.conf.MaxBackoff) {
+		return policy.conf.MaxBackoff
+	}
+	return time.Duration(backoff)
+}
+
+// RetryableStatus reports whether an attempt the adapter responded to with status code is retried
+func (policy RetryPolicy) RetryableStatus(statusCode int) bool {
+	return slices.Contains(policy.conf.RetryableStatuses, statusCode)
+}
+
+// RetryableError reports whether an attempt that failed to get a response from the adapter is retried.
+// Attempts are never retried once ctx is done.
+func (policy RetryPolicy) RetryableError(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	return slices.Contains(policy.conf.RetryableErrors, errorKind(err))
+}
+
+// errorKind classifies failures to get a response from an adapter as "timeout" or "connection"
+func errorKind(err error) string {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return "timeout"
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return "connection"
+	}
+	return ""
+}
@@ -0,0 +1,97 @@
+package adapters
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/Kaese72/device-store/internal/config"
+)
+
+func TestBackoff(t *testing.T) {
+	policy := NewRetryPolicy(config.AdapterRetryConfig{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second, Multiplier: 3})
+	expected := []time.Duration{100 * time.Millisecond, 300 * time.Millisecond, 900 * time.Millisecond, time.Second, time.Second}
+	for index, backoff := range expected {
+		if actual := policy.Backoff(index + 1); actual != backoff {
+			t.Errorf("expected retry %d to wait %s, got %s", index+1, backoff, actual)
+		}
+	}
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestErrorKind(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected string
+	}{
+		{name: "Connection refused", err: &url.Error{Op: "Post", URL: "http://adapter", Err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}}, expected: "connection"},
+		{name: "Connection closed", err: &url.Error{Op: "Post", URL: "http://adapter", Err: io.EOF}, expected: "connection"},
+		{name: "Timeout", err: &url.Error{Op: "Post", URL: "http://adapter", Err: timeoutError{}}, expected: "timeout"},
+		{name: "Other", err: errors.New("unsupported protocol scheme"), expected: ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := errorKind(test.err); actual != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestPostRetries(t *testing.T) {
+	tests := []struct {
+		name       string
+		statuses   []int
+		attempts   int
+		expected   int
+		requests   int
+		retryables []int
+	}{
+		{name: "Succeeds after retries", statuses: []int{503, 502, 200}, attempts: 3, expected: 200, requests: 3, retryables: []int{502, 503}},
+		{name: "Runs out of attempts", statuses: []int{503, 503, 503}, attempts: 2, expected: 503, requests: 2, retryables: []int{503}},
+		{name: "Status not retryable", statuses: []int{500, 200}, attempts: 3, expected: 500, requests: 1, retryables: []int{503}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			requests := 0
+			keys := map[string]bool{}
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				keys[r.Header.Get("Idempotency-Key")] = true
+				w.WriteHeader(test.statuses[requests])
+				requests++
+			}))
+			defer server.Close()
+			client := NewAdapterClient(config.AdapterRetryConfig{Attempts: test.attempts, Multiplier: 1, RetryableStatuses: test.retryables})
+			statusCode, _, attempts, err := client.post(context.Background(), server.URL, []byte("{}"))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if statusCode != test.expected {
+				t.Errorf("expected status %d, got %d", test.expected, statusCode)
+			}
+			if requests != test.requests || len(attempts) != test.requests {
+				t.Errorf("expected %d attempts, got %d requests and %d recorded attempts", test.requests, requests, len(attempts))
+			}
+			for index, attempt := range attempts {
+				if attempt.Attempt != index+1 || attempt.StatusCode == nil || *attempt.StatusCode != test.statuses[index] {
+					t.Errorf("unexpected attempt %+v", attempt)
+				}
+			}
+			if len(keys) != 1 {
+				t.Errorf("expected every attempt to carry the same idempotency key, got %v", keys)
+			}
+		})
+	}
+}
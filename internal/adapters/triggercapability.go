@@ -3,12 +3,18 @@ package adapters
 import (
 	"bytes"
 	"context"
+	"crypto/rand"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/Kaese72/device-store/internal/config"
 	"github.com/Kaese72/device-store/internal/logging"
 	"github.com/Kaese72/device-store/restmodels"
 	"github.com/danielgtaylor/huma/v2"
@@ -18,58 +24,165 @@ import (
 
 var tracingClient = apmhttp.WrapClient(http.DefaultClient)
 
-func TriggerDeviceCapability(ctx context.Context, adapterAddress string, bridgeDeviceIdentifier string, capabilityID string, capArg restmodels.DeviceCapabilityArgs) error {
+// AdapterClient triggers capabilities through adapters, retrying failed attempts according to its retry policy.
+// Every attempt is returned so that it can be audited.
+type AdapterClient struct {
+	retry RetryPolicy
+}
+
+func NewAdapterClient(conf config.AdapterRetryConfig) AdapterClient {
+	return AdapterClient{retry: NewRetryPolicy(conf)}
+}
+
+func (client AdapterClient) TriggerDeviceCapability(ctx context.Context, adapterAddress string, bridgeDeviceIdentifier string, capabilityID string, capArg restmodels.DeviceCapabilityArgs) ([]restmodels.TriggerAttempt, error) {
+	return client.trigger(ctx, adapterAddress, fmt.Sprintf("devices/%s/capabilities/%s", bridgeDeviceIdentifier, capabilityID), capArg)
+}
+
+func (client AdapterClient) TriggerGroupCapability(ctx context.Context, adapterAddress string, groupId string, capabilityId string, capArg restmodels.DeviceCapabilityArgs) ([]restmodels.TriggerAttempt, error) {
+	return client.trigger(ctx, adapterAddress, fmt.Sprintf("groups/%s/capabilities/%s", groupId, capabilityId), capArg)
+}
+
+// trigger sends a trigger to the capability at path of an adapter, and maps the outcome to the error to respond
+// with, carrying the message the adapter responded with if any
+func (client AdapterClient) trigger(ctx context.Context, adapterAddress string, path string, capArg restmodels.DeviceCapabilityArgs) ([]restmodels.TriggerAttempt, error) {
 	jsonEncoded, err := json.Marshal(capArg)
 	if err != nil {
-		return huma.Error400BadRequest(err.Error())
+		return nil, huma.Error400BadRequest(err.Error())
 	}
 
 	adapterURL, err := url.Parse(adapterAddress)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	adapterURL.Path = fmt.Sprintf("devices/%s/capabilities/%s", bridgeDeviceIdentifier, capabilityID)
+	adapterURL.Path = path
 	logging.Info("Triggering capability", ctx, map[string]interface{}{"capUri": adapterURL.String()})
-	resp, err := ctxhttp.Post(ctx, tracingClient, adapterURL.String(), "application/json", bytes.NewBuffer(jsonEncoded))
+	statusCode, responseBody, attempts, err := client.post(ctx, adapterURL.String(), jsonEncoded)
 	if err != nil {
-		// FIXME What if there is interesting debug information in the response?
-		// We should log it or incorporate it in the response message or something
-		return err
+		logging.Info("Capability failed to trigger", ctx, map[string]interface{}{"error": err.Error()})
+		return attempts, requestError(err)
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != 200 {
-		logging.Info("Capability failed to trigger", ctx, map[string]interface{}{"rCode": strconv.Itoa(resp.StatusCode)})
-		return huma.Error500InternalServerError(fmt.Sprintf("not HTTP/200, %d", resp.StatusCode))
+	if err := statusError(statusCode, adapterMessage(responseBody)); err != nil {
+		logging.Info("Capability failed to trigger", ctx, map[string]interface{}{"rCode": strconv.Itoa(statusCode), "error": err.Error()})
+		return attempts, err
 	}
-	// It is the callers responsibility to Close the body reader
-	// But there should not be anything of interest here at the moment
-	logging.Info("Capability triggered", ctx, map[string]interface{}{"rCode": strconv.Itoa(resp.StatusCode)})
-	return nil
+	logging.Info("Capability triggered", ctx, map[string]interface{}{"rCode": strconv.Itoa(statusCode)})
+	return attempts, nil
 }
 
-func TriggerGroupCapability(ctx context.Context, adapterAddress string, groupId string, capabilityId string, capArg restmodels.DeviceCapabilityArgs) error {
-	jsonEncoded, err := json.Marshal(capArg)
-	if err != nil {
-		return huma.Error400BadRequest(err.Error())
+// maxResponseBody bounds how much of the response of an adapter is kept for its message
+const maxResponseBody = 4096
+
+// adapterMessage extracts the message of an error response of an adapter, which is either a problem document like
+// the store responds with itself, a JSON object with a message or an error, or plain text
+func adapterMessage(responseBody []byte) string {
+	var problem struct {
+		Title   string `json:"title"`
+		Detail  string `json:"detail"`
+		Message string `json:"message"`
+		Error   string `json:"error"`
+	}
+	if json.Unmarshal(responseBody, &problem) == nil {
+		for _, message := range []string{problem.Detail, problem.Message, problem.Error, problem.Title} {
+			if message != "" {
+				return message
+			}
+		}
+	}
+	return strings.TrimSpace(string(responseBody))
+}
+
+// statusError maps the status an adapter responded to a trigger with to the error to respond with. A trigger the
+// adapter refused was at fault itself and is refused with the same status, unless the adapter refused the store
+// rather than the trigger. Any other status not telling success is the adapter failing.
+func statusError(statusCode int, message string) error {
+	if statusCode >= 200 && statusCode < 300 {
+		return nil
+	}
+	detail := fmt.Sprintf("adapter responded with HTTP/%d", statusCode)
+	if message != "" {
+		detail += ": " + message
+	}
+	switch {
+	case statusCode == http.StatusRequestTimeout || statusCode == http.StatusGatewayTimeout:
+		return huma.Error504GatewayTimeout(detail)
+	case statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden || statusCode == http.StatusProxyAuthRequired:
+		return huma.Error502BadGateway(detail)
+	case statusCode >= 400 && statusCode < 500:
+		return huma.NewError(statusCode, detail)
 	}
+	return huma.Error502BadGateway(detail)
+}
 
-	adapterURL, err := url.Parse(adapterAddress)
-	if err != nil {
+// requestError maps a failure to get a response from an adapter to the error to respond with. Triggers abandoned by
+// the caller are left as they are.
+func requestError(err error) error {
+	if errors.Is(err, context.Canceled) {
 		return err
 	}
+	if errorKind(err) == "timeout" {
+		return huma.Error504GatewayTimeout(fmt.Sprintf("adapter did not respond in time: %s", err.Error()))
+	}
+	return huma.Error502BadGateway(fmt.Sprintf("failed to reach adapter: %s", err.Error()))
+}
 
-	adapterURL.Path = fmt.Sprintf("groups/%s/capabilities/%s", groupId, capabilityId)
-	logging.Info("Triggering capability", ctx, map[string]interface{}{"capUri": adapterURL.String()})
-	resp, err := ctxhttp.Post(ctx, tracingClient, adapterURL.String(), "application/json", bytes.NewBuffer(jsonEncoded))
+// post sends a trigger to an adapter until the adapter responds with a status not worth retrying, the trigger
+// fails in a way not worth retrying, or the attempts run out. Every attempt carries the same Idempotency-Key
+// header so that adapters can recognize retries of a trigger they already performed. It returns the status and
+// body of the last response.
+func (client AdapterClient) post(ctx context.Context, adapterURL string, body []byte) (int, []byte, []restmodels.TriggerAttempt, error) {
+	idempotencyKey := rand.Text()
+	attempts := []restmodels.TriggerAttempt{}
+	for attempt := 1; ; attempt++ {
+		if attempt > 1 {
+			backoff := client.retry.Backoff(attempt - 1)
+			logging.Info(fmt.Sprintf("Retrying capability trigger in %s", backoff), ctx, map[string]interface{}{"capUri": adapterURL, "attempt": strconv.Itoa(attempt)})
+			select {
+			case <-ctx.Done():
+				return 0, nil, attempts, ctx.Err()
+			case <-time.After(backoff):
+			}
+		}
+		record := restmodels.TriggerAttempt{Attempt: attempt, Started: time.Now().UTC()}
+		statusCode, responseBody, err := postOnce(ctx, adapterURL, body, idempotencyKey)
+		lastAttempt := attempt >= client.retry.conf.Attempts
+		if err != nil {
+			errMsg := err.Error()
+			record.ErrorMessage = &errMsg
+			attempts = append(attempts, record)
+			if lastAttempt || !client.retry.RetryableError(ctx, err) {
+				return 0, nil, attempts, err
+			}
+			continue
+		}
+		record.StatusCode = &statusCode
+		if statusCode < 200 || statusCode >= 300 {
+			if message := adapterMessage(responseBody); message != "" {
+				record.ErrorMessage = &message
+			}
+		}
+		attempts = append(attempts, record)
+		if lastAttempt || !client.retry.RetryableStatus(statusCode) || ctx.Err() != nil {
+			return statusCode, responseBody, attempts, nil
+		}
+	}
+}
+
+func postOnce(ctx context.Context, adapterURL string, body []byte, idempotencyKey string) (int, []byte, error) {
+	req, err := http.NewRequest(http.MethodPost, adapterURL, bytes.NewReader(body))
 	if err != nil {
-		// FIXME What if there is interesting debug information in the response?
-		// We should log it or incorporate it in the response message or something
-		return err
+		return 0, nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", idempotencyKey)
+	resp, err := ctxhttp.Do(ctx, tracingClient, req)
+	if err != nil {
+		return 0, nil, err
 	}
-	// It is the callers responsibility to Close the body reader
-	// But there should not be anything of interest here at the moment
 	defer resp.Body.Close()
-	logging.Info("Capability triggered", ctx, map[string]interface{}{"rCode": strconv.Itoa(resp.StatusCode)})
-	return nil
+	// The body only matters for the message of an error, failing to read it does not fail the trigger
+	responseBody, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseBody))
+	// The rest of the body has to be read for the connection to be reused
+	io.Copy(io.Discard, resp.Body)
+	return resp.StatusCode, responseBody, nil
 }
@@ -0,0 +1,106 @@
+package adapters
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Kaese72/device-store/internal/config"
+	"github.com/danielgtaylor/huma/v2"
+)
+
+func TestAdapterMessage(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		expected string
+	}{
+		{"problem document", `{"title":"Bad Request","status":400,"detail":"brightness out of range"}`, "brightness out of range"},
+		{"problem without detail", `{"title":"Bad Request","status":400}`, "Bad Request"},
+		{"message", `{"message":"device unreachable"}`, "device unreachable"},
+		{"error", `{"error":"device unreachable"}`, "device unreachable"},
+		{"plain text", "device unreachable\n", "device unreachable"},
+		{"empty", "", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if message := adapterMessage([]byte(test.body)); message != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, message)
+			}
+		})
+	}
+}
+
+func TestStatusError(t *testing.T) {
+	tests := []struct {
+		statusCode int
+		expected   int
+	}{
+		{http.StatusOK, 0},
+		{http.StatusNoContent, 0},
+		{http.StatusBadRequest, http.StatusBadRequest},
+		{http.StatusNotFound, http.StatusNotFound},
+		{http.StatusConflict, http.StatusConflict},
+		{http.StatusUnauthorized, http.StatusBadGateway},
+		{http.StatusForbidden, http.StatusBadGateway},
+		{http.StatusRequestTimeout, http.StatusGatewayTimeout},
+		{http.StatusMovedPermanently, http.StatusBadGateway},
+		{http.StatusInternalServerError, http.StatusBadGateway},
+		{http.StatusServiceUnavailable, http.StatusBadGateway},
+		{http.StatusGatewayTimeout, http.StatusGatewayTimeout},
+	}
+	for _, test := range tests {
+		t.Run(http.StatusText(test.statusCode), func(t *testing.T) {
+			err := statusError(test.statusCode, "message")
+			if test.expected == 0 {
+				if err != nil {
+					t.Errorf("expected no error, got %v", err)
+				}
+				return
+			}
+			var statusErr huma.StatusError
+			if !errors.As(err, &statusErr) || statusErr.GetStatus() != test.expected {
+				t.Errorf("expected status %d, got %v", test.expected, err)
+			}
+		})
+	}
+}
+
+func TestRequestError(t *testing.T) {
+	var statusErr huma.StatusError
+	if err := requestError(context.DeadlineExceeded); !errors.As(err, &statusErr) || statusErr.GetStatus() != http.StatusGatewayTimeout {
+		t.Errorf("expected a timeout to be a gateway timeout, got %v", err)
+	}
+	if err := requestError(&net.OpError{Op: "dial", Err: errors.New("connection refused")}); !errors.As(err, &statusErr) || statusErr.GetStatus() != http.StatusBadGateway {
+		t.Errorf("expected a connection failure to be a bad gateway, got %v", err)
+	}
+	if err := requestError(context.Canceled); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected an abandoned trigger to be left as it is, got %v", err)
+	}
+}
+
+func TestTriggerGroupCapability(t *testing.T) {
+	var path string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		w.Header().Set("Content-Type", "application/problem+json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		w.Write([]byte(`{"title":"Unprocessable Entity","status":422,"detail":"scene not found"}`))
+	}))
+	defer server.Close()
+	client := NewAdapterClient(config.AdapterRetryConfig{Attempts: 1, Multiplier: 1})
+	attempts, err := client.TriggerGroupCapability(context.Background(), server.URL, "1", "scene", nil)
+	var model *huma.ErrorModel
+	if !errors.As(err, &model) || model.Status != http.StatusUnprocessableEntity || model.Detail != "adapter responded with HTTP/422: scene not found" {
+		t.Fatalf("expected the refusal of the adapter to be passed on, got %v", err)
+	}
+	if path != "/groups/1/capabilities/scene" {
+		t.Errorf("expected the capability of the group to be triggered, got %s", path)
+	}
+	if len(attempts) != 1 || attempts[0].ErrorMessage == nil || *attempts[0].ErrorMessage != "scene not found" {
+		t.Errorf("expected the attempt to record the message of the adapter, got %+v", attempts)
+	}
+}
@@ -0,0 +1,77 @@
+// Package aggregation derives the state of a group from the state of its members
+package aggregation
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/Kaese72/device-store/restmodels"
+)
+
+// GroupAttributes aggregates the attributes of the member devices of a group, one group attribute per attribute name.
+// Boolean values are aggregated as any/all, numeric values as min/max/avg and text values by majority.
+func GroupAttributes(members [][]restmodels.Attribute) []restmodels.GroupAttribute {
+	byName := map[string][]restmodels.Attribute{}
+	for _, attributes := range members {
+		for _, attribute := range attributes {
+			byName[attribute.Name] = append(byName[attribute.Name], attribute)
+		}
+	}
+	groupAttributes := []restmodels.GroupAttribute{}
+	for name, attributes := range byName {
+		groupAttributes = append(groupAttributes, aggregate(name, attributes))
+	}
+	slices.SortFunc(groupAttributes, func(a, b restmodels.GroupAttribute) int { return strings.Compare(a.Name, b.Name) })
+	return groupAttributes
+}
+
+func aggregate(name string, attributes []restmodels.Attribute) restmodels.GroupAttribute {
+	groupAttribute := restmodels.GroupAttribute{Name: name, Members: len(attributes)}
+	var booleans []bool
+	var numerics []float32
+	textCounts := map[string]int{}
+	for _, attribute := range attributes {
+		if attribute.Updated.After(groupAttribute.Updated) {
+			groupAttribute.Updated = attribute.Updated
+		}
+		if attribute.Boolean != nil {
+			booleans = append(booleans, *attribute.Boolean)
+		}
+		if attribute.Numeric != nil {
+			numerics = append(numerics, *attribute.Numeric)
+		}
+		if attribute.Text != nil {
+			textCounts[*attribute.Text]++
+		}
+	}
+	if len(booleans) > 0 {
+		anyTrue := slices.Contains(booleans, true)
+		allTrue := !slices.Contains(booleans, false)
+		groupAttribute.Any = &anyTrue
+		groupAttribute.All = &allTrue
+	}
+	if len(numerics) > 0 {
+		minimum := slices.Min(numerics)
+		maximum := slices.Max(numerics)
+		var sum float64
+		for _, numeric := range numerics {
+			sum += float64(numeric)
+		}
+		average := float32(sum / float64(len(numerics)))
+		groupAttribute.Min = &minimum
+		groupAttribute.Max = &maximum
+		groupAttribute.Avg = &average
+	}
+	if len(textCounts) > 0 {
+		var majority string
+		majorityCount := 0
+		for text, count := range textCounts {
+			if count > majorityCount || (count == majorityCount && text < majority) {
+				majority = text
+				majorityCount = count
+			}
+		}
+		groupAttribute.Majority = &majority
+	}
+	return groupAttribute
+}
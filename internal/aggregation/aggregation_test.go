@@ -0,0 +1,108 @@
+package aggregation
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Kaese72/device-store/restmodels"
+)
+
+func ptrBool(value bool) *bool {
+	return &value
+}
+
+func ptrFloat32(value float32) *float32 {
+	return &value
+}
+
+func ptrString(value string) *string {
+	return &value
+}
+
+func TestGroupAttributes(t *testing.T) {
+	earlier := time.Date(2025, 8, 24, 14, 30, 0, 0, time.UTC)
+	later := earlier.Add(time.Minute)
+	tests := []struct {
+		name     string
+		members  [][]restmodels.Attribute
+		expected []restmodels.GroupAttribute
+	}{
+		{
+			name:     "No members",
+			members:  nil,
+			expected: []restmodels.GroupAttribute{},
+		},
+		{
+			name: "Booleans, some true",
+			members: [][]restmodels.Attribute{
+				{{Name: "active", Boolean: ptrBool(true), Updated: earlier}},
+				{{Name: "active", Boolean: ptrBool(false), Updated: later}},
+			},
+			expected: []restmodels.GroupAttribute{
+				{Name: "active", Members: 2, Any: ptrBool(true), All: ptrBool(false), Updated: later},
+			},
+		},
+		{
+			name: "Booleans, all true",
+			members: [][]restmodels.Attribute{
+				{{Name: "active", Boolean: ptrBool(true), Updated: earlier}},
+				{{Name: "active", Boolean: ptrBool(true), Updated: earlier}},
+			},
+			expected: []restmodels.GroupAttribute{
+				{Name: "active", Members: 2, Any: ptrBool(true), All: ptrBool(true), Updated: earlier},
+			},
+		},
+		{
+			name: "Numerics",
+			members: [][]restmodels.Attribute{
+				{{Name: "brightness", Numeric: ptrFloat32(10), Updated: earlier}},
+				{{Name: "brightness", Numeric: ptrFloat32(20), Updated: earlier}},
+				{{Name: "brightness", Numeric: ptrFloat32(60), Updated: earlier}},
+			},
+			expected: []restmodels.GroupAttribute{
+				{Name: "brightness", Members: 3, Min: ptrFloat32(10), Max: ptrFloat32(60), Avg: ptrFloat32(30), Updated: earlier},
+			},
+		},
+		{
+			name: "Text majority",
+			members: [][]restmodels.Attribute{
+				{{Name: "mode", Text: ptrString("heat"), Updated: earlier}},
+				{{Name: "mode", Text: ptrString("cool"), Updated: earlier}},
+				{{Name: "mode", Text: ptrString("heat"), Updated: earlier}},
+			},
+			expected: []restmodels.GroupAttribute{
+				{Name: "mode", Members: 3, Majority: ptrString("heat"), Updated: earlier},
+			},
+		},
+		{
+			name: "Text tie goes to the smallest value",
+			members: [][]restmodels.Attribute{
+				{{Name: "mode", Text: ptrString("heat"), Updated: earlier}},
+				{{Name: "mode", Text: ptrString("cool"), Updated: earlier}},
+			},
+			expected: []restmodels.GroupAttribute{
+				{Name: "mode", Members: 2, Majority: ptrString("cool"), Updated: earlier},
+			},
+		},
+		{
+			name: "Attributes only on some members, sorted by name",
+			members: [][]restmodels.Attribute{
+				{{Name: "on", Boolean: ptrBool(true), Updated: earlier}, {Name: "brightness", Numeric: ptrFloat32(50), Updated: earlier}},
+				{{Name: "on", Boolean: ptrBool(true), Updated: earlier}},
+			},
+			expected: []restmodels.GroupAttribute{
+				{Name: "brightness", Members: 1, Min: ptrFloat32(50), Max: ptrFloat32(50), Avg: ptrFloat32(50), Updated: earlier},
+				{Name: "on", Members: 2, Any: ptrBool(true), All: ptrBool(true), Updated: earlier},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := GroupAttributes(test.members)
+			if !reflect.DeepEqual(result, test.expected) {
+				t.Errorf("GroupAttributes() = %+v, expected %+v", result, test.expected)
+			}
+		})
+	}
+}
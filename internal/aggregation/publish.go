@@ -0,0 +1,56 @@
+package aggregation
+
+import (
+	"context"
+	"maps"
+	"slices"
+
+	"github.com/Kaese72/device-store/eventmodels"
+	"github.com/Kaese72/device-store/internal/logging"
+	"github.com/Kaese72/device-store/internal/persistence"
+)
+
+// PublishGroupAttributeUpdates sends the new aggregated values of the named attributes for every group affected by a
+// change to the devices or to the members of the groups, as resolved by AggregateGroupAttributes. Failures are only
+// logged, as the change itself already succeeded.
+func PublishGroupAttributeUpdates(ctx context.Context, p persistence.GroupAttributesPersistenceDB, storeEvents chan<- eventmodels.StoreEvent, deviceIds []int, groupIds []int, names []string) {
+	if len(names) == 0 {
+		return
+	}
+	aggregates, err := p.AggregateGroupAttributes(ctx, deviceIds, groupIds, names)
+	if err != nil {
+		logging.ErrorErr(err, ctx)
+		return
+	}
+	for _, groupId := range slices.Sorted(maps.Keys(aggregates)) {
+		event := eventmodels.GroupAttributeUpdate{GroupID: groupId, Attributes: []eventmodels.AggregatedAttribute{}}
+		for _, attribute := range aggregates[groupId] {
+			event.Attributes = append(event.Attributes, eventmodels.AggregatedAttribute{
+				Name:     attribute.Name,
+				Members:  attribute.Members,
+				Any:      attribute.Any,
+				All:      attribute.All,
+				Min:      attribute.Min,
+				Max:      attribute.Max,
+				Avg:      attribute.Avg,
+				Majority: attribute.Majority,
+				Updated:  attribute.Updated,
+			})
+		}
+		if len(event.Attributes) != 0 {
+			storeEvents <- event
+		}
+	}
+}
+
+// PublishMemberChanges sends group attribute updates for member devices and groups that were added, removed, deleted
+// or restored, covering every attribute of those members. deviceIds and groupIds select the affected groups as for
+// PublishGroupAttributeUpdates.
+func PublishMemberChanges(ctx context.Context, p persistence.GroupAttributesPersistenceDB, storeEvents chan<- eventmodels.StoreEvent, deviceIds []int, groupIds []int, memberDeviceIds []int, memberGroupIds []int) {
+	names, err := p.GetMemberAttributeNames(ctx, memberDeviceIds, memberGroupIds)
+	if err != nil {
+		logging.ErrorErr(err, ctx)
+		return
+	}
+	PublishGroupAttributeUpdates(ctx, p, storeEvents, deviceIds, groupIds, names)
+}
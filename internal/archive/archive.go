@@ -0,0 +1,131 @@
+package archive
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/Kaese72/device-store/restmodels"
+	"github.com/danielgtaylor/huma/v2"
+)
+
+const (
+	FormatJSON   = "json"
+	FormatNDJSON = "ndjson"
+)
+
+// ContentType returns the media type of an archive format
+func ContentType(format string) string {
+	if format == FormatNDJSON {
+		return "application/x-ndjson"
+	}
+	return "application/json"
+}
+
+// Encode writes archive to w in the requested format
+func Encode(w io.Writer, archive restmodels.Archive, format string) error {
+	switch format {
+	case FormatJSON:
+		return json.NewEncoder(w).Encode(archive)
+	case FormatNDJSON:
+		encoder := json.NewEncoder(w)
+		if err := encoder.Encode(restmodels.ArchiveRecord{Kind: "header", Header: &archive.ArchiveHeader}); err != nil {
+			return err
+		}
+		for index := range archive.Devices {
+			if err := encoder.Encode(restmodels.ArchiveRecord{Kind: "device", Device: &archive.Devices[index]}); err != nil {
+				return err
+			}
+		}
+		for index := range archive.Groups {
+			if err := encoder.Encode(restmodels.ArchiveRecord{Kind: "group", Group: &archive.Groups[index]}); err != nil {
+				return err
+			}
+		}
+		if archive.Audits == nil {
+			return nil
+		}
+		for index := range archive.Audits.Attributes {
+			if err := encoder.Encode(restmodels.ArchiveRecord{Kind: "attribute-audit", AttributeAudit: &archive.Audits.Attributes[index]}); err != nil {
+				return err
+			}
+		}
+		for index := range archive.Audits.DeviceCapabilityTriggers {
+			if err := encoder.Encode(restmodels.ArchiveRecord{Kind: "device-capability-trigger-audit", DeviceCapabilityTriggerAudit: &archive.Audits.DeviceCapabilityTriggers[index]}); err != nil {
+				return err
+			}
+		}
+		for index := range archive.Audits.GroupCapabilityTriggers {
+			if err := encoder.Encode(restmodels.ArchiveRecord{Kind: "group-capability-trigger-audit", GroupCapabilityTriggerAudit: &archive.Audits.GroupCapabilityTriggers[index]}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return huma.Error400BadRequest(fmt.Sprintf("unknown archive format %s", format))
+}
+
+// Decode reads an archive in either format. NDJSON archives are recognized by their leading header record.
+func Decode(r io.Reader) (restmodels.Archive, error) {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return restmodels.Archive{}, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	var first restmodels.ArchiveRecord
+	if err := decoder.Decode(&first); err != nil {
+		return restmodels.Archive{}, huma.Error400BadRequest(fmt.Sprintf("could not decode archive, %s", err.Error()))
+	}
+	var archive restmodels.Archive
+	if first.Kind == "header" && first.Header != nil {
+		archive, err = decodeRecords(first, decoder)
+	} else {
+		err = json.Unmarshal(content, &archive)
+	}
+	if err != nil {
+		return restmodels.Archive{}, huma.Error400BadRequest(fmt.Sprintf("could not decode archive, %s", err.Error()))
+	}
+	if archive.Version < 1 || archive.Version > restmodels.ArchiveVersion {
+		return restmodels.Archive{}, huma.Error400BadRequest(fmt.Sprintf("unsupported archive version %d", archive.Version))
+	}
+	return archive, nil
+}
+
+func decodeRecords(header restmodels.ArchiveRecord, decoder *json.Decoder) (restmodels.Archive, error) {
+	archive := restmodels.Archive{ArchiveHeader: *header.Header}
+	for {
+		var record restmodels.ArchiveRecord
+		err := decoder.Decode(&record)
+		if err == io.EOF {
+			return archive, nil
+		}
+		if err != nil {
+			return restmodels.Archive{}, err
+		}
+		switch {
+		case record.Kind == "device" && record.Device != nil:
+			archive.Devices = append(archive.Devices, *record.Device)
+		case record.Kind == "group" && record.Group != nil:
+			archive.Groups = append(archive.Groups, *record.Group)
+		case record.Kind == "attribute-audit" && record.AttributeAudit != nil:
+			archive.Audits = ensureAudits(archive.Audits)
+			archive.Audits.Attributes = append(archive.Audits.Attributes, *record.AttributeAudit)
+		case record.Kind == "device-capability-trigger-audit" && record.DeviceCapabilityTriggerAudit != nil:
+			archive.Audits = ensureAudits(archive.Audits)
+			archive.Audits.DeviceCapabilityTriggers = append(archive.Audits.DeviceCapabilityTriggers, *record.DeviceCapabilityTriggerAudit)
+		case record.Kind == "group-capability-trigger-audit" && record.GroupCapabilityTriggerAudit != nil:
+			archive.Audits = ensureAudits(archive.Audits)
+			archive.Audits.GroupCapabilityTriggers = append(archive.Audits.GroupCapabilityTriggers, *record.GroupCapabilityTriggerAudit)
+		default:
+			return restmodels.Archive{}, fmt.Errorf("unexpected archive record of kind %q", record.Kind)
+		}
+	}
+}
+
+func ensureAudits(audits *restmodels.ArchiveAudits) *restmodels.ArchiveAudits {
+	if audits == nil {
+		return &restmodels.ArchiveAudits{}
+	}
+	return audits
+}
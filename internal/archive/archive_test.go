@@ -0,0 +1,84 @@
+package archive
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Kaese72/device-store/restmodels"
+)
+
+func TestEncodeDecodeRoundTrip(t *testing.T) {
+	text := "on"
+	original := restmodels.Archive{
+		ArchiveHeader: restmodels.ArchiveHeader{
+			Version:  restmodels.ArchiveVersion,
+			Exported: time.Date(2025, 8, 24, 14, 30, 25, 0, time.UTC),
+		},
+		Devices: []restmodels.ArchiveDevice{
+			{
+				ID:               12,
+				BridgeIdentifier: "lights/1",
+				AdapterId:        3,
+				Attributes:       []restmodels.Attribute{{Name: "state", Text: &text}},
+				Capabilities:     []restmodels.DeviceCapability{{Name: "on"}},
+			},
+		},
+		Groups: []restmodels.ArchiveGroup{
+			{ID: 4, Name: "kitchen", AdapterId: 3, BridgeIdentifier: "groups/1", DeviceIds: []int{12}},
+		},
+		Audits: &restmodels.ArchiveAudits{
+			Attributes:               []restmodels.AttributeAudit{{ID: 1, DeviceID: 12, Name: "state", NewTextValue: &text}},
+			DeviceCapabilityTriggers: []restmodels.CapabilityTriggerAudit{{ID: 2, DeviceID: 12, Name: "on", Success: true}},
+			GroupCapabilityTriggers:  []restmodels.GroupCapabilityTriggerAudit{{ID: 3, GroupID: 4, Name: "on", Success: true}},
+		},
+	}
+	for _, format := range []string{FormatJSON, FormatNDJSON} {
+		t.Run(format, func(t *testing.T) {
+			buffer := bytes.Buffer{}
+			if err := Encode(&buffer, original, format); err != nil {
+				t.Fatalf("Encode() unexpected error: %v", err)
+			}
+			decoded, err := Decode(&buffer)
+			if err != nil {
+				t.Fatalf("Decode() unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(decoded, original) {
+				t.Errorf("Decode() = %+v, expected %+v", decoded, original)
+			}
+		})
+	}
+}
+
+func TestDecodeRejectsInvalidArchives(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{
+			name:  "Empty input",
+			input: "",
+		},
+		{
+			name:  "Missing version",
+			input: `{"devices": [], "groups": []}`,
+		},
+		{
+			name:  "Future version",
+			input: `{"version": 999, "devices": [], "groups": []}`,
+		},
+		{
+			name:  "Unknown NDJSON record",
+			input: "{\"kind\": \"header\", \"header\": {\"version\": 1}}\n{\"kind\": \"scene\"}\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Decode(strings.NewReader(tt.input)); err == nil {
+				t.Errorf("Decode(%q) expected error but got none", tt.input)
+			}
+		})
+	}
+}
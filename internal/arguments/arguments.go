@@ -0,0 +1,43 @@
+// Package arguments validates capability trigger arguments against the argument specs declared by adapters
+package arguments
+
+import (
+	"maps"
+	"slices"
+
+	"github.com/Kaese72/device-store/restmodels"
+	"github.com/danielgtaylor/huma/v2"
+)
+
+// Validate checks the arguments of a capability trigger against the JSON Schema of the capability, see Schema, and
+// returns the arguments with declared defaults filled in for arguments that were left out. Every problem is reported
+// as a detail of a single 422 error. Capabilities whose adapter declared neither argument specs (nil rather than
+// empty) nor a schema accept any arguments.
+func Validate(specs []restmodels.ArgumentSpec, supplied map[string]any, args restmodels.DeviceCapabilityArgs) (restmodels.DeviceCapabilityArgs, error) {
+	validated := restmodels.DeviceCapabilityArgs{}
+	maps.Copy(validated, args)
+	if specs == nil && supplied == nil {
+		return validated, nil
+	}
+	schema, err := ParseSchema(Schema(specs, supplied))
+	if err != nil {
+		return nil, err
+	}
+	result := &huma.ValidateResult{}
+	huma.Validate(registry, schema, huma.NewPathBuffer([]byte("body"), 4), huma.ModeWriteToServer, map[string]any(args), result)
+	if len(result.Errors) > 0 {
+		return nil, huma.Error422UnprocessableEntity("invalid capability arguments", result.Errors...)
+	}
+	for _, name := range slices.Sorted(maps.Keys(schema.Properties)) {
+		if _, ok := validated[name]; ok {
+			continue
+		}
+		if value := schema.Properties[name].Default; value != nil {
+			validated[name] = value
+		}
+	}
+	return validated, nil
+}
+
+// registry resolves references in argument schemas. Adapter supplied schemas are self contained, so it stays empty.
+var registry = huma.NewMapRegistry("#/components/schemas/", huma.DefaultSchemaNamer)
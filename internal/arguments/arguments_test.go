@@ -0,0 +1,240 @@
+package arguments
+
+import (
+	"errors"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/Kaese72/device-store/restmodels"
+	"github.com/danielgtaylor/huma/v2"
+)
+
+func ptrBool(value bool) *bool {
+	return &value
+}
+
+func ptrFloat32(value float32) *float32 {
+	return &value
+}
+
+func ptrInt(value int) *int {
+	return &value
+}
+
+func ptrString(value string) *string {
+	return &value
+}
+
+func TestValidate(t *testing.T) {
+	specs := []restmodels.ArgumentSpec{
+		{Name: "on", ArgumentKind: restmodels.ArgumentKind{Boolean: &restmodels.BooleanArgumentSpec{Default: ptrBool(true)}}},
+		{Name: "brightness", ArgumentKind: restmodels.ArgumentKind{Numeric: &restmodels.NumericArgumentSpec{Min: 0, Max: 254}}},
+		{Name: "transition", ArgumentKind: restmodels.ArgumentKind{Numeric: &restmodels.NumericArgumentSpec{Default: ptrFloat32(4)}}},
+		{Name: "label", ArgumentKind: restmodels.ArgumentKind{Text: &restmodels.TextArgumentSpec{Min: ptrInt(1), Max: ptrInt(4), Default: ptrString("tv")}}},
+	}
+	kinds := []restmodels.ArgumentSpec{
+		{Name: "mode", ArgumentKind: restmodels.ArgumentKind{Enum: &restmodels.EnumArgumentSpec{Values: []string{"relax", "focus"}, Default: ptrString("relax")}}},
+		{Name: "level", ArgumentKind: restmodels.ArgumentKind{Integer: &restmodels.IntegerArgumentSpec{Min: ptrInt(0), Max: ptrInt(100), Step: ptrInt(10)}}},
+		{Name: "color", ArgumentKind: restmodels.ArgumentKind{Color: &restmodels.ColorArgumentSpec{}}},
+		{Name: "transition", ArgumentKind: restmodels.ArgumentKind{Duration: &restmodels.DurationArgumentSpec{Max: ptrInt(60000), Default: ptrInt(400)}}},
+		{Name: "zones", ArgumentKind: restmodels.ArgumentKind{Array: &restmodels.ArrayArgumentSpec{
+			Items:    restmodels.ArgumentKind{Integer: &restmodels.IntegerArgumentSpec{Min: ptrInt(1)}},
+			MaxItems: ptrInt(2),
+		}}},
+	}
+	tests := []struct {
+		name      string
+		specs     []restmodels.ArgumentSpec
+		schema    map[string]any
+		args      restmodels.DeviceCapabilityArgs
+		expected  restmodels.DeviceCapabilityArgs
+		locations []string
+	}{
+		{
+			name:     "No specs declared",
+			specs:    nil,
+			args:     restmodels.DeviceCapabilityArgs{"anything": "goes"},
+			expected: restmodels.DeviceCapabilityArgs{"anything": "goes"},
+		},
+		{
+			name:      "Empty specs declared",
+			specs:     []restmodels.ArgumentSpec{},
+			args:      restmodels.DeviceCapabilityArgs{"anything": "goes"},
+			locations: []string{"body.anything"},
+		},
+		{
+			name:     "Defaults filled",
+			specs:    specs,
+			args:     restmodels.DeviceCapabilityArgs{"brightness": float64(100)},
+			expected: restmodels.DeviceCapabilityArgs{"on": true, "brightness": float64(100), "transition": float64(4), "label": "tv"},
+		},
+		{
+			name:     "Given values kept",
+			specs:    specs,
+			args:     restmodels.DeviceCapabilityArgs{"on": false, "transition": float64(-3), "label": "ünïc"},
+			expected: restmodels.DeviceCapabilityArgs{"on": false, "transition": float64(-3), "label": "ünïc"},
+		},
+		{
+			name:      "Wrong types",
+			specs:     specs,
+			args:      restmodels.DeviceCapabilityArgs{"on": "yes", "brightness": true, "label": float64(1)},
+			locations: []string{"body.brightness", "body.label", "body.on"},
+		},
+		{
+			name:      "Out of range and unknown",
+			specs:     specs,
+			args:      restmodels.DeviceCapabilityArgs{"brightness": float64(255), "label": "toolong", "colour": "red"},
+			locations: []string{"body.brightness", "body.colour", "body.label"},
+		},
+		{
+			name:     "Richer kinds",
+			specs:    kinds,
+			args:     restmodels.DeviceCapabilityArgs{"level": float64(30), "color": "#ff00Aa", "zones": []any{float64(1), float64(3)}},
+			expected: restmodels.DeviceCapabilityArgs{"mode": "relax", "level": float64(30), "color": "#ff00Aa", "transition": float64(400), "zones": []any{float64(1), float64(3)}},
+		},
+		{
+			name:      "Richer kinds violated",
+			specs:     kinds,
+			args:      restmodels.DeviceCapabilityArgs{"mode": "party", "level": float64(35), "color": "red", "transition": float64(-1), "zones": []any{float64(0), float64(1), float64(2)}},
+			locations: []string{"body.color", "body.level", "body.mode", "body.transition", "body.zones", "body.zones[0]"},
+		},
+		{
+			name:  "Supplied schema",
+			specs: []restmodels.ArgumentSpec{},
+			schema: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"scene": map[string]any{"type": "string", "enum": []any{"relax", "focus"}, "default": "relax"},
+					"zones": map[string]any{"type": "array", "items": map[string]any{"type": "integer"}},
+				},
+			},
+			args:     restmodels.DeviceCapabilityArgs{"zones": []any{float64(1), float64(2)}, "extra": true},
+			expected: restmodels.DeviceCapabilityArgs{"zones": []any{float64(1), float64(2)}, "extra": true, "scene": "relax"},
+		},
+		{
+			name: "Supplied schema violated",
+			schema: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"scene": map[string]any{"type": "string", "enum": []any{"relax", "focus"}},
+					"zones": map[string]any{"type": "array", "items": map[string]any{"type": "integer"}},
+				},
+				"additionalProperties": false,
+			},
+			args:      restmodels.DeviceCapabilityArgs{"scene": "party", "zones": []any{float64(1.5)}, "extra": true},
+			locations: []string{"body.extra", "body.scene", "body.zones[0]"},
+		},
+		{
+			name:      "Too short",
+			specs:     specs,
+			args:      restmodels.DeviceCapabilityArgs{"label": ""},
+			locations: []string{"body.label"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			validated, err := Validate(test.specs, test.schema, test.args)
+			if test.locations == nil {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if !reflect.DeepEqual(validated, test.expected) {
+					t.Errorf("expected %v, got %v", test.expected, validated)
+				}
+				return
+			}
+			var model *huma.ErrorModel
+			if !errors.As(err, &model) {
+				t.Fatalf("expected a huma error, got %v", err)
+			}
+			if model.Status != 422 {
+				t.Errorf("expected status 422, got %d", model.Status)
+			}
+			locations := []string{}
+			for _, detail := range model.Errors {
+				locations = append(locations, detail.Location)
+			}
+			slices.Sort(locations)
+			if !reflect.DeepEqual(locations, test.locations) {
+				t.Errorf("expected errors at %v, got %v", test.locations, locations)
+			}
+		})
+	}
+}
+
+func TestSchema(t *testing.T) {
+	specs := []restmodels.ArgumentSpec{
+		{Name: "on", ArgumentKind: restmodels.ArgumentKind{Boolean: &restmodels.BooleanArgumentSpec{}}},
+		{Name: "brightness", ArgumentKind: restmodels.ArgumentKind{Numeric: &restmodels.NumericArgumentSpec{Min: 1, Max: 254, Default: ptrFloat32(100)}}},
+		{Name: "label", ArgumentKind: restmodels.ArgumentKind{Text: &restmodels.TextArgumentSpec{Max: ptrInt(4)}}},
+	}
+	expected := map[string]any{
+		"$schema": SchemaDialect,
+		"type":    "object",
+		"properties": map[string]any{
+			"on":         map[string]any{"type": "boolean"},
+			"brightness": map[string]any{"type": "number", "minimum": float64(1), "maximum": float64(254), "default": float64(100)},
+			"label":      map[string]any{"type": "string", "maxLength": 4},
+		},
+		"additionalProperties": false,
+	}
+	if schema := Schema(specs, nil); !reflect.DeepEqual(schema, expected) {
+		t.Errorf("expected %v, got %v", expected, schema)
+	}
+	supplied := map[string]any{"type": "object"}
+	if schema := Schema(specs, supplied); !reflect.DeepEqual(schema, supplied) {
+		t.Errorf("expected the supplied schema, got %v", schema)
+	}
+	if _, err := ParseSchema(map[string]any{"type": "string"}); err == nil {
+		t.Errorf("expected schemas not describing an object to be rejected")
+	}
+}
+
+func TestParseSchemaKeywords(t *testing.T) {
+	tests := []struct {
+		name      string
+		schema    map[string]any
+		supported bool
+	}{
+		{"supported keywords", map[string]any{
+			"type":       "object",
+			"properties": map[string]any{"level": map[string]any{"type": "integer", "minimum": 0, "x-unit": "percent"}},
+			"anyOf":      []any{map[string]any{"required": []any{"level"}}},
+		}, true},
+		{"reference", map[string]any{
+			"type":       "object",
+			"properties": map[string]any{"color": map[string]any{"$ref": "#/$defs/color"}},
+			"$defs":      map[string]any{"color": map[string]any{"type": "string"}},
+		}, false},
+		{"nested reference", map[string]any{
+			"type":       "object",
+			"properties": map[string]any{"colors": map[string]any{"type": "array", "items": map[string]any{"$ref": "#/$defs/color"}}},
+		}, false},
+		{"reference in a combinator", map[string]any{
+			"type":  "object",
+			"oneOf": []any{map[string]any{"$ref": "#/$defs/on"}},
+		}, false},
+		{"keyword not validated", map[string]any{
+			"type":       "object",
+			"properties": map[string]any{"mode": map[string]any{"const": "heat"}},
+		}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := ParseSchema(test.schema)
+			if test.supported && err != nil {
+				t.Errorf("expected the schema to be supported, got %s", err)
+			}
+			if !test.supported && err == nil {
+				t.Error("expected the schema to be rejected")
+			}
+		})
+	}
+
+	// Validating arguments against a stored schema with a reference fails rather than panics
+	supplied := map[string]any{"type": "object", "properties": map[string]any{"color": map[string]any{"$ref": "#/$defs/color"}}}
+	if _, err := Validate(nil, supplied, restmodels.DeviceCapabilityArgs{"color": "red"}); err == nil {
+		t.Error("expected arguments not to be validated against a schema with a reference")
+	}
+}
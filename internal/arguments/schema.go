@@ -0,0 +1,237 @@
+package arguments
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/Kaese72/device-store/restmodels"
+	"github.com/danielgtaylor/huma/v2"
+)
+
+// SchemaDialect is the JSON Schema version of generated schemas
+const SchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// Schema returns the JSON Schema of the arguments of a capability. A schema supplied by the adapter is returned
+// as is, otherwise one is generated from the argument specs. Capabilities without either accept any object.
+func Schema(specs []restmodels.ArgumentSpec, supplied map[string]any) map[string]any {
+	if supplied != nil {
+		return supplied
+	}
+	if specs == nil {
+		return map[string]any{"$schema": SchemaDialect, "type": "object"}
+	}
+	properties := map[string]any{}
+	for _, spec := range specs {
+		properties[spec.Name] = kindSchema(spec.ArgumentKind)
+	}
+	return map[string]any{
+		"$schema":              SchemaDialect,
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+}
+
+// colorPattern matches colors written as "#rrggbb"
+const colorPattern = "^#[0-9a-fA-F]{6}$"
+
+func kindSchema(kind restmodels.ArgumentKind) map[string]any {
+	property := map[string]any{}
+	switch {
+	case kind.Boolean != nil:
+		property["type"] = "boolean"
+		if kind.Boolean.Default != nil {
+			property["default"] = *kind.Boolean.Default
+		}
+	case kind.Numeric != nil:
+		property["type"] = "number"
+		// Specs declaring neither min nor max do not limit the range
+		if kind.Numeric.Min != 0 || kind.Numeric.Max != 0 {
+			property["minimum"] = float64(kind.Numeric.Min)
+			property["maximum"] = float64(kind.Numeric.Max)
+		}
+		if kind.Numeric.Default != nil {
+			property["default"] = float64(*kind.Numeric.Default)
+		}
+	case kind.Text != nil:
+		property["type"] = "string"
+		if kind.Text.Min != nil {
+			property["minLength"] = *kind.Text.Min
+		}
+		if kind.Text.Max != nil {
+			property["maxLength"] = *kind.Text.Max
+		}
+		if kind.Text.Default != nil {
+			property["default"] = *kind.Text.Default
+		}
+	case kind.Enum != nil:
+		property["type"] = "string"
+		values := []any{}
+		for _, value := range kind.Enum.Values {
+			values = append(values, value)
+		}
+		property["enum"] = values
+		if kind.Enum.Default != nil {
+			property["default"] = *kind.Enum.Default
+		}
+	case kind.Integer != nil:
+		property["type"] = "integer"
+		if kind.Integer.Min != nil {
+			property["minimum"] = float64(*kind.Integer.Min)
+		}
+		if kind.Integer.Max != nil {
+			property["maximum"] = float64(*kind.Integer.Max)
+		}
+		if kind.Integer.Step != nil {
+			property["multipleOf"] = float64(*kind.Integer.Step)
+		}
+		if kind.Integer.Default != nil {
+			property["default"] = float64(*kind.Integer.Default)
+		}
+	case kind.Color != nil:
+		// Form libraries render strings of the color format as a color picker
+		property["type"] = "string"
+		property["format"] = "color"
+		property["pattern"] = colorPattern
+		if kind.Color.Default != nil {
+			property["default"] = *kind.Color.Default
+		}
+	case kind.Duration != nil:
+		property["type"] = "integer"
+		property["description"] = "duration in milliseconds"
+		property["minimum"] = float64(0)
+		if kind.Duration.Min != nil {
+			property["minimum"] = float64(*kind.Duration.Min)
+		}
+		if kind.Duration.Max != nil {
+			property["maximum"] = float64(*kind.Duration.Max)
+		}
+		if kind.Duration.Default != nil {
+			property["default"] = float64(*kind.Duration.Default)
+		}
+	case kind.Array != nil:
+		property["type"] = "array"
+		property["items"] = kindSchema(kind.Array.Items)
+		if kind.Array.MinItems != nil {
+			property["minItems"] = *kind.Array.MinItems
+		}
+		if kind.Array.MaxItems != nil {
+			property["maxItems"] = *kind.Array.MaxItems
+		}
+	}
+	return property
+}
+
+// ParseSchema converts a JSON Schema into a schema the huma validator can validate arguments with.
+// Only schemas describing an object are accepted, since capability arguments are always an object.
+func ParseSchema(raw map[string]any) (*huma.Schema, error) {
+	if err := checkKeywords(raw, "#"); err != nil {
+		return nil, err
+	}
+	schema, err := parseSchema(raw)
+	if err != nil {
+		return nil, err
+	}
+	if schema.Type != "object" {
+		return nil, fmt.Errorf("argument schema must be of type object")
+	}
+	schema.PrecomputeMessages()
+	return schema, nil
+}
+
+// supportedKeywords are the keywords the huma validator validates arguments with, along with annotations. Other
+// keywords would silently be ignored when validating, and references cannot be resolved since argument schemas are
+// not registered anywhere.
+var supportedKeywords = []string{
+	"$schema", "$id", "$comment", "title", "description", "default", "examples", "deprecated", "readOnly", "writeOnly",
+	"type", "format", "contentEncoding", "enum", "pattern", "patternDescription",
+	"minimum", "maximum", "exclusiveMinimum", "exclusiveMaximum", "multipleOf", "minLength", "maxLength",
+	"items", "minItems", "maxItems", "uniqueItems",
+	"properties", "additionalProperties", "required", "dependentRequired", "minProperties", "maxProperties",
+	"oneOf", "anyOf", "allOf", "not",
+}
+
+// checkKeywords refuses schemas using keywords arguments cannot be validated with, such as $ref and $defs.
+// Extension keywords starting with "x-" are allowed, as they do not affect validation.
+func checkKeywords(raw any, location string) error {
+	schema, ok := raw.(map[string]any)
+	if !ok {
+		// Boolean schemas, and malformed schemas which fail to parse later
+		return nil
+	}
+	for _, keyword := range slices.Sorted(maps.Keys(schema)) {
+		if !slices.Contains(supportedKeywords, keyword) && !strings.HasPrefix(keyword, "x-") {
+			return fmt.Errorf("argument schema keyword %s at %s is not supported", keyword, location)
+		}
+	}
+	subSchemas := map[string]any{}
+	for _, keyword := range []string{"items", "additionalProperties", "not"} {
+		if subSchema, ok := schema[keyword]; ok {
+			subSchemas[location+"/"+keyword] = subSchema
+		}
+	}
+	for _, keyword := range []string{"oneOf", "anyOf", "allOf"} {
+		if list, ok := schema[keyword].([]any); ok {
+			for index, subSchema := range list {
+				subSchemas[fmt.Sprintf("%s/%s/%d", location, keyword, index)] = subSchema
+			}
+		}
+	}
+	if properties, ok := schema["properties"].(map[string]any); ok {
+		for name, subSchema := range properties {
+			subSchemas[location+"/properties/"+name] = subSchema
+		}
+	}
+	for _, subLocation := range slices.Sorted(maps.Keys(subSchemas)) {
+		if err := checkKeywords(subSchemas[subLocation], subLocation); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func parseSchema(raw any) (*huma.Schema, error) {
+	encoded, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	schema := &huma.Schema{}
+	if err := json.Unmarshal(encoded, schema); err != nil {
+		return nil, fmt.Errorf("invalid argument schema: %w", err)
+	}
+	return schema, resolveAdditionalProperties(schema)
+}
+
+// resolveAdditionalProperties turns every additionalProperties holding a schema into a *huma.Schema,
+// which is the only form of schema the huma validator validates additional properties against
+func resolveAdditionalProperties(schema *huma.Schema) error {
+	if schema == nil {
+		return nil
+	}
+	if additional, ok := schema.AdditionalProperties.(map[string]any); ok {
+		resolved, err := parseSchema(additional)
+		if err != nil {
+			return err
+		}
+		schema.AdditionalProperties = resolved
+	}
+	subSchemas := []*huma.Schema{schema.Items, schema.Not}
+	subSchemas = append(subSchemas, schema.OneOf...)
+	subSchemas = append(subSchemas, schema.AnyOf...)
+	subSchemas = append(subSchemas, schema.AllOf...)
+	for name, property := range schema.Properties {
+		if property == nil {
+			return fmt.Errorf("invalid argument schema: property %s has no schema", name)
+		}
+		subSchemas = append(subSchemas, property)
+	}
+	for _, subSchema := range subSchemas {
+		if err := resolveAdditionalProperties(subSchema); err != nil {
+			return err
+		}
+	}
+	return nil
+}
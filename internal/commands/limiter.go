@@ -0,0 +1,69 @@
+// Package commands paces and orders the capabilities triggered through adapters
+package commands
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Limiter is a token bucket per key, refilled at rate tokens per second up to burst tokens
+type Limiter struct {
+	rate  float64
+	burst int
+	lock  sync.Mutex
+	// buckets is keyed by device or adapter ID
+	buckets map[int]*bucket
+	now     func() time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewLimiter returns a limiter, or nil if rate is zero, which does not limit anything
+func NewLimiter(rate float64, burst int) *Limiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &Limiter{rate: rate, burst: burst, buckets: map[int]*bucket{}, now: time.Now}
+}
+
+// reserve takes a token for key and returns how long to wait before it may be used
+func (limiter *Limiter) reserve(key int) time.Duration {
+	limiter.lock.Lock()
+	defer limiter.lock.Unlock()
+	now := limiter.now()
+	current, ok := limiter.buckets[key]
+	if !ok {
+		current = &bucket{tokens: float64(limiter.burst), last: now}
+		limiter.buckets[key] = current
+	}
+	current.tokens = min(float64(limiter.burst), current.tokens+now.Sub(current.last).Seconds()*limiter.rate)
+	current.last = now
+	current.tokens--
+	if current.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-current.tokens / limiter.rate * float64(time.Second))
+}
+
+// Wait takes a token for key, waiting for it to become available unless ctx is done first
+func (limiter *Limiter) Wait(ctx context.Context, key int) error {
+	if limiter == nil {
+		return nil
+	}
+	delay := limiter.reserve(key)
+	if delay == 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
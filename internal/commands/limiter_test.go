@@ -0,0 +1,44 @@
+package commands
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiterReserve(t *testing.T) {
+	now := time.Date(2024, 1, 5, 12, 0, 0, 0, time.UTC)
+	limiter := NewLimiter(2, 2)
+	limiter.now = func() time.Time { return now }
+	steps := []struct {
+		name     string
+		elapsed  time.Duration
+		key      int
+		expected time.Duration
+	}{
+		{"first of the burst", 0, 1, 0},
+		{"last of the burst", 0, 1, 0},
+		{"paced after the burst", 0, 1, 500 * time.Millisecond},
+		{"queued behind the paced token", 0, 1, time.Second},
+		{"another key has a burst of its own", 0, 2, 0},
+		{"refilled while waiting", 2 * time.Second, 1, 0},
+		{"refill is capped by the burst", 10 * time.Second, 1, 0},
+		{"burst after being capped", 0, 1, 0},
+		{"paced after being capped", 0, 1, 500 * time.Millisecond},
+	}
+	for _, step := range steps {
+		now = now.Add(step.elapsed)
+		if delay := limiter.reserve(step.key); delay != step.expected {
+			t.Errorf("%s: expected a delay of %s, got %s", step.name, step.expected, delay)
+		}
+	}
+}
+
+func TestNoLimiter(t *testing.T) {
+	limiter := NewLimiter(0, 0)
+	if limiter != nil {
+		t.Fatal("expected no limiter without a rate")
+	}
+	if err := limiter.Wait(t.Context(), 1); err != nil {
+		t.Errorf("expected no limit, got %s", err)
+	}
+}
@@ -0,0 +1,144 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/Kaese72/device-store/internal/config"
+	"github.com/danielgtaylor/huma/v2"
+)
+
+// Command triggers a capability and returns the ID of the audit row recording the outcome
+type Command func(ctx context.Context) (int, error)
+
+type outcome struct {
+	done    chan struct{}
+	auditId int
+	err     error
+}
+
+type queuedCommand struct {
+	ctx        context.Context
+	adapterId  int
+	capability string
+	coalesce   bool
+	run        Command
+	// outcomes are those of this command, and of the commands it replaced when coalescing
+	outcomes []*outcome
+}
+
+type deviceQueue struct {
+	pending []*queuedCommand
+	// draining is set while a worker runs the pending commands of the device
+	draining bool
+}
+
+// Queue runs the commands of every device one at a time, in the order they were submitted, paced by the rate
+// limits of the device and of its adapter. Limits and queues are kept by every replica of the store on its own.
+type Queue struct {
+	deviceLimits  *Limiter
+	adapterLimits *Limiter
+	maxQueued     int
+	lock          sync.Mutex
+	// devices is keyed by device ID
+	devices map[int]*deviceQueue
+}
+
+func NewQueue(conf config.CommandsConfig) *Queue {
+	return &Queue{
+		deviceLimits:  NewLimiter(conf.DeviceRate, conf.DeviceBurst),
+		adapterLimits: NewLimiter(conf.AdapterRate, conf.AdapterBurst),
+		maxQueued:     conf.MaxQueued,
+		devices:       map[int]*deviceQueue{},
+	}
+}
+
+// Run queues a command triggering a capability of a device and waits for its outcome. If coalesce is set, a
+// command for the same capability that is also coalescing and still waiting is replaced by this one, which takes
+// its place in the queue, and its caller gets the outcome of this one. Run fails with 429 if too many commands wait for the device.
+func (queue *Queue) Run(ctx context.Context, deviceId int, adapterId int, capability string, coalesce bool, run Command) (int, error) {
+	result := &outcome{done: make(chan struct{})}
+	command := &queuedCommand{ctx: ctx, adapterId: adapterId, capability: capability, coalesce: coalesce, run: run, outcomes: []*outcome{result}}
+	queue.lock.Lock()
+	device, ok := queue.devices[deviceId]
+	if !ok {
+		device = &deviceQueue{}
+		queue.devices[deviceId] = device
+	}
+	replaced := -1
+	if coalesce {
+		replaced = slices.IndexFunc(device.pending, func(pending *queuedCommand) bool {
+			return pending.coalesce && pending.capability == capability
+		})
+	}
+	if replaced >= 0 {
+		command.outcomes = append(device.pending[replaced].outcomes, result)
+		device.pending[replaced] = command
+	} else if len(device.pending) >= queue.maxQueued {
+		queue.lock.Unlock()
+		return 0, huma.Error429TooManyRequests(fmt.Sprintf("too many capability triggers are waiting for device %d, try again later", deviceId))
+	} else {
+		device.pending = append(device.pending, command)
+	}
+	if !device.draining {
+		device.draining = true
+		go queue.drain(deviceId, device)
+	}
+	queue.lock.Unlock()
+	select {
+	case <-result.done:
+		return result.auditId, result.err
+	case <-ctx.Done():
+		// The command fails without being sent once its turn comes
+		return 0, ctx.Err()
+	}
+}
+
+// drain runs the pending commands of a device until there are none left
+func (queue *Queue) drain(deviceId int, device *deviceQueue) {
+	for {
+		queue.lock.Lock()
+		if len(device.pending) == 0 {
+			device.draining = false
+			delete(queue.devices, deviceId)
+			queue.lock.Unlock()
+			return
+		}
+		command := device.pending[0]
+		queue.lock.Unlock()
+
+		// Commands arriving while waiting for the rate limits may still replace this one
+		err := queue.deviceLimits.Wait(command.ctx, deviceId)
+		if err == nil {
+			err = queue.adapterLimits.Wait(command.ctx, command.adapterId)
+		}
+		queue.lock.Lock()
+		if device.pending[0] != command {
+			// Replaced by a coalescing command while waiting, which is sent with the tokens waited for. Should the
+			// replaced caller have given up waiting, the replacing command waits for tokens of its own.
+			if err != nil {
+				queue.lock.Unlock()
+				continue
+			}
+			command = device.pending[0]
+		}
+		device.pending = device.pending[1:]
+		queue.lock.Unlock()
+
+		auditId := 0
+		if err == nil {
+			auditId, err = command.run(command.ctx)
+		}
+		for _, result := range command.outcomes {
+			result.auditId, result.err = auditId, err
+			close(result.done)
+		}
+	}
+}
+
+// WaitAdapter waits for the rate limit of an adapter, for capabilities triggered through it other than on devices
+func (queue *Queue) WaitAdapter(ctx context.Context, adapterId int) error {
+	return queue.adapterLimits.Wait(ctx, adapterId)
+}
@@ -0,0 +1,119 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Kaese72/device-store/internal/config"
+	"github.com/danielgtaylor/huma/v2"
+)
+
+// waitCallers waits for the number of callers waiting for queued commands of a device to become n
+func waitCallers(t *testing.T, queue *Queue, deviceId int, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		queue.lock.Lock()
+		callers := 0
+		if device, ok := queue.devices[deviceId]; ok {
+			for _, command := range device.pending {
+				callers += len(command.outcomes)
+			}
+		}
+		queue.lock.Unlock()
+		if callers == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("expected %d callers waiting for device %d", n, deviceId)
+}
+
+func TestQueue(t *testing.T) {
+	queue := NewQueue(config.CommandsConfig{MaxQueued: 2})
+	var lock sync.Mutex
+	ran := []int{}
+	command := func(auditId int) Command {
+		return func(ctx context.Context) (int, error) {
+			lock.Lock()
+			defer lock.Unlock()
+			ran = append(ran, auditId)
+			return auditId, nil
+		}
+	}
+
+	// Keep the device busy while the other commands are queued
+	started, unblock := make(chan struct{}), make(chan struct{})
+	auditIds := make([]int, 4)
+	var wg sync.WaitGroup
+	wg.Go(func() {
+		auditIds[0], _ = queue.Run(t.Context(), 1, 1, "on", false, func(ctx context.Context) (int, error) {
+			close(started)
+			<-unblock
+			return command(1)(ctx)
+		})
+	})
+	<-started
+	wg.Go(func() { auditIds[1], _ = queue.Run(t.Context(), 1, 1, "level", true, command(2)) })
+	waitCallers(t, queue, 1, 1)
+	wg.Go(func() { auditIds[2], _ = queue.Run(t.Context(), 1, 1, "off", false, command(3)) })
+	waitCallers(t, queue, 1, 2)
+	_, err := queue.Run(t.Context(), 1, 1, "off", false, command(4))
+	var statusErr huma.StatusError
+	if !errors.As(err, &statusErr) || statusErr.GetStatus() != http.StatusTooManyRequests {
+		t.Errorf("expected a full queue to fail with 429, got %v", err)
+	}
+	// Coalescing replaces the waiting command in its place even though the queue is full
+	wg.Go(func() { auditIds[3], _ = queue.Run(t.Context(), 1, 1, "level", true, command(5)) })
+	waitCallers(t, queue, 1, 3)
+	// Another device is not held up by the busy one
+	if auditId, err := queue.Run(t.Context(), 2, 1, "on", false, command(6)); err != nil || auditId != 6 {
+		t.Errorf("expected another device to run right away, got %d and %v", auditId, err)
+	}
+	close(unblock)
+	wg.Wait()
+
+	expectedRan := []int{6, 1, 5, 3}
+	if len(ran) != len(expectedRan) {
+		t.Fatalf("expected commands %v to run, got %v", expectedRan, ran)
+	}
+	for index := range ran {
+		if ran[index] != expectedRan[index] {
+			t.Fatalf("expected commands %v to run, got %v", expectedRan, ran)
+		}
+	}
+	expectedAuditIds := []int{1, 5, 3, 5}
+	for index, expected := range expectedAuditIds {
+		if auditIds[index] != expected {
+			t.Errorf("expected caller %d to get the outcome of command %d, got %d", index, expected, auditIds[index])
+		}
+	}
+}
+
+func TestQueueCoalescingKeepsSending(t *testing.T) {
+	queue := NewQueue(config.CommandsConfig{DeviceRate: 20, DeviceBurst: 1, MaxQueued: 10})
+	var lock sync.Mutex
+	sent := 0
+	run := func(ctx context.Context) (int, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		sent++
+		return sent, nil
+	}
+	// Coalesce a command every few milliseconds for half a second, much faster than the rate of 20 per second
+	var wg sync.WaitGroup
+	deadline := time.Now().Add(500 * time.Millisecond)
+	for time.Now().Before(deadline) {
+		wg.Go(func() { queue.Run(t.Context(), 1, 1, "level", true, run) })
+		time.Sleep(2 * time.Millisecond)
+	}
+	wg.Wait()
+	// One right away, then one for every token, with some slack for slow test machines
+	if sent < 5 {
+		t.Errorf("expected commands to keep being sent while coalescing, %d were sent", sent)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 
@@ -74,12 +75,320 @@ func (conf AuthConfig) Validate() error {
 	return nil
 }
 
+type AuditRetentionPolicy struct {
+	// MaxAge is the age after which audit rows are pruned. Zero disables age based pruning.
+	MaxAge time.Duration `json:"max-age" mapstructure:"max-age"`
+	// MaxRows is the number of audit rows kept per device (or group). Zero disables row count based pruning.
+	MaxRows int `json:"max-rows" mapstructure:"max-rows"`
+}
+
+func (conf AuditRetentionPolicy) Validate() error {
+	if conf.MaxAge < 0 {
+		return errors.New("audit retention max-age may not be negative")
+	}
+	if conf.MaxRows < 0 {
+		return errors.New("audit retention max-rows may not be negative")
+	}
+	return nil
+}
+
+type RetentionConfig struct {
+	Enabled                      bool                 `json:"enabled" mapstructure:"enabled"`
+	Interval                     time.Duration        `json:"interval" mapstructure:"interval"`
+	BatchSize                    int                  `json:"batch-size" mapstructure:"batch-size"`
+	AttributeAudit               AuditRetentionPolicy `json:"attribute-audit" mapstructure:"attribute-audit"`
+	DeviceCapabilityTriggerAudit AuditRetentionPolicy `json:"device-capability-trigger-audit" mapstructure:"device-capability-trigger-audit"`
+	GroupCapabilityTriggerAudit  AuditRetentionPolicy `json:"group-capability-trigger-audit" mapstructure:"group-capability-trigger-audit"`
+	SceneActivationAudit         AuditRetentionPolicy `json:"scene-activation-audit" mapstructure:"scene-activation-audit"`
+	ScheduleRuns                 AuditRetentionPolicy `json:"schedule-runs" mapstructure:"schedule-runs"`
+	RuleFirings                  AuditRetentionPolicy `json:"rule-firings" mapstructure:"rule-firings"`
+	// Tombstones of deleted devices and groups are only pruned by age. Sync tokens older than
+	// the pruned tombstones can no longer be used and clients have to perform a full sync.
+	Tombstones AuditRetentionPolicy `json:"tombstones" mapstructure:"tombstones"`
+	// IdempotencyKeys are only pruned by age. Requests repeating a pruned key are triggered again.
+	IdempotencyKeys AuditRetentionPolicy `json:"idempotency-keys" mapstructure:"idempotency-keys"`
+	// TriggerJobs are only pruned by age, counted from when they finished. Unfinished jobs are never pruned.
+	TriggerJobs AuditRetentionPolicy `json:"trigger-jobs" mapstructure:"trigger-jobs"`
+}
+
+func (conf RetentionConfig) Validate() error {
+	if !conf.Enabled {
+		return nil
+	}
+	if conf.Interval <= 0 {
+		return errors.New("must supply a positive retention interval")
+	}
+	if conf.BatchSize <= 0 {
+		return errors.New("must supply a positive retention batch size")
+	}
+	if err := conf.AttributeAudit.Validate(); err != nil {
+		return err
+	}
+	if err := conf.DeviceCapabilityTriggerAudit.Validate(); err != nil {
+		return err
+	}
+	if err := conf.GroupCapabilityTriggerAudit.Validate(); err != nil {
+		return err
+	}
+	if err := conf.SceneActivationAudit.Validate(); err != nil {
+		return err
+	}
+	if err := conf.ScheduleRuns.Validate(); err != nil {
+		return err
+	}
+	if err := conf.RuleFirings.Validate(); err != nil {
+		return err
+	}
+	if err := conf.Tombstones.Validate(); err != nil {
+		return err
+	}
+	if conf.Tombstones.MaxRows != 0 {
+		return errors.New("tombstones may only be pruned by max-age")
+	}
+	if err := conf.IdempotencyKeys.Validate(); err != nil {
+		return err
+	}
+	if conf.IdempotencyKeys.MaxRows != 0 {
+		return errors.New("idempotency keys may only be pruned by max-age")
+	}
+	if err := conf.TriggerJobs.Validate(); err != nil {
+		return err
+	}
+	if conf.TriggerJobs.MaxRows != 0 {
+		return errors.New("trigger jobs may only be pruned by max-age")
+	}
+	return nil
+}
+
+type SoftDeleteConfig struct {
+	// PurgeDelay is how long soft deleted devices and groups are kept before they, and all
+	// their history, are permanently deleted by the retention worker. Zero keeps them forever.
+	PurgeDelay time.Duration `json:"purge-delay" mapstructure:"purge-delay"`
+	// IngestBehaviour decides what happens when an adapter posts a soft deleted device or group.
+	// "resurrect" restores it with its original ID, "suppress" ignores the post and keeps it deleted.
+	IngestBehaviour string `json:"ingest-behaviour" mapstructure:"ingest-behaviour"`
+}
+
+func (conf SoftDeleteConfig) Validate() error {
+	if conf.PurgeDelay < 0 {
+		return errors.New("soft delete purge delay may not be negative")
+	}
+	if conf.IngestBehaviour != "resurrect" && conf.IngestBehaviour != "suppress" {
+		return errors.New("soft delete ingest behaviour must be one of resurrect or suppress")
+	}
+	return nil
+}
+
+type JobsConfig struct {
+	// Workers is the number of capability triggers run in the background at the same time
+	Workers int `json:"workers" mapstructure:"workers"`
+	// QueueSize is the number of background triggers waiting for a worker before new ones are refused
+	QueueSize int `json:"queue-size" mapstructure:"queue-size"`
+	// Lease is how long a job is considered running by other replicas without the replica running it renewing it
+	Lease time.Duration `json:"lease" mapstructure:"lease"`
+}
+
+func (conf JobsConfig) Validate() error {
+	if conf.Workers <= 0 {
+		return errors.New("must supply a positive number of job workers")
+	}
+	if conf.QueueSize < 0 {
+		return errors.New("job queue size may not be negative")
+	}
+	if conf.Lease < time.Second {
+		return errors.New("job lease must be at least a second")
+	}
+	return nil
+}
+
+type AdapterRetryConfig struct {
+	// Attempts is the number of times a capability trigger is sent to an adapter, including the first time
+	Attempts int `json:"attempts" mapstructure:"attempts"`
+	// InitialBackoff is the wait before the first retry. Every following wait is Multiplier times longer, up to MaxBackoff.
+	InitialBackoff time.Duration `json:"initial-backoff" mapstructure:"initial-backoff"`
+	MaxBackoff     time.Duration `json:"max-backoff" mapstructure:"max-backoff"`
+	Multiplier     float64       `json:"multiplier" mapstructure:"multiplier"`
+	// RetryableStatuses are the adapter response status codes that are retried
+	RetryableStatuses []int `json:"retryable-statuses" mapstructure:"retryable-statuses"`
+	// RetryableErrors are the kinds of failures to reach an adapter that are retried, "connection" and "timeout"
+	RetryableErrors []string `json:"retryable-errors" mapstructure:"retryable-errors"`
+}
+
+func (conf AdapterRetryConfig) Validate() error {
+	if conf.Attempts <= 0 {
+		return errors.New("must supply a positive number of adapter trigger attempts")
+	}
+	if conf.InitialBackoff < 0 || conf.MaxBackoff < conf.InitialBackoff {
+		return errors.New("adapter retry backoffs may not be negative and max-backoff may not be less than initial-backoff")
+	}
+	if conf.Multiplier < 1 {
+		return errors.New("adapter retry multiplier may not be less than 1")
+	}
+	for _, status := range conf.RetryableStatuses {
+		if status < 100 || status > 599 {
+			return errors.Errorf("adapter retryable status %d is not an HTTP status code", status)
+		}
+	}
+	for _, kind := range conf.RetryableErrors {
+		if kind != "connection" && kind != "timeout" {
+			return errors.Errorf("adapter retryable error %s must be one of connection or timeout", kind)
+		}
+	}
+	return nil
+}
+
+type CircuitBreakerConfig struct {
+	Enabled bool `json:"enabled" mapstructure:"enabled"`
+	// Window is the number of most recent triggers of an adapter its failure rate is calculated over
+	Window int `json:"window" mapstructure:"window"`
+	// MinimumTriggers is the number of triggers in the window needed before the breaker may open
+	MinimumTriggers int `json:"minimum-triggers" mapstructure:"minimum-triggers"`
+	// FailureRate is the share of failed triggers, between 0 and 1, at which the breaker opens
+	FailureRate float64 `json:"failure-rate" mapstructure:"failure-rate"`
+	// OpenDuration is how long an open breaker fails triggers fast before letting a single probing trigger through
+	OpenDuration time.Duration `json:"open-duration" mapstructure:"open-duration"`
+}
+
+func (conf CircuitBreakerConfig) Validate() error {
+	if !conf.Enabled {
+		return nil
+	}
+	if conf.Window <= 0 {
+		return errors.New("must supply a positive circuit breaker window")
+	}
+	if conf.MinimumTriggers <= 0 || conf.MinimumTriggers > conf.Window {
+		return errors.New("circuit breaker minimum-triggers must be positive and no larger than the window")
+	}
+	if conf.FailureRate <= 0 || conf.FailureRate > 1 {
+		return errors.New("circuit breaker failure-rate must be larger than 0 and at most 1")
+	}
+	if conf.OpenDuration <= 0 {
+		return errors.New("must supply a positive circuit breaker open-duration")
+	}
+	return nil
+}
+
+type BatchConfig struct {
+	// MaxItems is the number of triggers a single batch may hold
+	MaxItems int `json:"max-items" mapstructure:"max-items"`
+	// AdapterParallelism is the number of triggers of a batch sent to the same adapter at the same time
+	AdapterParallelism int `json:"adapter-parallelism" mapstructure:"adapter-parallelism"`
+}
+
+func (conf BatchConfig) Validate() error {
+	if conf.MaxItems <= 0 {
+		return errors.New("must supply a positive batch max-items")
+	}
+	if conf.AdapterParallelism <= 0 {
+		return errors.New("must supply a positive batch adapter-parallelism")
+	}
+	return nil
+}
+
+type SchedulerConfig struct {
+	Enabled bool `json:"enabled" mapstructure:"enabled"`
+	// Interval is how often due schedules are looked for
+	Interval time.Duration `json:"interval" mapstructure:"interval"`
+	// MisfireGrace is how late a run may start, for example after the store was down, before it is skipped as missed
+	MisfireGrace time.Duration `json:"misfire-grace" mapstructure:"misfire-grace"`
+	// Timezone is the IANA time zone cron expressions and sunrise and sunset days are evaluated in
+	Timezone string `json:"timezone" mapstructure:"timezone"`
+	// Latitude and Longitude locate the sun for sunrise and sunset schedules, which are refused if left out
+	Latitude  *float64 `json:"latitude" mapstructure:"latitude"`
+	Longitude *float64 `json:"longitude" mapstructure:"longitude"`
+}
+
+func (conf SchedulerConfig) Validate() error {
+	if _, err := time.LoadLocation(conf.Timezone); err != nil {
+		return errors.Wrap(err, "invalid scheduler timezone")
+	}
+	if (conf.Latitude == nil) != (conf.Longitude == nil) {
+		return errors.New("scheduler latitude and longitude must be supplied together")
+	}
+	if conf.Latitude != nil && (*conf.Latitude < -90 || *conf.Latitude > 90 || *conf.Longitude < -180 || *conf.Longitude > 180) {
+		return errors.New("scheduler latitude must be within -90 and 90 and longitude within -180 and 180")
+	}
+	if !conf.Enabled {
+		return nil
+	}
+	if conf.Interval <= 0 {
+		return errors.New("must supply a positive scheduler interval")
+	}
+	if conf.MisfireGrace < 0 {
+		return errors.New("scheduler misfire grace may not be negative")
+	}
+	return nil
+}
+
+type RulesConfig struct {
+	Enabled bool `json:"enabled" mapstructure:"enabled"`
+	// Interval is how often rules waiting for their conditions to have held long enough are evaluated
+	Interval time.Duration `json:"interval" mapstructure:"interval"`
+}
+
+func (conf RulesConfig) Validate() error {
+	if !conf.Enabled {
+		return nil
+	}
+	if conf.Interval <= 0 {
+		return errors.New("must supply a positive rules interval")
+	}
+	return nil
+}
+
+type CommandsConfig struct {
+	// DeviceRate is how many capabilities may be triggered per second on the same device, zero for no limit
+	DeviceRate float64 `json:"device-rate" mapstructure:"device-rate"`
+	// DeviceBurst is how many capabilities may be triggered on the same device at once before being paced
+	DeviceBurst int `json:"device-burst" mapstructure:"device-burst"`
+	// AdapterRate is how many capabilities may be triggered per second through the same adapter, zero for no limit
+	AdapterRate  float64 `json:"adapter-rate" mapstructure:"adapter-rate"`
+	AdapterBurst int     `json:"adapter-burst" mapstructure:"adapter-burst"`
+	// MaxQueued is how many capability triggers may wait for the same device, further triggers fail with 429
+	MaxQueued int `json:"max-queued" mapstructure:"max-queued"`
+}
+
+func (conf CommandsConfig) Validate() error {
+	if conf.DeviceRate < 0 || conf.AdapterRate < 0 {
+		return errors.New("command rates may not be negative")
+	}
+	if (conf.DeviceRate > 0 && conf.DeviceBurst <= 0) || (conf.AdapterRate > 0 && conf.AdapterBurst <= 0) {
+		return errors.New("must supply a positive command burst for every rate limit")
+	}
+	if conf.MaxQueued <= 0 {
+		return errors.New("must supply a positive number of commands queued per device")
+	}
+	return nil
+}
+
+type OutcomesConfig struct {
+	// Timeout is how long to wait for a device to report the expected outcome of a trigger
+	Timeout time.Duration `json:"timeout" mapstructure:"timeout"`
+}
+
+func (conf OutcomesConfig) Validate() error {
+	if conf.Timeout <= 0 {
+		return errors.New("must supply a positive outcome timeout")
+	}
+	return nil
+}
+
 type Config struct {
 	Database         DatabaseConfig         `json:"database" mapstructure:"database"`
 	AdapterAttendant AdapterAttendantConfig `json:"adapter-attendant" mapstructure:"adapter-attendant"`
+	AdapterRetry     AdapterRetryConfig     `json:"adapter-retry" mapstructure:"adapter-retry"`
+	CircuitBreaker   CircuitBreakerConfig   `json:"circuit-breaker" mapstructure:"circuit-breaker"`
 	DeviceIngest     DeviceIngestConfig     `json:"device-ingest" mapstructure:"device-ingest"`
 	Auth             AuthConfig             `json:"auth" mapstructure:"auth"`
 	Event            EventConfig            `json:"event" mapstructure:"event"`
+	Retention        RetentionConfig        `json:"retention" mapstructure:"retention"`
+	SoftDelete       SoftDeleteConfig       `json:"soft-delete" mapstructure:"soft-delete"`
+	Jobs             JobsConfig             `json:"jobs" mapstructure:"jobs"`
+	Batch            BatchConfig            `json:"batch" mapstructure:"batch"`
+	Scheduler        SchedulerConfig        `json:"scheduler" mapstructure:"scheduler"`
+	Rules            RulesConfig            `json:"rules" mapstructure:"rules"`
+	Commands         CommandsConfig         `json:"commands" mapstructure:"commands"`
+	Outcomes         OutcomesConfig         `json:"outcomes" mapstructure:"outcomes"`
 	PublicPort       int                    `json:"public-port" mapstructure:"public-port"`
 	InternalPort     int                    `json:"internal-port" mapstructure:"internal-port"`
 }
@@ -91,6 +400,12 @@ func (conf Config) Validate() error {
 	if err := conf.AdapterAttendant.Validate(); err != nil {
 		return err
 	}
+	if err := conf.AdapterRetry.Validate(); err != nil {
+		return err
+	}
+	if err := conf.CircuitBreaker.Validate(); err != nil {
+		return err
+	}
 	if err := conf.DeviceIngest.Validate(); err != nil {
 		return err
 	}
@@ -100,6 +415,30 @@ func (conf Config) Validate() error {
 	if err := conf.Event.Validate(); err != nil {
 		return err
 	}
+	if err := conf.Retention.Validate(); err != nil {
+		return err
+	}
+	if err := conf.SoftDelete.Validate(); err != nil {
+		return err
+	}
+	if err := conf.Jobs.Validate(); err != nil {
+		return err
+	}
+	if err := conf.Batch.Validate(); err != nil {
+		return err
+	}
+	if err := conf.Scheduler.Validate(); err != nil {
+		return err
+	}
+	if err := conf.Rules.Validate(); err != nil {
+		return err
+	}
+	if err := conf.Commands.Validate(); err != nil {
+		return err
+	}
+	if err := conf.Outcomes.Validate(); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -123,6 +462,32 @@ func init() {
 	viper.BindEnv("adapter-attendant.url")
 	viper.SetDefault("adapter-attendant.url", "http://adapter-attendant-internal:8081")
 
+	// # Adapter trigger retries
+	viper.BindEnv("adapter-retry.attempts")
+	viper.SetDefault("adapter-retry.attempts", 3)
+	viper.BindEnv("adapter-retry.initial-backoff")
+	viper.SetDefault("adapter-retry.initial-backoff", "200ms")
+	viper.BindEnv("adapter-retry.max-backoff")
+	viper.SetDefault("adapter-retry.max-backoff", "2s")
+	viper.BindEnv("adapter-retry.multiplier")
+	viper.SetDefault("adapter-retry.multiplier", 2)
+	viper.BindEnv("adapter-retry.retryable-statuses")
+	viper.SetDefault("adapter-retry.retryable-statuses", []int{502, 503, 504})
+	viper.BindEnv("adapter-retry.retryable-errors")
+	viper.SetDefault("adapter-retry.retryable-errors", []string{"connection", "timeout"})
+
+	// # Adapter circuit breakers
+	viper.BindEnv("circuit-breaker.enabled")
+	viper.SetDefault("circuit-breaker.enabled", true)
+	viper.BindEnv("circuit-breaker.window")
+	viper.SetDefault("circuit-breaker.window", 20)
+	viper.BindEnv("circuit-breaker.minimum-triggers")
+	viper.SetDefault("circuit-breaker.minimum-triggers", 5)
+	viper.BindEnv("circuit-breaker.failure-rate")
+	viper.SetDefault("circuit-breaker.failure-rate", 0.5)
+	viper.BindEnv("circuit-breaker.open-duration")
+	viper.SetDefault("circuit-breaker.open-duration", "30s")
+
 	// # Device ingest auth
 	viper.BindEnv("device-ingest.jwt-secret")
 
@@ -145,6 +510,80 @@ func init() {
 	viper.SetDefault("event.device-updates", "deviceUpdates")
 	viper.BindEnv("event.connectionstring")
 
+	// # Audit retention
+	viper.BindEnv("retention.enabled")
+	viper.SetDefault("retention.enabled", true)
+	viper.BindEnv("retention.interval")
+	viper.SetDefault("retention.interval", "1h")
+	viper.BindEnv("retention.batch-size")
+	viper.SetDefault("retention.batch-size", 1000)
+	for _, auditTable := range []string{"attribute-audit", "device-capability-trigger-audit", "group-capability-trigger-audit", "scene-activation-audit", "schedule-runs", "rule-firings"} {
+		viper.BindEnv("retention." + auditTable + ".max-age")
+		viper.SetDefault("retention."+auditTable+".max-age", "0s")
+		viper.BindEnv("retention." + auditTable + ".max-rows")
+		viper.SetDefault("retention."+auditTable+".max-rows", 0)
+	}
+	viper.BindEnv("retention.tombstones.max-age")
+	viper.SetDefault("retention.tombstones.max-age", "720h")
+	viper.BindEnv("retention.idempotency-keys.max-age")
+	viper.SetDefault("retention.idempotency-keys.max-age", "24h")
+	viper.BindEnv("retention.trigger-jobs.max-age")
+	viper.SetDefault("retention.trigger-jobs.max-age", "168h")
+
+	// # Soft deletion
+	viper.BindEnv("soft-delete.purge-delay")
+	viper.SetDefault("soft-delete.purge-delay", "720h")
+	viper.BindEnv("soft-delete.ingest-behaviour")
+	viper.SetDefault("soft-delete.ingest-behaviour", "resurrect")
+
+	// # Background capability triggers
+	viper.BindEnv("jobs.workers")
+	viper.SetDefault("jobs.workers", 4)
+	viper.BindEnv("jobs.queue-size")
+	viper.SetDefault("jobs.queue-size", 100)
+	viper.BindEnv("jobs.lease")
+	viper.SetDefault("jobs.lease", "1m")
+
+	// # Batch triggers
+	viper.BindEnv("batch.max-items")
+	viper.SetDefault("batch.max-items", 100)
+	viper.BindEnv("batch.adapter-parallelism")
+	viper.SetDefault("batch.adapter-parallelism", 4)
+
+	// # Scheduler
+	viper.BindEnv("scheduler.enabled")
+	viper.SetDefault("scheduler.enabled", true)
+	viper.BindEnv("scheduler.interval")
+	viper.SetDefault("scheduler.interval", "15s")
+	viper.BindEnv("scheduler.misfire-grace")
+	viper.SetDefault("scheduler.misfire-grace", "5m")
+	viper.BindEnv("scheduler.timezone")
+	viper.SetDefault("scheduler.timezone", "UTC")
+	viper.BindEnv("scheduler.latitude")
+	viper.BindEnv("scheduler.longitude")
+
+	// # Rules
+	viper.BindEnv("rules.enabled")
+	viper.SetDefault("rules.enabled", true)
+	viper.BindEnv("rules.interval")
+	viper.SetDefault("rules.interval", "5s")
+
+	// # Command pacing
+	viper.BindEnv("commands.device-rate")
+	viper.SetDefault("commands.device-rate", 5)
+	viper.BindEnv("commands.device-burst")
+	viper.SetDefault("commands.device-burst", 5)
+	viper.BindEnv("commands.adapter-rate")
+	viper.SetDefault("commands.adapter-rate", 50)
+	viper.BindEnv("commands.adapter-burst")
+	viper.SetDefault("commands.adapter-burst", 20)
+	viper.BindEnv("commands.max-queued")
+	viper.SetDefault("commands.max-queued", 50)
+
+	// # Trigger outcome verification
+	viper.BindEnv("outcomes.timeout")
+	viper.SetDefault("outcomes.timeout", "10s")
+
 	err := viper.Unmarshal(&Loaded)
 	if err != nil {
 		logging.Error(err.Error(), context.TODO())
@@ -11,13 +11,28 @@ import (
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
-type DeviceSubscriptions struct {
-	input      <-chan eventmodels.DeviceAttributeUpdate
-	middles    map[chan eventmodels.DeviceAttributeUpdate]struct{}
+// Subscriptions fans out events received from the message queue to every subscriber
+type Subscriptions[T any] struct {
+	input      <-chan T
+	middles    map[chan T]struct{}
 	outputLock sync.Mutex
 }
 
-func (ds *DeviceSubscriptions) startFanout() {
+type DeviceSubscriptions = Subscriptions[eventmodels.DeviceAttributeUpdate]
+
+type StoreEventSubscriptions = Subscriptions[eventmodels.StoreEvent]
+
+func newSubscriptions[T any](input <-chan T) *Subscriptions[T] {
+	subscriptionManager := &Subscriptions[T]{
+		input:      input,
+		middles:    make(map[chan T]struct{}),
+		outputLock: sync.Mutex{},
+	}
+	subscriptionManager.startFanout()
+	return subscriptionManager
+}
+
+func (ds *Subscriptions[T]) startFanout() {
 	// Start a goroutine to read from the input channel and send to all output channels
 	go func() {
 		for update := range ds.input {
@@ -30,9 +45,9 @@ func (ds *DeviceSubscriptions) startFanout() {
 	}()
 }
 
-func (ds *DeviceSubscriptions) Subscribe(ctx context.Context) <-chan eventmodels.DeviceAttributeUpdate {
-	middle := make(chan eventmodels.DeviceAttributeUpdate)
-	subscription := make(chan eventmodels.DeviceAttributeUpdate)
+func (ds *Subscriptions[T]) Subscribe(ctx context.Context) <-chan T {
+	middle := make(chan T)
+	subscription := make(chan T)
 	ds.outputLock.Lock()
 	defer ds.outputLock.Unlock()
 	ds.middles[middle] = struct{}{}
@@ -81,19 +96,37 @@ func (h *EventsConsumer) Close() error {
 // DeviceUpdates returns a channel on which we get device updates on
 // from the message queue. The channel is closed when the connection is closed.
 func (h *EventsConsumer) DeviceUpdates(ctx context.Context) (*DeviceSubscriptions, error) {
-	ch, err := h.connection.Channel()
+	out, err := consume(ctx, h.connection, deviceAttributeUpdatesExchange, func(msg amqp.Delivery) (eventmodels.DeviceAttributeUpdate, error) {
+		var update eventmodels.DeviceAttributeUpdate
+		err := json.Unmarshal(msg.Body, &update)
+		return update, err
+	})
 	if err != nil {
 		return nil, err
 	}
-	err = ch.ExchangeDeclare(
-		"deviceAttributeUpdates", // name
-		"fanout",                 // Send to all attached queues
-		true,                     // durable
-		false,                    // auto-deleted
-		false,                    // internal
-		false,                    // no-wait
-		nil,                      // arguments
-	)
+	return newSubscriptions(out), nil
+}
+
+// StoreEvents returns subscriptions to device and group lifecycle events from the message queue.
+// The message type tells which kind of event the body contains.
+func (h *EventsConsumer) StoreEvents(ctx context.Context) (*StoreEventSubscriptions, error) {
+	out, err := consume(ctx, h.connection, storeEventsExchange, func(msg amqp.Delivery) (eventmodels.StoreEvent, error) {
+		return eventmodels.DecodeStoreEvent(msg.Type, msg.Body)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newSubscriptions(out), nil
+}
+
+// consume binds an exclusive queue to exchange and returns a channel of the decoded messages.
+// The channel is closed when the connection is closed or ctx is done.
+func consume[T any](ctx context.Context, connection *amqp.Connection, exchange string, decode func(amqp.Delivery) (T, error)) (<-chan T, error) {
+	ch, err := connection.Channel()
+	if err != nil {
+		return nil, err
+	}
+	err = declareExchange(ch, exchange)
 	if err != nil {
 		return nil, err
 	}
@@ -111,11 +144,11 @@ func (h *EventsConsumer) DeviceUpdates(ctx context.Context) (*DeviceSubscription
 	// Bind the queue to the exchange, this should result in all messages
 	// sent to the exchange being delivered to this queue
 	err = ch.QueueBind(
-		q.Name,                   // queue name
-		"",                       // Routing key, not used for fanout
-		"deviceAttributeUpdates", // exchange
-		false,                    // no-wait
-		nil,                      // arguments
+		q.Name,   // queue name
+		"",       // Routing key, not used for fanout
+		exchange, // exchange
+		false,    // no-wait
+		nil,      // arguments
 	)
 	if err != nil {
 		return nil, err
@@ -132,7 +165,7 @@ func (h *EventsConsumer) DeviceUpdates(ctx context.Context) (*DeviceSubscription
 	if err != nil {
 		return nil, err
 	}
-	out := make(chan eventmodels.DeviceAttributeUpdate)
+	out := make(chan T)
 	go func() {
 		defer ch.Close()
 		clientDone := ctx.Done()
@@ -148,8 +181,7 @@ func (h *EventsConsumer) DeviceUpdates(ctx context.Context) (*DeviceSubscription
 					break EVENT_LOOP
 				}
 				// Received a message, continue processing
-				var update eventmodels.DeviceAttributeUpdate
-				err := json.Unmarshal(msg.Body, &update)
+				update, err := decode(msg)
 				if err != nil {
 					logging.ErrorErr(err, ctx, nil)
 					// Continue processing the next message even though this one failed
@@ -162,11 +194,5 @@ func (h *EventsConsumer) DeviceUpdates(ctx context.Context) (*DeviceSubscription
 		// Cleanup and terminate
 		close(out)
 	}()
-	subscriptionManager := &DeviceSubscriptions{
-		input:      out,
-		middles:    make(map[chan eventmodels.DeviceAttributeUpdate]struct{}),
-		outputLock: sync.Mutex{},
-	}
-	subscriptionManager.startFanout()
-	return subscriptionManager, nil
+	return out, nil
 }
@@ -11,6 +11,24 @@ import (
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
+const (
+	deviceAttributeUpdatesExchange = "deviceAttributeUpdates"
+	storeEventsExchange            = "storeEvents"
+)
+
+// declareExchange declares a fanout exchange, both producers and consumers declare the exchanges they use
+func declareExchange(ch *amqp.Channel, exchange string) error {
+	return ch.ExchangeDeclare(
+		exchange, // name
+		"fanout", // Send to all attached queues
+		true,     // durable
+		false,    // auto-deleted
+		false,    // internal
+		false,    // no-wait
+		nil,      // arguments
+	)
+}
+
 type EventsProducer struct {
 	connection         *amqp.Connection
 	deviceUpdatesTopic string
@@ -38,16 +56,7 @@ func (h *EventsProducer) ProduceDeviceUpdates() (chan eventmodels.DeviceAttribut
 	if err != nil {
 		return nil, err
 	}
-	// FIXME this piece of code is in two places... How to avoid duplication?
-	err = ch.ExchangeDeclare(
-		"deviceAttributeUpdates", // name
-		"fanout",                 // Send to all attached queues
-		true,                     // durable
-		false,                    // auto-deleted
-		false,                    // internal
-		false,                    // no-wait
-		nil,                      // arguments
-	)
+	err = declareExchange(ch, deviceAttributeUpdatesExchange)
 	if err != nil {
 		return nil, err
 	}
@@ -60,12 +69,51 @@ func (h *EventsProducer) ProduceDeviceUpdates() (chan eventmodels.DeviceAttribut
 				continue // We do not want to stop even if something goes wrong
 			}
 			err = ch.PublishWithContext(context.Background(),
-				"deviceAttributeUpdates",      // default exchange
-				strconv.Itoa(update.DeviceID), // routing key
-				false,                         // mandatory
-				false,                         // immediate
+				deviceAttributeUpdatesExchange, // default exchange
+				strconv.Itoa(update.DeviceID),  // routing key
+				false,                          // mandatory
+				false,                          // immediate
+				amqp.Publishing{
+					ContentType: "application/json",
+					Body:        body,
+				},
+			)
+			if err != nil {
+				logging.ErrorErr(err, context.Background())
+				continue // We do not want to stop even if something goes wrong
+			}
+		}
+	}()
+	return retChan, nil
+}
+
+// ProduceStoreEvents returns a channel on which device and group lifecycle events are
+// published to the message queue. The event name is sent as the message type.
+func (h *EventsProducer) ProduceStoreEvents() (chan eventmodels.StoreEvent, error) {
+	ch, err := h.connection.Channel()
+	if err != nil {
+		return nil, err
+	}
+	err = declareExchange(ch, storeEventsExchange)
+	if err != nil {
+		return nil, err
+	}
+	retChan := make(chan eventmodels.StoreEvent, 10)
+	go func() {
+		for event := range retChan {
+			body, err := json.Marshal(event)
+			if err != nil {
+				logging.ErrorErr(err, context.Background())
+				continue // We do not want to stop even if something goes wrong
+			}
+			err = ch.PublishWithContext(context.Background(),
+				storeEventsExchange, // exchange
+				"",                  // routing key, not used for fanout
+				false,               // mandatory
+				false,               // immediate
 				amqp.Publishing{
 					ContentType: "application/json",
+					Type:        event.EventName(),
 					Body:        body,
 				},
 			)
@@ -2,21 +2,32 @@ package ingestwebapp
 
 import (
 	"context"
+	"fmt"
+	"slices"
 
 	"github.com/Kaese72/device-store/eventmodels"
 	"github.com/Kaese72/device-store/ingestmodels"
+	"github.com/Kaese72/device-store/internal/aggregation"
+	"github.com/Kaese72/device-store/internal/arguments"
 	"github.com/Kaese72/device-store/internal/persistence"
+	"github.com/Kaese72/device-store/internal/persistence/intermediaries"
+	"github.com/danielgtaylor/huma/v2"
 )
 
 type webApp struct {
 	persistence       persistence.IngestPersistenceDB
 	deviceUpdatesChan chan eventmodels.DeviceAttributeUpdate
+	storeEventsChan   chan eventmodels.StoreEvent
+	// onDeleted decides what happens when an adapter reports a soft deleted device or group
+	onDeleted intermediaries.DeletedIngestBehaviour
 }
 
-func NewWebApp(persistence persistence.IngestPersistenceDB, deviceUpdatesChan chan eventmodels.DeviceAttributeUpdate) webApp {
+func NewWebApp(persistence persistence.IngestPersistenceDB, deviceUpdatesChan chan eventmodels.DeviceAttributeUpdate, storeEventsChan chan eventmodels.StoreEvent, onDeleted intermediaries.DeletedIngestBehaviour) webApp {
 	return webApp{
 		persistence:       persistence,
 		deviceUpdatesChan: deviceUpdatesChan,
+		storeEventsChan:   storeEventsChan,
+		onDeleted:         onDeleted,
 	}
 }
 
@@ -25,16 +36,33 @@ func (app webApp) PostDevice(ctx context.Context, input *struct {
 }) (*struct{}, error) {
 	device := input.Body
 	device.AdapterId = ctx.Value(adapterIDContextKey{}).(int)
-	deviceId, updates, err := app.persistence.PostDevice(ctx, device)
+	schemas := []map[string]any{}
+	for _, capability := range device.Capabilities {
+		schemas = append(schemas, capability.ArgumentJSONSchema)
+	}
+	if err := validateArgumentSchemas(schemas); err != nil {
+		return nil, err
+	}
+	result, err := app.persistence.PostDevice(ctx, device, app.onDeleted)
 	if err != nil {
 		return nil, err
 	}
-	if len(updates) != 0 {
+	// Lifecycle events go first so that consumers learn about a device before its attributes
+	if result.Created {
+		app.storeEventsChan <- eventmodels.DeviceCreated{DeviceID: result.DeviceID, AdapterID: device.AdapterId, BridgeIdentifier: device.BridgeIdentifier}
+	}
+	if result.Restored {
+		app.storeEventsChan <- eventmodels.DeviceRestored{DeviceID: result.DeviceID}
+	}
+	if len(result.AddedCapabilities) != 0 {
+		app.storeEventsChan <- eventmodels.DeviceCapabilitiesAdded{DeviceID: result.DeviceID, Capabilities: result.AddedCapabilities}
+	}
+	if len(result.UpdatedAttributes) != 0 {
 		deviceUpdateEvent := eventmodels.DeviceAttributeUpdate{
-			DeviceID:   deviceId,
+			DeviceID:   result.DeviceID,
 			Attributes: []eventmodels.UpdatedAttribute{},
 		}
-		for _, update := range updates {
+		for _, update := range result.UpdatedAttributes {
 			deviceUpdateEvent.Attributes = append(deviceUpdateEvent.Attributes, eventmodels.UpdatedAttribute{
 				Name:    update.Name,
 				Boolean: update.Boolean,
@@ -45,6 +73,7 @@ func (app webApp) PostDevice(ctx context.Context, input *struct {
 		}
 		app.deviceUpdatesChan <- deviceUpdateEvent
 	}
+	app.publishGroupAttributeUpdates(ctx, result)
 	return &struct{}{}, nil
 }
 
@@ -53,9 +82,73 @@ func (app webApp) PostGroup(ctx context.Context, input *struct {
 }) (*struct{}, error) {
 	group := input.Body
 	group.AdapterId = ctx.Value(adapterIDContextKey{}).(int)
-	err := app.persistence.PostGroup(ctx, group)
+	schemas := []map[string]any{}
+	for _, capability := range group.Capabilities {
+		schemas = append(schemas, capability.ArgumentJSONSchema)
+	}
+	if err := validateArgumentSchemas(schemas); err != nil {
+		return nil, err
+	}
+	result, err := app.persistence.PostGroup(ctx, group, app.onDeleted)
 	if err != nil {
 		return nil, err
 	}
+	if result.Created {
+		app.storeEventsChan <- eventmodels.GroupCreated{GroupID: result.GroupID, AdapterID: group.AdapterId, BridgeIdentifier: group.BridgeIdentifier, Name: group.Name}
+	}
+	if result.Restored {
+		app.storeEventsChan <- eventmodels.GroupRestored{GroupID: result.GroupID}
+	}
+	if len(result.AddedCapabilities) != 0 {
+		app.storeEventsChan <- eventmodels.GroupCapabilitiesAdded{GroupID: result.GroupID, Capabilities: result.AddedCapabilities}
+	}
+	if len(result.AddedDeviceIDs) != 0 || len(result.RemovedDeviceIDs) != 0 {
+		app.storeEventsChan <- eventmodels.GroupMembershipChanged{GroupID: result.GroupID, AddedDeviceIDs: nonNil(result.AddedDeviceIDs), RemovedDeviceIDs: nonNil(result.RemovedDeviceIDs)}
+		aggregation.PublishMemberChanges(ctx, app.persistence, app.storeEventsChan, nil, []int{result.GroupID}, slices.Concat(result.AddedDeviceIDs, result.RemovedDeviceIDs), nil)
+	}
 	return &struct{}{}, nil
 }
+
+// nonNil makes sure empty lists are encoded as [] rather than null in events
+func nonNil(ids []int) []int {
+	if ids == nil {
+		return []int{}
+	}
+	return ids
+}
+
+// publishGroupAttributeUpdates sends the new aggregated values of the updated attributes for every group the device is a member of,
+// directly or through contained groups, as those aggregate the attributes of the device too. A restored device counts towards
+// them again with every attribute it has.
+func (app webApp) publishGroupAttributeUpdates(ctx context.Context, result intermediaries.DevicePostResult) {
+	if result.Restored {
+		aggregation.PublishMemberChanges(ctx, app.persistence, app.storeEventsChan, []int{result.DeviceID}, nil, []int{result.DeviceID}, nil)
+		return
+	}
+	names := []string{}
+	for _, update := range result.UpdatedAttributes {
+		names = append(names, update.Name)
+	}
+	aggregation.PublishGroupAttributeUpdates(ctx, app.persistence, app.storeEventsChan, []int{result.DeviceID}, nil, names)
+}
+
+// validateArgumentSchemas makes sure every JSON Schema supplied for a capability can be used to validate arguments,
+// so that a broken schema is refused on ingest rather than failing every later trigger
+func validateArgumentSchemas(schemas []map[string]any) error {
+	details := []error{}
+	for index, schema := range schemas {
+		if schema == nil {
+			continue
+		}
+		if _, err := arguments.ParseSchema(schema); err != nil {
+			details = append(details, &huma.ErrorDetail{
+				Message:  err.Error(),
+				Location: fmt.Sprintf("body.capabilities[%d].argument-json-schema", index),
+			})
+		}
+	}
+	if len(details) > 0 {
+		return huma.Error422UnprocessableEntity("invalid capability argument schemas", details...)
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package ingestwebapp
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/Kaese72/device-store/eventmodels"
+	"github.com/Kaese72/device-store/ingestmodels"
+	"github.com/Kaese72/device-store/internal/persistence"
+	"github.com/Kaese72/device-store/internal/persistence/intermediaries"
+	"github.com/Kaese72/device-store/restmodels"
+)
+
+// aggregateCall is a request for the aggregated attributes of the groups affected by a change
+type aggregateCall struct {
+	deviceIds []int
+	groupIds  []int
+	names     []string
+}
+
+// fakePersistence aggregates the named attributes for groups 1 and 2, whatever changed, and reports every member as
+// having the attributes memberNames
+type fakePersistence struct {
+	persistence.IngestPersistenceDB
+	memberNames []string
+	group       intermediaries.GroupPostResult
+	calls       *[]aggregateCall
+}
+
+func (p fakePersistence) AggregateGroupAttributes(ctx context.Context, deviceIds []int, groupIds []int, names []string) (map[int][]restmodels.GroupAttribute, error) {
+	*p.calls = append(*p.calls, aggregateCall{deviceIds: deviceIds, groupIds: groupIds, names: names})
+	attributes := []restmodels.GroupAttribute{}
+	for _, name := range names {
+		attributes = append(attributes, restmodels.GroupAttribute{Name: name, Members: 2})
+	}
+	return map[int][]restmodels.GroupAttribute{1: attributes, 2: attributes}, nil
+}
+
+func (p fakePersistence) GetMemberAttributeNames(ctx context.Context, deviceIds []int, groupIds []int) ([]string, error) {
+	return p.memberNames, nil
+}
+
+func (p fakePersistence) PostGroup(ctx context.Context, group ingestmodels.IngestGroup, onDeleted intermediaries.DeletedIngestBehaviour) (intermediaries.GroupPostResult, error) {
+	return p.group, nil
+}
+
+func TestPublishGroupAttributeUpdates(t *testing.T) {
+	on := true
+	tests := []struct {
+		name          string
+		result        intermediaries.DevicePostResult
+		expectedNames []string
+	}{
+		{
+			name:          "Only the updated attributes are aggregated",
+			result:        intermediaries.DevicePostResult{DeviceID: 7, UpdatedAttributes: []ingestmodels.IngestAttribute{{Name: "active", Boolean: &on}}},
+			expectedNames: []string{"active"},
+		},
+		{
+			name:          "Restored devices count towards every attribute again",
+			result:        intermediaries.DevicePostResult{DeviceID: 7, Restored: true},
+			expectedNames: []string{"active", "level"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := []aggregateCall{}
+			storeEvents := make(chan eventmodels.StoreEvent, 10)
+			app := NewWebApp(fakePersistence{memberNames: []string{"active", "level"}, calls: &calls}, nil, storeEvents, "")
+			app.publishGroupAttributeUpdates(context.Background(), tt.result)
+			close(storeEvents)
+
+			if len(calls) != 1 || !slices.Equal(calls[0].deviceIds, []int{7}) || !slices.Equal(calls[0].names, tt.expectedNames) {
+				t.Fatalf("expected %v to be aggregated for the groups of device 7, got %+v", tt.expectedNames, calls)
+			}
+			groupIds := []int{}
+			for event := range storeEvents {
+				update, ok := event.(eventmodels.GroupAttributeUpdate)
+				if !ok {
+					t.Fatalf("expected group attribute updates, got %T", event)
+				}
+				if len(update.Attributes) != len(tt.expectedNames) || update.Attributes[0].Name != tt.expectedNames[0] {
+					t.Errorf("expected the attributes %v of group %d, got %+v", tt.expectedNames, update.GroupID, update.Attributes)
+				}
+				groupIds = append(groupIds, update.GroupID)
+			}
+			if !slices.Equal(groupIds, []int{1, 2}) {
+				t.Errorf("expected updates of the groups the device is a member of, directly or not, got %v", groupIds)
+			}
+		})
+	}
+
+	t.Run("Membership changes aggregate the attributes of the members that joined or left", func(t *testing.T) {
+		calls := []aggregateCall{}
+		storeEvents := make(chan eventmodels.StoreEvent, 10)
+		p := fakePersistence{
+			memberNames: []string{"level"},
+			group:       intermediaries.GroupPostResult{GroupID: 1, AddedDeviceIDs: []int{8}, RemovedDeviceIDs: []int{7}},
+			calls:       &calls,
+		}
+		app := NewWebApp(p, nil, storeEvents, "")
+		ctx := context.WithValue(context.Background(), adapterIDContextKey{}, 1)
+		if _, err := app.PostGroup(ctx, &struct {
+			Body ingestmodels.IngestGroup `body:""`
+		}{Body: ingestmodels.IngestGroup{Name: "downstairs", BridgeIdentifier: "groups/1"}}); err != nil {
+			t.Fatalf("expected the group to be posted, got %v", err)
+		}
+		close(storeEvents)
+		if len(calls) != 1 || !slices.Equal(calls[0].groupIds, []int{1}) || !slices.Equal(calls[0].names, []string{"level"}) {
+			t.Fatalf("expected the attributes of the members to be aggregated for group 1, got %+v", calls)
+		}
+		if _, ok := (<-storeEvents).(eventmodels.GroupMembershipChanged); !ok {
+			t.Errorf("expected the membership change to be published first")
+		}
+		if update, ok := (<-storeEvents).(eventmodels.GroupAttributeUpdate); !ok || update.GroupID != 1 || update.Attributes[0].Name != "level" {
+			t.Errorf("expected the aggregated attributes of group 1 to be published, got %+v", update)
+		}
+	})
+}
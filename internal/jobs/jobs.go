@@ -0,0 +1,104 @@
+// Package jobs runs work in the background on a fixed number of workers
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/Kaese72/device-store/internal/config"
+	"github.com/Kaese72/device-store/internal/logging"
+	"github.com/Kaese72/device-store/internal/persistence"
+)
+
+// Pool runs submitted jobs on a fixed number of workers, queueing a limited number of jobs waiting for a worker.
+// The lease of a job is renewed while it is queued or running, and jobs of other replicas are failed once their
+// lease expires, as the replica running them stopped.
+type Pool struct {
+	queue       chan func()
+	persistence persistence.JobsPersistenceDB
+	lease       time.Duration
+	lock        sync.Mutex
+	held        map[int]struct{}
+}
+
+// NewPool starts the workers of a pool, which stop when ctx is cancelled
+func NewPool(ctx context.Context, persistence persistence.JobsPersistenceDB, conf config.JobsConfig) *Pool {
+	pool := &Pool{
+		queue:       make(chan func(), conf.QueueSize),
+		persistence: persistence,
+		lease:       conf.Lease,
+		held:        map[int]struct{}{},
+	}
+	for range conf.Workers {
+		go pool.work(ctx)
+	}
+	go pool.renew(ctx)
+	return pool
+}
+
+// Lease is how long a job created for the pool is leased to this replica before it is first renewed
+func (pool *Pool) Lease() time.Duration {
+	return pool.lease
+}
+
+func (pool *Pool) work(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job := <-pool.queue:
+			job()
+		}
+	}
+}
+
+func (pool *Pool) renew(ctx context.Context) {
+	ticker := time.NewTicker(pool.lease / 3)
+	defer ticker.Stop()
+	for {
+		pool.lock.Lock()
+		jobIds := slices.Collect(maps.Keys(pool.held))
+		pool.lock.Unlock()
+		if err := pool.persistence.RenewTriggerJobLeases(ctx, jobIds, pool.lease); err != nil {
+			logging.Error(fmt.Sprintf("failed to renew the leases of trigger jobs: %s", err.Error()), ctx)
+		}
+		failed, err := pool.persistence.FailExpiredTriggerJobs(ctx)
+		if err != nil {
+			logging.Error(fmt.Sprintf("failed to fail expired trigger jobs: %s", err.Error()), ctx)
+		} else if failed > 0 {
+			logging.Info(fmt.Sprintf("Failed %d trigger jobs whose lease expired", failed), ctx)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Submit queues job jobId, and reports false without queueing it if the queue is full. Its lease is renewed
+// until it returns.
+func (pool *Pool) Submit(jobId int, job func()) bool {
+	pool.lock.Lock()
+	pool.held[jobId] = struct{}{}
+	pool.lock.Unlock()
+	release := func() {
+		pool.lock.Lock()
+		delete(pool.held, jobId)
+		pool.lock.Unlock()
+	}
+	select {
+	case pool.queue <- func() {
+		defer release()
+		job()
+	}:
+		return true
+	default:
+		release()
+		return false
+	}
+}
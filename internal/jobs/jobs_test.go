@@ -0,0 +1,123 @@
+package jobs
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Kaese72/device-store/internal/config"
+)
+
+type leasedJob struct {
+	expires time.Time
+	failed  bool
+}
+
+// fakeJobs keeps the leases of jobs of every replica in memory
+type fakeJobs struct {
+	lock sync.Mutex
+	jobs map[int]*leasedJob
+}
+
+func (p *fakeJobs) RenewTriggerJobLeases(ctx context.Context, jobIds []int, lease time.Duration) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for _, jobId := range jobIds {
+		if job, ok := p.jobs[jobId]; ok {
+			job.expires = time.Now().Add(lease)
+		}
+	}
+	return nil
+}
+
+func (p *fakeJobs) FailExpiredTriggerJobs(ctx context.Context) (int64, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	var failed int64
+	for _, job := range p.jobs {
+		if !job.failed && job.expires.Before(time.Now()) {
+			job.failed = true
+			failed++
+		}
+	}
+	return failed, nil
+}
+
+func (p *fakeJobs) failed(jobId int) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.jobs[jobId].failed
+}
+
+// waitFor polls condition until it holds, failing the test if it does not within timeout
+func waitFor(t *testing.T, timeout time.Duration, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("condition not met within %s", timeout)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPool(t *testing.T) {
+	t.Run("Submitting to a full queue is refused", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		pool := NewPool(ctx, &fakeJobs{jobs: map[int]*leasedJob{}}, config.JobsConfig{Workers: 1, QueueSize: 1, Lease: time.Hour})
+		started := make(chan struct{})
+		release := make(chan struct{})
+		if !pool.Submit(1, func() { close(started); <-release }) {
+			t.Fatalf("expected the first job to be accepted")
+		}
+		<-started
+		ran := make(chan struct{})
+		if !pool.Submit(2, func() { close(ran) }) {
+			t.Fatalf("expected the second job to be queued")
+		}
+		if pool.Submit(3, func() {}) {
+			t.Fatalf("expected the third job to be refused")
+		}
+		pool.lock.Lock()
+		_, held := pool.held[3]
+		pool.lock.Unlock()
+		if held {
+			t.Errorf("expected the lease of the refused job not to be renewed")
+		}
+		close(release)
+		select {
+		case <-ran:
+		case <-time.After(time.Second):
+			t.Fatalf("expected the queued job to run once a worker is free")
+		}
+	})
+
+	t.Run("Only expired jobs of other replicas are failed", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		lease := 300 * time.Millisecond
+		// Job 1 is run by this replica, jobs 2 and 3 by others of which only the one running 3 still renews it
+		persistence := &fakeJobs{jobs: map[int]*leasedJob{
+			1: {expires: time.Now().Add(lease)},
+			2: {expires: time.Now().Add(lease)},
+			3: {expires: time.Now().Add(time.Hour)},
+		}}
+		pool := NewPool(ctx, persistence, config.JobsConfig{Workers: 1, QueueSize: 1, Lease: lease})
+		release := make(chan struct{})
+		done := make(chan struct{})
+		pool.Submit(1, func() { <-release; close(done) })
+		waitFor(t, 3*lease, func() bool { return persistence.failed(2) })
+		if persistence.failed(1) || persistence.failed(3) {
+			t.Fatalf("expected the jobs whose leases are renewed to be left running")
+		}
+		// Once the job returns its lease is no longer renewed
+		close(release)
+		<-done
+		waitFor(t, 3*lease, func() bool { return persistence.failed(1) })
+		if persistence.failed(3) {
+			t.Errorf("expected the job whose lease has not expired to be left running")
+		}
+	})
+}
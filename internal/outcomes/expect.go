@@ -0,0 +1,82 @@
+// Package outcomes verifies capability triggers against the attribute states their capabilities declare
+package outcomes
+
+import (
+	"encoding/json"
+
+	"github.com/Kaese72/device-store/restmodels"
+)
+
+// Expectation is an attribute state a device is expected to report once a trigger took effect
+type Expectation struct {
+	// Attribute is the expected state, exactly one of its states is set
+	Attribute restmodels.Attribute
+	// Tolerance is how far a numeric state may be from the expected one
+	Tolerance float32
+}
+
+// Expect resolves the expected outcome of a capability for the arguments it was triggered with. Attributes taking
+// the value of an argument that was not given, or that is not a boolean, number or string, are not expected.
+func Expect(expected []restmodels.ExpectedAttribute, capArgs restmodels.DeviceCapabilityArgs) []Expectation {
+	expectations := []Expectation{}
+	for _, attribute := range expected {
+		expectation := Expectation{
+			Attribute: restmodels.Attribute{Name: attribute.Name, Boolean: attribute.Boolean, Numeric: attribute.Numeric, Text: attribute.Text},
+			Tolerance: attribute.Tolerance,
+		}
+		if attribute.Argument != nil {
+			switch value := capArgs[*attribute.Argument].(type) {
+			case bool:
+				expectation.Attribute.Boolean = &value
+			case string:
+				expectation.Attribute.Text = &value
+			case float64:
+				numeric := float32(value)
+				expectation.Attribute.Numeric = &numeric
+			case int:
+				numeric := float32(value)
+				expectation.Attribute.Numeric = &numeric
+			case json.Number:
+				parsed, err := value.Float64()
+				if err != nil {
+					continue
+				}
+				numeric := float32(parsed)
+				expectation.Attribute.Numeric = &numeric
+			default:
+				continue
+			}
+		}
+		expectations = append(expectations, expectation)
+	}
+	return expectations
+}
+
+// holds reports whether an attribute is in the expected state
+func (expectation Expectation) holds(attribute restmodels.Attribute) bool {
+	expected := expectation.Attribute
+	switch {
+	case expected.Boolean != nil:
+		return attribute.Boolean != nil && *attribute.Boolean == *expected.Boolean
+	case expected.Numeric != nil:
+		if attribute.Numeric == nil {
+			return false
+		}
+		difference := *attribute.Numeric - *expected.Numeric
+		return difference <= expectation.Tolerance && -difference <= expectation.Tolerance
+	case expected.Text != nil:
+		return attribute.Text != nil && *attribute.Text == *expected.Text
+	}
+	return false
+}
+
+// Converged reports whether the attributes of a device, by name, are in every expected state
+func Converged(expectations []Expectation, attributes map[string]restmodels.Attribute) bool {
+	for _, expectation := range expectations {
+		attribute, ok := attributes[expectation.Attribute.Name]
+		if !ok || !expectation.holds(attribute) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,63 @@
+package outcomes
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/Kaese72/device-store/restmodels"
+)
+
+func TestExpect(t *testing.T) {
+	on, mode, level := true, "heat", float32(40)
+	argument := func(name string) *string { return &name }
+	expected := []restmodels.ExpectedAttribute{
+		{Name: "on", Boolean: &on},
+		{Name: "mode", Text: &mode},
+		{Name: "level", Numeric: &level, Tolerance: 1},
+		{Name: "brightness", Argument: argument("brightness"), Tolerance: 2},
+		{Name: "scene", Argument: argument("scene")},
+		{Name: "color", Argument: argument("color")},
+	}
+	expectations := Expect(expected, restmodels.DeviceCapabilityArgs{"brightness": json.Number("80"), "color": []any{1, 2}})
+	if len(expectations) != 4 {
+		t.Fatalf("expected arguments not given or of other kinds to be left out, got %+v", expectations)
+	}
+	if numeric := expectations[3].Attribute.Numeric; numeric == nil || *numeric != 80 {
+		t.Fatalf("expected brightness to take the value of its argument, got %+v", expectations[3])
+	}
+
+	boolean := func(value bool) *bool { return &value }
+	numeric := func(value float32) *float32 { return &value }
+	text := func(value string) *string { return &value }
+	tests := []struct {
+		name       string
+		attributes map[string]restmodels.Attribute
+		expected   bool
+	}{
+		{"converged", map[string]restmodels.Attribute{
+			"on": {Boolean: boolean(true)}, "mode": {Text: text("heat")}, "level": {Numeric: numeric(40)}, "brightness": {Numeric: numeric(80)},
+		}, true},
+		{"within tolerance", map[string]restmodels.Attribute{
+			"on": {Boolean: boolean(true)}, "mode": {Text: text("heat")}, "level": {Numeric: numeric(39)}, "brightness": {Numeric: numeric(81.5)},
+		}, true},
+		{"beyond tolerance", map[string]restmodels.Attribute{
+			"on": {Boolean: boolean(true)}, "mode": {Text: text("heat")}, "level": {Numeric: numeric(38)}, "brightness": {Numeric: numeric(80)},
+		}, false},
+		{"other state", map[string]restmodels.Attribute{
+			"on": {Boolean: boolean(false)}, "mode": {Text: text("heat")}, "level": {Numeric: numeric(40)}, "brightness": {Numeric: numeric(80)},
+		}, false},
+		{"state of another kind", map[string]restmodels.Attribute{
+			"on": {Boolean: boolean(true)}, "mode": {Numeric: numeric(1)}, "level": {Numeric: numeric(40)}, "brightness": {Numeric: numeric(80)},
+		}, false},
+		{"missing attribute", map[string]restmodels.Attribute{
+			"on": {Boolean: boolean(true)}, "mode": {Text: text("heat")}, "level": {Numeric: numeric(40)},
+		}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if converged := Converged(expectations, test.attributes); converged != test.expected {
+				t.Errorf("expected %t, got %t", test.expected, converged)
+			}
+		})
+	}
+}
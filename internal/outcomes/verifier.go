@@ -0,0 +1,166 @@
+package outcomes
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Kaese72/device-store/eventmodels"
+	"github.com/Kaese72/device-store/internal/config"
+	"github.com/Kaese72/device-store/internal/logging"
+	"github.com/Kaese72/device-store/internal/persistence"
+	"github.com/Kaese72/device-store/restmodels"
+)
+
+// Verifier watches the attribute updates of devices for the expected outcomes of the triggers sent to them. Every
+// replica of the store receives every update, so a trigger is verified by the replica that sent it.
+type Verifier struct {
+	persistence persistence.OutcomesPersistenceDB
+	timeout     time.Duration
+	lock        sync.Mutex
+	// watches is keyed by device ID
+	watches map[int]map[*Watch]struct{}
+}
+
+func NewVerifier(persistence persistence.OutcomesPersistenceDB, conf config.OutcomesConfig) *Verifier {
+	return &Verifier{
+		persistence: persistence,
+		timeout:     conf.Timeout,
+		watches:     map[int]map[*Watch]struct{}{},
+	}
+}
+
+// Run passes the attribute updates of devices on to the watches of the devices until ctx is cancelled or updates
+// is closed
+func (verifier *Verifier) Run(ctx context.Context, updates <-chan eventmodels.DeviceAttributeUpdate) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case update, ok := <-updates:
+			if !ok {
+				return
+			}
+			attributes := []restmodels.Attribute{}
+			for _, attribute := range update.Attributes {
+				attributes = append(attributes, restmodels.Attribute{Name: attribute.Name, Boolean: attribute.Boolean, Numeric: attribute.Numeric, Text: attribute.Text, Updated: attribute.Updated})
+			}
+			verifier.lock.Lock()
+			for watch := range verifier.watches[update.DeviceID] {
+				watch.observe(attributes)
+			}
+			verifier.lock.Unlock()
+		}
+	}
+}
+
+// Watch collects the attributes a device reports while waiting for it to be in the expected states
+type Watch struct {
+	verifier     *Verifier
+	deviceId     int
+	expectations []Expectation
+	lock         sync.Mutex
+	// attributes are the latest states reported of the expected attributes, by name
+	attributes map[string]restmodels.Attribute
+	// changed is signalled whenever attributes changes
+	changed chan struct{}
+}
+
+// Watch starts collecting the attributes a device reports, to be done before triggering the capability so that no
+// update is missed. It returns nil if nothing is expected.
+func (verifier *Verifier) Watch(deviceId int, expectations []Expectation) *Watch {
+	if len(expectations) == 0 {
+		return nil
+	}
+	watch := &Watch{
+		verifier:     verifier,
+		deviceId:     deviceId,
+		expectations: expectations,
+		attributes:   map[string]restmodels.Attribute{},
+		changed:      make(chan struct{}, 1),
+	}
+	verifier.lock.Lock()
+	defer verifier.lock.Unlock()
+	if _, ok := verifier.watches[deviceId]; !ok {
+		verifier.watches[deviceId] = map[*Watch]struct{}{}
+	}
+	verifier.watches[deviceId][watch] = struct{}{}
+	return watch
+}
+
+// observe records reported attributes, ignoring those older than what was already reported
+func (watch *Watch) observe(attributes []restmodels.Attribute) {
+	watch.lock.Lock()
+	defer watch.lock.Unlock()
+	for _, attribute := range attributes {
+		if present, ok := watch.attributes[attribute.Name]; ok && present.Updated.After(attribute.Updated) {
+			continue
+		}
+		watch.attributes[attribute.Name] = attribute
+	}
+	select {
+	case watch.changed <- struct{}{}:
+	default:
+	}
+}
+
+func (watch *Watch) converged() bool {
+	watch.lock.Lock()
+	defer watch.lock.Unlock()
+	return Converged(watch.expectations, watch.attributes)
+}
+
+// wait waits for the device to be in the expected states, and reports false if it was not before the timeout
+func (watch *Watch) wait(timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for !watch.converged() {
+		select {
+		case <-watch.changed:
+		case <-timer.C:
+			return false
+		}
+	}
+	return true
+}
+
+// Close stops collecting attributes, closing a nil watch does nothing
+func (watch *Watch) Close() {
+	if watch == nil {
+		return
+	}
+	watch.verifier.lock.Lock()
+	defer watch.verifier.lock.Unlock()
+	delete(watch.verifier.watches[watch.deviceId], watch)
+	if len(watch.verifier.watches[watch.deviceId]) == 0 {
+		delete(watch.verifier.watches, watch.deviceId)
+	}
+}
+
+// Verify waits in the background for the device of a watch to be in the expected states, at most for the
+// configured timeout, and records the outcome on the trigger audit row. The outcome is also sent on the returned
+// channel. The watch is closed once done.
+func (verifier *Verifier) Verify(ctx context.Context, auditId int, watch *Watch) <-chan string {
+	verified := make(chan string, 1)
+	go func() {
+		defer watch.Close()
+		// The device may already be in the expected states, in which case it reports no updates
+		current, err := verifier.persistence.GetDeviceAttributes(ctx, []int{watch.deviceId})
+		if err != nil {
+			logging.Error(fmt.Sprintf("failed to get attributes of device %d to verify trigger %d: %s", watch.deviceId, auditId, err.Error()), ctx)
+		}
+		watch.observe(current[watch.deviceId])
+		outcome := restmodels.TriggerOutcomeConfirmed
+		if !watch.wait(verifier.timeout) {
+			outcome = restmodels.TriggerOutcomeUnconfirmed
+		}
+		if auditId != 0 {
+			if err := verifier.persistence.SetCapabilityTriggerAuditOutcome(ctx, auditId, outcome); err != nil {
+				logging.Error(fmt.Sprintf("failed to record outcome of trigger %d: %s", auditId, err.Error()), ctx)
+			}
+		}
+		verified <- outcome
+	}()
+	return verified
+}
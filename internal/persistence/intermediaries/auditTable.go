@@ -0,0 +1,21 @@
+package intermediaries
+
+// AuditTable identifies one of the audit tables subject to retention.
+// Tombstones of deleted devices and groups, and idempotency keys of trigger requests, are retained the same way.
+// The persistence layer maps these onto the actual database tables.
+type AuditTable string
+
+const (
+	AttributeAudits               AuditTable = "attribute-audits"
+	DeviceCapabilityTriggerAudits AuditTable = "device-capability-trigger-audits"
+	GroupCapabilityTriggerAudits  AuditTable = "group-capability-trigger-audits"
+	SceneActivationAudits         AuditTable = "scene-activation-audits"
+	ScheduleRuns                  AuditTable = "schedule-runs"
+	RuleFirings                   AuditTable = "rule-firings"
+	Tombstones                    AuditTable = "tombstones"
+	IdempotencyKeys               AuditTable = "idempotency-keys"
+	TriggerJobs                   AuditTable = "trigger-jobs"
+)
+
+// AuditTables lists every audit table, in the order they are reported and pruned
+var AuditTables = []AuditTable{AttributeAudits, DeviceCapabilityTriggerAudits, GroupCapabilityTriggerAudits, SceneActivationAudits, ScheduleRuns, RuleFirings, Tombstones, IdempotencyKeys, TriggerJobs}
@@ -0,0 +1,11 @@
+package intermediaries
+
+// DeletedIngestBehaviour decides what happens when an adapter posts a device or group that has been soft deleted
+type DeletedIngestBehaviour string
+
+const (
+	// ResurrectDeleted restores the device or group, keeping its ID and history
+	ResurrectDeleted DeletedIngestBehaviour = "resurrect"
+	// SuppressDeleted ignores the post and keeps the device or group deleted
+	SuppressDeleted DeletedIngestBehaviour = "suppress"
+)
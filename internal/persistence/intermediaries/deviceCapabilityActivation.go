@@ -1,6 +1,10 @@
 package intermediaries
 
+import "github.com/Kaese72/device-store/restmodels"
+
 type DeviceCapabilityIntermediaryActivation struct {
+	// DeviceId is the store ID of the device
+	DeviceId int
 	// BridgeIdentifier is an encoded string that contains the information
 	// needed to identify a device on the Adapter (/Bridge). It generally
 	// contains information about what type and unique ID the device has on the Adapter
@@ -10,6 +14,12 @@ type DeviceCapabilityIntermediaryActivation struct {
 	Name string
 	// AdapterId is the ID of the adapter this capability is tied to.
 	AdapterId int
+	// ArgumentSpecs are the arguments the adapter declared for the capability, nil if it declared none
+	ArgumentSpecs []restmodels.ArgumentSpec
+	// ArgumentJSONSchema is the JSON Schema the adapter supplied for the capability, nil if it supplied none
+	ArgumentJSONSchema map[string]any
+	// ExpectedOutcome is what the device reports once the capability took effect, nil if the adapter declared nothing
+	ExpectedOutcome []restmodels.ExpectedAttribute
 	// "Bridge" is the old name for "Adapter". The Key used to identiy which adapter/bridge to use
 	// BridgeKey        string
 }
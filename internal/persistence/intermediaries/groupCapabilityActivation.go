@@ -1,6 +1,10 @@
 package intermediaries
 
+import "github.com/Kaese72/device-store/restmodels"
+
 type GroupCapabilityIntermediaryActivation struct {
+	// GroupId is the store ID of the group
+	GroupId int
 	// BridgeIdentifier is an encoded string that contains the information
 	// needed to identify a group on the Adapter (/Bridge). It generally
 	// contains information about what type and unique ID the group has on the Adapter
@@ -10,6 +14,20 @@ type GroupCapabilityIntermediaryActivation struct {
 	Name string
 	// AdapterId is the ID of the adapter this capability is tied to.
 	AdapterId int
+	// ArgumentSpecs are the arguments the adapter declared for the capability, nil if it declared none
+	ArgumentSpecs []restmodels.ArgumentSpec
+	// ArgumentJSONSchema is the JSON Schema the adapter supplied for the capability, nil if it supplied none
+	ArgumentJSONSchema map[string]any
+	// StoreManaged groups have no adapter. Their capabilities are triggered on each member device instead.
+	StoreManaged bool
 	// "Bridge" is the old name for "Adapter". The Key used to identiy which adapter/bridge to use
 	// BridgeKey        string
 }
+
+// GroupTriggerTargets is what to trigger when a capability is fanned out over the members of a group
+type GroupTriggerTargets struct {
+	// Groups are contained groups having the capability themselves. Their members are not part of Devices.
+	Groups []GroupCapabilityIntermediaryActivation
+	// Devices are the remaining members having the capability
+	Devices []DeviceCapabilityIntermediaryActivation
+}
@@ -0,0 +1,8 @@
+package intermediaries
+
+// IdempotentResponse is the stored response of the first trigger request made with an idempotency key
+type IdempotentResponse struct {
+	Status int
+	// Body is the JSON encoded response body, or error model
+	Body []byte
+}
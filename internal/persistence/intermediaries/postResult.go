@@ -0,0 +1,32 @@
+package intermediaries
+
+import "github.com/Kaese72/device-store/ingestmodels"
+
+// DevicePostResult describes what changed when an adapter posted a device
+type DevicePostResult struct {
+	DeviceID int
+	// Created is set when the device did not exist before
+	Created bool
+	// Restored is set when a soft deleted device was resurrected
+	Restored bool
+	// Suppressed is set when the device is soft deleted and the post was ignored
+	Suppressed        bool
+	UpdatedAttributes []ingestmodels.IngestAttribute
+	AddedCapabilities []string
+}
+
+// GroupPostResult describes what changed when an adapter posted a group
+type GroupPostResult struct {
+	GroupID int
+	// Created is set when the group did not exist before
+	Created bool
+	// Restored is set when a soft deleted group was resurrected
+	Restored bool
+	// Suppressed is set when the group is soft deleted and the post was ignored
+	Suppressed        bool
+	AddedCapabilities []string
+	AddedDeviceIDs    []int
+	RemovedDeviceIDs  []int
+	AddedGroupIDs     []int
+	RemovedGroupIDs   []int
+}
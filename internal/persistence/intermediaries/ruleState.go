@@ -0,0 +1,12 @@
+package intermediaries
+
+import "time"
+
+// RuleState is how far a rule has come since its conditions last became true
+type RuleState struct {
+	// SatisfiedSince is when all conditions became true, nil if they are not
+	SatisfiedSince *time.Time
+	// Fired is set once the rule fired, or was due outside of its windows, while the conditions stay true
+	Fired     bool
+	LastFired *time.Time
+}
@@ -0,0 +1,411 @@
+package mariadb
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/Kaese72/device-store/restmodels"
+)
+
+func (persistence mariadbPersistence) ExportArchive(ctx context.Context, includeAudits bool) (restmodels.Archive, error) {
+	archive := restmodels.Archive{
+		ArchiveHeader: restmodels.ArchiveHeader{
+			Version:  restmodels.ArchiveVersion,
+			Exported: time.Now().UTC(),
+		},
+		Devices: []restmodels.ArchiveDevice{},
+		Groups:  []restmodels.ArchiveGroup{},
+	}
+	// Soft deleted devices and groups are exported as well, so that a restore keeps them until they are purged
+	anyDeleted := []restmodels.Filter{{Key: "deleted", Operator: "eq", Value: "any"}}
+	devices, err := persistence.GetDevices(ctx, anyDeleted)
+	if err != nil {
+		return restmodels.Archive{}, err
+	}
+	for _, device := range devices {
+		archive.Devices = append(archive.Devices, restmodels.ArchiveDevice{
+			ID:               device.ID,
+			BridgeIdentifier: device.BridgeIdentifier,
+			AdapterId:        device.AdapterId,
+			Updated:          device.Updated,
+			Attributes:       device.Attributes,
+			Capabilities:     device.Capabilities,
+			Deleted:          device.Deleted,
+		})
+	}
+	groups, err := getGroupsTx(ctx, anyDeleted, persistence.db)
+	if err != nil {
+		return restmodels.Archive{}, err
+	}
+	// Groups list their soft deleted members only when read from the membership tables directly
+	deviceMembers, err := exportMembershipsTx(ctx, `SELECT groupId, deviceId FROM groupDevices ORDER BY groupId, deviceId`, persistence.db)
+	if err != nil {
+		return restmodels.Archive{}, err
+	}
+	groupMembers, err := exportMembershipsTx(ctx, `SELECT parentGroupId, childGroupId FROM groupGroups ORDER BY parentGroupId, childGroupId`, persistence.db)
+	if err != nil {
+		return restmodels.Archive{}, err
+	}
+	for _, group := range groups {
+		group.DeviceIds = append([]int{}, deviceMembers[group.ID]...)
+		group.GroupIds = append([]int{}, groupMembers[group.ID]...)
+		archive.Groups = append(archive.Groups, archiveGroup(group))
+	}
+	if !includeAudits {
+		return archive, nil
+	}
+	archive.Audits = &restmodels.ArchiveAudits{}
+	archive.Audits.Attributes, err = persistence.GetAttributeAudits(ctx, nil)
+	if err != nil {
+		return restmodels.Archive{}, err
+	}
+	archive.Audits.DeviceCapabilityTriggers, err = exportCapabilityTriggerAudits(ctx, persistence.db)
+	if err != nil {
+		return restmodels.Archive{}, err
+	}
+	archive.Audits.GroupCapabilityTriggers, err = exportGroupCapabilityTriggerAudits(ctx, persistence.db)
+	if err != nil {
+		return restmodels.Archive{}, err
+	}
+	return archive, nil
+}
+
+// archiveGroup returns a group as it is exported. Store managed groups have no capabilities of their own, theirs are
+// worked out from their members when read, so none are exported for them.
+func archiveGroup(group restmodels.Group) restmodels.ArchiveGroup {
+	archived := restmodels.ArchiveGroup{
+		ID:               group.ID,
+		Name:             group.Name,
+		AdapterId:        group.AdapterId,
+		BridgeIdentifier: group.BridgeIdentifier,
+		Capabilities:     group.Capabilities,
+		DeviceIds:        group.DeviceIds,
+		StoreManaged:     group.StoreManaged,
+		GroupIds:         group.GroupIds,
+		Deleted:          group.Deleted,
+	}
+	if group.StoreManaged {
+		archived.Capabilities = []restmodels.GroupCapability{}
+	}
+	return archived
+}
+
+// exportMembershipsTx returns the members of every group, keyed by group ID, as listed by a query selecting pairs
+// of group ID and member ID
+func exportMembershipsTx(ctx context.Context, query string, tx queryAble) (map[int][]int, error) {
+	rows, err := tx.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	members := map[int][]int{}
+	for rows.Next() {
+		var groupId, memberId int
+		if err := rows.Scan(&groupId, &memberId); err != nil {
+			return nil, err
+		}
+		members[groupId] = append(members[groupId], memberId)
+	}
+	return members, rows.Err()
+}
+
+func exportCapabilityTriggerAudits(ctx context.Context, tx queryAble) ([]restmodels.CapabilityTriggerAudit, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT id, deviceId, name, success, errorMessage, timestamp, arguments, attempts, outcome FROM deviceCapabilityTriggerAudit ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	audits := []restmodels.CapabilityTriggerAudit{}
+	for rows.Next() {
+		var audit restmodels.CapabilityTriggerAudit
+		var attemptsBytes []byte
+		if err := rows.Scan(&audit.ID, &audit.DeviceID, &audit.Name, &audit.Success, &audit.ErrorMessage, &audit.Timestamp, &audit.Arguments, &attemptsBytes, &audit.Outcome); err != nil {
+			return nil, err
+		}
+		if audit.Attempts, err = unmarshalTriggerAttempts(attemptsBytes); err != nil {
+			return nil, err
+		}
+		audits = append(audits, audit)
+	}
+	return audits, rows.Err()
+}
+
+func exportGroupCapabilityTriggerAudits(ctx context.Context, tx queryAble) ([]restmodels.GroupCapabilityTriggerAudit, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT id, groupId, name, success, errorMessage, timestamp, arguments, attempts FROM groupCapabilityTriggerAudit ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	audits := []restmodels.GroupCapabilityTriggerAudit{}
+	for rows.Next() {
+		var audit restmodels.GroupCapabilityTriggerAudit
+		var attemptsBytes []byte
+		if err := rows.Scan(&audit.ID, &audit.GroupID, &audit.Name, &audit.Success, &audit.ErrorMessage, &audit.Timestamp, &audit.Arguments, &attemptsBytes); err != nil {
+			return nil, err
+		}
+		if audit.Attempts, err = unmarshalTriggerAttempts(attemptsBytes); err != nil {
+			return nil, err
+		}
+		audits = append(audits, audit)
+	}
+	return audits, rows.Err()
+}
+
+// ImportArchive restores an archive into the store. Devices and groups are matched to existing
+// ones by bridge identifier and adapter, and every archive ID is remapped to the ID in this store.
+// Audits are only restored for devices and groups created by the import, since existing ones
+// already have a history of their own. Devices and groups are left soft deleted or not as they
+// were when exported. A dry run performs the full import and rolls it back.
+func (persistence mariadbPersistence) ImportArchive(ctx context.Context, archive restmodels.Archive, dryRun bool) (restmodels.ImportReport, error) {
+	report := restmodels.ImportReport{
+		DryRun:    dryRun,
+		DeviceIds: []restmodels.ArchiveIdMapping{},
+		GroupIds:  []restmodels.ArchiveIdMapping{},
+		Warnings:  []string{},
+	}
+	tx, err := persistence.db.BeginTx(ctx, nil)
+	if err != nil {
+		return restmodels.ImportReport{}, err
+	}
+	defer tx.Rollback()
+
+	// Attribute inserts below generate audit rows through database triggers. Remember where
+	// they start so that they can be replaced by the archived history of created devices.
+	var auditWatermark int
+	if err := tx.QueryRowContext(ctx, `SELECT COALESCE(MAX(id), 0) FROM deviceAttributeAudit`).Scan(&auditWatermark); err != nil {
+		return restmodels.ImportReport{}, err
+	}
+
+	deviceIds := map[int]int{}
+	createdDevices := map[int]bool{}
+	for _, device := range archive.Devices {
+		storeId, created, err := importDevice(ctx, tx, device)
+		if err != nil {
+			return restmodels.ImportReport{}, err
+		}
+		deviceIds[device.ID] = storeId
+		report.DeviceIds = append(report.DeviceIds, restmodels.ArchiveIdMapping{ArchiveID: device.ID, StoreID: storeId})
+		if created {
+			createdDevices[storeId] = true
+			report.DevicesCreated++
+		} else {
+			report.DevicesUpdated++
+		}
+		report.Attributes += len(device.Attributes)
+		report.DeviceCapabilities += len(device.Capabilities)
+	}
+
+	groupIds := map[int]int{}
+	createdGroups := map[int]bool{}
+	for _, group := range archive.Groups {
+		storeId, created, err := importGroup(ctx, tx, group)
+		if err != nil {
+			return restmodels.ImportReport{}, err
+		}
+		groupIds[group.ID] = storeId
+		report.GroupIds = append(report.GroupIds, restmodels.ArchiveIdMapping{ArchiveID: group.ID, StoreID: storeId})
+		if created {
+			createdGroups[storeId] = true
+			report.GroupsCreated++
+		} else {
+			report.GroupsUpdated++
+		}
+		if !group.StoreManaged {
+			report.GroupCapabilities += len(group.Capabilities)
+		}
+		for _, archiveDeviceId := range group.DeviceIds {
+			deviceId, ok := deviceIds[archiveDeviceId]
+			if !ok {
+				report.Warnings = append(report.Warnings, fmt.Sprintf("group %d refers to device %d which is not part of the archive", group.ID, archiveDeviceId))
+				continue
+			}
+			if _, err := tx.ExecContext(ctx, `INSERT IGNORE INTO groupDevices (groupId, deviceId) VALUES (?, ?)`, storeId, deviceId); err != nil {
+				return restmodels.ImportReport{}, err
+			}
+			report.Memberships++
+		}
+	}
+	// Contained groups can only be linked once every group of the archive exists
+	for _, group := range archive.Groups {
+		for _, archiveChildId := range group.GroupIds {
+			childId, ok := groupIds[archiveChildId]
+			if !ok {
+				report.Warnings = append(report.Warnings, fmt.Sprintf("group %d refers to group %d which is not part of the archive", group.ID, archiveChildId))
+				continue
+			}
+			if _, err := tx.ExecContext(ctx, `INSERT IGNORE INTO groupGroups (parentGroupId, childGroupId) VALUES (?, ?)`, groupIds[group.ID], childId); err != nil {
+				return restmodels.ImportReport{}, err
+			}
+			report.Memberships++
+		}
+	}
+
+	if archive.Audits != nil {
+		for deviceId := range createdDevices {
+			if _, err := tx.ExecContext(ctx, `DELETE FROM deviceAttributeAudit WHERE id > ? AND deviceId = ?`, auditWatermark, deviceId); err != nil {
+				return restmodels.ImportReport{}, err
+			}
+		}
+		skipped := 0
+		for _, audit := range archive.Audits.Attributes {
+			deviceId, ok := deviceIds[audit.DeviceID]
+			if !ok || !createdDevices[deviceId] {
+				skipped++
+				continue
+			}
+			_, err := tx.ExecContext(ctx,
+				`INSERT INTO deviceAttributeAudit (deviceId, name, timestamp, oldBooleanValue, oldNumericValue, oldTextValue, newBooleanValue, newNumericValue, newTextValue) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+				deviceId, audit.Name, audit.Timestamp, audit.OldBooleanValue, audit.OldNumericValue, audit.OldTextValue, audit.NewBooleanValue, audit.NewNumericValue, audit.NewTextValue,
+			)
+			if err != nil {
+				return restmodels.ImportReport{}, err
+			}
+			report.AttributeAudits++
+		}
+		for _, audit := range archive.Audits.DeviceCapabilityTriggers {
+			deviceId, ok := deviceIds[audit.DeviceID]
+			if !ok || !createdDevices[deviceId] {
+				skipped++
+				continue
+			}
+			attemptsBytes, err := marshalTriggerAttempts(audit.Attempts)
+			if err != nil {
+				return restmodels.ImportReport{}, err
+			}
+			_, err = tx.ExecContext(ctx,
+				`INSERT INTO deviceCapabilityTriggerAudit (deviceId, name, success, errorMessage, timestamp, arguments, attempts, outcome) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+				deviceId, audit.Name, audit.Success, audit.ErrorMessage, audit.Timestamp, audit.Arguments, attemptsBytes, audit.Outcome,
+			)
+			if err != nil {
+				return restmodels.ImportReport{}, err
+			}
+			report.DeviceCapabilityTriggerAudits++
+		}
+		for _, audit := range archive.Audits.GroupCapabilityTriggers {
+			groupId, ok := groupIds[audit.GroupID]
+			if !ok || !createdGroups[groupId] {
+				skipped++
+				continue
+			}
+			attemptsBytes, err := marshalTriggerAttempts(audit.Attempts)
+			if err != nil {
+				return restmodels.ImportReport{}, err
+			}
+			_, err = tx.ExecContext(ctx,
+				`INSERT INTO groupCapabilityTriggerAudit (groupId, name, success, errorMessage, timestamp, arguments, attempts) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+				groupId, audit.Name, audit.Success, audit.ErrorMessage, audit.Timestamp, audit.Arguments, attemptsBytes,
+			)
+			if err != nil {
+				return restmodels.ImportReport{}, err
+			}
+			report.GroupCapabilityTriggerAudits++
+		}
+		if skipped > 0 {
+			report.Warnings = append(report.Warnings, fmt.Sprintf("skipped %d audit rows belonging to devices or groups that already existed or are not part of the archive", skipped))
+		}
+	}
+
+	if dryRun {
+		return report, nil
+	}
+	return report, tx.Commit()
+}
+
+func importDevice(ctx context.Context, tx queryAble, device restmodels.ArchiveDevice) (int, bool, error) {
+	var deviceId int
+	created := false
+	err := tx.QueryRowContext(ctx, `SELECT id FROM devices WHERE bridgeIdentifier = ? AND adapterId = ?`, device.BridgeIdentifier, device.AdapterId).Scan(&deviceId)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, false, err
+	}
+	if err == sql.ErrNoRows {
+		err = tx.QueryRowContext(ctx, `INSERT INTO devices (bridgeIdentifier, adapterId, updated, deleted) VALUES (?, ?, ?, ?) RETURNING id`, device.BridgeIdentifier, device.AdapterId, device.Updated, device.Deleted).Scan(&deviceId)
+		if err != nil {
+			return 0, false, err
+		}
+		created = true
+	} else {
+		_, err = tx.ExecContext(ctx, `UPDATE devices SET updated = GREATEST(updated, ?), deleted = ? WHERE id = ?`, device.Updated, device.Deleted, deviceId)
+		if err != nil {
+			return 0, false, err
+		}
+	}
+	for _, attribute := range device.Attributes {
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO deviceAttributes (deviceId, name, booleanValue, numericValue, textValue, updated) VALUES (?, ?, ?, ?, ?, ?) ON DUPLICATE KEY UPDATE booleanValue = VALUES(booleanValue), numericValue = VALUES(numericValue), textValue = VALUES(textValue), updated = VALUES(updated)`,
+			deviceId, attribute.Name, toDbBoolean(attribute.Boolean), attribute.Numeric, attribute.Text, attribute.Updated,
+		)
+		if err != nil {
+			return 0, false, err
+		}
+	}
+	for _, capability := range device.Capabilities {
+		argumentsJsonSchema, err := json.Marshal(capability.ArgumentSpecs)
+		if err != nil {
+			return 0, false, err
+		}
+		argumentStandardSchema, err := marshalArgumentSchema(capability.ArgumentJSONSchema)
+		if err != nil {
+			return 0, false, err
+		}
+		expectedOutcome, err := marshalExpectedOutcome(capability.ExpectedOutcome)
+		if err != nil {
+			return 0, false, err
+		}
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO deviceCapabilities (deviceId, name, argumentJsonSchema, argumentStandardSchema, expectedOutcome, updated) VALUES (?, ?, ?, ?, ?, ?) ON DUPLICATE KEY UPDATE argumentJsonSchema = VALUES(argumentJsonSchema), argumentStandardSchema = VALUES(argumentStandardSchema), expectedOutcome = VALUES(expectedOutcome), updated = VALUES(updated)`,
+			deviceId, capability.Name, argumentsJsonSchema, argumentStandardSchema, expectedOutcome, capability.Updated,
+		)
+		if err != nil {
+			return 0, false, err
+		}
+	}
+	return deviceId, created, nil
+}
+
+func importGroup(ctx context.Context, tx queryAble, group restmodels.ArchiveGroup) (int, bool, error) {
+	var groupId int
+	created := false
+	err := tx.QueryRowContext(ctx, `SELECT id FROM groups WHERE bridgeIdentifier = ? AND adapterId = ?`, group.BridgeIdentifier, group.AdapterId).Scan(&groupId)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, false, err
+	}
+	if err == sql.ErrNoRows {
+		err = tx.QueryRowContext(ctx, `INSERT INTO groups (bridgeIdentifier, adapterId, name, storeManaged, deleted) VALUES (?, ?, ?, ?, ?) RETURNING id`, group.BridgeIdentifier, group.AdapterId, group.Name, group.StoreManaged, group.Deleted).Scan(&groupId)
+		if err != nil {
+			return 0, false, err
+		}
+		created = true
+	} else {
+		_, err = tx.ExecContext(ctx, `UPDATE groups SET name = ?, deleted = ? WHERE id = ?`, group.Name, group.Deleted, groupId)
+		if err != nil {
+			return 0, false, err
+		}
+	}
+	if group.StoreManaged {
+		// Archives exported before store managed groups were left without capabilities list the capabilities of
+		// their members. Storing those would have the group triggered through an adapter it does not have.
+		return groupId, created, nil
+	}
+	for _, capability := range group.Capabilities {
+		argumentsJsonSchema, err := json.Marshal(capability.ArgumentSpecs)
+		if err != nil {
+			return 0, false, err
+		}
+		argumentStandardSchema, err := marshalArgumentSchema(capability.ArgumentJSONSchema)
+		if err != nil {
+			return 0, false, err
+		}
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO groupCapabilities (groupId, name, argumentJsonSchema, argumentStandardSchema, updated) VALUES (?, ?, ?, ?, ?) ON DUPLICATE KEY UPDATE argumentJsonSchema = VALUES(argumentJsonSchema), argumentStandardSchema = VALUES(argumentStandardSchema), updated = VALUES(updated)`,
+			groupId, capability.Name, argumentsJsonSchema, argumentStandardSchema, capability.Updated,
+		)
+		if err != nil {
+			return 0, false, err
+		}
+	}
+	return groupId, created, nil
+}
@@ -0,0 +1,203 @@
+package mariadb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Kaese72/device-store/restmodels"
+)
+
+type tableGroup struct {
+	id               int64
+	bridgeIdentifier string
+	adapterId        int64
+	storeManaged     bool
+	deleted          bool
+	capabilities     []string
+}
+
+// groupTables is a database of groups answering the statements made while importing groups and looking up
+// their capabilities for activation
+type groupTables struct {
+	groups []*tableGroup
+}
+
+func (tables *groupTables) Open(name string) (driver.Conn, error) { return tables, nil }
+func (tables *groupTables) Connect(context.Context) (driver.Conn, error) {
+	return tables, nil
+}
+func (tables *groupTables) Driver() driver.Driver { return tables }
+func (tables *groupTables) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("not supported")
+}
+func (tables *groupTables) Close() error              { return nil }
+func (tables *groupTables) Begin() (driver.Tx, error) { return nil, errors.New("not supported") }
+
+func (tables *groupTables) group(id int64) *tableGroup {
+	for _, group := range tables.groups {
+		if group.id == id {
+			return group
+		}
+	}
+	return nil
+}
+
+func (tables *groupTables) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	switch {
+	case query == `SELECT id FROM groups WHERE bridgeIdentifier = ? AND adapterId = ?`:
+		for _, group := range tables.groups {
+			if group.bridgeIdentifier == args[0].Value && group.adapterId == args[1].Value {
+				return &tableRows{rows: [][]driver.Value{{group.id}}}, nil
+			}
+		}
+		return &tableRows{}, nil
+	case strings.HasPrefix(query, "INSERT INTO groups "):
+		group := &tableGroup{
+			id:               int64(len(tables.groups) + 1),
+			bridgeIdentifier: args[0].Value.(string),
+			adapterId:        args[1].Value.(int64),
+			storeManaged:     args[3].Value.(bool),
+			deleted:          args[4].Value != nil,
+		}
+		tables.groups = append(tables.groups, group)
+		return &tableRows{rows: [][]driver.Value{{group.id}}}, nil
+	case strings.HasPrefix(query, "SELECT bridgeIdentifier, groupCapabilities.name, adapterId"):
+		group := tables.group(args[0].Value.(int64))
+		for _, name := range group.capabilities {
+			if name == args[1].Value && !group.deleted {
+				return &tableRows{rows: [][]driver.Value{{group.bridgeIdentifier, name, group.adapterId, nil, nil}}}, nil
+			}
+		}
+		return &tableRows{}, nil
+	case query == `SELECT storeManaged FROM groups WHERE id = ? AND deleted IS NULL`:
+		if group := tables.group(args[0].Value.(int64)); group != nil && !group.deleted {
+			return &tableRows{rows: [][]driver.Value{{group.storeManaged}}}, nil
+		}
+		return &tableRows{}, nil
+	}
+	return nil, errors.New("unexpected query " + query)
+}
+
+func (tables *groupTables) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	switch {
+	case query == `UPDATE groups SET name = ?, deleted = ? WHERE id = ?`:
+		tables.group(args[2].Value.(int64)).deleted = args[1].Value != nil
+	case strings.HasPrefix(query, "INSERT INTO groupCapabilities "):
+		group := tables.group(args[0].Value.(int64))
+		group.capabilities = append(group.capabilities, args[1].Value.(string))
+	default:
+		return nil, errors.New("unexpected statement " + query)
+	}
+	return driver.RowsAffected(1), nil
+}
+
+// tableRows is a result of any number of columns
+type tableRows struct {
+	rows [][]driver.Value
+}
+
+func (rows *tableRows) Columns() []string {
+	if len(rows.rows) == 0 {
+		return []string{"id"}
+	}
+	return make([]string, len(rows.rows[0]))
+}
+func (rows *tableRows) Close() error { return nil }
+func (rows *tableRows) Next(dest []driver.Value) error {
+	if len(rows.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, rows.rows[0])
+	rows.rows = rows.rows[1:]
+	return nil
+}
+
+func TestArchiveStoreGroup(t *testing.T) {
+	// The capabilities of store managed groups are those of their members when read
+	group := restmodels.Group{
+		ID:               7,
+		Name:             "downstairs",
+		BridgeIdentifier: "0b4f2a4e-2d0c-4f6b-9c47-8f0f0f8e7a11",
+		Capabilities:     []restmodels.GroupCapability{{Name: "dim"}},
+		DeviceIds:        []int{1, 2},
+		StoreManaged:     true,
+	}
+	exported := archiveGroup(group)
+	if len(exported.Capabilities) != 0 {
+		t.Fatalf("expected no capabilities to be exported for a store managed group, got %v", exported.Capabilities)
+	}
+	// Archives exported before store managed groups were left without capabilities list them all the same
+	older := exported
+	older.Capabilities = group.Capabilities
+
+	tests := []struct {
+		name     string
+		archived restmodels.ArchiveGroup
+	}{
+		{name: "Current archive", archived: exported},
+		{name: "Older archive", archived: older},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tables := &groupTables{}
+			db := sql.OpenDB(tables)
+			defer db.Close()
+			ctx := context.Background()
+			groupId, created, err := importGroup(ctx, db, tt.archived)
+			if err != nil || !created {
+				t.Fatalf("expected the group to be created, got %v", err)
+			}
+			if capabilities := tables.group(int64(groupId)).capabilities; len(capabilities) != 0 {
+				t.Errorf("expected no capabilities to be stored, got %v", capabilities)
+			}
+			// Triggering the restored group fans out over its members rather than going through an adapter
+			capability, err := mariadbPersistence{db: db}.GetGroupCapabilityForActivation(ctx, groupId, "dim")
+			if err != nil {
+				t.Fatalf("expected the capability to be found, got %v", err)
+			}
+			if !capability.StoreManaged || capability.AdapterId != 0 {
+				t.Errorf("expected the capability of a store managed group, got %+v", capability)
+			}
+		})
+	}
+}
+
+func TestArchiveDeletedGroup(t *testing.T) {
+	deleted := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		existing []*tableGroup
+		deleted  *time.Time
+		expected bool
+	}{
+		{name: "New deleted group", deleted: &deleted, expected: true},
+		{name: "New group", expected: false},
+		{name: "Existing group deleted in the archive", existing: []*tableGroup{{id: 1, bridgeIdentifier: "hallway", adapterId: 2}}, deleted: &deleted, expected: true},
+		{name: "Deleted group restored by the archive", existing: []*tableGroup{{id: 1, bridgeIdentifier: "hallway", adapterId: 2, deleted: true}}, expected: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tables := &groupTables{groups: tt.existing}
+			db := sql.OpenDB(tables)
+			defer db.Close()
+			groupId, _, err := importGroup(context.Background(), db, restmodels.ArchiveGroup{
+				Name:             "hallway",
+				AdapterId:        2,
+				BridgeIdentifier: "hallway",
+				Deleted:          tt.deleted,
+			})
+			if err != nil {
+				t.Fatalf("expected the group to be imported, got %v", err)
+			}
+			if tables.group(int64(groupId)).deleted != tt.expected {
+				t.Errorf("expected deleted to be %v", tt.expected)
+			}
+		})
+	}
+}
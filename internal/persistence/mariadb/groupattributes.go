@@ -0,0 +1,96 @@
+package mariadb
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	"github.com/Kaese72/device-store/internal/aggregation"
+	"github.com/Kaese72/device-store/restmodels"
+)
+
+func (persistence mariadbPersistence) AggregateGroupAttributes(ctx context.Context, deviceIds []int, groupIds []int, names []string) (map[int][]restmodels.GroupAttribute, error) {
+	aggregates := map[int][]restmodels.GroupAttribute{}
+	if len(names) == 0 {
+		return aggregates, nil
+	}
+	affected := []int{}
+	for _, deviceId := range deviceIds {
+		ids, err := queryIdsTx(ctx, persistence.db, ancestorGroupsCTE+`SELECT id FROM ancestors`, deviceId)
+		if err != nil {
+			return nil, err
+		}
+		affected = append(affected, ids...)
+	}
+	for _, groupId := range groupIds {
+		ids, err := queryIdsTx(ctx, persistence.db, containingGroupsCTE+`SELECT id FROM ancestors`, groupId)
+		if err != nil {
+			return nil, err
+		}
+		affected = append(affected, ids...)
+	}
+	slices.Sort(affected)
+	affected = slices.Compact(affected)
+	members := map[int][]int{}
+	memberIds := []int{}
+	for _, groupId := range affected {
+		ids, err := getEffectiveDeviceIdsTx(ctx, groupId, persistence.db)
+		if err != nil {
+			return nil, err
+		}
+		members[groupId] = ids
+		memberIds = append(memberIds, ids...)
+	}
+	slices.Sort(memberIds)
+	deviceAttributes, err := getDeviceAttributesTx(ctx, persistence.db, slices.Compact(memberIds), names)
+	if err != nil {
+		return nil, err
+	}
+	for _, groupId := range affected {
+		memberAttributes := [][]restmodels.Attribute{}
+		for _, deviceId := range members[groupId] {
+			memberAttributes = append(memberAttributes, deviceAttributes[deviceId])
+		}
+		attributes := aggregation.GroupAttributes(memberAttributes)
+		for _, name := range names {
+			if !slices.ContainsFunc(attributes, func(attribute restmodels.GroupAttribute) bool { return attribute.Name == name }) {
+				attributes = append(attributes, restmodels.GroupAttribute{Name: name})
+			}
+		}
+		slices.SortFunc(attributes, func(a, b restmodels.GroupAttribute) int { return strings.Compare(a.Name, b.Name) })
+		aggregates[groupId] = attributes
+	}
+	return aggregates, nil
+}
+
+func (persistence mariadbPersistence) GetMemberAttributeNames(ctx context.Context, deviceIds []int, groupIds []int) ([]string, error) {
+	memberIds := slices.Clone(deviceIds)
+	for _, groupId := range groupIds {
+		ids, err := getEffectiveDeviceIdsTx(ctx, groupId, persistence.db)
+		if err != nil {
+			return nil, err
+		}
+		memberIds = append(memberIds, ids...)
+	}
+	names := []string{}
+	if len(memberIds) == 0 {
+		return names, nil
+	}
+	variables := make([]any, 0, len(memberIds))
+	for _, deviceId := range memberIds {
+		variables = append(variables, deviceId)
+	}
+	rows, err := persistence.db.QueryContext(ctx, `SELECT DISTINCT name FROM deviceAttributes WHERE deviceId IN (`+strings.TrimSuffix(strings.Repeat("?,", len(memberIds)), ",")+`) ORDER BY name`, variables...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
@@ -0,0 +1,51 @@
+package mariadb
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/Kaese72/device-store/internal/persistence/intermediaries"
+	"github.com/danielgtaylor/huma/v2"
+)
+
+func (persistence mariadbPersistence) ClaimIdempotencyKey(ctx context.Context, key string, request string) (*intermediaries.IdempotentResponse, error) {
+	result, err := persistence.db.ExecContext(ctx, `INSERT IGNORE INTO triggerIdempotencyKeys (idempotencyKey, request) VALUES (?, ?)`, key, request)
+	if err != nil {
+		return nil, err
+	}
+	claimed, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if claimed == 1 {
+		return nil, nil
+	}
+	var claimedRequest string
+	var status *int
+	var body []byte
+	err = persistence.db.QueryRowContext(ctx, `SELECT request, status, response FROM triggerIdempotencyKeys WHERE idempotencyKey = ?`, key).Scan(&claimedRequest, &status, &body)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			// Released by the request that claimed it in the meantime
+			return nil, huma.Error409Conflict("a request with this idempotency key is in progress")
+		}
+		return nil, err
+	}
+	if claimedRequest != request {
+		return nil, huma.Error422UnprocessableEntity("the idempotency key was used for a different request")
+	}
+	if status == nil {
+		return nil, huma.Error409Conflict("a request with this idempotency key is in progress")
+	}
+	return &intermediaries.IdempotentResponse{Status: *status, Body: body}, nil
+}
+
+func (persistence mariadbPersistence) StoreIdempotentResponse(ctx context.Context, key string, response intermediaries.IdempotentResponse) error {
+	_, err := persistence.db.ExecContext(ctx, `UPDATE triggerIdempotencyKeys SET status = ?, response = ? WHERE idempotencyKey = ?`, response.Status, response.Body, key)
+	return err
+}
+
+func (persistence mariadbPersistence) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	_, err := persistence.db.ExecContext(ctx, `DELETE FROM triggerIdempotencyKeys WHERE idempotencyKey = ? AND status IS NULL`, key)
+	return err
+}
@@ -0,0 +1,101 @@
+package mariadb
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Kaese72/device-store/restmodels"
+	"github.com/danielgtaylor/huma/v2"
+)
+
+func (persistence mariadbPersistence) CreateTriggerJob(ctx context.Context, job restmodels.TriggerJob, lease time.Duration) (int, error) {
+	arguments, err := json.Marshal(job.Arguments)
+	if err != nil {
+		return 0, err
+	}
+	var jobId int
+	err = persistence.db.QueryRowContext(ctx,
+		`INSERT INTO triggerJobs (deviceId, groupId, name, arguments, status, leaseExpires) VALUES (?, ?, ?, ?, ?, NOW() + INTERVAL ? SECOND) RETURNING id`,
+		job.DeviceID, job.GroupID, job.Name, string(arguments), restmodels.TriggerJobQueued, int(lease.Seconds()),
+	).Scan(&jobId)
+	return jobId, err
+}
+
+func (persistence mariadbPersistence) StartTriggerJob(ctx context.Context, jobId int) error {
+	_, err := persistence.db.ExecContext(ctx, `UPDATE triggerJobs SET status = ?, started = NOW() WHERE id = ?`, restmodels.TriggerJobRunning, jobId)
+	return err
+}
+
+func (persistence mariadbPersistence) FinishTriggerJob(ctx context.Context, jobId int, status string, errorMessage *string, auditId *int, groupResult *restmodels.GroupTriggerResult) error {
+	var groupResultBytes []byte
+	if groupResult != nil {
+		var err error
+		groupResultBytes, err = json.Marshal(groupResult)
+		if err != nil {
+			return err
+		}
+	}
+	_, err := persistence.db.ExecContext(ctx,
+		`UPDATE triggerJobs SET status = ?, errorMessage = ?, auditId = ?, groupResult = ?, finished = NOW() WHERE id = ?`,
+		status, errorMessage, auditId, groupResultBytes, jobId,
+	)
+	return err
+}
+
+func (persistence mariadbPersistence) GetTriggerJob(ctx context.Context, jobId int) (restmodels.TriggerJob, error) {
+	job := restmodels.TriggerJob{}
+	var arguments string
+	var groupResultBytes []byte
+	err := persistence.db.QueryRowContext(ctx,
+		`SELECT id, deviceId, groupId, name, arguments, status, errorMessage, auditId, groupResult, created, started, finished FROM triggerJobs WHERE id = ?`,
+		jobId,
+	).Scan(&job.ID, &job.DeviceID, &job.GroupID, &job.Name, &arguments, &job.Status, &job.ErrorMessage, &job.AuditID, &groupResultBytes, &job.Created, &job.Started, &job.Finished)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return restmodels.TriggerJob{}, huma.Error404NotFound(fmt.Sprintf("job %d not found", jobId))
+		}
+		return restmodels.TriggerJob{}, err
+	}
+	if err := json.Unmarshal([]byte(arguments), &job.Arguments); err != nil {
+		return restmodels.TriggerJob{}, err
+	}
+	if groupResultBytes != nil {
+		job.GroupResult = &restmodels.GroupTriggerResult{}
+		if err := json.Unmarshal(groupResultBytes, job.GroupResult); err != nil {
+			return restmodels.TriggerJob{}, err
+		}
+	}
+	return job, nil
+}
+
+func (persistence mariadbPersistence) RenewTriggerJobLeases(ctx context.Context, jobIds []int, lease time.Duration) error {
+	if len(jobIds) == 0 {
+		return nil
+	}
+	placeholders := make([]string, 0, len(jobIds))
+	args := []any{int(lease.Seconds())}
+	for _, jobId := range jobIds {
+		placeholders = append(placeholders, "?")
+		args = append(args, jobId)
+	}
+	_, err := persistence.db.ExecContext(ctx,
+		`UPDATE triggerJobs SET leaseExpires = NOW() + INTERVAL ? SECOND WHERE id IN (`+strings.Join(placeholders, ",")+`)`,
+		args...,
+	)
+	return err
+}
+
+func (persistence mariadbPersistence) FailExpiredTriggerJobs(ctx context.Context) (int64, error) {
+	result, err := persistence.db.ExecContext(ctx,
+		`UPDATE triggerJobs SET status = ?, errorMessage = 'interrupted by the device store replica running it stopping', finished = NOW() WHERE status IN (?, ?) AND (leaseExpires IS NULL OR leaseExpires < NOW())`,
+		restmodels.TriggerJobFailed, restmodels.TriggerJobQueued, restmodels.TriggerJobRunning,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -12,6 +12,7 @@ import (
 	"time"
 
 	"github.com/Kaese72/device-store/ingestmodels"
+	"github.com/Kaese72/device-store/internal/aggregation"
 	"github.com/Kaese72/device-store/internal/config"
 	"github.com/Kaese72/device-store/internal/logging"
 	"github.com/Kaese72/device-store/internal/persistence/intermediaries"
@@ -50,6 +51,42 @@ var deviceFilters = map[string]map[string]func(string) (string, []string, error)
 			return "", nil, huma.Error400BadRequest("id filter must be an integer value")
 		},
 	},
+	"updated": {
+		"gt": func(value string) (string, []string, error) {
+			return "updated > ?", []string{value}, validateTimestamp(value)
+		},
+		"lt": func(value string) (string, []string, error) {
+			return "updated < ?", []string{value}, validateTimestamp(value)
+		},
+	},
+	"deleted": {
+		"eq": deletedFilter,
+	},
+	"effective-group-id": {
+		"eq": effectiveGroupIdFilter,
+	},
+}
+
+// deletedFilter selects soft deleted ("true"), not deleted ("false") or all ("any") objects.
+// Soft deleted objects are excluded unless this filter is used.
+func deletedFilter(value string) (string, []string, error) {
+	switch value {
+	case "true":
+		return "deleted IS NOT NULL", []string{}, nil
+	case "false":
+		return "deleted IS NULL", []string{}, nil
+	case "any":
+		return "TRUE", []string{}, nil
+	}
+	return "", nil, huma.Error400BadRequest("deleted filter must be one of true, false, or any")
+}
+
+// withDeletedFilter adds the default filter excluding soft deleted objects, unless the caller filters on deletion themselves
+func withDeletedFilter(filters []restmodels.Filter) []restmodels.Filter {
+	if slices.ContainsFunc(filters, func(filter restmodels.Filter) bool { return filter.Key == "deleted" }) {
+		return filters
+	}
+	return append(slices.Clone(filters), restmodels.Filter{Key: "deleted", Operator: "eq", Value: "false"})
 }
 
 // validateTimestamp validates that the valis is on the format 'YYYY-MM-DD' or 'YYYY-MM-DD HH:MM:SS'
@@ -101,59 +138,22 @@ var deviceAttributeAuditFilters = map[string]map[string]func(string) (string, []
 }
 
 type GetDevicesCapabilityIntermediate struct {
-	Name          string    `json:"name"`
-	Updated       time.Time `json:"updated"`
-	ArgumentSpecs []struct {
-		Name    string `json:"name"`
-		Boolean *struct {
-			Default *bool `json:"default,omitempty"`
-		} `json:"boolean,omitempty"`
-		Numeric *struct {
-			Min     float32  `json:"min"`
-			Max     float32  `json:"max"`
-			Default *float32 `json:"default,omitempty"`
-		} `json:"numeric,omitempty"`
-		Text *struct {
-			Default *string `json:"default,omitempty"`
-			Min     *int    `json:"min,omitempty"`
-			Max     *int    `json:"max,omitempty"`
-		} `json:"text,omitempty"`
-	} `json:"argument-specs"`
+	Name               string         `json:"name"`
+	Updated            time.Time      `json:"updated"`
+	ArgumentJSONSchema map[string]any `json:"argument-json-schema"`
+	// ArgumentSpecs is null for capabilities stored before argument specs existed, which is kept apart from an
+	// empty list since only the latter restricts the arguments of the capability
+	ArgumentSpecs   []restmodels.ArgumentSpec      `json:"argument-specs"`
+	ExpectedOutcome []restmodels.ExpectedAttribute `json:"expected-outcome"`
 }
 
 func (i GetDevicesCapabilityIntermediate) toRest() restmodels.DeviceCapability {
 	return restmodels.DeviceCapability{
-		Name:    i.Name,
-		Updated: i.Updated,
-		ArgumentSpecs: func() []restmodels.ArgumentSpec {
-			var specs []restmodels.ArgumentSpec
-			for _, argSpec := range i.ArgumentSpecs {
-				spec := restmodels.ArgumentSpec{
-					Name: argSpec.Name,
-				}
-				if argSpec.Boolean != nil {
-					spec.Boolean = &restmodels.BooleanArgumentSpec{
-						Default: argSpec.Boolean.Default,
-					}
-				}
-				if argSpec.Numeric != nil {
-					spec.Numeric = &restmodels.NumericArgumentSpec{
-						Min:     argSpec.Numeric.Min,
-						Max:     argSpec.Numeric.Max,
-						Default: argSpec.Numeric.Default,
-					}
-				}
-				if argSpec.Text != nil {
-					spec.Text = &restmodels.TextArgumentSpec{
-						Default: argSpec.Text.Default,
-						Min:     argSpec.Text.Min,
-						Max:     argSpec.Text.Max,
-					}
-				}
-				specs = append(specs, spec)
-			}
-			return specs
-		}(),
+		Name:               i.Name,
+		Updated:            i.Updated,
+		ArgumentJSONSchema: i.ArgumentJSONSchema,
+		ArgumentSpecs:      i.ArgumentSpecs,
+		ExpectedOutcome:    i.ExpectedOutcome,
 	}
 }
 
@@ -192,13 +192,14 @@ func (persistence mariadbPersistence) GetDevices(ctx context.Context, filters []
 		"bridgeIdentifier",
 		"adapterId",
 		"updated",
+		"deleted",
 		"(SELECT COALESCE(JSON_ARRAYAGG(JSON_OBJECT(\"name\", name, \"boolean\", booleanValue, \"numeric\", numericValue, \"text\", textValue, \"updated\", DATE_FORMAT(updated, '%Y-%m-%dT%H:%i:%sZ'))), JSON_ARRAY()) FROM deviceAttributes WHERE deviceAttributes.deviceId = devices.id) as attributes",
-		"(SELECT COALESCE(JSON_ARRAYAGG(JSON_OBJECT(\"name\", name, \"argument-specs\", argumentJsonSchema, \"updated\", DATE_FORMAT(updated, '%Y-%m-%dT%H:%i:%sZ'))), JSON_ARRAY()) FROM deviceCapabilities WHERE deviceId = devices.id) as capabilities",
-		"(SELECT COALESCE(JSON_ARRAYAGG(groupId), JSON_ARRAY()) FROM groupDevices WHERE deviceId = devices.id) as groupIds",
+		"(SELECT COALESCE(JSON_ARRAYAGG(JSON_OBJECT(\"name\", name, \"argument-specs\", argumentJsonSchema, \"argument-json-schema\", argumentStandardSchema, \"expected-outcome\", expectedOutcome, \"updated\", DATE_FORMAT(updated, '%Y-%m-%dT%H:%i:%sZ'))), JSON_ARRAY()) FROM deviceCapabilities WHERE deviceId = devices.id) as capabilities",
+		"(SELECT COALESCE(JSON_ARRAYAGG(groupId), JSON_ARRAY()) FROM groupDevices INNER JOIN groups ON groups.id = groupDevices.groupId WHERE deviceId = devices.id AND groups.deleted IS NULL) as groupIds",
 		"(SELECT COALESCE(JSON_ARRAYAGG(JSON_OBJECT(\"name\", name)), JSON_ARRAY()) FROM deviceTriggers WHERE deviceTriggers.deviceId = devices.id) as triggers",
 	}
 	query := `SELECT ` + strings.Join(fields, ",") + ` FROM devices`
-	queryFragments, variables, err := intermediaries.TranslateFiltersToQueryFragments(filters, deviceFilters)
+	queryFragments, variables, err := intermediaries.TranslateFiltersToQueryFragments(withDeletedFilter(filters), deviceFilters)
 	if err != nil {
 		return nil, err
 	}
@@ -218,7 +219,7 @@ func (persistence mariadbPersistence) GetDevices(ctx context.Context, filters []
 		var attributesBytes []byte
 		var triggerBytes []byte
 		var groupIdsBytes []byte
-		err = rows.Scan(&device.ID, &device.BridgeIdentifier, &device.AdapterId, &device.Updated, &attributesBytes, &capabilitiesBytes, &groupIdsBytes, &triggerBytes)
+		err = rows.Scan(&device.ID, &device.BridgeIdentifier, &device.AdapterId, &device.Updated, &device.Deleted, &attributesBytes, &capabilitiesBytes, &groupIdsBytes, &triggerBytes)
 		if err != nil {
 			return nil, err
 		}
@@ -252,8 +253,107 @@ func (persistence mariadbPersistence) GetDevices(ctx context.Context, filters []
 	return retDevices, rows.Err()
 }
 
+// existedAt reports whether a device created and deleted at the given times existed at a point in time. Devices
+// created before their creation was recorded may have existed at any point before being deleted.
+func existedAt(created *time.Time, deleted *time.Time, at time.Time) bool {
+	if created != nil && created.After(at) {
+		return false
+	}
+	return deleted == nil || deleted.After(at)
+}
+
+func (persistence mariadbPersistence) GetDevicesAt(ctx context.Context, filters []restmodels.Filter, at time.Time) ([]restmodels.Device, error) {
+	// Devices deleted since may have existed at the time, which is decided below
+	if !slices.ContainsFunc(filters, func(filter restmodels.Filter) bool { return filter.Key == "deleted" }) {
+		filters = append(slices.Clone(filters), restmodels.Filter{Key: "deleted", Operator: "eq", Value: "any"})
+	}
+	devices, err := persistence.GetDevices(ctx, filters)
+	if err != nil {
+		return nil, err
+	}
+	if len(devices) == 0 {
+		return devices, nil
+	}
+	placeholders := make([]string, 0, len(devices))
+	ids := []any{}
+	for _, device := range devices {
+		placeholders = append(placeholders, "?")
+		ids = append(ids, device.ID)
+	}
+	created := map[int]*time.Time{}
+	createdRows, err := persistence.db.QueryContext(ctx, `SELECT id, created FROM devices WHERE id IN (`+strings.Join(placeholders, ",")+`)`, ids...)
+	if err != nil {
+		return nil, err
+	}
+	defer createdRows.Close()
+	for createdRows.Next() {
+		var deviceId int
+		var deviceCreated *time.Time
+		if err := createdRows.Scan(&deviceId, &deviceCreated); err != nil {
+			return nil, err
+		}
+		created[deviceId] = deviceCreated
+	}
+	if err := createdRows.Err(); err != nil {
+		return nil, err
+	}
+	devices = slices.DeleteFunc(devices, func(device restmodels.Device) bool {
+		return !existedAt(created[device.ID], device.Deleted, at)
+	})
+	if len(devices) == 0 {
+		return devices, nil
+	}
+	placeholders = placeholders[:0]
+	variables := []any{at}
+	for _, device := range devices {
+		placeholders = append(placeholders, "?")
+		variables = append(variables, device.ID)
+	}
+	// The newest audit row of every attribute at the given time holds the value the attribute had at that time
+	query := `SELECT audit.deviceId, audit.name, audit.newBooleanValue, audit.newNumericValue, audit.newTextValue, audit.timestamp FROM deviceAttributeAudit audit ` +
+		`INNER JOIN (SELECT MAX(id) AS id FROM deviceAttributeAudit WHERE timestamp <= ? AND deviceId IN (` + strings.Join(placeholders, ",") + `) GROUP BY deviceId, name) latest ON audit.id = latest.id`
+	rows, err := persistence.db.QueryContext(ctx, query, variables...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	historicAttributes := map[int]map[string]restmodels.Attribute{}
+	for rows.Next() {
+		var deviceId int
+		var attribute restmodels.Attribute
+		var numericValue *float64
+		err = rows.Scan(&deviceId, &attribute.Name, &attribute.Boolean, &numericValue, &attribute.Text, &attribute.Updated)
+		if err != nil {
+			return nil, err
+		}
+		if numericValue != nil {
+			attribute.Numeric = &[]float32{float32(*numericValue)}[0]
+		}
+		if _, ok := historicAttributes[deviceId]; !ok {
+			historicAttributes[deviceId] = map[string]restmodels.Attribute{}
+		}
+		historicAttributes[deviceId][attribute.Name] = attribute
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for index, device := range devices {
+		attributes := []restmodels.Attribute{}
+		for _, current := range device.Attributes {
+			historic, ok := historicAttributes[device.ID][current.Name]
+			if !ok {
+				// No value had been recorded yet, or the audit rows have since been pruned
+				historic = restmodels.Attribute{Name: current.Name, Unknown: true}
+			}
+			attributes = append(attributes, historic)
+		}
+		devices[index].Attributes = attributes
+	}
+	return devices, nil
+}
+
 func (persistence mariadbPersistence) DeleteGroup(ctx context.Context, storeIdentifier int) error {
-	result, err := persistence.db.ExecContext(ctx, `DELETE FROM groups WHERE id = ?`, storeIdentifier)
+	result, err := persistence.db.ExecContext(ctx, `UPDATE groups SET deleted = NOW() WHERE id = ? AND deleted IS NULL`, storeIdentifier)
 	if err != nil {
 		return err
 	}
@@ -267,8 +367,23 @@ func (persistence mariadbPersistence) DeleteGroup(ctx context.Context, storeIden
 	return nil
 }
 
+func (persistence mariadbPersistence) RestoreGroup(ctx context.Context, storeIdentifier int) error {
+	result, err := persistence.db.ExecContext(ctx, `UPDATE groups SET deleted = NULL, updated = NOW() WHERE id = ? AND deleted IS NOT NULL`, storeIdentifier)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return huma.Error404NotFound(fmt.Sprintf("deleted group %d not found", storeIdentifier))
+	}
+	return nil
+}
+
 func (persistence mariadbPersistence) DeleteDevice(ctx context.Context, storeIdentifier int) error {
-	result, err := persistence.db.ExecContext(ctx, `DELETE FROM devices WHERE id = ?`, storeIdentifier)
+	result, err := persistence.db.ExecContext(ctx, `UPDATE devices SET deleted = NOW() WHERE id = ? AND deleted IS NULL`, storeIdentifier)
 	if err != nil {
 		return err
 	}
@@ -282,6 +397,21 @@ func (persistence mariadbPersistence) DeleteDevice(ctx context.Context, storeIde
 	return nil
 }
 
+func (persistence mariadbPersistence) RestoreDevice(ctx context.Context, storeIdentifier int) error {
+	result, err := persistence.db.ExecContext(ctx, `UPDATE devices SET deleted = NULL, updated = NOW() WHERE id = ? AND deleted IS NOT NULL`, storeIdentifier)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return huma.Error404NotFound(fmt.Sprintf("deleted device %d not found", storeIdentifier))
+	}
+	return nil
+}
+
 // GetAttributeAudits
 func (persistence mariadbPersistence) GetAttributeAudits(ctx context.Context, filters []restmodels.Filter) ([]restmodels.AttributeAudit, error) {
 	fields := []string{
@@ -383,44 +513,63 @@ func (a dbAttribute) EqualRest(other ingestmodels.IngestAttribute) bool {
 	return true
 }
 
-func (persistence mariadbPersistence) PostDevice(ctx context.Context, device ingestmodels.IngestDevice) (int, []ingestmodels.IngestAttribute, error) {
+func (persistence mariadbPersistence) PostDevice(ctx context.Context, device ingestmodels.IngestDevice, onDeleted intermediaries.DeletedIngestBehaviour) (intermediaries.DevicePostResult, error) {
+	result := intermediaries.DevicePostResult{}
 	var foundId int
+	var foundDeleted *time.Time
 	tx, err := persistence.db.BeginTx(ctx, nil)
 	if err != nil {
-		return 0, nil, err
+		return intermediaries.DevicePostResult{}, err
 	}
 
 	defer tx.Rollback()
-	row := tx.QueryRowContext(ctx, `SELECT id FROM devices WHERE bridgeIdentifier = ? AND adapterId = ?`, device.BridgeIdentifier, device.AdapterId)
-	err = row.Scan(&foundId)
+	row := tx.QueryRowContext(ctx, `SELECT id, deleted FROM devices WHERE bridgeIdentifier = ? AND adapterId = ?`, device.BridgeIdentifier, device.AdapterId)
+	err = row.Scan(&foundId, &foundDeleted)
 	if err != nil && err != sql.ErrNoRows {
-		return 0, nil, err
+		return intermediaries.DevicePostResult{}, err
+	}
+	if foundDeleted != nil {
+		if onDeleted == intermediaries.SuppressDeleted {
+			// The device stays deleted and nothing about it is recorded
+			return intermediaries.DevicePostResult{DeviceID: foundId, Suppressed: true}, nil
+		}
+		_, err = tx.ExecContext(ctx, `UPDATE devices SET deleted = NULL, updated = NOW() WHERE id = ?`, foundId)
+		if err != nil {
+			return intermediaries.DevicePostResult{}, err
+		}
+		result.Restored = true
 	}
 
 	var deviceId int
 	var presentAttributes map[string]dbAttribute = make(map[string]dbAttribute)
+	presentCapabilities := []string{}
 	if foundId == 0 {
 		rows := tx.QueryRowContext(ctx, `INSERT INTO devices (bridgeIdentifier, adapterId, updated) VALUES (?, ?, NOW()) RETURNING id`, device.BridgeIdentifier, device.AdapterId)
 		err := rows.Scan(&deviceId)
 		if err != nil {
-			return 0, nil, err
+			return intermediaries.DevicePostResult{}, err
 		}
+		result.Created = true
 	} else {
 		deviceId = foundId
 		// Find already present attributes
 		rows, err := tx.QueryContext(ctx, `SELECT name, booleanValue, numericValue, textValue FROM deviceAttributes WHERE deviceId = ?`, deviceId)
 		if err != nil {
-			return 0, nil, err
+			return intermediaries.DevicePostResult{}, err
 		}
 		defer rows.Close()
 		for rows.Next() {
 			var presentAttribute dbAttribute
 			err = rows.Scan(&presentAttribute.Name, &presentAttribute.BooleanValue, &presentAttribute.NumericValue, &presentAttribute.TextValue)
 			if err != nil {
-				return 0, nil, err
+				return intermediaries.DevicePostResult{}, err
 			}
 			presentAttributes[presentAttribute.Name] = presentAttribute
 		}
+		presentCapabilities, err = getCapabilityNamesTx(ctx, tx, `SELECT name FROM deviceCapabilities WHERE deviceId = ?`, deviceId)
+		if err != nil {
+			return intermediaries.DevicePostResult{}, err
+		}
 	}
 	var updatedAttributes []ingestmodels.IngestAttribute
 	for _, attribute := range device.Attributes {
@@ -429,11 +578,11 @@ func (persistence mariadbPersistence) PostDevice(ctx context.Context, device ing
 			if !presentAttribute.EqualRest(attribute) {
 				_, err = tx.ExecContext(ctx, `UPDATE deviceAttributes SET booleanValue=?, numericValue=?, textValue=?, updated=NOW() WHERE deviceId=? AND name=?`, toDbBoolean(attribute.Boolean), attribute.Numeric, attribute.Text, deviceId, attribute.Name)
 				if err != nil {
-					return 0, nil, err
+					return intermediaries.DevicePostResult{}, err
 				}
 				updated, err := getAttributeUpdated(ctx, tx, deviceId, attribute.Name)
 				if err != nil {
-					return 0, nil, err
+					return intermediaries.DevicePostResult{}, err
 				}
 				updatedAttribute := attribute
 				updatedAttribute.Updated = updated
@@ -444,11 +593,11 @@ func (persistence mariadbPersistence) PostDevice(ctx context.Context, device ing
 			// If the attribute is not present, insert it
 			_, err = tx.ExecContext(ctx, `INSERT INTO deviceAttributes (deviceId, name, booleanValue, numericValue, textValue, updated) VALUES (?, ?, ?, ?, ?, NOW())`, deviceId, attribute.Name, toDbBoolean(attribute.Boolean), attribute.Numeric, attribute.Text)
 			if err != nil {
-				return 0, nil, err
+				return intermediaries.DevicePostResult{}, err
 			}
 			updated, err := getAttributeUpdated(ctx, tx, deviceId, attribute.Name)
 			if err != nil {
-				return 0, nil, err
+				return intermediaries.DevicePostResult{}, err
 			}
 			updatedAttribute := attribute
 			updatedAttribute.Updated = updated
@@ -460,34 +609,104 @@ func (persistence mariadbPersistence) PostDevice(ctx context.Context, device ing
 		// JSON encode ArgumentsJsonSchema so it can be saved in the database
 		argumentsJsonSchema, err := json.Marshal(capability.ArgumentSpecs)
 		if err != nil {
-			return 0, nil, err
+			return intermediaries.DevicePostResult{}, err
+		}
+		argumentStandardSchema, err := marshalArgumentSchema(capability.ArgumentJSONSchema)
+		if err != nil {
+			return intermediaries.DevicePostResult{}, err
+		}
+		expectedOutcome, err := marshalExpectedOutcome(capability.ExpectedOutcome)
+		if err != nil {
+			return intermediaries.DevicePostResult{}, err
 		}
-		_, err = tx.ExecContext(ctx, `INSERT INTO deviceCapabilities (deviceId, name, argumentJsonSchema, updated) VALUES (?, ?, ?, NOW()) ON DUPLICATE KEY UPDATE argumentJsonSchema = VALUES(argumentJsonSchema), updated = NOW()`, deviceId, capability.Name, argumentsJsonSchema)
+		_, err = tx.ExecContext(ctx, `INSERT INTO deviceCapabilities (deviceId, name, argumentJsonSchema, argumentStandardSchema, expectedOutcome, updated) VALUES (?, ?, ?, ?, ?, NOW()) ON DUPLICATE KEY UPDATE argumentJsonSchema = VALUES(argumentJsonSchema), argumentStandardSchema = VALUES(argumentStandardSchema), expectedOutcome = VALUES(expectedOutcome), updated = NOW()`, deviceId, capability.Name, argumentsJsonSchema, argumentStandardSchema, expectedOutcome)
 		if err != nil {
-			return 0, nil, err
+			return intermediaries.DevicePostResult{}, err
+		}
+		if !slices.Contains(presentCapabilities, capability.Name) {
+			result.AddedCapabilities = append(result.AddedCapabilities, capability.Name)
 		}
 		deviceWasUpdated = true
 	}
 	if deviceWasUpdated {
 		_, err = tx.ExecContext(ctx, `UPDATE devices SET updated = GREATEST(updated, NOW()) WHERE id = ?`, deviceId)
 		if err != nil {
-			return 0, nil, err
+			return intermediaries.DevicePostResult{}, err
 		}
 	}
-	return deviceId, updatedAttributes, tx.Commit()
+	result.DeviceID = deviceId
+	result.UpdatedAttributes = updatedAttributes
+	return result, tx.Commit()
+}
+
+// unmarshalArgumentSpecs decodes a stored argumentJsonSchema column, which is NULL for capabilities stored before
+// argument specs existed
+func unmarshalArgumentSpecs(argumentsBytes []byte) ([]restmodels.ArgumentSpec, error) {
+	var specs []restmodels.ArgumentSpec
+	if argumentsBytes == nil {
+		return nil, nil
+	}
+	err := json.Unmarshal(argumentsBytes, &specs)
+	return specs, err
+}
+
+// marshalArgumentSchema encodes a JSON Schema supplied by an adapter, storing NULL when none was supplied
+func marshalArgumentSchema(schema map[string]any) ([]byte, error) {
+	if schema == nil {
+		return nil, nil
+	}
+	return json.Marshal(schema)
+}
+
+// unmarshalArgumentSchema decodes a stored argumentStandardSchema column
+func unmarshalArgumentSchema(schemaBytes []byte) (map[string]any, error) {
+	var schema map[string]any
+	if schemaBytes == nil {
+		return nil, nil
+	}
+	err := json.Unmarshal(schemaBytes, &schema)
+	return schema, err
+}
+
+// marshalExpectedOutcome encodes the expected outcome declared for a capability, storing NULL when none was declared
+func marshalExpectedOutcome[T any](expected []T) ([]byte, error) {
+	if len(expected) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(expected)
+}
+
+// unmarshalExpectedOutcome decodes a stored expectedOutcome column
+func unmarshalExpectedOutcome(expectedBytes []byte) ([]restmodels.ExpectedAttribute, error) {
+	var expected []restmodels.ExpectedAttribute
+	if expectedBytes == nil {
+		return nil, nil
+	}
+	err := json.Unmarshal(expectedBytes, &expected)
+	return expected, err
 }
 
 func (persistence mariadbPersistence) GetDeviceCapabilityForActivation(ctx context.Context, storeIdentifier int, capabilityName string) (intermediaries.DeviceCapabilityIntermediaryActivation, error) {
 	capability := intermediaries.DeviceCapabilityIntermediaryActivation{}
-	row := persistence.db.QueryRowContext(ctx, `SELECT bridgeIdentifier, name, adapterId FROM deviceCapabilities INNER JOIN devices on deviceCapabilities.deviceId = devices.id WHERE deviceId = ? AND name = ?`, storeIdentifier, capabilityName)
-	err := row.Scan(&capability.BridgeIdentifier, &capability.Name, &capability.AdapterId)
+	var argumentsBytes, schemaBytes, expectedBytes []byte
+	row := persistence.db.QueryRowContext(ctx, `SELECT deviceId, bridgeIdentifier, name, adapterId, argumentJsonSchema, argumentStandardSchema, expectedOutcome FROM deviceCapabilities INNER JOIN devices on deviceCapabilities.deviceId = devices.id WHERE deviceId = ? AND name = ? AND devices.deleted IS NULL`, storeIdentifier, capabilityName)
+	err := row.Scan(&capability.DeviceId, &capability.BridgeIdentifier, &capability.Name, &capability.AdapterId, &argumentsBytes, &schemaBytes, &expectedBytes)
 	if err != nil {
 		if err != sql.ErrNoRows {
 			return intermediaries.DeviceCapabilityIntermediaryActivation{}, err
 		}
 		return intermediaries.DeviceCapabilityIntermediaryActivation{}, huma.Error404NotFound(fmt.Sprintf("capability %s not found for device %d", capabilityName, storeIdentifier))
 	}
-	return capability, nil
+	capability.ArgumentSpecs, err = unmarshalArgumentSpecs(argumentsBytes)
+	if err != nil {
+		return intermediaries.DeviceCapabilityIntermediaryActivation{}, err
+	}
+	capability.ArgumentJSONSchema, err = unmarshalArgumentSchema(schemaBytes)
+	if err != nil {
+		return intermediaries.DeviceCapabilityIntermediaryActivation{}, err
+	}
+	capability.ExpectedOutcome, err = unmarshalExpectedOutcome(expectedBytes)
+	return capability, err
 }
 
 var groupFilters = map[string]map[string]func(string) (string, []string, error){
@@ -506,6 +725,33 @@ var groupFilters = map[string]map[string]func(string) (string, []string, error){
 			return "adapterId = ?", []string{value}, nil
 		},
 	},
+	"updated": {
+		"gt": func(value string) (string, []string, error) {
+			return "updated > ?", []string{value}, validateTimestamp(value)
+		},
+		"lt": func(value string) (string, []string, error) {
+			return "updated < ?", []string{value}, validateTimestamp(value)
+		},
+	},
+	"deleted": {
+		"eq": deletedFilter,
+	},
+	"device-id": {
+		"eq": func(value string) (string, []string, error) {
+			return "id IN (SELECT groupId FROM groupDevices WHERE deviceId = ?)", []string{value}, nil
+		},
+	},
+	"effective-device-id": {
+		"eq": effectiveDeviceIdFilter,
+	},
+	"store-managed": {
+		"eq": func(value string) (string, []string, error) {
+			if value != "true" && value != "false" {
+				return "", nil, huma.Error400BadRequest("store-managed filter must be one of true or false")
+			}
+			return "storeManaged = " + strings.ToUpper(value), []string{}, nil
+		},
+	},
 }
 
 func (persistence mariadbPersistence) GetGroups(ctx context.Context, filters []restmodels.Filter) ([]restmodels.Group, error) {
@@ -519,11 +765,14 @@ func getGroupsTx(ctx context.Context, filters []restmodels.Filter, tx queryAble)
 		"adapterId",
 		"name",
 		"updated",
-		"(SELECT COALESCE(JSON_ARRAYAGG(JSON_OBJECT(\"name\", name, \"argument-specs\", argumentJsonSchema, \"updated\", DATE_FORMAT(updated, '%Y-%m-%dT%H:%i:%sZ'))), JSON_ARRAY()) FROM groupCapabilities WHERE groupId = groups.id) as capabilities",
-		"(SELECT COALESCE(JSON_ARRAYAGG(deviceId), JSON_ARRAY()) FROM groupDevices WHERE groupId = groups.id) as deviceIds",
+		"deleted",
+		"storeManaged",
+		"(SELECT COALESCE(JSON_ARRAYAGG(JSON_OBJECT(\"name\", name, \"argument-specs\", argumentJsonSchema, \"argument-json-schema\", argumentStandardSchema, \"updated\", DATE_FORMAT(updated, '%Y-%m-%dT%H:%i:%sZ'))), JSON_ARRAY()) FROM groupCapabilities WHERE groupId = groups.id) as capabilities",
+		"(SELECT COALESCE(JSON_ARRAYAGG(deviceId), JSON_ARRAY()) FROM groupDevices INNER JOIN devices ON devices.id = groupDevices.deviceId WHERE groupId = groups.id AND devices.deleted IS NULL) as deviceIds",
+		"(SELECT COALESCE(JSON_ARRAYAGG(childGroupId), JSON_ARRAY()) FROM groupGroups INNER JOIN groups children ON children.id = groupGroups.childGroupId WHERE parentGroupId = groups.id AND children.deleted IS NULL) as groupIds",
 	}
 	query := `SELECT ` + strings.Join(fields, ",") + ` FROM groups`
-	queryFragments, variables, err := intermediaries.TranslateFiltersToQueryFragments(filters, groupFilters)
+	queryFragments, variables, err := intermediaries.TranslateFiltersToQueryFragments(withDeletedFilter(filters), groupFilters)
 	if err != nil {
 		return nil, err
 	}
@@ -541,7 +790,8 @@ func getGroupsTx(ctx context.Context, filters []restmodels.Filter, tx queryAble)
 		var group restmodels.Group
 		var capabilitiesBytes []byte
 		var deviceIdsBytes []byte
-		err = rows.Scan(&group.ID, &group.BridgeIdentifier, &group.AdapterId, &group.Name, &group.Updated, &capabilitiesBytes, &deviceIdsBytes)
+		var groupIdsBytes []byte
+		err = rows.Scan(&group.ID, &group.BridgeIdentifier, &group.AdapterId, &group.Name, &group.Updated, &group.Deleted, &group.StoreManaged, &capabilitiesBytes, &deviceIdsBytes, &groupIdsBytes)
 		if err != nil {
 			return nil, err
 		}
@@ -553,25 +803,125 @@ func getGroupsTx(ctx context.Context, filters []restmodels.Filter, tx queryAble)
 		if err != nil {
 			return nil, err
 		}
+		err = json.Unmarshal(groupIdsBytes, &group.GroupIds)
+		if err != nil {
+			return nil, err
+		}
 		groups = append(groups, group)
 	}
-	return groups, rows.Err()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+	err = setEffectiveMembershipTx(ctx, groups, tx)
+	if err != nil {
+		return nil, err
+	}
+	for index, group := range groups {
+		if !group.StoreManaged {
+			continue
+		}
+		groups[index].Capabilities, err = getStoreGroupCapabilitiesTx(ctx, group.EffectiveDeviceIds, tx)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = setGroupAttributesTx(ctx, groups, tx)
+	if err != nil {
+		return nil, err
+	}
+	return groups, nil
 }
 
-func (persistence mariadbPersistence) PostGroup(ctx context.Context, group ingestmodels.IngestGroup) error {
-	tx, err := persistence.db.BeginTx(ctx, nil)
+// setGroupAttributesTx aggregates the attributes of the members of every group, including the members of contained groups
+func setGroupAttributesTx(ctx context.Context, groups []restmodels.Group, tx queryAble) error {
+	deviceIds := []int{}
+	for _, group := range groups {
+		for _, deviceId := range group.EffectiveDeviceIds {
+			if !slices.Contains(deviceIds, deviceId) {
+				deviceIds = append(deviceIds, deviceId)
+			}
+		}
+	}
+	deviceAttributes, err := getDeviceAttributesTx(ctx, tx, deviceIds, nil)
 	if err != nil {
 		return err
 	}
+	for index, group := range groups {
+		members := [][]restmodels.Attribute{}
+		for _, deviceId := range group.EffectiveDeviceIds {
+			members = append(members, deviceAttributes[deviceId])
+		}
+		groups[index].Attributes = aggregation.GroupAttributes(members)
+	}
+	return nil
+}
+
+// getDeviceAttributesTx returns the current attributes of the devices, by device ID. Only the named attributes are
+// returned, unless names is nil.
+func getDeviceAttributesTx(ctx context.Context, tx queryAble, deviceIds []int, names []string) (map[int][]restmodels.Attribute, error) {
+	deviceAttributes := map[int][]restmodels.Attribute{}
+	if len(deviceIds) > 0 {
+		placeholders := make([]string, 0, len(deviceIds))
+		variables := make([]any, 0, len(deviceIds))
+		for _, deviceId := range deviceIds {
+			placeholders = append(placeholders, "?")
+			variables = append(variables, deviceId)
+		}
+		query := `SELECT deviceId, name, booleanValue, numericValue, textValue, updated FROM deviceAttributes WHERE deviceId IN (` + strings.Join(placeholders, ",") + `)`
+		if names != nil {
+			if len(names) == 0 {
+				return deviceAttributes, nil
+			}
+			query += ` AND name IN (` + strings.TrimSuffix(strings.Repeat("?,", len(names)), ",") + `)`
+			for _, name := range names {
+				variables = append(variables, name)
+			}
+		}
+		rows, err := tx.QueryContext(ctx, query, variables...)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var deviceId int
+			var attribute restmodels.Attribute
+			var numericValue *float64
+			err = rows.Scan(&deviceId, &attribute.Name, &attribute.Boolean, &numericValue, &attribute.Text, &attribute.Updated)
+			if err != nil {
+				return nil, err
+			}
+			if numericValue != nil {
+				attribute.Numeric = &[]float32{float32(*numericValue)}[0]
+			}
+			deviceAttributes[deviceId] = append(deviceAttributes[deviceId], attribute)
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return deviceAttributes, nil
+}
+
+func (persistence mariadbPersistence) PostGroup(ctx context.Context, group ingestmodels.IngestGroup, onDeleted intermediaries.DeletedIngestBehaviour) (intermediaries.GroupPostResult, error) {
+	tx, err := persistence.db.BeginTx(ctx, nil)
+	if err != nil {
+		return intermediaries.GroupPostResult{}, err
+	}
 	defer tx.Rollback()
-	err = postGroupTx(ctx, group, tx)
+	result, err := postGroupTx(ctx, group, onDeleted, tx)
 	if err != nil {
-		return err
+		return intermediaries.GroupPostResult{}, err
 	}
-	return tx.Commit()
+	return result, tx.Commit()
 }
 
-func postGroupTx(ctx context.Context, group ingestmodels.IngestGroup, tx queryAble) error {
+func postGroupTx(ctx context.Context, group ingestmodels.IngestGroup, onDeleted intermediaries.DeletedIngestBehaviour, tx queryAble) (intermediaries.GroupPostResult, error) {
+	result := intermediaries.GroupPostResult{}
+	memberIds, err := resolveIngestGroupMembersTx(ctx, group, tx)
+	if err != nil {
+		return intermediaries.GroupPostResult{}, err
+	}
 	foundGroups, err := getGroupsTx(ctx, []restmodels.Filter{
 		{
 			Key:      "bridge-identifier",
@@ -583,74 +933,195 @@ func postGroupTx(ctx context.Context, group ingestmodels.IngestGroup, tx queryAb
 			Operator: "eq",
 			Value:    fmt.Sprintf("%d", group.AdapterId),
 		},
+		{
+			Key:      "deleted",
+			Operator: "eq",
+			Value:    "any",
+		},
 	}, tx)
 	if err != nil {
-		return err
+		return intermediaries.GroupPostResult{}, err
 	}
 	var groupId int
-	deviceIdsInGroup := []int{}
+	presentCapabilities := []string{}
 	if len(foundGroups) == 0 {
-		result := tx.QueryRowContext(ctx, `INSERT INTO groups (bridgeIdentifier, adapterId, name) VALUES (?, ?, ?) RETURNING id`, group.BridgeIdentifier, group.AdapterId, group.Name)
-		if result == nil {
-			return fmt.Errorf("failed to insert group: QueryRowContext returned nil")
+		row := tx.QueryRowContext(ctx, `INSERT INTO groups (bridgeIdentifier, adapterId, name) VALUES (?, ?, ?) RETURNING id`, group.BridgeIdentifier, group.AdapterId, group.Name)
+		if row == nil {
+			return intermediaries.GroupPostResult{}, fmt.Errorf("failed to insert group: QueryRowContext returned nil")
 		}
-		err := result.Scan(&groupId)
+		err := row.Scan(&groupId)
 		if err != nil {
-			return err
+			return intermediaries.GroupPostResult{}, err
 		}
+		result.Created = true
 	} else {
 		groupId = foundGroups[0].ID
-		deviceIdsInGroup = foundGroups[0].DeviceIds
-		_, err := tx.ExecContext(ctx, `UPDATE groups SET name = ? WHERE id = ?`, group.Name, groupId)
+		if foundGroups[0].StoreManaged {
+			// Adapters never use adapter ID 0, so this should not happen
+			return intermediaries.GroupPostResult{}, huma.Error409Conflict(fmt.Sprintf("group %d is managed by the store", groupId))
+		}
+		if foundGroups[0].Deleted != nil && onDeleted == intermediaries.SuppressDeleted {
+			// The group stays deleted and nothing about it is recorded
+			return intermediaries.GroupPostResult{GroupID: groupId, Suppressed: true}, nil
+		}
+		result.Restored = foundGroups[0].Deleted != nil
+		_, err := tx.ExecContext(ctx, `UPDATE groups SET name = ?, deleted = NULL WHERE id = ?`, group.Name, groupId)
 		if err != nil {
-			return err
+			return intermediaries.GroupPostResult{}, err
+		}
+		for _, capability := range foundGroups[0].Capabilities {
+			presentCapabilities = append(presentCapabilities, capability.Name)
 		}
 	}
 	// Update capabilities
 	for _, capability := range group.Capabilities {
 		argumentsJsonSchema, err := json.Marshal(capability.ArgumentSpecs)
 		if err != nil {
-			return err
+			return intermediaries.GroupPostResult{}, err
 		}
-		_, err = tx.ExecContext(ctx, `INSERT INTO groupCapabilities (groupId, name, argumentJsonSchema, updated) VALUES (?, ?, ?, NOW()) ON DUPLICATE KEY UPDATE argumentJsonSchema = VALUES(argumentJsonSchema), updated = NOW()`, groupId, capability.Name, argumentsJsonSchema)
+		argumentStandardSchema, err := marshalArgumentSchema(capability.ArgumentJSONSchema)
 		if err != nil {
-			return err
+			return intermediaries.GroupPostResult{}, err
+		}
+		_, err = tx.ExecContext(ctx, `INSERT INTO groupCapabilities (groupId, name, argumentJsonSchema, argumentStandardSchema, updated) VALUES (?, ?, ?, ?, NOW()) ON DUPLICATE KEY UPDATE argumentJsonSchema = VALUES(argumentJsonSchema), argumentStandardSchema = VALUES(argumentStandardSchema), updated = NOW()`, groupId, capability.Name, argumentsJsonSchema, argumentStandardSchema)
+		if err != nil {
+			return intermediaries.GroupPostResult{}, err
+		}
+		if !slices.Contains(presentCapabilities, capability.Name) {
+			result.AddedCapabilities = append(result.AddedCapabilities, capability.Name)
 		}
 	}
 	// Update deviceIds
-	// // Add missing deviceIds
-	for _, deviceId := range group.DeviceIds {
-		if slices.Contains(deviceIdsInGroup, deviceId) {
+	result.AddedDeviceIDs, result.RemovedDeviceIDs, err = setGroupMembershipTx(ctx, groupId, memberIds, tx)
+	if err != nil {
+		return intermediaries.GroupPostResult{}, err
+	}
+	result.GroupID = groupId
+	return result, nil
+}
+
+// getCapabilityNamesTx returns the capability names selected by query
+func getCapabilityNamesTx(ctx context.Context, tx queryAble, query string, args ...any) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	names := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// setGroupMembershipTx makes deviceIds the members of a group and returns the added and removed device IDs
+func setGroupMembershipTx(ctx context.Context, groupId int, deviceIds []int, tx queryAble) ([]int, []int, error) {
+	// Group listings hide soft deleted devices, but their memberships still exist
+	deviceIdsInGroup, err := getGroupMembershipTx(ctx, groupId, tx)
+	if err != nil {
+		return nil, nil, err
+	}
+	var added, removed []int
+	// Add missing deviceIds
+	for _, deviceId := range deviceIds {
+		if slices.Contains(deviceIdsInGroup, deviceId) || slices.Contains(added, deviceId) {
 			continue
 		}
 		_, err = tx.ExecContext(ctx, `INSERT INTO groupDevices (groupId, deviceId) VALUES (?, ?)`, groupId, deviceId)
 		if err != nil {
-			return err
+			return nil, nil, err
 		}
+		added = append(added, deviceId)
 	}
-	// // Remove deviceIds that are not in the new list
+	// Remove deviceIds that are not in the new list
 	for _, deviceId := range deviceIdsInGroup {
-		if !slices.Contains(group.DeviceIds, deviceId) {
+		if !slices.Contains(deviceIds, deviceId) {
 			_, err = tx.ExecContext(ctx, `DELETE FROM groupDevices WHERE groupId = ? AND deviceId = ?`, groupId, deviceId)
 			if err != nil {
-				return err
+				return nil, nil, err
 			}
+			removed = append(removed, deviceId)
 		}
 	}
-	return nil
+	return added, removed, nil
 }
 
-func (persistence mariadbPersistence) WriteCapabilityTriggerAudit(ctx context.Context, deviceId int, capabilityName string, success bool, errorMessage *string, arguments string) error {
-	_, err := persistence.db.ExecContext(ctx,
-		`INSERT INTO deviceCapabilityTriggerAudit (deviceId, name, success, errorMessage, arguments) VALUES (?, ?, ?, ?, ?)`,
-		deviceId, capabilityName, success, errorMessage, arguments,
-	)
+func getGroupMembershipTx(ctx context.Context, groupId int, tx queryAble) ([]int, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT deviceId FROM groupDevices WHERE groupId = ?`, groupId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	deviceIds := []int{}
+	for rows.Next() {
+		var deviceId int
+		if err := rows.Scan(&deviceId); err != nil {
+			return nil, err
+		}
+		deviceIds = append(deviceIds, deviceId)
+	}
+	return deviceIds, rows.Err()
+}
+
+func (persistence mariadbPersistence) GetAdapterIds(ctx context.Context) ([]int, error) {
+	rows, err := persistence.db.QueryContext(ctx, `SELECT adapterId FROM devices WHERE deleted IS NULL UNION SELECT adapterId FROM groups WHERE deleted IS NULL AND NOT storeManaged ORDER BY adapterId`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	adapterIds := []int{}
+	for rows.Next() {
+		var adapterId int
+		if err := rows.Scan(&adapterId); err != nil {
+			return nil, err
+		}
+		adapterIds = append(adapterIds, adapterId)
+	}
+	return adapterIds, rows.Err()
+}
+
+// marshalTriggerAttempts encodes the attempts of a capability trigger, storing NULL when there were none
+func marshalTriggerAttempts(attempts []restmodels.TriggerAttempt) ([]byte, error) {
+	if len(attempts) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(attempts)
+}
+
+func unmarshalTriggerAttempts(attemptsBytes []byte) ([]restmodels.TriggerAttempt, error) {
+	if attemptsBytes == nil {
+		return nil, nil
+	}
+	var attempts []restmodels.TriggerAttempt
+	err := json.Unmarshal(attemptsBytes, &attempts)
+	return attempts, err
+}
+
+func (persistence mariadbPersistence) WriteCapabilityTriggerAudit(ctx context.Context, deviceId int, capabilityName string, success bool, errorMessage *string, arguments string, attempts []restmodels.TriggerAttempt, outcome *string) (int, error) {
+	attemptsBytes, err := marshalTriggerAttempts(attempts)
+	if err != nil {
+		return 0, err
+	}
+	var auditId int
+	err = persistence.db.QueryRowContext(ctx,
+		`INSERT INTO deviceCapabilityTriggerAudit (deviceId, name, success, errorMessage, arguments, attempts, outcome) VALUES (?, ?, ?, ?, ?, ?, ?) RETURNING id`,
+		deviceId, capabilityName, success, errorMessage, arguments, attemptsBytes, outcome,
+	).Scan(&auditId)
+	return auditId, err
+}
+
+func (persistence mariadbPersistence) SetCapabilityTriggerAuditOutcome(ctx context.Context, auditId int, outcome string) error {
+	_, err := persistence.db.ExecContext(ctx, `UPDATE deviceCapabilityTriggerAudit SET outcome = ? WHERE id = ?`, outcome, auditId)
 	return err
 }
 
 func (persistence mariadbPersistence) GetCapabilityTriggerAudits(ctx context.Context, deviceId int) ([]restmodels.CapabilityTriggerAudit, error) {
 	rows, err := persistence.db.QueryContext(ctx,
-		`SELECT id, deviceId, name, success, errorMessage, timestamp, arguments FROM deviceCapabilityTriggerAudit WHERE deviceId = ? ORDER BY timestamp DESC LIMIT 50`,
+		`SELECT id, deviceId, name, success, errorMessage, timestamp, arguments, attempts, outcome FROM deviceCapabilityTriggerAudit WHERE deviceId = ? ORDER BY timestamp DESC LIMIT 50`,
 		deviceId,
 	)
 	if err != nil {
@@ -660,7 +1131,11 @@ func (persistence mariadbPersistence) GetCapabilityTriggerAudits(ctx context.Con
 	var audits []restmodels.CapabilityTriggerAudit
 	for rows.Next() {
 		var audit restmodels.CapabilityTriggerAudit
-		if err := rows.Scan(&audit.ID, &audit.DeviceID, &audit.Name, &audit.Success, &audit.ErrorMessage, &audit.Timestamp, &audit.Arguments); err != nil {
+		var attemptsBytes []byte
+		if err := rows.Scan(&audit.ID, &audit.DeviceID, &audit.Name, &audit.Success, &audit.ErrorMessage, &audit.Timestamp, &audit.Arguments, &attemptsBytes, &audit.Outcome); err != nil {
+			return nil, err
+		}
+		if audit.Attempts, err = unmarshalTriggerAttempts(attemptsBytes); err != nil {
 			return nil, err
 		}
 		audits = append(audits, audit)
@@ -668,17 +1143,22 @@ func (persistence mariadbPersistence) GetCapabilityTriggerAudits(ctx context.Con
 	return audits, rows.Err()
 }
 
-func (persistence mariadbPersistence) WriteGroupCapabilityTriggerAudit(ctx context.Context, groupId int, capabilityName string, success bool, errorMessage *string, arguments string) error {
-	_, err := persistence.db.ExecContext(ctx,
-		`INSERT INTO groupCapabilityTriggerAudit (groupId, name, success, errorMessage, arguments) VALUES (?, ?, ?, ?, ?)`,
-		groupId, capabilityName, success, errorMessage, arguments,
-	)
-	return err
+func (persistence mariadbPersistence) WriteGroupCapabilityTriggerAudit(ctx context.Context, groupId int, capabilityName string, success bool, errorMessage *string, arguments string, attempts []restmodels.TriggerAttempt) (int, error) {
+	attemptsBytes, err := marshalTriggerAttempts(attempts)
+	if err != nil {
+		return 0, err
+	}
+	var auditId int
+	err = persistence.db.QueryRowContext(ctx,
+		`INSERT INTO groupCapabilityTriggerAudit (groupId, name, success, errorMessage, arguments, attempts) VALUES (?, ?, ?, ?, ?, ?) RETURNING id`,
+		groupId, capabilityName, success, errorMessage, arguments, attemptsBytes,
+	).Scan(&auditId)
+	return auditId, err
 }
 
 func (persistence mariadbPersistence) GetGroupCapabilityTriggerAudits(ctx context.Context, groupId int) ([]restmodels.GroupCapabilityTriggerAudit, error) {
 	rows, err := persistence.db.QueryContext(ctx,
-		`SELECT id, groupId, name, success, errorMessage, timestamp, arguments FROM groupCapabilityTriggerAudit WHERE groupId = ? ORDER BY timestamp DESC LIMIT 50`,
+		`SELECT id, groupId, name, success, errorMessage, timestamp, arguments, attempts FROM groupCapabilityTriggerAudit WHERE groupId = ? ORDER BY timestamp DESC LIMIT 50`,
 		groupId,
 	)
 	if err != nil {
@@ -688,7 +1168,11 @@ func (persistence mariadbPersistence) GetGroupCapabilityTriggerAudits(ctx contex
 	var audits []restmodels.GroupCapabilityTriggerAudit
 	for rows.Next() {
 		var audit restmodels.GroupCapabilityTriggerAudit
-		if err := rows.Scan(&audit.ID, &audit.GroupID, &audit.Name, &audit.Success, &audit.ErrorMessage, &audit.Timestamp, &audit.Arguments); err != nil {
+		var attemptsBytes []byte
+		if err := rows.Scan(&audit.ID, &audit.GroupID, &audit.Name, &audit.Success, &audit.ErrorMessage, &audit.Timestamp, &audit.Arguments, &attemptsBytes); err != nil {
+			return nil, err
+		}
+		if audit.Attempts, err = unmarshalTriggerAttempts(attemptsBytes); err != nil {
 			return nil, err
 		}
 		audits = append(audits, audit)
@@ -697,14 +1181,121 @@ func (persistence mariadbPersistence) GetGroupCapabilityTriggerAudits(ctx contex
 }
 
 func (persistence mariadbPersistence) GetGroupCapabilityForActivation(ctx context.Context, storeIdentifier int, capabilityName string) (intermediaries.GroupCapabilityIntermediaryActivation, error) {
-	capability := intermediaries.GroupCapabilityIntermediaryActivation{}
-	row := persistence.db.QueryRowContext(ctx, `SELECT bridgeIdentifier, groupCapabilities.name, adapterId FROM groupCapabilities INNER JOIN groups on groupCapabilities.groupId = groups.id WHERE groupId = ? AND groupCapabilities.name = ?`, storeIdentifier, capabilityName)
-	err := row.Scan(&capability.BridgeIdentifier, &capability.Name, &capability.AdapterId)
+	capability := intermediaries.GroupCapabilityIntermediaryActivation{GroupId: storeIdentifier}
+	var argumentsBytes, schemaBytes []byte
+	row := persistence.db.QueryRowContext(ctx, `SELECT bridgeIdentifier, groupCapabilities.name, adapterId, argumentJsonSchema, argumentStandardSchema FROM groupCapabilities INNER JOIN groups on groupCapabilities.groupId = groups.id WHERE groupId = ? AND groupCapabilities.name = ? AND groups.deleted IS NULL`, storeIdentifier, capabilityName)
+	err := row.Scan(&capability.BridgeIdentifier, &capability.Name, &capability.AdapterId, &argumentsBytes, &schemaBytes)
 	if err != nil {
 		if err != sql.ErrNoRows {
 			return intermediaries.GroupCapabilityIntermediaryActivation{}, err
 		}
+		// Store managed groups have no capabilities of their own, they are triggered through their members
+		var storeManaged bool
+		err = persistence.db.QueryRowContext(ctx, `SELECT storeManaged FROM groups WHERE id = ? AND deleted IS NULL`, storeIdentifier).Scan(&storeManaged)
+		if err != nil && err != sql.ErrNoRows {
+			return intermediaries.GroupCapabilityIntermediaryActivation{}, err
+		}
+		if storeManaged {
+			return intermediaries.GroupCapabilityIntermediaryActivation{GroupId: storeIdentifier, Name: capabilityName, StoreManaged: true}, nil
+		}
 		return intermediaries.GroupCapabilityIntermediaryActivation{}, huma.Error404NotFound(fmt.Sprintf("capability %s not found for group %d", capabilityName, storeIdentifier))
 	}
+	capability.ArgumentSpecs, err = unmarshalArgumentSpecs(argumentsBytes)
+	if err != nil {
+		return intermediaries.GroupCapabilityIntermediaryActivation{}, err
+	}
+	capability.ArgumentJSONSchema, err = unmarshalArgumentSchema(schemaBytes)
 	return capability, err
 }
+
+// resolveIngestGroupMembersTx returns the store IDs of the members of an ingested group. Members referred to by
+// bridge identifier are looked up among the devices of the adapter, and members referred to by store ID must
+// belong to the adapter. Unknown and foreign devices are all listed in the returned error.
+func resolveIngestGroupMembersTx(ctx context.Context, group ingestmodels.IngestGroup, tx queryAble) ([]int, error) {
+	memberIds := []int{}
+	details := []error{}
+	if len(group.DeviceBridgeIdentifiers) > 0 {
+		placeholders := strings.TrimSuffix(strings.Repeat("?,", len(group.DeviceBridgeIdentifiers)), ",")
+		variables := []any{group.AdapterId}
+		for _, bridgeIdentifier := range group.DeviceBridgeIdentifiers {
+			variables = append(variables, bridgeIdentifier)
+		}
+		rows, err := tx.QueryContext(ctx, `SELECT id, bridgeIdentifier FROM devices WHERE adapterId = ? AND bridgeIdentifier IN (`+placeholders+`)`, variables...)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		found := map[string]int{}
+		for rows.Next() {
+			var deviceId int
+			var bridgeIdentifier string
+			if err := rows.Scan(&deviceId, &bridgeIdentifier); err != nil {
+				return nil, err
+			}
+			found[bridgeIdentifier] = deviceId
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		rows.Close()
+		for index, bridgeIdentifier := range group.DeviceBridgeIdentifiers {
+			deviceId, ok := found[bridgeIdentifier]
+			if !ok {
+				details = append(details, &huma.ErrorDetail{
+					Message:  "no device with this bridge identifier has been posted by the adapter",
+					Location: fmt.Sprintf("body.device-bridge-identifiers[%d]", index),
+					Value:    bridgeIdentifier,
+				})
+				continue
+			}
+			memberIds = append(memberIds, deviceId)
+		}
+	}
+	if len(group.DeviceIds) > 0 {
+		placeholders := strings.TrimSuffix(strings.Repeat("?,", len(group.DeviceIds)), ",")
+		variables := []any{}
+		for _, deviceId := range group.DeviceIds {
+			variables = append(variables, deviceId)
+		}
+		rows, err := tx.QueryContext(ctx, `SELECT id, adapterId FROM devices WHERE id IN (`+placeholders+`)`, variables...)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		adapterIds := map[int]int{}
+		for rows.Next() {
+			var deviceId, adapterId int
+			if err := rows.Scan(&deviceId, &adapterId); err != nil {
+				return nil, err
+			}
+			adapterIds[deviceId] = adapterId
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		for index, deviceId := range group.DeviceIds {
+			adapterId, ok := adapterIds[deviceId]
+			if !ok {
+				details = append(details, &huma.ErrorDetail{
+					Message:  "device not found",
+					Location: fmt.Sprintf("body.device-ids[%d]", index),
+					Value:    deviceId,
+				})
+				continue
+			}
+			if adapterId != group.AdapterId {
+				details = append(details, &huma.ErrorDetail{
+					Message:  "device belongs to another adapter",
+					Location: fmt.Sprintf("body.device-ids[%d]", index),
+					Value:    deviceId,
+				})
+				continue
+			}
+			memberIds = append(memberIds, deviceId)
+		}
+	}
+	if len(details) > 0 {
+		return nil, huma.Error422UnprocessableEntity("group refers to unknown devices or devices of other adapters", details...)
+	}
+	return memberIds, nil
+}
@@ -1,9 +1,18 @@
 package mariadb
 
 import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/Kaese72/device-store/ingestmodels"
+	"github.com/danielgtaylor/huma/v2"
 )
 
 func TestEqualRest(t *testing.T) {
@@ -356,3 +365,162 @@ func TestValidateTimestamp(t *testing.T) {
 		})
 	}
 }
+
+func TestExistedAt(t *testing.T) {
+	at := time.Date(2025, 8, 24, 14, 30, 0, 0, time.UTC)
+	before := at.Add(-time.Hour)
+	after := at.Add(time.Hour)
+	tests := []struct {
+		name     string
+		created  *time.Time
+		deleted  *time.Time
+		expected bool
+	}{
+		{name: "Created before", created: &before, expected: true},
+		{name: "Created at the time", created: &at, expected: true},
+		{name: "Created after", created: &after, expected: false},
+		{name: "Creation unknown", expected: true},
+		{name: "Deleted after", created: &before, deleted: &after, expected: true},
+		{name: "Deleted at the time", created: &before, deleted: &at, expected: false},
+		{name: "Deleted before", created: &before, deleted: &before, expected: false},
+		{name: "Creation unknown and deleted before", deleted: &before, expected: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := existedAt(tt.created, tt.deleted, at); result != tt.expected {
+				t.Errorf("existedAt() = %v, expected %v", result, tt.expected)
+			}
+		})
+	}
+}
+
+type tableDevice struct {
+	id               int64
+	bridgeIdentifier string
+	adapterId        int64
+}
+
+// deviceTable is a database of devices answering the queries made while resolving the members of ingested groups
+type deviceTable struct {
+	devices []tableDevice
+}
+
+func (table *deviceTable) Open(name string) (driver.Conn, error) { return table, nil }
+func (table *deviceTable) Connect(context.Context) (driver.Conn, error) {
+	return table, nil
+}
+func (table *deviceTable) Driver() driver.Driver { return table }
+func (table *deviceTable) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("not supported")
+}
+func (table *deviceTable) Close() error              { return nil }
+func (table *deviceTable) Begin() (driver.Tx, error) { return nil, errors.New("not supported") }
+
+func (table *deviceTable) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	rows := &tableRows{}
+	switch {
+	case strings.HasPrefix(query, "SELECT id, bridgeIdentifier FROM devices WHERE adapterId = ? AND bridgeIdentifier IN "):
+		for _, device := range table.devices {
+			if device.adapterId != args[0].Value {
+				continue
+			}
+			for _, arg := range args[1:] {
+				if device.bridgeIdentifier == arg.Value {
+					rows.rows = append(rows.rows, []driver.Value{device.id, device.bridgeIdentifier})
+				}
+			}
+		}
+	case strings.HasPrefix(query, "SELECT id, adapterId FROM devices WHERE id IN "):
+		for _, device := range table.devices {
+			for _, arg := range args {
+				if device.id == arg.Value {
+					rows.rows = append(rows.rows, []driver.Value{device.id, device.adapterId})
+				}
+			}
+		}
+	default:
+		return nil, errors.New("unexpected query " + query)
+	}
+	return rows, nil
+}
+
+func TestResolveIngestGroupMembers(t *testing.T) {
+	tests := []struct {
+		name  string
+		group ingestmodels.IngestGroup
+		// expected are the resolved store IDs, unless the members are refused
+		expected []int
+		// expectedLocations are those of the details of a 422, if the members are refused
+		expectedLocations []string
+	}{
+		{
+			name:     "Resolves bridge identifiers against the adapter of the group",
+			group:    ingestmodels.IngestGroup{AdapterId: 1, DeviceBridgeIdentifiers: []string{"lamp", "switch"}},
+			expected: []int{1, 2},
+		},
+		{
+			name:              "Refuses bridge identifiers only known to other adapters",
+			group:             ingestmodels.IngestGroup{AdapterId: 1, DeviceBridgeIdentifiers: []string{"lamp", "sensor"}},
+			expectedLocations: []string{"body.device-bridge-identifiers[1]"},
+		},
+		{
+			name:     "Resolves device IDs of the adapter of the group",
+			group:    ingestmodels.IngestGroup{AdapterId: 1, DeviceIds: []int{2}},
+			expected: []int{2},
+		},
+		{
+			name:              "Refuses device IDs of other adapters",
+			group:             ingestmodels.IngestGroup{AdapterId: 1, DeviceIds: []int{1, 4}},
+			expectedLocations: []string{"body.device-ids[1]"},
+		},
+		{
+			name:              "Refuses unknown devices",
+			group:             ingestmodels.IngestGroup{AdapterId: 1, DeviceIds: []int{9}, DeviceBridgeIdentifiers: []string{"unknown"}},
+			expectedLocations: []string{"body.device-bridge-identifiers[0]", "body.device-ids[0]"},
+		},
+		{
+			name:     "Mixes bridge identifiers and device IDs",
+			group:    ingestmodels.IngestGroup{AdapterId: 2, DeviceIds: []int{4}, DeviceBridgeIdentifiers: []string{"lamp"}},
+			expected: []int{3, 4},
+		},
+		{
+			name:              "Lists every bad member of both lists",
+			group:             ingestmodels.IngestGroup{AdapterId: 2, DeviceIds: []int{1, 4, 9}, DeviceBridgeIdentifiers: []string{"switch", "lamp", "unknown"}},
+			expectedLocations: []string{"body.device-bridge-identifiers[0]", "body.device-bridge-identifiers[2]", "body.device-ids[0]", "body.device-ids[2]"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Both adapters have a lamp, only adapter 1 has a switch and only adapter 2 has a sensor
+			table := &deviceTable{devices: []tableDevice{
+				{id: 1, bridgeIdentifier: "lamp", adapterId: 1},
+				{id: 2, bridgeIdentifier: "switch", adapterId: 1},
+				{id: 3, bridgeIdentifier: "lamp", adapterId: 2},
+				{id: 4, bridgeIdentifier: "sensor", adapterId: 2},
+			}}
+			db := sql.OpenDB(table)
+			defer db.Close()
+			memberIds, err := resolveIngestGroupMembersTx(context.Background(), tt.group, db)
+			if tt.expectedLocations != nil {
+				var errorModel *huma.ErrorModel
+				if !errors.As(err, &errorModel) || errorModel.Status != http.StatusUnprocessableEntity {
+					t.Fatalf("expected a 422, got %v", err)
+				}
+				locations := []string{}
+				for _, detail := range errorModel.Errors {
+					locations = append(locations, detail.Location)
+				}
+				if !slices.Equal(locations, tt.expectedLocations) {
+					t.Errorf("expected details at %v, got %v", tt.expectedLocations, locations)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if !slices.Equal(memberIds, tt.expected) {
+				t.Errorf("expected members %v, got %v", tt.expected, memberIds)
+			}
+		})
+	}
+}
@@ -0,0 +1,258 @@
+package mariadb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/Kaese72/device-store/internal/persistence/intermediaries"
+	"github.com/Kaese72/device-store/restmodels"
+	"github.com/danielgtaylor/huma/v2"
+)
+
+// descendantGroupsCTE resolves the group passed as its only argument and every group contained in it, directly or
+// through other groups, into "descendants". Soft deleted groups and everything below them are left out.
+// UNION rather than UNION ALL makes the recursion stop at groups already visited.
+const descendantGroupsCTE = `WITH RECURSIVE descendants (id) AS (` +
+	`SELECT CAST(? AS UNSIGNED) UNION ` +
+	`SELECT groupGroups.childGroupId FROM groupGroups INNER JOIN descendants ON groupGroups.parentGroupId = descendants.id ` +
+	`INNER JOIN groups ON groups.id = groupGroups.childGroupId WHERE groups.deleted IS NULL) `
+
+// ancestorGroupsCTE resolves every group containing the device passed as its only argument, directly or
+// through other groups, into "ancestors". Soft deleted groups and everything above them are left out.
+const ancestorGroupsCTE = `WITH RECURSIVE ancestors (id) AS (` +
+	`SELECT groupDevices.groupId FROM groupDevices INNER JOIN groups ON groups.id = groupDevices.groupId WHERE groupDevices.deviceId = ? AND groups.deleted IS NULL UNION ` +
+	`SELECT groupGroups.parentGroupId FROM groupGroups INNER JOIN ancestors ON groupGroups.childGroupId = ancestors.id ` +
+	`INNER JOIN groups ON groups.id = groupGroups.parentGroupId WHERE groups.deleted IS NULL) `
+
+// containingGroupsCTE resolves the group passed as its only argument and every group containing it, directly or
+// through other groups, into "ancestors". Soft deleted groups and everything above them are left out.
+const containingGroupsCTE = `WITH RECURSIVE ancestors (id) AS (` +
+	`SELECT id FROM groups WHERE id = ? AND deleted IS NULL UNION ` +
+	`SELECT groupGroups.parentGroupId FROM groupGroups INNER JOIN ancestors ON groupGroups.childGroupId = ancestors.id ` +
+	`INNER JOIN groups ON groups.id = groupGroups.parentGroupId WHERE groups.deleted IS NULL) `
+
+// effectiveGroupIdFilter selects the devices that are members of a group, directly or through contained groups
+func effectiveGroupIdFilter(value string) (string, []string, error) {
+	if !regexp.MustCompile(`^\d+$`).MatchString(value) {
+		return "", nil, huma.Error400BadRequest("effective-group-id filter must be an integer value")
+	}
+	return "id IN (" + descendantGroupsCTE + "SELECT groupDevices.deviceId FROM groupDevices INNER JOIN descendants ON descendants.id = groupDevices.groupId)", []string{value}, nil
+}
+
+// effectiveDeviceIdFilter selects the groups a device is a member of, directly or through contained groups
+func effectiveDeviceIdFilter(value string) (string, []string, error) {
+	if !regexp.MustCompile(`^\d+$`).MatchString(value) {
+		return "", nil, huma.Error400BadRequest("effective-device-id filter must be an integer value")
+	}
+	return "id IN (" + ancestorGroupsCTE + "SELECT id FROM ancestors)", []string{value}, nil
+}
+
+func queryIdsTx(ctx context.Context, tx queryAble, query string, args ...any) ([]int, error) {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// getDescendantGroupIdsTx returns the group and every group contained in it
+func getDescendantGroupIdsTx(ctx context.Context, groupId int, tx queryAble) ([]int, error) {
+	return queryIdsTx(ctx, tx, descendantGroupsCTE+`SELECT id FROM descendants`, groupId)
+}
+
+// getEffectiveDeviceIdsTx returns the devices of the group and of every group contained in it
+func getEffectiveDeviceIdsTx(ctx context.Context, groupId int, tx queryAble) ([]int, error) {
+	return queryIdsTx(ctx, tx, descendantGroupsCTE+`SELECT DISTINCT groupDevices.deviceId FROM groupDevices INNER JOIN descendants ON descendants.id = groupDevices.groupId `+
+		`INNER JOIN devices ON devices.id = groupDevices.deviceId WHERE devices.deleted IS NULL ORDER BY groupDevices.deviceId`, groupId)
+}
+
+// setGroupChildrenTx makes childIds the groups contained in a group and returns the added and removed group IDs.
+// Contained groups must exist and may not contain the group itself, directly or indirectly.
+func setGroupChildrenTx(ctx context.Context, groupId int, childIds []int, tx queryAble) ([]int, []int, error) {
+	details := []error{}
+	for index, childId := range childIds {
+		var deleted *string
+		err := tx.QueryRowContext(ctx, `SELECT deleted FROM groups WHERE id = ?`, childId).Scan(&deleted)
+		if err != nil && err != sql.ErrNoRows {
+			return nil, nil, err
+		}
+		if err == sql.ErrNoRows || deleted != nil {
+			details = append(details, &huma.ErrorDetail{Message: "group not found", Location: fmt.Sprintf("body.group-ids[%d]", index), Value: childId})
+			continue
+		}
+		descendants, err := getDescendantGroupIdsTx(ctx, childId, tx)
+		if err != nil {
+			return nil, nil, err
+		}
+		if slices.Contains(descendants, groupId) {
+			details = append(details, &huma.ErrorDetail{Message: "group contains this group, which would create a cycle", Location: fmt.Sprintf("body.group-ids[%d]", index), Value: childId})
+		}
+	}
+	if len(details) > 0 {
+		return nil, nil, huma.Error422UnprocessableEntity("group refers to unknown groups or groups that would create a cycle", details...)
+	}
+	present, err := queryIdsTx(ctx, tx, `SELECT childGroupId FROM groupGroups WHERE parentGroupId = ?`, groupId)
+	if err != nil {
+		return nil, nil, err
+	}
+	var added, removed []int
+	for _, childId := range childIds {
+		if slices.Contains(present, childId) || slices.Contains(added, childId) {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO groupGroups (parentGroupId, childGroupId) VALUES (?, ?)`, groupId, childId); err != nil {
+			return nil, nil, err
+		}
+		added = append(added, childId)
+	}
+	for _, childId := range present {
+		if slices.Contains(childIds, childId) {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM groupGroups WHERE parentGroupId = ? AND childGroupId = ?`, groupId, childId); err != nil {
+			return nil, nil, err
+		}
+		removed = append(removed, childId)
+	}
+	return added, removed, nil
+}
+
+func (persistence mariadbPersistence) GetGroupTriggerTargets(ctx context.Context, storeIdentifier int, capabilityName string) (intermediaries.GroupTriggerTargets, error) {
+	targets := intermediaries.GroupTriggerTargets{
+		Groups:  []intermediaries.GroupCapabilityIntermediaryActivation{},
+		Devices: []intermediaries.DeviceCapabilityIntermediaryActivation{},
+	}
+	var exists int
+	err := persistence.db.QueryRowContext(ctx, `SELECT 1 FROM groups WHERE id = ? AND deleted IS NULL`, storeIdentifier).Scan(&exists)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return targets, huma.Error404NotFound(fmt.Sprintf("group %d not found", storeIdentifier))
+		}
+		return targets, err
+	}
+	// Walk down the contained groups. Groups having the capability themselves are triggered as a whole,
+	// the devices of all other groups are triggered one by one.
+	visited := []int{storeIdentifier}
+	deviceGroups := []int{storeIdentifier}
+	queue := []int{storeIdentifier}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		rows, err := persistence.db.QueryContext(ctx,
+			`SELECT groups.id, groups.bridgeIdentifier, groups.adapterId, groupCapabilities.name IS NOT NULL, groupCapabilities.argumentJsonSchema, groupCapabilities.argumentStandardSchema FROM groupGroups INNER JOIN groups ON groups.id = groupGroups.childGroupId `+
+				`LEFT JOIN groupCapabilities ON groupCapabilities.groupId = groups.id AND groupCapabilities.name = ? WHERE groupGroups.parentGroupId = ? AND groups.deleted IS NULL ORDER BY groups.id`,
+			capabilityName, current)
+		if err != nil {
+			return targets, err
+		}
+		for rows.Next() {
+			child := intermediaries.GroupCapabilityIntermediaryActivation{Name: capabilityName}
+			var native bool
+			var argumentsBytes, schemaBytes []byte
+			if err := rows.Scan(&child.GroupId, &child.BridgeIdentifier, &child.AdapterId, &native, &argumentsBytes, &schemaBytes); err != nil {
+				rows.Close()
+				return targets, err
+			}
+			if child.ArgumentSpecs, err = unmarshalArgumentSpecs(argumentsBytes); err != nil {
+				rows.Close()
+				return targets, err
+			}
+			if child.ArgumentJSONSchema, err = unmarshalArgumentSchema(schemaBytes); err != nil {
+				rows.Close()
+				return targets, err
+			}
+			if slices.Contains(visited, child.GroupId) {
+				continue
+			}
+			visited = append(visited, child.GroupId)
+			if native {
+				targets.Groups = append(targets.Groups, child)
+				continue
+			}
+			deviceGroups = append(deviceGroups, child.GroupId)
+			queue = append(queue, child.GroupId)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return targets, err
+		}
+	}
+	// Devices in groups triggered as a whole must not be triggered again
+	covered := []int{}
+	for _, group := range targets.Groups {
+		deviceIds, err := getEffectiveDeviceIdsTx(ctx, group.GroupId, persistence.db)
+		if err != nil {
+			return targets, err
+		}
+		covered = append(covered, deviceIds...)
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(deviceGroups)), ",")
+	variables := []any{capabilityName}
+	for _, groupId := range deviceGroups {
+		variables = append(variables, groupId)
+	}
+	rows, err := persistence.db.QueryContext(ctx,
+		`SELECT DISTINCT devices.id, devices.bridgeIdentifier, deviceCapabilities.name, devices.adapterId, deviceCapabilities.argumentJsonSchema, deviceCapabilities.argumentStandardSchema, deviceCapabilities.expectedOutcome FROM groupDevices INNER JOIN devices ON devices.id = groupDevices.deviceId `+
+			`INNER JOIN deviceCapabilities ON deviceCapabilities.deviceId = devices.id WHERE deviceCapabilities.name = ? AND devices.deleted IS NULL AND groupDevices.groupId IN (`+placeholders+`) ORDER BY devices.id`,
+		variables...)
+	if err != nil {
+		return targets, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var capability intermediaries.DeviceCapabilityIntermediaryActivation
+		var argumentsBytes, schemaBytes, expectedBytes []byte
+		if err := rows.Scan(&capability.DeviceId, &capability.BridgeIdentifier, &capability.Name, &capability.AdapterId, &argumentsBytes, &schemaBytes, &expectedBytes); err != nil {
+			return targets, err
+		}
+		if capability.ArgumentSpecs, err = unmarshalArgumentSpecs(argumentsBytes); err != nil {
+			return targets, err
+		}
+		if capability.ArgumentJSONSchema, err = unmarshalArgumentSchema(schemaBytes); err != nil {
+			return targets, err
+		}
+		if capability.ExpectedOutcome, err = unmarshalExpectedOutcome(expectedBytes); err != nil {
+			return targets, err
+		}
+		if slices.Contains(covered, capability.DeviceId) {
+			continue
+		}
+		targets.Devices = append(targets.Devices, capability)
+	}
+	if err := rows.Err(); err != nil {
+		return targets, err
+	}
+	if len(targets.Groups) == 0 && len(targets.Devices) == 0 {
+		return targets, huma.Error404NotFound(fmt.Sprintf("capability %s not found for any member of group %d", capabilityName, storeIdentifier))
+	}
+	return targets, nil
+}
+
+// setEffectiveMembershipTx fills in the devices of every group including those of contained groups
+func setEffectiveMembershipTx(ctx context.Context, groups []restmodels.Group, tx queryAble) error {
+	var err error
+	for index, group := range groups {
+		if len(group.GroupIds) == 0 {
+			groups[index].EffectiveDeviceIds = slices.Clone(group.DeviceIds)
+			continue
+		}
+		groups[index].EffectiveDeviceIds, err = getEffectiveDeviceIdsTx(ctx, group.ID, tx)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
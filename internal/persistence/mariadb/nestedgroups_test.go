@@ -0,0 +1,188 @@
+package mariadb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/danielgtaylor/huma/v2"
+)
+
+// groupGraph is a database of groups answering the queries made while nesting groups
+type groupGraph struct {
+	// children are the groups contained in a group, keyed by group ID
+	children map[int64][]int64
+	deleted  []int64
+}
+
+func (graph *groupGraph) Open(name string) (driver.Conn, error) { return graph, nil }
+func (graph *groupGraph) Connect(context.Context) (driver.Conn, error) {
+	return graph, nil
+}
+func (graph *groupGraph) Driver() driver.Driver { return graph }
+func (graph *groupGraph) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("not supported")
+}
+func (graph *groupGraph) Close() error              { return nil }
+func (graph *groupGraph) Begin() (driver.Tx, error) { return nil, errors.New("not supported") }
+
+func (graph *groupGraph) exists(groupId int64) bool {
+	if _, ok := graph.children[groupId]; ok {
+		return true
+	}
+	for _, children := range graph.children {
+		if slices.Contains(children, groupId) {
+			return true
+		}
+	}
+	return false
+}
+
+func (graph *groupGraph) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	id := args[0].Value.(int64)
+	switch {
+	case query == `SELECT deleted FROM groups WHERE id = ?`:
+		if !graph.exists(id) {
+			return &idRows{}, nil
+		}
+		if slices.Contains(graph.deleted, id) {
+			return &idRows{values: []driver.Value{"2024-01-01 00:00:00"}}, nil
+		}
+		return &idRows{values: []driver.Value{nil}}, nil
+	case query == descendantGroupsCTE+`SELECT id FROM descendants`:
+		descendants := []driver.Value{id}
+		for queue := []int64{id}; len(queue) > 0; queue = queue[1:] {
+			for _, child := range graph.children[queue[0]] {
+				if !slices.Contains(descendants, driver.Value(child)) && !slices.Contains(graph.deleted, child) {
+					descendants = append(descendants, child)
+					queue = append(queue, child)
+				}
+			}
+		}
+		return &idRows{values: descendants}, nil
+	case query == `SELECT childGroupId FROM groupGroups WHERE parentGroupId = ?`:
+		rows := &idRows{}
+		for _, child := range graph.children[id] {
+			rows.values = append(rows.values, child)
+		}
+		return rows, nil
+	}
+	return nil, errors.New("unexpected query " + query)
+}
+
+func (graph *groupGraph) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	parent, child := args[0].Value.(int64), args[1].Value.(int64)
+	switch {
+	case strings.HasPrefix(query, "INSERT INTO groupGroups"):
+		graph.children[parent] = append(graph.children[parent], child)
+	case strings.HasPrefix(query, "DELETE FROM groupGroups"):
+		graph.children[parent] = slices.DeleteFunc(graph.children[parent], func(id int64) bool { return id == child })
+	default:
+		return nil, errors.New("unexpected statement " + query)
+	}
+	return driver.RowsAffected(1), nil
+}
+
+// idRows is a result of a single column
+type idRows struct {
+	values []driver.Value
+}
+
+func (rows *idRows) Columns() []string { return []string{"id"} }
+func (rows *idRows) Close() error      { return nil }
+func (rows *idRows) Next(dest []driver.Value) error {
+	if len(rows.values) == 0 {
+		return io.EOF
+	}
+	dest[0], rows.values = rows.values[0], rows.values[1:]
+	return nil
+}
+
+func TestSetGroupChildren(t *testing.T) {
+	tests := []struct {
+		name            string
+		groupId         int
+		childIds        []int
+		expectedAdded   []int
+		expectedRemoved []int
+		// expectedLocations are those of the details of a 422, if the children are refused
+		expectedLocations []string
+	}{
+		{
+			name:            "Adds and removes children",
+			groupId:         1,
+			childIds:        []int{3, 4},
+			expectedAdded:   []int{4},
+			expectedRemoved: []int{2},
+		},
+		{
+			name:              "Refuses containing itself",
+			groupId:           1,
+			childIds:          []int{1},
+			expectedLocations: []string{"body.group-ids[0]"},
+		},
+		{
+			name:              "Refuses a group containing it",
+			groupId:           3,
+			childIds:          []int{4, 1},
+			expectedLocations: []string{"body.group-ids[1]"},
+		},
+		{
+			name:              "Refuses a group containing it indirectly",
+			groupId:           5,
+			childIds:          []int{1},
+			expectedLocations: []string{"body.group-ids[0]"},
+		},
+		{
+			name:              "Refuses unknown and deleted groups",
+			groupId:           1,
+			childIds:          []int{9, 6},
+			expectedLocations: []string{"body.group-ids[0]", "body.group-ids[1]"},
+		},
+		{
+			name:            "Ignores cycles through deleted groups",
+			groupId:         7,
+			childIds:        []int{8},
+			expectedAdded:   []int{8},
+			expectedRemoved: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 1 contains 2 and 3, 3 contains 5, 6 is deleted and contains 7, and 8 contains 6
+			graph := &groupGraph{
+				children: map[int64][]int64{1: {2, 3}, 3: {5}, 4: {}, 6: {7}, 8: {6}},
+				deleted:  []int64{6},
+			}
+			db := sql.OpenDB(graph)
+			defer db.Close()
+			added, removed, err := setGroupChildrenTx(context.Background(), tt.groupId, tt.childIds, db)
+			if tt.expectedLocations != nil {
+				var errorModel *huma.ErrorModel
+				if !errors.As(err, &errorModel) || errorModel.Status != http.StatusUnprocessableEntity {
+					t.Fatalf("expected a 422, got %v", err)
+				}
+				locations := []string{}
+				for _, detail := range errorModel.Errors {
+					locations = append(locations, detail.Location)
+				}
+				if !slices.Equal(locations, tt.expectedLocations) {
+					t.Errorf("expected details at %v, got %v", tt.expectedLocations, locations)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if !slices.Equal(added, tt.expectedAdded) || !slices.Equal(removed, tt.expectedRemoved) {
+				t.Errorf("expected %v added and %v removed, got %v and %v", tt.expectedAdded, tt.expectedRemoved, added, removed)
+			}
+		})
+	}
+}
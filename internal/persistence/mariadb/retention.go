@@ -0,0 +1,177 @@
+package mariadb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/Kaese72/device-store/internal/persistence/intermediaries"
+	"github.com/Kaese72/device-store/restmodels"
+)
+
+type auditTableDefinition struct {
+	// table is the name of the database table
+	table string
+	// ownerColumn is the column referring to the device or group the audit row belongs to.
+	// Tables without an owner can only be pruned by age.
+	ownerColumn string
+	// timestampColumn is the column rows are aged by, "timestamp" unless set. Rows where it is NULL are kept.
+	timestampColumn string
+}
+
+func (definition auditTableDefinition) timestamp() string {
+	if definition.timestampColumn == "" {
+		return "timestamp"
+	}
+	return definition.timestampColumn
+}
+
+// auditTableDefinitions maps the audit tables subject to retention onto the database.
+// Table names are interpolated into queries, so they must never come from user input.
+var auditTableDefinitions = map[intermediaries.AuditTable]auditTableDefinition{
+	intermediaries.AttributeAudits:               {table: "deviceAttributeAudit", ownerColumn: "deviceId"},
+	intermediaries.DeviceCapabilityTriggerAudits: {table: "deviceCapabilityTriggerAudit", ownerColumn: "deviceId"},
+	intermediaries.GroupCapabilityTriggerAudits:  {table: "groupCapabilityTriggerAudit", ownerColumn: "groupId"},
+	intermediaries.SceneActivationAudits:         {table: "sceneActivationAudit", ownerColumn: "sceneId"},
+	intermediaries.ScheduleRuns:                  {table: "scheduleRuns", ownerColumn: "scheduleId"},
+	intermediaries.RuleFirings:                   {table: "ruleFirings", ownerColumn: "ruleId"},
+	intermediaries.Tombstones:                    {table: "tombstones"},
+	intermediaries.IdempotencyKeys:               {table: "triggerIdempotencyKeys"},
+	intermediaries.TriggerJobs:                   {table: "triggerJobs", timestampColumn: "finished"},
+}
+
+func lookupAuditTable(table intermediaries.AuditTable) (auditTableDefinition, error) {
+	definition, ok := auditTableDefinitions[table]
+	if !ok {
+		return auditTableDefinition{}, fmt.Errorf("unknown audit table %s", table)
+	}
+	return definition, nil
+}
+
+// deleteInBatches repeatedly executes a DELETE ... LIMIT query until fewer than batchSize rows are affected.
+// Running many small deletes instead of one large one avoids holding locks on the audit tables for long.
+func (persistence mariadbPersistence) deleteInBatches(ctx context.Context, query string, batchSize int, args ...any) (int64, error) {
+	var deleted int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return deleted, err
+		}
+		result, err := persistence.db.ExecContext(ctx, query, append(args, batchSize)...)
+		if err != nil {
+			return deleted, err
+		}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return deleted, err
+		}
+		deleted += rowsAffected
+		if rowsAffected < int64(batchSize) {
+			return deleted, nil
+		}
+	}
+}
+
+func (persistence mariadbPersistence) PruneAuditsOlderThan(ctx context.Context, table intermediaries.AuditTable, cutoff time.Time, batchSize int) (int64, error) {
+	definition, err := lookupAuditTable(table)
+	if err != nil {
+		return 0, err
+	}
+	return persistence.deleteInBatches(ctx, `DELETE FROM `+definition.table+` WHERE `+definition.timestamp()+` < ? ORDER BY id LIMIT ?`, batchSize, cutoff)
+}
+
+func (persistence mariadbPersistence) PruneAuditsExceedingRows(ctx context.Context, table intermediaries.AuditTable, maxRows int, batchSize int) (int64, error) {
+	definition, err := lookupAuditTable(table)
+	if err != nil {
+		return 0, err
+	}
+	if definition.ownerColumn == "" {
+		return 0, fmt.Errorf("%s can not be pruned by row count", table)
+	}
+	// Find all owners with too many rows before deleting anything, so that we do not
+	// keep a result set open while executing deletes on the same table
+	rows, err := persistence.db.QueryContext(ctx, `SELECT `+definition.ownerColumn+` FROM `+definition.table+` GROUP BY `+definition.ownerColumn+` HAVING COUNT(*) > ?`, maxRows)
+	if err != nil {
+		return 0, err
+	}
+	var owners []int
+	for rows.Next() {
+		var owner int
+		if err := rows.Scan(&owner); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		owners = append(owners, owner)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	var deleted int64
+	for _, owner := range owners {
+		// The newest row that should be removed. Everything with an id lower than or equal to it goes as well.
+		var cutoffId int
+		row := persistence.db.QueryRowContext(ctx, `SELECT id FROM `+definition.table+` WHERE `+definition.ownerColumn+` = ? ORDER BY id DESC LIMIT 1 OFFSET ?`, owner, maxRows)
+		if err := row.Scan(&cutoffId); err != nil {
+			if err == sql.ErrNoRows {
+				// Pruned concurrently by someone else
+				continue
+			}
+			return deleted, err
+		}
+		ownerDeleted, err := persistence.deleteInBatches(ctx, `DELETE FROM `+definition.table+` WHERE `+definition.ownerColumn+` = ? AND id <= ? ORDER BY id LIMIT ?`, batchSize, owner, cutoffId)
+		deleted += ownerDeleted
+		if err != nil {
+			return deleted, err
+		}
+	}
+	return deleted, nil
+}
+
+func (persistence mariadbPersistence) PurgeSoftDeleted(ctx context.Context, cutoff time.Time, batchSize int) (int64, error) {
+	// Purged devices and groups leave tombstones behind through the delete triggers
+	devicesDeleted, err := persistence.deleteInBatches(ctx, `DELETE FROM devices WHERE deleted < ? ORDER BY id LIMIT ?`, batchSize, cutoff)
+	if err != nil {
+		return devicesDeleted, err
+	}
+	groupsDeleted, err := persistence.deleteInBatches(ctx, `DELETE FROM groups WHERE deleted < ? ORDER BY id LIMIT ?`, batchSize, cutoff)
+	return devicesDeleted + groupsDeleted, err
+}
+
+func (persistence mariadbPersistence) WriteAuditPruneRun(ctx context.Context, run restmodels.AuditPruneRun) error {
+	_, err := persistence.db.ExecContext(ctx,
+		`INSERT INTO auditPruneRuns (auditTable, started, finished, deletedRows, cutoff, errorMessage) VALUES (?, ?, ?, ?, ?, ?)`,
+		run.Table, run.Started, run.Finished, run.DeletedRows, run.Cutoff, run.ErrorMessage,
+	)
+	return err
+}
+
+func (persistence mariadbPersistence) GetAuditTableStatus(ctx context.Context) ([]restmodels.AuditTableStatus, error) {
+	statuses := []restmodels.AuditTableStatus{}
+	for _, table := range intermediaries.AuditTables {
+		definition, err := lookupAuditTable(table)
+		if err != nil {
+			return nil, err
+		}
+		status := restmodels.AuditTableStatus{Table: string(table)}
+		row := persistence.db.QueryRowContext(ctx, `SELECT COUNT(*), MIN(`+definition.timestamp()+`) FROM `+definition.table)
+		if err := row.Scan(&status.Rows, &status.OldestTimestamp); err != nil {
+			return nil, err
+		}
+		row = persistence.db.QueryRowContext(ctx, `SELECT COALESCE(DATA_LENGTH + INDEX_LENGTH, 0) FROM information_schema.TABLES WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ?`, definition.table)
+		if err := row.Scan(&status.Bytes); err != nil && err != sql.ErrNoRows {
+			return nil, err
+		}
+		lastRun := restmodels.AuditPruneRun{}
+		row = persistence.db.QueryRowContext(ctx, `SELECT auditTable, started, finished, deletedRows, cutoff, errorMessage FROM auditPruneRuns WHERE auditTable = ? ORDER BY finished DESC, id DESC LIMIT 1`, string(table))
+		err = row.Scan(&lastRun.Table, &lastRun.Started, &lastRun.Finished, &lastRun.DeletedRows, &lastRun.Cutoff, &lastRun.ErrorMessage)
+		if err != nil && err != sql.ErrNoRows {
+			return nil, err
+		}
+		if err == nil {
+			status.LastPruneRun = &lastRun
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
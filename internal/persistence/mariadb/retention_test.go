@@ -0,0 +1,252 @@
+package mariadb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Kaese72/device-store/internal/persistence/intermediaries"
+	"github.com/Kaese72/device-store/restmodels"
+)
+
+// auditRow is a row of any table pruned by the retention worker. A zero timestamp is NULL.
+type auditRow struct {
+	id        int64
+	owner     int64
+	timestamp time.Time
+}
+
+// auditTables is a database of audit tables, keyed by table name, answering the statements made while pruning them
+// and reporting on them. Every table is aged by its only timestamp column, whatever its name.
+type auditTables struct {
+	tables  map[string][]auditRow
+	runs    []restmodels.AuditPruneRun
+	deletes int
+}
+
+func (db *auditTables) Open(name string) (driver.Conn, error) { return db, nil }
+func (db *auditTables) Connect(context.Context) (driver.Conn, error) {
+	return db, nil
+}
+func (db *auditTables) Driver() driver.Driver { return db }
+func (db *auditTables) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("not supported")
+}
+func (db *auditTables) Close() error              { return nil }
+func (db *auditTables) Begin() (driver.Tx, error) { return nil, errors.New("not supported") }
+
+func (db *auditTables) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	fields := strings.Fields(query)
+	switch {
+	case strings.Contains(query, " GROUP BY "):
+		counts := map[int64]int64{}
+		for _, row := range db.tables[fields[3]] {
+			counts[row.owner]++
+		}
+		rows := &tableRows{}
+		for owner, count := range counts {
+			if count > args[0].Value.(int64) {
+				rows.rows = append(rows.rows, []driver.Value{owner})
+			}
+		}
+		return rows, nil
+	case strings.Contains(query, " ORDER BY id DESC LIMIT 1 OFFSET ?"):
+		ids := []int64{}
+		for _, row := range db.tables[fields[3]] {
+			if row.owner == args[0].Value {
+				ids = append(ids, row.id)
+			}
+		}
+		slices.Reverse(ids)
+		if offset := int(args[1].Value.(int64)); offset < len(ids) {
+			return &tableRows{rows: [][]driver.Value{{ids[offset]}}}, nil
+		}
+		return &tableRows{}, nil
+	case strings.HasPrefix(query, "SELECT COUNT(*), MIN("):
+		var oldest driver.Value
+		for _, row := range db.tables[fields[4]] {
+			if !row.timestamp.IsZero() && (oldest == nil || row.timestamp.Before(oldest.(time.Time))) {
+				oldest = row.timestamp
+			}
+		}
+		return &tableRows{rows: [][]driver.Value{{int64(len(db.tables[fields[4]])), oldest}}}, nil
+	case strings.HasPrefix(query, "SELECT COALESCE(DATA_LENGTH + INDEX_LENGTH, 0)"):
+		// Every row takes a kilobyte
+		return &tableRows{rows: [][]driver.Value{{int64(1024 * len(db.tables[args[0].Value.(string)]))}}}, nil
+	case strings.HasPrefix(query, "SELECT auditTable, started, finished, deletedRows, cutoff, errorMessage FROM auditPruneRuns"):
+		for _, run := range slices.Backward(db.runs) {
+			if run.Table == args[0].Value {
+				var cutoff, errorMessage driver.Value
+				if run.Cutoff != nil {
+					cutoff = *run.Cutoff
+				}
+				if run.ErrorMessage != nil {
+					errorMessage = *run.ErrorMessage
+				}
+				return &tableRows{rows: [][]driver.Value{{run.Table, run.Started, run.Finished, run.DeletedRows, cutoff, errorMessage}}}, nil
+			}
+		}
+		return &tableRows{}, nil
+	}
+	return nil, errors.New("unexpected query " + query)
+}
+
+func (db *auditTables) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	fields := strings.Fields(query)
+	switch {
+	case strings.HasPrefix(query, "DELETE FROM "):
+		db.deletes++
+		table := fields[2]
+		// The rows to delete, oldest first, up to the LIMIT given as the last argument
+		matches := func(row auditRow) bool {
+			if fields[5] == "<" {
+				return !row.timestamp.IsZero() && row.timestamp.Before(args[0].Value.(time.Time))
+			}
+			return row.owner == args[0].Value && row.id <= args[1].Value.(int64)
+		}
+		limit := args[len(args)-1].Value.(int64)
+		var deleted int64
+		db.tables[table] = slices.DeleteFunc(db.tables[table], func(row auditRow) bool {
+			if deleted < limit && matches(row) {
+				deleted++
+				return true
+			}
+			return false
+		})
+		return driver.RowsAffected(deleted), nil
+	case strings.HasPrefix(query, "INSERT INTO auditPruneRuns "):
+		run := restmodels.AuditPruneRun{Table: args[0].Value.(string), Started: args[1].Value.(time.Time), Finished: args[2].Value.(time.Time), DeletedRows: args[3].Value.(int64)}
+		if args[4].Value != nil {
+			cutoff := args[4].Value.(time.Time)
+			run.Cutoff = &cutoff
+		}
+		if args[5].Value != nil {
+			errorMessage := args[5].Value.(string)
+			run.ErrorMessage = &errorMessage
+		}
+		db.runs = append(db.runs, run)
+		return driver.RowsAffected(1), nil
+	}
+	return nil, errors.New("unexpected statement " + query)
+}
+
+// auditRows returns count rows of owner, with IDs from firstId, an hour apart with the oldest at oldest
+func auditRows(firstId int64, owner int64, count int, oldest time.Time) []auditRow {
+	rows := []auditRow{}
+	for index := range count {
+		rows = append(rows, auditRow{id: firstId + int64(index), owner: owner, timestamp: oldest.Add(time.Duration(index) * time.Hour)})
+	}
+	return rows
+}
+
+func rowIds(rows []auditRow) []int64 {
+	ids := []int64{}
+	for _, row := range rows {
+		ids = append(ids, row.id)
+	}
+	return ids
+}
+
+func TestPruneAudits(t *testing.T) {
+	oldest := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	ctx := context.Background()
+
+	t.Run("Pruning by age deletes in batches", func(t *testing.T) {
+		db := &auditTables{tables: map[string][]auditRow{"deviceAttributeAudit": auditRows(1, 1, 7, oldest)}}
+		conn := sql.OpenDB(db)
+		defer conn.Close()
+		deleted, err := mariadbPersistence{db: conn}.PruneAuditsOlderThan(ctx, intermediaries.AttributeAudits, oldest.Add(5*time.Hour), 2)
+		if err != nil || deleted != 5 {
+			t.Fatalf("expected 5 rows to be deleted, got %d and %v", deleted, err)
+		}
+		// Two full batches and the one finding fewer rows than the batch size
+		if db.deletes != 3 {
+			t.Errorf("expected 3 batches, got %d", db.deletes)
+		}
+		if remaining := rowIds(db.tables["deviceAttributeAudit"]); !slices.Equal(remaining, []int64{6, 7}) {
+			t.Errorf("expected the newest rows to remain, got %v", remaining)
+		}
+	})
+
+	t.Run("Pruning by row count keeps the newest rows of every owner", func(t *testing.T) {
+		rows := slices.Concat(auditRows(1, 1, 5, oldest), auditRows(6, 2, 2, oldest), auditRows(8, 1, 1, oldest))
+		db := &auditTables{tables: map[string][]auditRow{"deviceCapabilityTriggerAudit": rows}}
+		conn := sql.OpenDB(db)
+		defer conn.Close()
+		deleted, err := mariadbPersistence{db: conn}.PruneAuditsExceedingRows(ctx, intermediaries.DeviceCapabilityTriggerAudits, 2, 2)
+		if err != nil || deleted != 4 {
+			t.Fatalf("expected 4 rows to be deleted, got %d and %v", deleted, err)
+		}
+		if remaining := rowIds(db.tables["deviceCapabilityTriggerAudit"]); !slices.Equal(remaining, []int64{5, 6, 7, 8}) {
+			t.Errorf("expected the 2 newest rows of every owner to remain, got %v", remaining)
+		}
+	})
+
+	t.Run("Tables without owners are not pruned by row count", func(t *testing.T) {
+		if _, err := (mariadbPersistence{}).PruneAuditsExceedingRows(ctx, intermediaries.Tombstones, 2, 2); err == nil {
+			t.Errorf("expected tombstones to be refused")
+		}
+	})
+
+	t.Run("Purging soft deleted devices and groups keeps those deleted recently", func(t *testing.T) {
+		db := &auditTables{tables: map[string][]auditRow{
+			"devices": {{id: 1, timestamp: oldest}, {id: 2}, {id: 3, timestamp: oldest.Add(48 * time.Hour)}},
+			"groups":  {{id: 1, timestamp: oldest}, {id: 2, timestamp: oldest.Add(time.Hour)}},
+		}}
+		conn := sql.OpenDB(db)
+		defer conn.Close()
+		purged, err := mariadbPersistence{db: conn}.PurgeSoftDeleted(ctx, oldest.Add(24*time.Hour), 10)
+		if err != nil || purged != 3 {
+			t.Fatalf("expected 3 devices and groups to be purged, got %d and %v", purged, err)
+		}
+		if remaining := rowIds(db.tables["devices"]); !slices.Equal(remaining, []int64{2, 3}) {
+			t.Errorf("expected the device not deleted and the one deleted recently to remain, got %v", remaining)
+		}
+		if remaining := rowIds(db.tables["groups"]); len(remaining) != 0 {
+			t.Errorf("expected every group to be purged, got %v", remaining)
+		}
+	})
+}
+
+func TestGetAuditTableStatus(t *testing.T) {
+	oldest := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	ctx := context.Background()
+	db := &auditTables{tables: map[string][]auditRow{"deviceAttributeAudit": auditRows(1, 1, 3, oldest)}}
+	conn := sql.OpenDB(db)
+	defer conn.Close()
+	persistence := mariadbPersistence{db: conn}
+	errorMessage := "lock wait timeout exceeded"
+	for _, run := range []restmodels.AuditPruneRun{
+		{Table: string(intermediaries.AttributeAudits), Started: oldest, Finished: oldest, DeletedRows: 4},
+		{Table: string(intermediaries.AttributeAudits), Started: oldest.Add(time.Hour), Finished: oldest.Add(time.Hour), DeletedRows: 1, ErrorMessage: &errorMessage},
+	} {
+		if err := persistence.WriteAuditPruneRun(ctx, run); err != nil {
+			t.Fatalf("expected the run to be written, got %v", err)
+		}
+	}
+
+	statuses, err := persistence.GetAuditTableStatus(ctx)
+	if err != nil {
+		t.Fatalf("expected the status of every table, got %v", err)
+	}
+	if len(statuses) != len(intermediaries.AuditTables) {
+		t.Fatalf("expected the status of %d tables, got %d", len(intermediaries.AuditTables), len(statuses))
+	}
+	attributes := statuses[0]
+	if attributes.Table != string(intermediaries.AttributeAudits) || attributes.Rows != 3 || attributes.Bytes != 3*1024 || attributes.OldestTimestamp == nil || !attributes.OldestTimestamp.Equal(oldest) {
+		t.Errorf("expected the size and oldest row of the attribute audits, got %+v", attributes)
+	}
+	if run := attributes.LastPruneRun; run == nil || run.DeletedRows != 1 || run.ErrorMessage == nil || *run.ErrorMessage != errorMessage {
+		t.Errorf("expected the last run of the attribute audits, got %+v", run)
+	}
+	for _, status := range statuses[1:] {
+		if status.Rows != 0 || status.OldestTimestamp != nil || status.LastPruneRun != nil {
+			t.Errorf("expected %s to be empty and never pruned, got %+v", status.Table, status)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package mariadb
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"github.com/Kaese72/device-store/internal/persistence/intermediaries"
+	"github.com/Kaese72/device-store/restmodels"
+	"github.com/danielgtaylor/huma/v2"
+)
+
+const ruleColumns = `rules.id, rules.name, rules.enabled, rules.spec, rules.satisfiedSince, rules.fired, rules.lastFired, rules.created, rules.updated, rules.version`
+
+func scanRule(row interface{ Scan(...any) error }) (restmodels.Rule, error) {
+	rule := restmodels.Rule{}
+	var name string
+	var enabled bool
+	var spec []byte
+	if err := row.Scan(&rule.ID, &name, &enabled, &spec, &rule.SatisfiedSince, &rule.Fired, &rule.LastFired, &rule.Created, &rule.Updated, &rule.Version); err != nil {
+		return restmodels.Rule{}, err
+	}
+	if err := json.Unmarshal(spec, &rule.RuleSpec); err != nil {
+		return restmodels.Rule{}, err
+	}
+	rule.Name = name
+	rule.Enabled = &enabled
+	return rule, nil
+}
+
+func (persistence mariadbPersistence) queryRules(ctx context.Context, query string, args ...any) ([]restmodels.Rule, error) {
+	rows, err := persistence.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	rules := []restmodels.Rule{}
+	for rows.Next() {
+		rule, err := scanRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+func (persistence mariadbPersistence) GetRules(ctx context.Context) ([]restmodels.Rule, error) {
+	return persistence.queryRules(ctx, `SELECT `+ruleColumns+` FROM rules ORDER BY id`)
+}
+
+func (persistence mariadbPersistence) GetRule(ctx context.Context, ruleId int) (restmodels.Rule, error) {
+	rule, err := scanRule(persistence.db.QueryRowContext(ctx, `SELECT `+ruleColumns+` FROM rules WHERE id = ?`, ruleId))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return restmodels.Rule{}, huma.Error404NotFound(fmt.Sprintf("rule %d not found", ruleId))
+		}
+		return restmodels.Rule{}, err
+	}
+	return rule, nil
+}
+
+// setRuleDevicesTx replaces the devices a rule is evaluated for with the devices of its conditions
+func setRuleDevicesTx(ctx context.Context, ruleId int, conditions []restmodels.RuleCondition, tx queryAble) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM ruleDevices WHERE ruleId = ?`, ruleId); err != nil {
+		return err
+	}
+	deviceIds := []int{}
+	for _, condition := range conditions {
+		if slices.Contains(deviceIds, condition.DeviceID) {
+			continue
+		}
+		deviceIds = append(deviceIds, condition.DeviceID)
+		if _, err := tx.ExecContext(ctx, `INSERT INTO ruleDevices (ruleId, deviceId) VALUES (?, ?)`, ruleId, condition.DeviceID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (persistence mariadbPersistence) CreateRule(ctx context.Context, rule restmodels.RuleSpec, state intermediaries.RuleState) (restmodels.Rule, error) {
+	spec, err := json.Marshal(rule)
+	if err != nil {
+		return restmodels.Rule{}, err
+	}
+	tx, err := persistence.db.BeginTx(ctx, nil)
+	if err != nil {
+		return restmodels.Rule{}, err
+	}
+	defer tx.Rollback()
+	var ruleId int
+	err = tx.QueryRowContext(ctx,
+		`INSERT INTO rules (name, enabled, spec, satisfiedSince, fired) VALUES (?, ?, ?, ?, ?) RETURNING id`,
+		rule.Name, rule.IsEnabled(), spec, state.SatisfiedSince, state.Fired,
+	).Scan(&ruleId)
+	if err != nil {
+		return restmodels.Rule{}, err
+	}
+	if err := setRuleDevicesTx(ctx, ruleId, rule.Conditions, tx); err != nil {
+		return restmodels.Rule{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return restmodels.Rule{}, err
+	}
+	return persistence.GetRule(ctx, ruleId)
+}
+
+func (persistence mariadbPersistence) UpdateRule(ctx context.Context, ruleId int, rule restmodels.RuleSpec, state intermediaries.RuleState) (restmodels.Rule, error) {
+	spec, err := json.Marshal(rule)
+	if err != nil {
+		return restmodels.Rule{}, err
+	}
+	tx, err := persistence.db.BeginTx(ctx, nil)
+	if err != nil {
+		return restmodels.Rule{}, err
+	}
+	defer tx.Rollback()
+	result, err := tx.ExecContext(ctx,
+		`UPDATE rules SET name = ?, enabled = ?, spec = ?, satisfiedSince = ?, fired = ?, version = version + 1, updated = NOW() WHERE id = ?`,
+		rule.Name, rule.IsEnabled(), spec, state.SatisfiedSince, state.Fired, ruleId,
+	)
+	if err != nil {
+		return restmodels.Rule{}, err
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return restmodels.Rule{}, err
+	}
+	if updated == 0 {
+		return restmodels.Rule{}, huma.Error404NotFound(fmt.Sprintf("rule %d not found", ruleId))
+	}
+	if err := setRuleDevicesTx(ctx, ruleId, rule.Conditions, tx); err != nil {
+		return restmodels.Rule{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return restmodels.Rule{}, err
+	}
+	return persistence.GetRule(ctx, ruleId)
+}
+
+func (persistence mariadbPersistence) DeleteRule(ctx context.Context, ruleId int) error {
+	result, err := persistence.db.ExecContext(ctx, `DELETE FROM rules WHERE id = ?`, ruleId)
+	if err != nil {
+		return err
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return huma.Error404NotFound(fmt.Sprintf("rule %d not found", ruleId))
+	}
+	return nil
+}
+
+func (persistence mariadbPersistence) GetRulesForDevice(ctx context.Context, deviceId int) ([]restmodels.Rule, error) {
+	return persistence.queryRules(ctx,
+		`SELECT `+ruleColumns+` FROM rules INNER JOIN ruleDevices ON ruleDevices.ruleId = rules.id WHERE ruleDevices.deviceId = ? AND rules.enabled ORDER BY rules.id`,
+		deviceId,
+	)
+}
+
+func (persistence mariadbPersistence) GetPendingRules(ctx context.Context) ([]restmodels.Rule, error) {
+	return persistence.queryRules(ctx, `SELECT `+ruleColumns+` FROM rules WHERE enabled AND NOT fired AND satisfiedSince IS NOT NULL ORDER BY id`)
+}
+
+func (persistence mariadbPersistence) GetDeviceAttributes(ctx context.Context, deviceIds []int) (map[int][]restmodels.Attribute, error) {
+	return getDeviceAttributesTx(ctx, persistence.db, deviceIds, nil)
+}
+
+func (persistence mariadbPersistence) UpdateRuleState(ctx context.Context, ruleId int, version int, state intermediaries.RuleState) (bool, error) {
+	// A change of state is not an update of the rule itself
+	result, err := persistence.db.ExecContext(ctx,
+		`UPDATE rules SET satisfiedSince = ?, fired = ?, lastFired = ?, version = version + 1, updated = updated WHERE id = ? AND version = ?`,
+		state.SatisfiedSince, state.Fired, state.LastFired, ruleId, version,
+	)
+	if err != nil {
+		return false, err
+	}
+	changed, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return changed == 1, nil
+}
+
+func (persistence mariadbPersistence) WriteRuleFiring(ctx context.Context, firing restmodels.RuleFiring) error {
+	observed, err := json.Marshal(firing.Observed)
+	if err != nil {
+		return err
+	}
+	var result []byte
+	if firing.Result != nil {
+		result, err = json.Marshal(firing.Result)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = persistence.db.ExecContext(ctx,
+		`INSERT INTO ruleFirings (ruleId, status, errorMessage, observed, result, sceneActivationId) VALUES (?, ?, ?, ?, ?, ?)`,
+		firing.RuleID, firing.Status, firing.ErrorMessage, observed, result, firing.SceneActivationID,
+	)
+	return err
+}
+
+func (persistence mariadbPersistence) GetRuleFirings(ctx context.Context, ruleId int) ([]restmodels.RuleFiring, error) {
+	if _, err := persistence.GetRule(ctx, ruleId); err != nil {
+		return nil, err
+	}
+	rows, err := persistence.db.QueryContext(ctx,
+		`SELECT id, ruleId, timestamp, status, errorMessage, observed, result, sceneActivationId FROM ruleFirings WHERE ruleId = ? ORDER BY timestamp DESC LIMIT 50`,
+		ruleId,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	firings := []restmodels.RuleFiring{}
+	for rows.Next() {
+		var firing restmodels.RuleFiring
+		var observed, result []byte
+		if err := rows.Scan(&firing.ID, &firing.RuleID, &firing.Timestamp, &firing.Status, &firing.ErrorMessage, &observed, &result, &firing.SceneActivationID); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(observed, &firing.Observed); err != nil {
+			return nil, err
+		}
+		if result != nil {
+			firing.Result = &restmodels.CapabilityInvocationResult{}
+			if err := json.Unmarshal(result, firing.Result); err != nil {
+				return nil, err
+			}
+		}
+		firings = append(firings, firing)
+	}
+	return firings, rows.Err()
+}
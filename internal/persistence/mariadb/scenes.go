@@ -0,0 +1,128 @@
+package mariadb
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"github.com/Kaese72/device-store/restmodels"
+	"github.com/danielgtaylor/huma/v2"
+)
+
+func scanScene(row interface{ Scan(...any) error }) (restmodels.Scene, error) {
+	scene := restmodels.Scene{}
+	var items []byte
+	if err := row.Scan(&scene.ID, &scene.Name, &items, &scene.Created, &scene.Updated); err != nil {
+		return restmodels.Scene{}, err
+	}
+	if err := json.Unmarshal(items, &scene.Items); err != nil {
+		return restmodels.Scene{}, err
+	}
+	return scene, nil
+}
+
+func (persistence mariadbPersistence) GetScenes(ctx context.Context) ([]restmodels.Scene, error) {
+	rows, err := persistence.db.QueryContext(ctx, `SELECT id, name, items, created, updated FROM scenes ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	scenes := []restmodels.Scene{}
+	for rows.Next() {
+		scene, err := scanScene(rows)
+		if err != nil {
+			return nil, err
+		}
+		scenes = append(scenes, scene)
+	}
+	return scenes, rows.Err()
+}
+
+func (persistence mariadbPersistence) GetScene(ctx context.Context, sceneId int) (restmodels.Scene, error) {
+	scene, err := scanScene(persistence.db.QueryRowContext(ctx, `SELECT id, name, items, created, updated FROM scenes WHERE id = ?`, sceneId))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return restmodels.Scene{}, huma.Error404NotFound(fmt.Sprintf("scene %d not found", sceneId))
+		}
+		return restmodels.Scene{}, err
+	}
+	return scene, nil
+}
+
+func (persistence mariadbPersistence) CreateScene(ctx context.Context, scene restmodels.SceneSpec) (restmodels.Scene, error) {
+	items, err := json.Marshal(scene.Items)
+	if err != nil {
+		return restmodels.Scene{}, err
+	}
+	var sceneId int
+	err = persistence.db.QueryRowContext(ctx, `INSERT INTO scenes (name, items) VALUES (?, ?) RETURNING id`, scene.Name, items).Scan(&sceneId)
+	if err != nil {
+		return restmodels.Scene{}, err
+	}
+	return persistence.GetScene(ctx, sceneId)
+}
+
+func (persistence mariadbPersistence) UpdateScene(ctx context.Context, sceneId int, scene restmodels.SceneSpec) (restmodels.Scene, error) {
+	items, err := json.Marshal(scene.Items)
+	if err != nil {
+		return restmodels.Scene{}, err
+	}
+	_, err = persistence.db.ExecContext(ctx, `UPDATE scenes SET name = ?, items = ?, updated = NOW() WHERE id = ?`, scene.Name, items, sceneId)
+	if err != nil {
+		return restmodels.Scene{}, err
+	}
+	// Fails with 404 if there was no such scene to update
+	return persistence.GetScene(ctx, sceneId)
+}
+
+func (persistence mariadbPersistence) DeleteScene(ctx context.Context, sceneId int) error {
+	result, err := persistence.db.ExecContext(ctx, `DELETE FROM scenes WHERE id = ?`, sceneId)
+	if err != nil {
+		return err
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return huma.Error404NotFound(fmt.Sprintf("scene %d not found", sceneId))
+	}
+	return nil
+}
+
+func (persistence mariadbPersistence) WriteSceneActivationAudit(ctx context.Context, activation restmodels.SceneActivation) (restmodels.SceneActivation, error) {
+	results, err := json.Marshal(activation.Items)
+	if err != nil {
+		return restmodels.SceneActivation{}, err
+	}
+	err = persistence.db.QueryRowContext(ctx,
+		`INSERT INTO sceneActivationAudit (sceneId, succeeded, failed, results) VALUES (?, ?, ?, ?) RETURNING id, timestamp`,
+		activation.SceneID, activation.Succeeded, activation.Failed, results,
+	).Scan(&activation.ID, &activation.Timestamp)
+	return activation, err
+}
+
+func (persistence mariadbPersistence) GetSceneActivationAudits(ctx context.Context, sceneId int) ([]restmodels.SceneActivation, error) {
+	rows, err := persistence.db.QueryContext(ctx,
+		`SELECT id, sceneId, timestamp, succeeded, failed, results FROM sceneActivationAudit WHERE sceneId = ? ORDER BY timestamp DESC LIMIT 50`,
+		sceneId,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	audits := []restmodels.SceneActivation{}
+	for rows.Next() {
+		var audit restmodels.SceneActivation
+		var results []byte
+		if err := rows.Scan(&audit.ID, &audit.SceneID, &audit.Timestamp, &audit.Succeeded, &audit.Failed, &results); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(results, &audit.Items); err != nil {
+			return nil, err
+		}
+		audits = append(audits, audit)
+	}
+	return audits, rows.Err()
+}
@@ -0,0 +1,175 @@
+package mariadb
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/Kaese72/device-store/restmodels"
+	"github.com/danielgtaylor/huma/v2"
+)
+
+const scheduleColumns = `id, name, enabled, spec, nextRun, lastRun, created, updated`
+
+func scanSchedule(row interface{ Scan(...any) error }) (restmodels.Schedule, error) {
+	schedule := restmodels.Schedule{}
+	var name string
+	var enabled bool
+	var spec []byte
+	if err := row.Scan(&schedule.ID, &name, &enabled, &spec, &schedule.NextRun, &schedule.LastRun, &schedule.Created, &schedule.Updated); err != nil {
+		return restmodels.Schedule{}, err
+	}
+	if err := json.Unmarshal(spec, &schedule.ScheduleSpec); err != nil {
+		return restmodels.Schedule{}, err
+	}
+	schedule.Name = name
+	schedule.Enabled = &enabled
+	return schedule, nil
+}
+
+func (persistence mariadbPersistence) querySchedules(ctx context.Context, query string, args ...any) ([]restmodels.Schedule, error) {
+	rows, err := persistence.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	schedules := []restmodels.Schedule{}
+	for rows.Next() {
+		schedule, err := scanSchedule(rows)
+		if err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, schedule)
+	}
+	return schedules, rows.Err()
+}
+
+func (persistence mariadbPersistence) GetSchedules(ctx context.Context) ([]restmodels.Schedule, error) {
+	return persistence.querySchedules(ctx, `SELECT `+scheduleColumns+` FROM schedules ORDER BY id`)
+}
+
+func (persistence mariadbPersistence) GetSchedule(ctx context.Context, scheduleId int) (restmodels.Schedule, error) {
+	schedule, err := scanSchedule(persistence.db.QueryRowContext(ctx, `SELECT `+scheduleColumns+` FROM schedules WHERE id = ?`, scheduleId))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return restmodels.Schedule{}, huma.Error404NotFound(fmt.Sprintf("schedule %d not found", scheduleId))
+		}
+		return restmodels.Schedule{}, err
+	}
+	return schedule, nil
+}
+
+func (persistence mariadbPersistence) CreateSchedule(ctx context.Context, schedule restmodels.ScheduleSpec, nextRun *time.Time) (restmodels.Schedule, error) {
+	spec, err := json.Marshal(schedule)
+	if err != nil {
+		return restmodels.Schedule{}, err
+	}
+	var scheduleId int
+	err = persistence.db.QueryRowContext(ctx,
+		`INSERT INTO schedules (name, enabled, spec, nextRun) VALUES (?, ?, ?, ?) RETURNING id`,
+		schedule.Name, schedule.IsEnabled(), spec, nextRun,
+	).Scan(&scheduleId)
+	if err != nil {
+		return restmodels.Schedule{}, err
+	}
+	return persistence.GetSchedule(ctx, scheduleId)
+}
+
+func (persistence mariadbPersistence) UpdateSchedule(ctx context.Context, scheduleId int, schedule restmodels.ScheduleSpec, nextRun *time.Time) (restmodels.Schedule, error) {
+	spec, err := json.Marshal(schedule)
+	if err != nil {
+		return restmodels.Schedule{}, err
+	}
+	_, err = persistence.db.ExecContext(ctx,
+		`UPDATE schedules SET name = ?, enabled = ?, spec = ?, nextRun = ?, updated = NOW() WHERE id = ?`,
+		schedule.Name, schedule.IsEnabled(), spec, nextRun, scheduleId,
+	)
+	if err != nil {
+		return restmodels.Schedule{}, err
+	}
+	// Fails with 404 if there was no such schedule to update
+	return persistence.GetSchedule(ctx, scheduleId)
+}
+
+func (persistence mariadbPersistence) DeleteSchedule(ctx context.Context, scheduleId int) error {
+	result, err := persistence.db.ExecContext(ctx, `DELETE FROM schedules WHERE id = ?`, scheduleId)
+	if err != nil {
+		return err
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return huma.Error404NotFound(fmt.Sprintf("schedule %d not found", scheduleId))
+	}
+	return nil
+}
+
+func (persistence mariadbPersistence) GetDueSchedules(ctx context.Context, now time.Time) ([]restmodels.Schedule, error) {
+	return persistence.querySchedules(ctx, `SELECT `+scheduleColumns+` FROM schedules WHERE enabled AND nextRun <= ? ORDER BY nextRun`, now)
+}
+
+func (persistence mariadbPersistence) ClaimScheduleRun(ctx context.Context, scheduleId int, due time.Time, nextRun *time.Time) (bool, error) {
+	// Claiming a run is not an update of the schedule itself
+	result, err := persistence.db.ExecContext(ctx,
+		`UPDATE schedules SET lastRun = ?, nextRun = ?, updated = updated WHERE id = ? AND enabled AND nextRun = ?`,
+		due, nextRun, scheduleId, due,
+	)
+	if err != nil {
+		return false, err
+	}
+	claimed, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return claimed == 1, nil
+}
+
+func (persistence mariadbPersistence) WriteScheduleRun(ctx context.Context, run restmodels.ScheduleRun) error {
+	var result []byte
+	if run.Result != nil {
+		var err error
+		result, err = json.Marshal(run.Result)
+		if err != nil {
+			return err
+		}
+	}
+	_, err := persistence.db.ExecContext(ctx,
+		`INSERT INTO scheduleRuns (scheduleId, scheduledFor, status, errorMessage, result, sceneActivationId) VALUES (?, ?, ?, ?, ?, ?)`,
+		run.ScheduleID, run.ScheduledFor, run.Status, run.ErrorMessage, result, run.SceneActivationID,
+	)
+	return err
+}
+
+func (persistence mariadbPersistence) GetScheduleRuns(ctx context.Context, scheduleId int) ([]restmodels.ScheduleRun, error) {
+	if _, err := persistence.GetSchedule(ctx, scheduleId); err != nil {
+		return nil, err
+	}
+	rows, err := persistence.db.QueryContext(ctx,
+		`SELECT id, scheduleId, scheduledFor, timestamp, status, errorMessage, result, sceneActivationId FROM scheduleRuns WHERE scheduleId = ? ORDER BY timestamp DESC LIMIT 50`,
+		scheduleId,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	runs := []restmodels.ScheduleRun{}
+	for rows.Next() {
+		var run restmodels.ScheduleRun
+		var result []byte
+		if err := rows.Scan(&run.ID, &run.ScheduleID, &run.ScheduledFor, &run.Timestamp, &run.Status, &run.ErrorMessage, &result, &run.SceneActivationID); err != nil {
+			return nil, err
+		}
+		if result != nil {
+			run.Result = &restmodels.CapabilityInvocationResult{}
+			if err := json.Unmarshal(result, run.Result); err != nil {
+				return nil, err
+			}
+		}
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
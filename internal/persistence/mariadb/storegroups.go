@@ -0,0 +1,155 @@
+package mariadb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/Kaese72/device-store/internal/persistence/intermediaries"
+	"github.com/Kaese72/device-store/restmodels"
+	"github.com/danielgtaylor/huma/v2"
+)
+
+// getStoreGroupCapabilitiesTx returns the capabilities of a store managed group, which are the capabilities of
+// its effective members. When members disagree on the argument specs of a capability, the most recently updated one wins.
+func getStoreGroupCapabilitiesTx(ctx context.Context, deviceIds []int, tx queryAble) ([]restmodels.GroupCapability, error) {
+	capabilities := []restmodels.GroupCapability{}
+	if len(deviceIds) == 0 {
+		return capabilities, nil
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(deviceIds)), ",")
+	args := make([]any, 0, len(deviceIds))
+	for _, deviceId := range deviceIds {
+		args = append(args, deviceId)
+	}
+	rows, err := tx.QueryContext(ctx, `SELECT name, argumentJsonSchema, argumentStandardSchema, updated FROM deviceCapabilities WHERE deviceId IN (`+placeholders+`) ORDER BY updated DESC, id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var capability restmodels.GroupCapability
+		var argumentsBytes, schemaBytes []byte
+		if err := rows.Scan(&capability.Name, &argumentsBytes, &schemaBytes, &capability.Updated); err != nil {
+			return nil, err
+		}
+		if slices.ContainsFunc(capabilities, func(present restmodels.GroupCapability) bool { return present.Name == capability.Name }) {
+			continue
+		}
+		if capability.ArgumentSpecs, err = unmarshalArgumentSpecs(argumentsBytes); err != nil {
+			return nil, err
+		}
+		if capability.ArgumentJSONSchema, err = unmarshalArgumentSchema(schemaBytes); err != nil {
+			return nil, err
+		}
+		capabilities = append(capabilities, capability)
+	}
+	slices.SortFunc(capabilities, func(a, b restmodels.GroupCapability) int { return strings.Compare(a.Name, b.Name) })
+	return capabilities, rows.Err()
+}
+
+// validateGroupMembersTx makes sure every device ID refers to an existing device that is not deleted
+func validateGroupMembersTx(ctx context.Context, deviceIds []int, tx queryAble) error {
+	if len(deviceIds) == 0 {
+		return nil
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(deviceIds)), ",")
+	args := make([]any, 0, len(deviceIds))
+	for _, deviceId := range deviceIds {
+		args = append(args, deviceId)
+	}
+	rows, err := tx.QueryContext(ctx, `SELECT id FROM devices WHERE deleted IS NULL AND id IN (`+placeholders+`)`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	found := []int{}
+	for rows.Next() {
+		var deviceId int
+		if err := rows.Scan(&deviceId); err != nil {
+			return err
+		}
+		found = append(found, deviceId)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	details := []error{}
+	for index, deviceId := range deviceIds {
+		if !slices.Contains(found, deviceId) {
+			details = append(details, &huma.ErrorDetail{
+				Message:  "device not found",
+				Location: fmt.Sprintf("body.device-ids[%d]", index),
+				Value:    deviceId,
+			})
+		}
+	}
+	if len(details) > 0 {
+		return huma.Error422UnprocessableEntity("group refers to unknown devices", details...)
+	}
+	return nil
+}
+
+func (persistence mariadbPersistence) CreateStoreGroup(ctx context.Context, group restmodels.StoreGroup) (intermediaries.GroupPostResult, error) {
+	tx, err := persistence.db.BeginTx(ctx, nil)
+	if err != nil {
+		return intermediaries.GroupPostResult{}, err
+	}
+	defer tx.Rollback()
+	if err := validateGroupMembersTx(ctx, group.DeviceIds, tx); err != nil {
+		return intermediaries.GroupPostResult{}, err
+	}
+	result := intermediaries.GroupPostResult{Created: true}
+	// Store managed groups do not belong to an adapter, the bridge identifier only has to be unique
+	err = tx.QueryRowContext(ctx, `INSERT INTO groups (bridgeIdentifier, adapterId, name, storeManaged) VALUES (UUID(), 0, ?, TRUE) RETURNING id`, group.Name).Scan(&result.GroupID)
+	if err != nil {
+		return intermediaries.GroupPostResult{}, err
+	}
+	result.AddedDeviceIDs, _, err = setGroupMembershipTx(ctx, result.GroupID, group.DeviceIds, tx)
+	if err != nil {
+		return intermediaries.GroupPostResult{}, err
+	}
+	result.AddedGroupIDs, _, err = setGroupChildrenTx(ctx, result.GroupID, group.GroupIds, tx)
+	if err != nil {
+		return intermediaries.GroupPostResult{}, err
+	}
+	return result, tx.Commit()
+}
+
+func (persistence mariadbPersistence) UpdateStoreGroup(ctx context.Context, storeIdentifier int, group restmodels.StoreGroup) (intermediaries.GroupPostResult, error) {
+	tx, err := persistence.db.BeginTx(ctx, nil)
+	if err != nil {
+		return intermediaries.GroupPostResult{}, err
+	}
+	defer tx.Rollback()
+	var storeManaged bool
+	err = tx.QueryRowContext(ctx, `SELECT storeManaged FROM groups WHERE id = ? AND deleted IS NULL FOR UPDATE`, storeIdentifier).Scan(&storeManaged)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return intermediaries.GroupPostResult{}, huma.Error404NotFound(fmt.Sprintf("group %d not found", storeIdentifier))
+		}
+		return intermediaries.GroupPostResult{}, err
+	}
+	if !storeManaged {
+		return intermediaries.GroupPostResult{}, huma.Error409Conflict(fmt.Sprintf("group %d is managed by its adapter", storeIdentifier))
+	}
+	if err := validateGroupMembersTx(ctx, group.DeviceIds, tx); err != nil {
+		return intermediaries.GroupPostResult{}, err
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE groups SET name = ? WHERE id = ?`, group.Name, storeIdentifier)
+	if err != nil {
+		return intermediaries.GroupPostResult{}, err
+	}
+	result := intermediaries.GroupPostResult{GroupID: storeIdentifier}
+	result.AddedDeviceIDs, result.RemovedDeviceIDs, err = setGroupMembershipTx(ctx, storeIdentifier, group.DeviceIds, tx)
+	if err != nil {
+		return intermediaries.GroupPostResult{}, err
+	}
+	result.AddedGroupIDs, result.RemovedGroupIDs, err = setGroupChildrenTx(ctx, storeIdentifier, group.GroupIds, tx)
+	if err != nil {
+		return intermediaries.GroupPostResult{}, err
+	}
+	return result, tx.Commit()
+}
@@ -0,0 +1,102 @@
+package mariadb
+
+import (
+	"context"
+	"time"
+
+	"github.com/Kaese72/device-store/internal/persistence/intermediaries"
+	"github.com/Kaese72/device-store/restmodels"
+	"github.com/danielgtaylor/huma/v2"
+)
+
+func (persistence mariadbPersistence) GetSyncSnapshotTime(ctx context.Context) (time.Time, error) {
+	var now time.Time
+	err := persistence.db.QueryRowContext(ctx, `SELECT NOW()`).Scan(&now)
+	return now, err
+}
+
+func (persistence mariadbPersistence) GetTombstones(ctx context.Context, since time.Time) ([]restmodels.Tombstone, []restmodels.Tombstone, error) {
+	// Tombstones before the latest prune cutoff are gone, so deletions since then can not be reported reliably
+	var prunedBefore *time.Time
+	err := persistence.db.QueryRowContext(ctx, `SELECT MAX(cutoff) FROM auditPruneRuns WHERE auditTable = ?`, string(intermediaries.Tombstones)).Scan(&prunedBefore)
+	if err != nil {
+		return nil, nil, err
+	}
+	if prunedBefore != nil && since.Before(*prunedBefore) {
+		return nil, nil, huma.Error410Gone("sync token has expired, perform a full sync without a token")
+	}
+	rows, err := persistence.db.QueryContext(ctx, `SELECT kind, objectId, timestamp FROM tombstones WHERE timestamp >= ? ORDER BY id`, since)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	deletedDevices := []restmodels.Tombstone{}
+	deletedGroups := []restmodels.Tombstone{}
+	for rows.Next() {
+		var kind string
+		var tombstone restmodels.Tombstone
+		if err := rows.Scan(&kind, &tombstone.ID, &tombstone.Deleted); err != nil {
+			return nil, nil, err
+		}
+		switch kind {
+		case "device":
+			deletedDevices = append(deletedDevices, tombstone)
+		case "group":
+			deletedGroups = append(deletedGroups, tombstone)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	// Soft deleted objects are hidden from listings as well, so they are reported alongside the hard deleted ones
+	softDeletedDevices, err := persistence.getSoftDeleted(ctx, "devices", since)
+	if err != nil {
+		return nil, nil, err
+	}
+	softDeletedGroups, err := persistence.getSoftDeleted(ctx, "groups", since)
+	if err != nil {
+		return nil, nil, err
+	}
+	return append(deletedDevices, softDeletedDevices...), append(deletedGroups, softDeletedGroups...), nil
+}
+
+// getSoftDeleted returns tombstones for the objects in table soft deleted at or after since.
+// table is interpolated into the query, so it must never come from user input.
+func (persistence mariadbPersistence) getSoftDeleted(ctx context.Context, table string, since time.Time) ([]restmodels.Tombstone, error) {
+	rows, err := persistence.db.QueryContext(ctx, `SELECT id, deleted FROM `+table+` WHERE deleted >= ? ORDER BY deleted, id`, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tombstones := []restmodels.Tombstone{}
+	for rows.Next() {
+		var tombstone restmodels.Tombstone
+		if err := rows.Scan(&tombstone.ID, &tombstone.Deleted); err != nil {
+			return nil, err
+		}
+		tombstones = append(tombstones, tombstone)
+	}
+	return tombstones, rows.Err()
+}
+
+// deletionTables are the tables of the objects of every tombstone kind
+var deletionTables = map[string]string{"device": "devices", "group": "groups"}
+
+func (persistence mariadbPersistence) GetLastDeletion(ctx context.Context, kind string) (time.Time, error) {
+	var softDeleted, hardDeleted *time.Time
+	err := persistence.db.QueryRowContext(ctx, `SELECT MAX(deleted) FROM `+deletionTables[kind]).Scan(&softDeleted)
+	if err != nil {
+		return time.Time{}, err
+	}
+	err = persistence.db.QueryRowContext(ctx, `SELECT MAX(timestamp) FROM tombstones WHERE kind = ?`, kind).Scan(&hardDeleted)
+	if err != nil {
+		return time.Time{}, err
+	}
+	last := time.Time{}
+	for _, deleted := range []*time.Time{softDeleted, hardDeleted} {
+		if deleted != nil && deleted.After(last) {
+			last = *deleted
+		}
+	}
+	return last, nil
+}
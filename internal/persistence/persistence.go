@@ -2,6 +2,7 @@ package persistence
 
 import (
 	"context"
+	"time"
 
 	"github.com/Kaese72/device-store/ingestmodels"
 	"github.com/Kaese72/device-store/internal/persistence/intermediaries"
@@ -9,26 +10,162 @@ import (
 )
 
 type RestPersistenceDB interface {
+	GroupAttributesPersistenceDB
+	ArchivePersistenceDB
 	// Device Control
 	GetDevices(context.Context, []restmodels.Filter) ([]restmodels.Device, error)
+	// GetDevicesAt returns the devices existing at a point in time, with their attributes reconstructed from the attribute
+	// audit as of that time
+	GetDevicesAt(ctx context.Context, filters []restmodels.Filter, at time.Time) ([]restmodels.Device, error)
+	// DeleteDevice soft deletes a device, it is permanently deleted after the purge delay
 	DeleteDevice(ctx context.Context, storeIdentifier int) error
+	RestoreDevice(ctx context.Context, storeIdentifier int) error
 	GetDeviceCapabilityForActivation(ctx context.Context, storeIdentifier int, capabilityName string) (intermediaries.DeviceCapabilityIntermediaryActivation, error)
 	// Audits
 	GetAttributeAudits(context.Context, []restmodels.Filter) ([]restmodels.AttributeAudit, error)
-	WriteCapabilityTriggerAudit(ctx context.Context, deviceId int, capabilityName string, success bool, errorMessage *string, arguments string) error
+	// WriteCapabilityTriggerAudit records the outcome of a capability trigger and returns the ID of the audit row
+	// outcome is pending if the trigger is to be verified against the expected outcome of the capability
+	WriteCapabilityTriggerAudit(ctx context.Context, deviceId int, capabilityName string, success bool, errorMessage *string, arguments string, attempts []restmodels.TriggerAttempt, outcome *string) (int, error)
 	GetCapabilityTriggerAudits(ctx context.Context, deviceId int) ([]restmodels.CapabilityTriggerAudit, error)
 	//// Groups
 	GetGroups(context.Context, []restmodels.Filter) ([]restmodels.Group, error)
+	// DeleteGroup soft deletes a group, it is permanently deleted after the purge delay
 	DeleteGroup(ctx context.Context, storeIdentifier int) error
+	RestoreGroup(ctx context.Context, storeIdentifier int) error
 	GetGroupCapabilityForActivation(ctx context.Context, storeIdentifier int, capabilityName string) (intermediaries.GroupCapabilityIntermediaryActivation, error)
-	WriteGroupCapabilityTriggerAudit(ctx context.Context, groupId int, capabilityName string, success bool, errorMessage *string, arguments string) error
+	// GetGroupTriggerTargets returns the contained groups and member devices to trigger when fanning out a capability
+	GetGroupTriggerTargets(ctx context.Context, storeIdentifier int, capabilityName string) (intermediaries.GroupTriggerTargets, error)
+	// CreateStoreGroup creates a group managed by the store rather than an adapter
+	CreateStoreGroup(ctx context.Context, group restmodels.StoreGroup) (intermediaries.GroupPostResult, error)
+	// UpdateStoreGroup replaces the name and members of a store managed group
+	UpdateStoreGroup(ctx context.Context, storeIdentifier int, group restmodels.StoreGroup) (intermediaries.GroupPostResult, error)
+	WriteGroupCapabilityTriggerAudit(ctx context.Context, groupId int, capabilityName string, success bool, errorMessage *string, arguments string, attempts []restmodels.TriggerAttempt) (int, error)
 	GetGroupCapabilityTriggerAudits(ctx context.Context, groupId int) ([]restmodels.GroupCapabilityTriggerAudit, error)
+	//// Background triggers
+	// CreateTriggerJob records a queued job, leased to this replica for lease
+	CreateTriggerJob(ctx context.Context, job restmodels.TriggerJob, lease time.Duration) (int, error)
+	StartTriggerJob(ctx context.Context, jobId int) error
+	// FinishTriggerJob records the final outcome of a job, linking it to the trigger audit row of the outcome
+	FinishTriggerJob(ctx context.Context, jobId int, status string, errorMessage *string, auditId *int, groupResult *restmodels.GroupTriggerResult) error
+	GetTriggerJob(ctx context.Context, jobId int) (restmodels.TriggerJob, error)
+	//// Scenes
+	GetScenes(ctx context.Context) ([]restmodels.Scene, error)
+	GetScene(ctx context.Context, sceneId int) (restmodels.Scene, error)
+	CreateScene(ctx context.Context, scene restmodels.SceneSpec) (restmodels.Scene, error)
+	// UpdateScene replaces the name and items of a scene
+	UpdateScene(ctx context.Context, sceneId int, scene restmodels.SceneSpec) (restmodels.Scene, error)
+	DeleteScene(ctx context.Context, sceneId int) error
+	// WriteSceneActivationAudit records the outcome of activating a scene and returns it with its ID and timestamp
+	WriteSceneActivationAudit(ctx context.Context, activation restmodels.SceneActivation) (restmodels.SceneActivation, error)
+	GetSceneActivationAudits(ctx context.Context, sceneId int) ([]restmodels.SceneActivation, error)
+	//// Schedules
+	GetSchedules(ctx context.Context) ([]restmodels.Schedule, error)
+	GetSchedule(ctx context.Context, scheduleId int) (restmodels.Schedule, error)
+	CreateSchedule(ctx context.Context, schedule restmodels.ScheduleSpec, nextRun *time.Time) (restmodels.Schedule, error)
+	// UpdateSchedule replaces a schedule, which runs next at nextRun
+	UpdateSchedule(ctx context.Context, scheduleId int, schedule restmodels.ScheduleSpec, nextRun *time.Time) (restmodels.Schedule, error)
+	DeleteSchedule(ctx context.Context, scheduleId int) error
+	GetScheduleRuns(ctx context.Context, scheduleId int) ([]restmodels.ScheduleRun, error)
+	//// Rules
+	GetRules(ctx context.Context) ([]restmodels.Rule, error)
+	GetRule(ctx context.Context, ruleId int) (restmodels.Rule, error)
+	// CreateRule creates a rule in an initial state, see UpdateRule
+	CreateRule(ctx context.Context, rule restmodels.RuleSpec, state intermediaries.RuleState) (restmodels.Rule, error)
+	// UpdateRule replaces a rule and starts it over from state, keeping when it last fired
+	UpdateRule(ctx context.Context, ruleId int, rule restmodels.RuleSpec, state intermediaries.RuleState) (restmodels.Rule, error)
+	DeleteRule(ctx context.Context, ruleId int) error
+	GetRuleFirings(ctx context.Context, ruleId int) ([]restmodels.RuleFiring, error)
+	//// Adapters
+	// GetAdapterIds returns the adapters of all devices and groups, leaving out store managed groups
+	GetAdapterIds(ctx context.Context) ([]int, error)
+	//// Idempotency
+	// ClaimIdempotencyKey reserves an idempotency key for a trigger request, identified by a hash. If the key was
+	// claimed before it returns the stored response instead, or fails with 409 if that request has not finished
+	// yet and with 422 if the key was claimed for a different request.
+	ClaimIdempotencyKey(ctx context.Context, key string, request string) (*intermediaries.IdempotentResponse, error)
+	// StoreIdempotentResponse records the response of the request an idempotency key was claimed for
+	StoreIdempotentResponse(ctx context.Context, key string, response intermediaries.IdempotentResponse) error
+	// ReleaseIdempotencyKey forgets a claimed idempotency key, so that the request may be made again
+	ReleaseIdempotencyKey(ctx context.Context, key string) error
+	//// Sync
+	// GetSyncSnapshotTime returns the current time according to the database, to be used as a sync token
+	GetSyncSnapshotTime(ctx context.Context) (time.Time, error)
+	// GetTombstones returns the devices and groups deleted at or after since. It fails with
+	// 410 Gone if tombstones from that time may already have been pruned.
+	GetTombstones(ctx context.Context, since time.Time) (deletedDevices []restmodels.Tombstone, deletedGroups []restmodels.Tombstone, err error)
+	// GetLastDeletion returns when a device or group, as kind says, was last deleted, soft or permanently
+	GetLastDeletion(ctx context.Context, kind string) (time.Time, error)
+	//// Administration
+	GetAuditTableStatus(ctx context.Context) ([]restmodels.AuditTableStatus, error)
 }
 
 type IngestPersistenceDB interface {
 	// Device Control
 	// PostDevice updates a device and returns the stuff that has been changed
-	PostDevice(context.Context, ingestmodels.IngestDevice) (int, []ingestmodels.IngestAttribute, error)
+	PostDevice(context.Context, ingestmodels.IngestDevice, intermediaries.DeletedIngestBehaviour) (intermediaries.DevicePostResult, error)
 	//// Groups
-	PostGroup(context.Context, ingestmodels.IngestGroup) error
+	// PostGroup updates a group and returns the stuff that has been changed
+	PostGroup(context.Context, ingestmodels.IngestGroup, intermediaries.DeletedIngestBehaviour) (intermediaries.GroupPostResult, error)
+	GroupAttributesPersistenceDB
+}
+
+type GroupAttributesPersistenceDB interface {
+	// AggregateGroupAttributes aggregates the named attributes of the groups affected by a change to the devices or to
+	// the members of the groups, by group ID. Those are the groups containing the devices, the groups themselves, and
+	// every group containing them through other groups. Named attributes no member has are included without members.
+	AggregateGroupAttributes(ctx context.Context, deviceIds []int, groupIds []int, names []string) (map[int][]restmodels.GroupAttribute, error)
+	// GetMemberAttributeNames returns the names of the attributes of the devices and of the members of the groups,
+	// directly or through contained groups
+	GetMemberAttributeNames(ctx context.Context, deviceIds []int, groupIds []int) ([]string, error)
+}
+
+type RetentionPersistenceDB interface {
+	// PruneAuditsOlderThan deletes audit rows older than cutoff, batchSize rows at a time, and returns the number of deleted rows
+	PruneAuditsOlderThan(ctx context.Context, table intermediaries.AuditTable, cutoff time.Time, batchSize int) (int64, error)
+	// PruneAuditsExceedingRows deletes the oldest audit rows of every device (or group) having more than maxRows rows
+	PruneAuditsExceedingRows(ctx context.Context, table intermediaries.AuditTable, maxRows int, batchSize int) (int64, error)
+	WriteAuditPruneRun(ctx context.Context, run restmodels.AuditPruneRun) error
+	// PurgeSoftDeleted permanently deletes devices and groups soft deleted before cutoff
+	PurgeSoftDeleted(ctx context.Context, cutoff time.Time, batchSize int) (int64, error)
+}
+
+type SchedulerPersistenceDB interface {
+	// GetDueSchedules returns the enabled schedules whose next run is at or before now
+	GetDueSchedules(ctx context.Context, now time.Time) ([]restmodels.Schedule, error)
+	// ClaimScheduleRun moves the next run of a schedule from the run due to nextRun, and reports false if the
+	// next run was already moved, by another replica claiming the run first or by the schedule being updated
+	ClaimScheduleRun(ctx context.Context, scheduleId int, due time.Time, nextRun *time.Time) (bool, error)
+	WriteScheduleRun(ctx context.Context, run restmodels.ScheduleRun) error
+}
+
+type RulesPersistenceDB interface {
+	// GetRulesForDevice returns the enabled rules having a condition on the device
+	GetRulesForDevice(ctx context.Context, deviceId int) ([]restmodels.Rule, error)
+	// GetPendingRules returns the enabled rules whose conditions hold but that have not fired yet
+	GetPendingRules(ctx context.Context) ([]restmodels.Rule, error)
+	// GetDeviceAttributes returns the current attributes of the devices, by device ID
+	GetDeviceAttributes(ctx context.Context, deviceIds []int) (map[int][]restmodels.Attribute, error)
+	// UpdateRuleState changes the state of a rule, and reports false if the rule changed since version was read
+	UpdateRuleState(ctx context.Context, ruleId int, version int, state intermediaries.RuleState) (bool, error)
+	WriteRuleFiring(ctx context.Context, firing restmodels.RuleFiring) error
+}
+
+type JobsPersistenceDB interface {
+	// RenewTriggerJobLeases extends the leases of jobs this replica is running by lease
+	RenewTriggerJobLeases(ctx context.Context, jobIds []int, lease time.Duration) error
+	// FailExpiredTriggerJobs fails unfinished jobs whose lease expired, as the replica running them stopped
+	FailExpiredTriggerJobs(ctx context.Context) (int64, error)
+}
+
+type OutcomesPersistenceDB interface {
+	// GetDeviceAttributes returns the current attributes of the devices, by device ID
+	GetDeviceAttributes(ctx context.Context, deviceIds []int) (map[int][]restmodels.Attribute, error)
+	// SetCapabilityTriggerAuditOutcome records whether a trigger was verified against the expected outcome
+	SetCapabilityTriggerAuditOutcome(ctx context.Context, auditId int, outcome string) error
+}
+
+type ArchivePersistenceDB interface {
+	ExportArchive(ctx context.Context, includeAudits bool) (restmodels.Archive, error)
+	// ImportArchive restores an archive, remapping device and group IDs. A dry run reports what would be done without committing it.
+	ImportArchive(ctx context.Context, archive restmodels.Archive, dryRun bool) (restmodels.ImportReport, error)
 }
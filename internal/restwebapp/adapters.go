@@ -0,0 +1,18 @@
+package restwebapp
+
+import (
+	"context"
+
+	"github.com/Kaese72/device-store/restmodels"
+)
+
+// GetAdapters returns the health of every adapter, as tracked by its circuit breaker
+func (app webApp) GetAdapters(ctx context.Context, input *struct{}) (*struct {
+	Body []restmodels.AdapterHealth
+}, error) {
+	adapterIds, err := app.persistence.GetAdapterIds(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &struct{ Body []restmodels.AdapterHealth }{Body: app.breakers.Health(adapterIds)}, nil
+}
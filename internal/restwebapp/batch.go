@@ -0,0 +1,82 @@
+package restwebapp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/Kaese72/device-store/internal/logging"
+	"github.com/Kaese72/device-store/restmodels"
+	"github.com/danielgtaylor/huma/v2"
+)
+
+// TriggerBatch triggers every item of a batch concurrently, with a bounded number of triggers sent to the same
+// adapter at the same time. Every item is validated and audited as if triggered on its own, and the outcome of
+// every item is responded with regardless of how the others went.
+func (app webApp) TriggerBatch(ctx context.Context, input *struct {
+	Body restmodels.BatchTriggerRequest
+}) (*struct {
+	Body restmodels.BatchTriggerResult
+}, error) {
+	items := input.Body.Items
+	if len(items) > app.batch.MaxItems {
+		return nil, huma.Error422UnprocessableEntity("too many items in batch", &huma.ErrorDetail{
+			Message:  fmt.Sprintf("a batch may hold at most %d items", app.batch.MaxItems),
+			Location: "body.items",
+			Value:    len(items),
+		})
+	}
+	logging.Info(fmt.Sprintf("Triggering a batch of %d capabilities", len(items)), ctx)
+	results := app.invokeCapabilities(ctx, items)
+	return &struct{ Body restmodels.BatchTriggerResult }{Body: restmodels.BatchTriggerResult{Items: results}}, nil
+}
+
+// invokeCapabilities triggers every invocation concurrently, with a bounded number of triggers sent to the same
+// adapter at the same time, and returns the outcome of every invocation in order
+func (app webApp) invokeCapabilities(ctx context.Context, items []restmodels.CapabilityInvocation) []restmodels.CapabilityInvocationResult {
+	ctx = withAdapterLimiter(ctx, app.batch.AdapterParallelism)
+	results := make([]restmodels.CapabilityInvocationResult, len(items))
+	var wg sync.WaitGroup
+	for index, item := range items {
+		wg.Go(func() {
+			results[index] = app.invokeCapability(ctx, item)
+		})
+	}
+	wg.Wait()
+	return results
+}
+
+// InvokeCapability triggers a single capability invocation, like an item of a batch. The scheduler triggers
+// capabilities through it.
+func (app webApp) InvokeCapability(ctx context.Context, item restmodels.CapabilityInvocation) restmodels.CapabilityInvocationResult {
+	return app.invokeCapability(withAdapterLimiter(ctx, app.batch.AdapterParallelism), item)
+}
+
+func (app webApp) invokeCapability(ctx context.Context, item restmodels.CapabilityInvocation) restmodels.CapabilityInvocationResult {
+	capArgs := restmodels.DeviceCapabilityArgs{}
+	if item.Arguments != nil {
+		capArgs = item.Arguments
+	}
+	result := restmodels.CapabilityInvocationResult{}
+	var err error
+	if item.DeviceID != nil {
+		result.Status, _, _, err = app.handleDeviceTrigger(ctx, *item.DeviceID, item.Capability, false, false, false, capArgs)
+	} else {
+		var groupResult restmodels.GroupTriggerResult
+		result.Status, groupResult, err = app.handleGroupTrigger(ctx, *item.GroupID, item.Capability, item.FanOut, false, capArgs)
+		if groupResult.FannedOut {
+			result.GroupResult = &groupResult
+		}
+	}
+	if err != nil {
+		var model *huma.ErrorModel
+		if !errors.As(err, &model) {
+			model = &huma.ErrorModel{Status: http.StatusInternalServerError, Title: http.StatusText(http.StatusInternalServerError), Detail: err.Error()}
+		}
+		result.Status = model.Status
+		result.Error = model
+	}
+	return result
+}
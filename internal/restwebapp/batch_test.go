@@ -0,0 +1,63 @@
+package restwebapp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Kaese72/device-store/internal/config"
+	"github.com/Kaese72/device-store/internal/persistence/intermediaries"
+	"github.com/Kaese72/device-store/restmodels"
+	"github.com/danielgtaylor/huma/v2"
+)
+
+func TestTriggerBatch(t *testing.T) {
+	// Devices 10 through 15 are all behind adapter 1
+	p := &fakePersistence{devices: map[int]intermediaries.DeviceCapabilityIntermediaryActivation{}}
+	items := []restmodels.CapabilityInvocation{}
+	for deviceId := 10; deviceId < 16; deviceId++ {
+		p.devices[deviceId] = intermediaries.DeviceCapabilityIntermediaryActivation{DeviceId: deviceId, BridgeIdentifier: fmt.Sprintf("lights/%d", deviceId), Name: "on", AdapterId: 1}
+		items = append(items, restmodels.CapabilityInvocation{DeviceID: &deviceId, Capability: "on"})
+	}
+	adapter := newFakeAdapter(t)
+	adapter.delay = 50 * time.Millisecond
+	ctx := context.Background()
+
+	t.Run("Refuses batches of too many items", func(t *testing.T) {
+		app := newTestApp(t, p, adapter, config.BatchConfig{MaxItems: 2, AdapterParallelism: 2})
+		_, err := app.TriggerBatch(ctx, &struct {
+			Body restmodels.BatchTriggerRequest
+		}{Body: restmodels.BatchTriggerRequest{Items: items[:3]}})
+		var model *huma.ErrorModel
+		if !errors.As(err, &model) || model.Status != http.StatusUnprocessableEntity || len(model.Errors) != 1 || model.Errors[0].Location != "body.items" {
+			t.Fatalf("expected a 422 locating the items, got %v", err)
+		}
+		if len(p.deviceAudits) != 0 {
+			t.Errorf("expected no item to be triggered, got %v", p.deviceAudits)
+		}
+	})
+
+	t.Run("Sends a bounded number of triggers to the same adapter at a time", func(t *testing.T) {
+		app := newTestApp(t, p, adapter, config.BatchConfig{MaxItems: 10, AdapterParallelism: 2})
+		result, err := app.TriggerBatch(ctx, &struct {
+			Body restmodels.BatchTriggerRequest
+		}{Body: restmodels.BatchTriggerRequest{Items: items}})
+		if err != nil {
+			t.Fatalf("expected the batch to be triggered, got %v", err)
+		}
+		if len(result.Body.Items) != len(items) {
+			t.Fatalf("expected the outcome of %d items, got %d", len(items), len(result.Body.Items))
+		}
+		for index, item := range result.Body.Items {
+			if item.Status != http.StatusNoContent || item.Error != nil {
+				t.Errorf("expected item %d to succeed, got %d and %v", index, item.Status, item.Error)
+			}
+		}
+		if adapter.maxRunning != 2 {
+			t.Errorf("expected 2 triggers at a time, got %d", adapter.maxRunning)
+		}
+	})
+}
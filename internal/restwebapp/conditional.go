@@ -0,0 +1,71 @@
+package restwebapp
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/danielgtaylor/huma/v2/conditional"
+)
+
+// cacheValidators holds the ETag and Last-Modified headers of a response body
+type cacheValidators struct {
+	// ETag is a weak ETag derived from the response body. It is weak since the
+	// client may negotiate a different serialization than the one hashed here.
+	ETag         string
+	LastModified time.Time
+}
+
+// newCacheValidators calculates the validators for body, which was last modified at the latest of updated.
+// Without any updated times the body is only validated by its ETag and no Last-Modified is sent.
+func newCacheValidators(body any, updated ...time.Time) (cacheValidators, error) {
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		return cacheValidators{}, err
+	}
+	hash := sha256.Sum256(encoded)
+	validators := cacheValidators{ETag: hex.EncodeToString(hash[:16])}
+	for _, candidate := range updated {
+		if candidate.After(validators.LastModified) {
+			validators.LastModified = candidate
+		}
+	}
+	// HTTP dates only have second precision
+	validators.LastModified = validators.LastModified.UTC().Truncate(time.Second)
+	return validators, nil
+}
+
+// notModified returns a 304 error, carrying the validators, if the conditional request headers
+// show that the client already has the current representation. Otherwise the validators are
+// formatted for the response and nil is returned.
+func (validators *cacheValidators) notModified(params conditional.Params) error {
+	// If-Modified-Since must be ignored when If-None-Match is present, RFC 9110 section 13.1.3
+	if len(params.IfNoneMatch) > 0 {
+		params.IfModifiedSince = time.Time{}
+	}
+	// Without a Last-Modified there is nothing to compare If-Modified-Since with
+	if validators.LastModified.IsZero() {
+		params.IfModifiedSince = time.Time{}
+	}
+	// Only the read conditions are relevant for GET requests
+	params.IfMatch = nil
+	params.IfUnmodifiedSince = time.Time{}
+	rawETag := validators.ETag
+	validators.ETag = fmt.Sprintf(`W/"%s"`, rawETag)
+	if !params.HasConditionalParams() {
+		return nil
+	}
+	if err := params.PreconditionFailed(rawETag, validators.LastModified); err != nil {
+		headers := http.Header{}
+		headers.Set("ETag", validators.ETag)
+		if !validators.LastModified.IsZero() {
+			headers.Set("Last-Modified", validators.LastModified.Format(http.TimeFormat))
+		}
+		return huma.ErrorWithHeaders(err, headers)
+	}
+	return nil
+}
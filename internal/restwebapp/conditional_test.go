@@ -0,0 +1,91 @@
+package restwebapp
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Kaese72/device-store/restmodels"
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/danielgtaylor/huma/v2/conditional"
+)
+
+// historyPersistence has a single device, updated at updated, whose attributes can be reconstructed at any time
+type historyPersistence struct {
+	fakePersistence
+	updated time.Time
+}
+
+func (p *historyPersistence) GetDevices(ctx context.Context, filters []restmodels.Filter) ([]restmodels.Device, error) {
+	return []restmodels.Device{{ID: 1, Updated: p.updated}}, nil
+}
+
+func (p *historyPersistence) GetDevicesAt(ctx context.Context, filters []restmodels.Filter, at time.Time) ([]restmodels.Device, error) {
+	return []restmodels.Device{{ID: 1, Updated: p.updated, Attributes: []restmodels.Attribute{{Name: "on", Updated: at}}}}, nil
+}
+
+func (p *historyPersistence) GetLastDeletion(ctx context.Context, kind string) (time.Time, error) {
+	return p.updated.Add(-time.Hour), nil
+}
+
+func TestDeviceCacheValidators(t *testing.T) {
+	updated := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	app := webApp{persistence: &historyPersistence{updated: updated}}
+	ctx := context.Background()
+	tests := []struct {
+		name                 string
+		at                   time.Time
+		expectedLastModified time.Time
+	}{
+		{name: "Current devices were last modified when updated", expectedLastModified: updated},
+		{name: "Past devices are only validated by their ETag", at: updated.Add(-24 * time.Hour)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			device, err := app.GetDevice(ctx, &struct {
+				conditional.Params
+				StoreDeviceIdentifier string    `path:"storeDeviceIdentifier" doc:"the ID of the device to retrieve"`
+				At                    time.Time `query:"at" doc:"reconstruct attribute values as they were at this point in time"`
+			}{StoreDeviceIdentifier: "1", At: tt.at})
+			if err != nil {
+				t.Fatalf("expected the device, got %v", err)
+			}
+			if !device.LastModified.Equal(tt.expectedLastModified) {
+				t.Errorf("expected the device to be last modified at %v, got %v", tt.expectedLastModified, device.LastModified)
+			}
+			devices, err := app.GetDevices(ctx, &struct {
+				conditional.Params
+				Filters string    `query:"filters" doc:"a string JSON array of objects containting key, op, and value for filtering"`
+				At      time.Time `query:"at" doc:"reconstruct attribute values as they were at this point in time"`
+			}{At: tt.at})
+			if err != nil {
+				t.Fatalf("expected the devices, got %v", err)
+			}
+			if !devices.LastModified.Equal(tt.expectedLastModified) {
+				t.Errorf("expected the devices to be last modified at %v, got %v", tt.expectedLastModified, devices.LastModified)
+			}
+		})
+	}
+
+	t.Run("If-Modified-Since is ignored for past devices", func(t *testing.T) {
+		params := conditional.Params{IfModifiedSince: updated.Add(time.Hour)}
+		_, err := app.GetDevice(ctx, &struct {
+			conditional.Params
+			StoreDeviceIdentifier string    `path:"storeDeviceIdentifier" doc:"the ID of the device to retrieve"`
+			At                    time.Time `query:"at" doc:"reconstruct attribute values as they were at this point in time"`
+		}{Params: params, StoreDeviceIdentifier: "1", At: updated.Add(-24 * time.Hour)})
+		if err != nil {
+			t.Fatalf("expected the device rather than %v", err)
+		}
+		var statusErr huma.StatusError
+		if _, err := app.GetDevice(ctx, &struct {
+			conditional.Params
+			StoreDeviceIdentifier string    `path:"storeDeviceIdentifier" doc:"the ID of the device to retrieve"`
+			At                    time.Time `query:"at" doc:"reconstruct attribute values as they were at this point in time"`
+		}{Params: params, StoreDeviceIdentifier: "1"}); !errors.As(err, &statusErr) || statusErr.GetStatus() != http.StatusNotModified {
+			t.Fatalf("expected the current device not to be modified, got %v", err)
+		}
+	})
+}
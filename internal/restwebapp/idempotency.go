@@ -0,0 +1,78 @@
+package restwebapp
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+
+	"github.com/Kaese72/device-store/internal/logging"
+	"github.com/Kaese72/device-store/internal/persistence/intermediaries"
+	"github.com/danielgtaylor/huma/v2"
+)
+
+// retryableStatuses are the statuses of failed requests that may succeed when repeated
+var retryableStatuses = []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout}
+
+// idempotent performs a trigger request at most once per idempotency key, and replays the response of the first
+// request to repeated requests with the same key. Requests without a key are always performed. request identifies
+// what was requested, so that reusing a key for something else is refused. Requests failing unexpectedly, refused
+// because the store is too busy, or failing because the adapter failed or could not be reached, release the key
+// again so that they can be repeated.
+func idempotent[T any](ctx context.Context, app webApp, key string, request any, trigger func() (int, T, error)) (status int, body T, replayed bool, err error) {
+	if key == "" {
+		status, body, err = trigger()
+		return status, body, false, err
+	}
+	requestJSON, err := json.Marshal(request)
+	if err != nil {
+		return 0, body, false, err
+	}
+	requestHash := sha256.Sum256(requestJSON)
+	stored, err := app.persistence.ClaimIdempotencyKey(ctx, key, hex.EncodeToString(requestHash[:]))
+	if err != nil {
+		return 0, body, false, err
+	}
+	if stored != nil {
+		if stored.Status >= http.StatusBadRequest {
+			model := &huma.ErrorModel{}
+			if err := json.Unmarshal(stored.Body, model); err != nil {
+				return 0, body, false, err
+			}
+			return 0, body, true, model
+		}
+		if err := json.Unmarshal(stored.Body, &body); err != nil {
+			return 0, body, false, err
+		}
+		return stored.Status, body, true, nil
+	}
+
+	status, body, err = trigger()
+	// The outcome is recorded even if the client has gone away, it may well repeat the request
+	ctx = context.WithoutCancel(ctx)
+	var statusErr huma.StatusError
+	if err != nil && (!errors.As(err, &statusErr) || slices.Contains(retryableStatuses, statusErr.GetStatus())) {
+		if releaseErr := app.persistence.ReleaseIdempotencyKey(ctx, key); releaseErr != nil {
+			logging.ErrorErr(releaseErr, ctx)
+		}
+		return status, body, false, err
+	}
+	response := intermediaries.IdempotentResponse{Status: status}
+	if err != nil {
+		response.Status = statusErr.GetStatus()
+		response.Body, _ = json.Marshal(statusErr)
+	} else {
+		response.Body, _ = json.Marshal(body)
+	}
+	if storeErr := app.persistence.StoreIdempotentResponse(ctx, key, response); storeErr != nil {
+		logging.ErrorErr(storeErr, ctx)
+		// Better to perform a repeated request again than to refuse it forever
+		if releaseErr := app.persistence.ReleaseIdempotencyKey(ctx, key); releaseErr != nil {
+			logging.ErrorErr(releaseErr, ctx)
+		}
+	}
+	return status, body, false, err
+}
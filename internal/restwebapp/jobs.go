@@ -0,0 +1,83 @@
+package restwebapp
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Kaese72/device-store/eventmodels"
+	"github.com/Kaese72/device-store/internal/logging"
+	"github.com/Kaese72/device-store/restmodels"
+	"github.com/danielgtaylor/huma/v2"
+)
+
+// triggerRun performs a capability trigger, returning the ID of the audit row recording the outcome (zero if none
+// was written) and, for fanned out group triggers, the outcome for every member
+type triggerRun func(ctx context.Context) (int, *restmodels.GroupTriggerResult, error)
+
+// runInBackground records a trigger job and queues run on the worker pool. The job outlives the request, so run
+// gets a context that is not cancelled with it. When the job finishes its outcome is recorded and published as
+// a TriggerJobCompleted event. A job refused because the pool is full is recorded as failed but not published, as
+// the refusal is the response to the request.
+func (app webApp) runInBackground(ctx context.Context, job restmodels.TriggerJob, run triggerRun) (restmodels.TriggerJob, error) {
+	jobId, err := app.persistence.CreateTriggerJob(ctx, job, app.jobs.Lease())
+	if err != nil {
+		return restmodels.TriggerJob{}, err
+	}
+	jobCtx := context.WithoutCancel(ctx)
+	submitted := app.jobs.Submit(jobId, func() {
+		if err := app.persistence.StartTriggerJob(jobCtx, jobId); err != nil {
+			logging.Error(fmt.Sprintf("Failed to start trigger job %d: %s", jobId, err.Error()), jobCtx)
+		}
+		auditId, groupResult, err := run(jobCtx)
+		app.finishJob(jobCtx, jobId, job, auditId, groupResult, err)
+	})
+	if !submitted {
+		err := huma.Error503ServiceUnavailable("too many capability triggers are waiting to run, try again later")
+		msg := err.Error()
+		if err := app.persistence.FinishTriggerJob(ctx, jobId, restmodels.TriggerJobFailed, &msg, nil, nil); err != nil {
+			logging.Error(fmt.Sprintf("Failed to finish trigger job %d: %s", jobId, err.Error()), ctx)
+		}
+		return restmodels.TriggerJob{}, err
+	}
+	return app.persistence.GetTriggerJob(ctx, jobId)
+}
+
+// finishJob records the outcome of a trigger job and publishes it
+func (app webApp) finishJob(ctx context.Context, jobId int, job restmodels.TriggerJob, auditId int, groupResult *restmodels.GroupTriggerResult, runErr error) {
+	status := restmodels.TriggerJobSucceeded
+	var errMsg *string
+	if runErr != nil {
+		status = restmodels.TriggerJobFailed
+		msg := runErr.Error()
+		errMsg = &msg
+	}
+	var auditIdRef *int
+	if auditId != 0 {
+		auditIdRef = &auditId
+	}
+	if err := app.persistence.FinishTriggerJob(ctx, jobId, status, errMsg, auditIdRef, groupResult); err != nil {
+		logging.Error(fmt.Sprintf("Failed to finish trigger job %d: %s", jobId, err.Error()), ctx)
+	}
+	app.storeEventsChan <- eventmodels.TriggerJobCompleted{
+		JobID:        jobId,
+		DeviceID:     job.DeviceID,
+		GroupID:      job.GroupID,
+		Name:         job.Name,
+		Status:       status,
+		ErrorMessage: errMsg,
+		AuditID:      auditIdRef,
+	}
+}
+
+// GetTriggerJob returns a capability trigger running in the background, and its outcome once finished
+func (app webApp) GetTriggerJob(ctx context.Context, input *struct {
+	JobID int `path:"jobID" doc:"the ID of the job"`
+}) (*struct {
+	Body restmodels.TriggerJob
+}, error) {
+	job, err := app.persistence.GetTriggerJob(ctx, input.JobID)
+	if err != nil {
+		return nil, err
+	}
+	return &struct{ Body restmodels.TriggerJob }{Body: job}, nil
+}
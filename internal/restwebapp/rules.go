@@ -0,0 +1,128 @@
+package restwebapp
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/Kaese72/device-store/internal/rules"
+	"github.com/Kaese72/device-store/restmodels"
+	"github.com/danielgtaylor/huma/v2"
+)
+
+// validateRule checks that the devices of the conditions, and what a rule runs, currently exist. It returns whether
+// the conditions already hold.
+func (app webApp) validateRule(ctx context.Context, spec restmodels.RuleSpec) (bool, error) {
+	details := []error{}
+	attributes := map[int][]restmodels.Attribute{}
+	for index, condition := range spec.Conditions {
+		if _, ok := attributes[condition.DeviceID]; ok {
+			continue
+		}
+		devices, err := app.persistence.GetDevices(ctx, []restmodels.Filter{{Key: "id", Operator: "eq", Value: strconv.Itoa(condition.DeviceID)}})
+		if err != nil {
+			return false, err
+		}
+		if len(devices) == 0 {
+			details = append(details, &huma.ErrorDetail{
+				Message:  fmt.Sprintf("device %d not found", condition.DeviceID),
+				Location: fmt.Sprintf("body.conditions[%d].device-id", index),
+				Value:    condition.DeviceID,
+			})
+			continue
+		}
+		attributes[condition.DeviceID] = devices[0].Attributes
+	}
+	targetDetails, err := app.validateTarget(ctx, spec.Invocation, spec.SceneID)
+	if err != nil {
+		return false, err
+	}
+	details = append(details, targetDetails...)
+	if len(details) > 0 {
+		return false, huma.Error422UnprocessableEntity("invalid rule", details...)
+	}
+	_, satisfied := rules.Observe(spec.Conditions, attributes)
+	return satisfied, nil
+}
+
+func (app webApp) GetRules(ctx context.Context, input *struct{}) (*struct {
+	Body []restmodels.Rule
+}, error) {
+	allRules, err := app.persistence.GetRules(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &struct{ Body []restmodels.Rule }{Body: allRules}, nil
+}
+
+func (app webApp) GetRule(ctx context.Context, input *struct {
+	RuleID int `path:"ruleID" doc:"the ID of the rule"`
+}) (*struct {
+	Body restmodels.Rule
+}, error) {
+	rule, err := app.persistence.GetRule(ctx, input.RuleID)
+	if err != nil {
+		return nil, err
+	}
+	return &struct{ Body restmodels.Rule }{Body: rule}, nil
+}
+
+// CreateRule creates a rule after validating its devices and what it runs. A rule whose conditions already hold
+// does not fire until they stop holding and become true again.
+func (app webApp) CreateRule(ctx context.Context, input *struct {
+	Body restmodels.RuleSpec `body:""`
+}) (*struct {
+	Body restmodels.Rule
+}, error) {
+	now := time.Now().UTC().Truncate(time.Second)
+	satisfied, err := app.validateRule(ctx, input.Body)
+	if err != nil {
+		return nil, err
+	}
+	rule, err := app.persistence.CreateRule(ctx, input.Body, rules.InitialState(satisfied, now))
+	if err != nil {
+		return nil, err
+	}
+	return &struct{ Body restmodels.Rule }{Body: rule}, nil
+}
+
+// UpdateRule replaces a rule after validating it like when created, starting it over as if it was created
+func (app webApp) UpdateRule(ctx context.Context, input *struct {
+	RuleID int                 `path:"ruleID" doc:"the ID of the rule to update"`
+	Body   restmodels.RuleSpec `body:""`
+}) (*struct {
+	Body restmodels.Rule
+}, error) {
+	now := time.Now().UTC().Truncate(time.Second)
+	satisfied, err := app.validateRule(ctx, input.Body)
+	if err != nil {
+		return nil, err
+	}
+	rule, err := app.persistence.UpdateRule(ctx, input.RuleID, input.Body, rules.InitialState(satisfied, now))
+	if err != nil {
+		return nil, err
+	}
+	return &struct{ Body restmodels.Rule }{Body: rule}, nil
+}
+
+func (app webApp) DeleteRule(ctx context.Context, input *struct {
+	RuleID int `path:"ruleID" doc:"the ID of the rule to delete"`
+}) (*struct{}, error) {
+	if err := app.persistence.DeleteRule(ctx, input.RuleID); err != nil {
+		return nil, err
+	}
+	return &struct{}{}, nil
+}
+
+func (app webApp) GetRuleFirings(ctx context.Context, input *struct {
+	RuleID int `path:"ruleID" doc:"the ID of the rule"`
+}) (*struct {
+	Body []restmodels.RuleFiring
+}, error) {
+	firings, err := app.persistence.GetRuleFirings(ctx, input.RuleID)
+	if err != nil {
+		return nil, err
+	}
+	return &struct{ Body []restmodels.RuleFiring }{Body: firings}, nil
+}
@@ -0,0 +1,184 @@
+package restwebapp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Kaese72/device-store/internal/arguments"
+	"github.com/Kaese72/device-store/internal/logging"
+	"github.com/Kaese72/device-store/restmodels"
+	"github.com/danielgtaylor/huma/v2"
+)
+
+// validateInvocation checks that the device or group of a capability invocation currently has the capability,
+// and that the arguments are valid for it
+func (app webApp) validateInvocation(ctx context.Context, item restmodels.CapabilityInvocation) error {
+	capArgs := restmodels.DeviceCapabilityArgs{}
+	if item.Arguments != nil {
+		capArgs = item.Arguments
+	}
+	if item.DeviceID != nil {
+		capability, err := app.persistence.GetDeviceCapabilityForActivation(ctx, *item.DeviceID, item.Capability)
+		if err != nil {
+			return err
+		}
+		_, err = arguments.Validate(capability.ArgumentSpecs, capability.ArgumentJSONSchema, capArgs)
+		return err
+	}
+	_, _, err := app.prepareGroupTrigger(ctx, *item.GroupID, item.Capability, item.FanOut, capArgs)
+	return err
+}
+
+// invocationErrorDetails turns a failed validation of a capability invocation into error details located at the
+// invocation within a request body. Errors other than 404 and 422 are returned as they are.
+func invocationErrorDetails(err error, item restmodels.CapabilityInvocation, location string) ([]error, error) {
+	var model *huma.ErrorModel
+	if !errors.As(err, &model) || (model.Status != http.StatusNotFound && model.Status != http.StatusUnprocessableEntity) {
+		return nil, err
+	}
+	if len(model.Errors) == 0 {
+		return []error{&huma.ErrorDetail{Message: model.Detail, Location: location, Value: item}}, nil
+	}
+	details := []error{}
+	for _, detail := range model.Errors {
+		// Argument validation reports locations within the body of a single trigger
+		details = append(details, &huma.ErrorDetail{
+			Message:  detail.Message,
+			Location: location + ".arguments" + strings.TrimPrefix(detail.Location, "body"),
+			Value:    detail.Value,
+		})
+	}
+	return details, nil
+}
+
+// validateScene validates every item of a scene, reporting every problem as a detail of a single 422 error
+func (app webApp) validateScene(ctx context.Context, scene restmodels.SceneSpec) error {
+	details := []error{}
+	for index, item := range scene.Items {
+		err := app.validateInvocation(ctx, item)
+		if err == nil {
+			continue
+		}
+		itemDetails, err := invocationErrorDetails(err, item, fmt.Sprintf("body.items[%d]", index))
+		if err != nil {
+			return err
+		}
+		details = append(details, itemDetails...)
+	}
+	if len(details) > 0 {
+		return huma.Error422UnprocessableEntity("invalid scene items", details...)
+	}
+	return nil
+}
+
+func (app webApp) GetScenes(ctx context.Context, input *struct{}) (*struct {
+	Body []restmodels.Scene
+}, error) {
+	scenes, err := app.persistence.GetScenes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &struct{ Body []restmodels.Scene }{Body: scenes}, nil
+}
+
+func (app webApp) GetScene(ctx context.Context, input *struct {
+	SceneID int `path:"sceneID" doc:"the ID of the scene"`
+}) (*struct {
+	Body restmodels.Scene
+}, error) {
+	scene, err := app.persistence.GetScene(ctx, input.SceneID)
+	if err != nil {
+		return nil, err
+	}
+	return &struct{ Body restmodels.Scene }{Body: scene}, nil
+}
+
+// CreateScene creates a scene after validating its items against the current capabilities
+func (app webApp) CreateScene(ctx context.Context, input *struct {
+	Body restmodels.SceneSpec `body:""`
+}) (*struct {
+	Body restmodels.Scene
+}, error) {
+	if err := app.validateScene(ctx, input.Body); err != nil {
+		return nil, err
+	}
+	scene, err := app.persistence.CreateScene(ctx, input.Body)
+	if err != nil {
+		return nil, err
+	}
+	return &struct{ Body restmodels.Scene }{Body: scene}, nil
+}
+
+// UpdateScene replaces the name and items of a scene after validating its items against the current capabilities
+func (app webApp) UpdateScene(ctx context.Context, input *struct {
+	SceneID int                  `path:"sceneID" doc:"the ID of the scene to update"`
+	Body    restmodels.SceneSpec `body:""`
+}) (*struct {
+	Body restmodels.Scene
+}, error) {
+	if err := app.validateScene(ctx, input.Body); err != nil {
+		return nil, err
+	}
+	scene, err := app.persistence.UpdateScene(ctx, input.SceneID, input.Body)
+	if err != nil {
+		return nil, err
+	}
+	return &struct{ Body restmodels.Scene }{Body: scene}, nil
+}
+
+func (app webApp) DeleteScene(ctx context.Context, input *struct {
+	SceneID int `path:"sceneID" doc:"the ID of the scene to delete"`
+}) (*struct{}, error) {
+	if err := app.persistence.DeleteScene(ctx, input.SceneID); err != nil {
+		return nil, err
+	}
+	return &struct{}{}, nil
+}
+
+// ActivateScene triggers every item of a scene, like a batch, and audits the activation. Items failing, for example
+// because their device lost the capability since the scene was saved, do not stop the other items from being
+// triggered and are reported in the activation.
+func (app webApp) ActivateScene(ctx context.Context, input *struct {
+	SceneID int `path:"sceneID" doc:"the ID of the scene to activate"`
+}) (*struct {
+	Body restmodels.SceneActivation
+}, error) {
+	activation, err := app.RunScene(ctx, input.SceneID)
+	if err != nil {
+		return nil, err
+	}
+	return &struct{ Body restmodels.SceneActivation }{Body: activation}, nil
+}
+
+// RunScene activates a scene and returns its activation audit. The scheduler activates scenes through it.
+func (app webApp) RunScene(ctx context.Context, sceneId int) (restmodels.SceneActivation, error) {
+	scene, err := app.persistence.GetScene(ctx, sceneId)
+	if err != nil {
+		return restmodels.SceneActivation{}, err
+	}
+	logging.Info(fmt.Sprintf("Activating scene '%d' of %d items", scene.ID, len(scene.Items)), ctx)
+	activation := restmodels.SceneActivation{SceneID: scene.ID, Items: app.invokeCapabilities(ctx, scene.Items)}
+	for _, result := range activation.Items {
+		if result.Error != nil {
+			activation.Failed++
+		} else {
+			activation.Succeeded++
+		}
+	}
+	return app.persistence.WriteSceneActivationAudit(ctx, activation)
+}
+
+func (app webApp) GetSceneActivationAudits(ctx context.Context, input *struct {
+	SceneID int `path:"sceneID" doc:"the ID of the scene"`
+}) (*struct {
+	Body []restmodels.SceneActivation
+}, error) {
+	audits, err := app.persistence.GetSceneActivationAudits(ctx, input.SceneID)
+	if err != nil {
+		return nil, err
+	}
+	return &struct{ Body []restmodels.SceneActivation }{Body: audits}, nil
+}
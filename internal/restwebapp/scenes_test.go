@@ -0,0 +1,78 @@
+package restwebapp
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/Kaese72/device-store/internal/config"
+	"github.com/Kaese72/device-store/internal/persistence/intermediaries"
+	"github.com/Kaese72/device-store/restmodels"
+	"github.com/danielgtaylor/huma/v2"
+)
+
+func TestScenes(t *testing.T) {
+	device := func(deviceId int, bridgeIdentifier string) intermediaries.DeviceCapabilityIntermediaryActivation {
+		return intermediaries.DeviceCapabilityIntermediaryActivation{DeviceId: deviceId, BridgeIdentifier: bridgeIdentifier, Name: "dim", AdapterId: 1, ArgumentSpecs: levelSpecs(100)}
+	}
+	invocation := func(deviceId int, level int) restmodels.CapabilityInvocation {
+		return restmodels.CapabilityInvocation{DeviceID: &deviceId, Capability: "dim", Arguments: restmodels.DeviceCapabilityArgs{"level": level}}
+	}
+	// Device 12 is not known, and triggering device 11 fails
+	p := &fakePersistence{
+		devices: map[int]intermediaries.DeviceCapabilityIntermediaryActivation{10: device(10, "lights/1"), 11: device(11, "lights/2")},
+		scenes: map[int]restmodels.Scene{
+			1: {ID: 1, Name: "evening", Items: []restmodels.CapabilityInvocation{invocation(10, 40), invocation(11, 40)}},
+		},
+	}
+	app := newTestApp(t, p, newFakeAdapter(t, "lights/2"), config.BatchConfig{MaxItems: 10, AdapterParallelism: 2})
+	ctx := context.Background()
+
+	t.Run("Scenes are validated item by item", func(t *testing.T) {
+		if err := app.validateScene(ctx, restmodels.SceneSpec{Name: "evening", Items: []restmodels.CapabilityInvocation{invocation(10, 40)}}); err != nil {
+			t.Fatalf("expected the scene to be valid, got %v", err)
+		}
+		err := app.validateScene(ctx, restmodels.SceneSpec{Name: "evening", Items: []restmodels.CapabilityInvocation{invocation(10, 40), invocation(12, 40), invocation(11, 140)}})
+		var model *huma.ErrorModel
+		if !errors.As(err, &model) || model.Status != http.StatusUnprocessableEntity {
+			t.Fatalf("expected a 422, got %v", err)
+		}
+		locations := []string{}
+		for _, detail := range model.Errors {
+			locations = append(locations, detail.Location)
+		}
+		if expected := []string{"body.items[1]", "body.items[2].arguments.level"}; !slices.Equal(locations, expected) {
+			t.Errorf("expected details at %v, got %v", expected, locations)
+		}
+	})
+
+	t.Run("Activations count the items succeeding and failing", func(t *testing.T) {
+		activated, err := app.ActivateScene(ctx, &struct {
+			SceneID int `path:"sceneID" doc:"the ID of the scene to activate"`
+		}{SceneID: 1})
+		if err != nil {
+			t.Fatalf("expected the scene to be activated, got %v", err)
+		}
+		activation := activated.Body
+		if activation.ID != 1 || activation.SceneID != 1 || activation.Succeeded != 1 || activation.Failed != 1 {
+			t.Errorf("expected 1 item to succeed and 1 to fail, got %+v", activation)
+		}
+		if len(activation.Items) != 2 || activation.Items[0].Error != nil || activation.Items[1].Error == nil {
+			t.Errorf("expected the second item to fail, got %+v", activation.Items)
+		}
+		if len(p.activations) != 1 {
+			t.Errorf("expected the activation to be audited, got %d audits", len(p.activations))
+		}
+	})
+
+	t.Run("Unknown scenes are not activated", func(t *testing.T) {
+		var statusErr huma.StatusError
+		if _, err := app.ActivateScene(ctx, &struct {
+			SceneID int `path:"sceneID" doc:"the ID of the scene to activate"`
+		}{SceneID: 2}); !errors.As(err, &statusErr) || statusErr.GetStatus() != http.StatusNotFound {
+			t.Fatalf("expected a 404, got %v", err)
+		}
+	})
+}
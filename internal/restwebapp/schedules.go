@@ -0,0 +1,160 @@
+package restwebapp
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/Kaese72/device-store/restmodels"
+	"github.com/danielgtaylor/huma/v2"
+)
+
+// validateTarget checks that the scene, or the capability invocation, that a schedule or rule runs currently exists,
+// reporting problems as details of the 422 error
+func (app webApp) validateTarget(ctx context.Context, invocation *restmodels.CapabilityInvocation, sceneId *int) ([]error, error) {
+	if sceneId != nil {
+		_, err := app.persistence.GetScene(ctx, *sceneId)
+		var statusErr huma.StatusError
+		if errors.As(err, &statusErr) && statusErr.GetStatus() == http.StatusNotFound {
+			return []error{&huma.ErrorDetail{Message: err.Error(), Location: "body.scene-id", Value: *sceneId}}, nil
+		}
+		return nil, err
+	}
+	err := app.validateInvocation(ctx, *invocation)
+	if err == nil {
+		return nil, nil
+	}
+	return invocationErrorDetails(err, *invocation, "body.invocation")
+}
+
+// validateSchedule checks that the store can compute when a schedule runs, and that what it runs currently exists
+func (app webApp) validateSchedule(ctx context.Context, spec restmodels.ScheduleSpec, now time.Time) error {
+	if err := app.planner.Validate(spec, now); err != nil {
+		return err
+	}
+	details, err := app.validateTarget(ctx, spec.Invocation, spec.SceneID)
+	if err != nil {
+		return err
+	}
+	if len(details) > 0 {
+		return huma.Error422UnprocessableEntity("invalid schedule", details...)
+	}
+	return nil
+}
+
+// nextRun returns when a saved schedule runs next. Disabled schedules do not run, and start over from when they
+// are enabled again.
+func (app webApp) nextRun(spec restmodels.ScheduleSpec, now time.Time) *time.Time {
+	if !spec.IsEnabled() {
+		return nil
+	}
+	return app.planner.Next(spec, now)
+}
+
+func (app webApp) GetSchedules(ctx context.Context, input *struct{}) (*struct {
+	Body []restmodels.Schedule
+}, error) {
+	schedules, err := app.persistence.GetSchedules(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &struct{ Body []restmodels.Schedule }{Body: schedules}, nil
+}
+
+func (app webApp) GetSchedule(ctx context.Context, input *struct {
+	ScheduleID int `path:"scheduleID" doc:"the ID of the schedule"`
+}) (*struct {
+	Body restmodels.Schedule
+}, error) {
+	schedule, err := app.persistence.GetSchedule(ctx, input.ScheduleID)
+	if err != nil {
+		return nil, err
+	}
+	return &struct{ Body restmodels.Schedule }{Body: schedule}, nil
+}
+
+// CreateSchedule creates a schedule after validating what it runs against the current capabilities and scenes
+func (app webApp) CreateSchedule(ctx context.Context, input *struct {
+	Body restmodels.ScheduleSpec `body:""`
+}) (*struct {
+	Body restmodels.Schedule
+}, error) {
+	now := time.Now().UTC()
+	if err := app.validateSchedule(ctx, input.Body, now); err != nil {
+		return nil, err
+	}
+	schedule, err := app.persistence.CreateSchedule(ctx, input.Body, app.nextRun(input.Body, now))
+	if err != nil {
+		return nil, err
+	}
+	return &struct{ Body restmodels.Schedule }{Body: schedule}, nil
+}
+
+// UpdateSchedule replaces a schedule after validating it like when created. The next run is computed anew,
+// so a run that was due but not yet started is not run.
+func (app webApp) UpdateSchedule(ctx context.Context, input *struct {
+	ScheduleID int                     `path:"scheduleID" doc:"the ID of the schedule to update"`
+	Body       restmodels.ScheduleSpec `body:""`
+}) (*struct {
+	Body restmodels.Schedule
+}, error) {
+	now := time.Now().UTC()
+	if err := app.validateSchedule(ctx, input.Body, now); err != nil {
+		return nil, err
+	}
+	schedule, err := app.persistence.UpdateSchedule(ctx, input.ScheduleID, input.Body, app.nextRun(input.Body, now))
+	if err != nil {
+		return nil, err
+	}
+	return &struct{ Body restmodels.Schedule }{Body: schedule}, nil
+}
+
+func (app webApp) DeleteSchedule(ctx context.Context, input *struct {
+	ScheduleID int `path:"scheduleID" doc:"the ID of the schedule to delete"`
+}) (*struct{}, error) {
+	if err := app.persistence.DeleteSchedule(ctx, input.ScheduleID); err != nil {
+		return nil, err
+	}
+	return &struct{}{}, nil
+}
+
+// GetScheduleNextRuns previews when a saved schedule runs next
+func (app webApp) GetScheduleNextRuns(ctx context.Context, input *struct {
+	ScheduleID int `path:"scheduleID" doc:"the ID of the schedule"`
+	Count      int `query:"count" default:"10" minimum:"1" maximum:"100" doc:"the number of runs to preview"`
+}) (*struct {
+	Body []time.Time
+}, error) {
+	schedule, err := app.persistence.GetSchedule(ctx, input.ScheduleID)
+	if err != nil {
+		return nil, err
+	}
+	return &struct{ Body []time.Time }{Body: app.planner.NextRuns(schedule.ScheduleSpec, time.Now().UTC(), input.Count)}, nil
+}
+
+// PreviewSchedule previews when a schedule would run, without saving it or validating what it runs
+func (app webApp) PreviewSchedule(ctx context.Context, input *struct {
+	Count int                     `query:"count" default:"10" minimum:"1" maximum:"100" doc:"the number of runs to preview"`
+	Body  restmodels.ScheduleSpec `body:""`
+}) (*struct {
+	Body []time.Time
+}, error) {
+	now := time.Now().UTC()
+	if err := app.planner.Validate(input.Body, now); err != nil {
+		return nil, err
+	}
+	return &struct{ Body []time.Time }{Body: app.planner.NextRuns(input.Body, now, input.Count)}, nil
+}
+
+func (app webApp) GetScheduleRuns(ctx context.Context, input *struct {
+	ScheduleID int `path:"scheduleID" doc:"the ID of the schedule"`
+}) (*struct {
+	Body []restmodels.ScheduleRun
+}, error) {
+	runs, err := app.persistence.GetScheduleRuns(ctx, input.ScheduleID)
+	if err != nil {
+		return nil, err
+	}
+	return &struct{ Body []restmodels.ScheduleRun }{Body: runs}, nil
+}
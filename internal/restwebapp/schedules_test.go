@@ -0,0 +1,62 @@
+package restwebapp
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Kaese72/device-store/internal/config"
+	"github.com/Kaese72/device-store/internal/schedule"
+	"github.com/Kaese72/device-store/restmodels"
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/danielgtaylor/huma/v2/humatest"
+)
+
+func TestScheduleEnabled(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     map[string]any
+		expected bool
+	}{
+		{
+			name:     "Enabled by default",
+			body:     map[string]any{"name": "nightly", "cron": "0 3 * * *", "scene-id": 1},
+			expected: true,
+		},
+		{
+			name:     "Explicitly enabled",
+			body:     map[string]any{"name": "nightly", "enabled": true, "cron": "0 3 * * *", "scene-id": 1},
+			expected: true,
+		},
+		{
+			name:     "Disabled",
+			body:     map[string]any{"name": "nightly", "enabled": false, "cron": "0 3 * * *", "scene-id": 1},
+			expected: false,
+		},
+	}
+	app := webApp{planner: schedule.NewPlanner(config.SchedulerConfig{Timezone: "UTC"})}
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, api := humatest.New(t)
+			var received restmodels.ScheduleSpec
+			huma.Post(api, "/schedules", func(ctx context.Context, input *struct {
+				Body restmodels.ScheduleSpec
+			}) (*struct{}, error) {
+				received = input.Body
+				return &struct{}{}, nil
+			})
+			resp := api.Post("/schedules", tt.body)
+			if resp.Code != http.StatusNoContent {
+				t.Fatalf("expected status %d, got %d: %s", http.StatusNoContent, resp.Code, resp.Body.String())
+			}
+			if received.IsEnabled() != tt.expected {
+				t.Errorf("expected enabled %v, got %v", tt.expected, received.IsEnabled())
+			}
+			if next := app.nextRun(received, now); (next != nil) != tt.expected {
+				t.Errorf("expected next run %v, got %v", tt.expected, next)
+			}
+		})
+	}
+}
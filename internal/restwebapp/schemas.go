@@ -0,0 +1,57 @@
+package restwebapp
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Kaese72/device-store/internal/arguments"
+	"github.com/Kaese72/device-store/restmodels"
+	"github.com/danielgtaylor/huma/v2"
+)
+
+// GetDeviceCapabilitySchema returns the JSON Schema of the arguments of a device capability
+func (app webApp) GetDeviceCapabilitySchema(ctx context.Context, input *struct {
+	StoreDeviceIdentifier int    `path:"storeDeviceIdentifier" doc:"the ID of the device"`
+	CapabilityID          string `path:"capabilityID" doc:"the capability to describe"`
+}) (*struct {
+	Body map[string]any
+}, error) {
+	restDevices, err := app.persistence.GetDevices(ctx, []restmodels.Filter{
+		{
+			Key:      "id",
+			Operator: "eq",
+			Value:    fmt.Sprintf("%d", input.StoreDeviceIdentifier),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(restDevices) == 0 {
+		return nil, huma.Error404NotFound("device not found")
+	}
+	for _, capability := range restDevices[0].Capabilities {
+		if capability.Name == input.CapabilityID {
+			return &struct{ Body map[string]any }{Body: arguments.Schema(capability.ArgumentSpecs, capability.ArgumentJSONSchema)}, nil
+		}
+	}
+	return nil, huma.Error404NotFound(fmt.Sprintf("capability %s not found for device %d", input.CapabilityID, input.StoreDeviceIdentifier))
+}
+
+// GetGroupCapabilitySchema returns the JSON Schema of the arguments of a group capability
+func (app webApp) GetGroupCapabilitySchema(ctx context.Context, input *struct {
+	StoreGroupIdentifier int    `path:"storeGroupIdentifier" doc:"the ID of the group"`
+	CapabilityID         string `path:"capabilityID" doc:"the capability to describe"`
+}) (*struct {
+	Body map[string]any
+}, error) {
+	group, err := app.getGroup(ctx, input.StoreGroupIdentifier)
+	if err != nil {
+		return nil, err
+	}
+	for _, capability := range group.Capabilities {
+		if capability.Name == input.CapabilityID {
+			return &struct{ Body map[string]any }{Body: arguments.Schema(capability.ArgumentSpecs, capability.ArgumentJSONSchema)}, nil
+		}
+	}
+	return nil, huma.Error404NotFound(fmt.Sprintf("capability %s not found for group %d", input.CapabilityID, input.StoreGroupIdentifier))
+}
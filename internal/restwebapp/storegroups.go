@@ -0,0 +1,86 @@
+package restwebapp
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/Kaese72/device-store/eventmodels"
+	"github.com/Kaese72/device-store/internal/aggregation"
+	"github.com/Kaese72/device-store/internal/persistence/intermediaries"
+	"github.com/Kaese72/device-store/restmodels"
+	"github.com/danielgtaylor/huma/v2"
+)
+
+// getGroup returns a single group, failing with 404 if it does not exist
+func (app webApp) getGroup(ctx context.Context, storeGroupIdentifier int) (restmodels.Group, error) {
+	restGroups, err := app.persistence.GetGroups(ctx, []restmodels.Filter{
+		{
+			Key:      "id",
+			Operator: "eq",
+			Value:    fmt.Sprintf("%d", storeGroupIdentifier),
+		},
+	})
+	if err != nil {
+		return restmodels.Group{}, err
+	}
+	if len(restGroups) == 0 {
+		return restmodels.Group{}, huma.Error404NotFound("group not found")
+	}
+	return restGroups[0], nil
+}
+
+// publishMembershipChange emits an event if the member devices or contained groups of a group changed, followed by
+// the new aggregated values of the attributes of the members that joined or left
+func (app webApp) publishMembershipChange(ctx context.Context, result intermediaries.GroupPostResult) {
+	if len(result.AddedDeviceIDs) == 0 && len(result.RemovedDeviceIDs) == 0 && len(result.AddedGroupIDs) == 0 && len(result.RemovedGroupIDs) == 0 {
+		return
+	}
+	event := eventmodels.GroupMembershipChanged{GroupID: result.GroupID, AddedDeviceIDs: []int{}, RemovedDeviceIDs: []int{}}
+	event.AddedDeviceIDs = append(event.AddedDeviceIDs, result.AddedDeviceIDs...)
+	event.RemovedDeviceIDs = append(event.RemovedDeviceIDs, result.RemovedDeviceIDs...)
+	event.AddedGroupIDs = result.AddedGroupIDs
+	event.RemovedGroupIDs = result.RemovedGroupIDs
+	app.storeEventsChan <- event
+	memberDeviceIds := slices.Concat(result.AddedDeviceIDs, result.RemovedDeviceIDs)
+	memberGroupIds := slices.Concat(result.AddedGroupIDs, result.RemovedGroupIDs)
+	aggregation.PublishMemberChanges(ctx, app.persistence, app.storeEventsChan, nil, []int{result.GroupID}, memberDeviceIds, memberGroupIds)
+}
+
+// CreateStoreGroup creates a group owned by the store. It may contain devices from any adapter.
+func (app webApp) CreateStoreGroup(ctx context.Context, input *struct {
+	Body restmodels.StoreGroup `body:""`
+}) (*struct {
+	Body restmodels.Group
+}, error) {
+	result, err := app.persistence.CreateStoreGroup(ctx, input.Body)
+	if err != nil {
+		return nil, err
+	}
+	group, err := app.getGroup(ctx, result.GroupID)
+	if err != nil {
+		return nil, err
+	}
+	app.storeEventsChan <- eventmodels.GroupCreated{GroupID: group.ID, AdapterID: group.AdapterId, BridgeIdentifier: group.BridgeIdentifier, Name: group.Name}
+	app.publishMembershipChange(ctx, result)
+	return &struct{ Body restmodels.Group }{Body: group}, nil
+}
+
+// UpdateStoreGroup replaces the name and members of a group owned by the store
+func (app webApp) UpdateStoreGroup(ctx context.Context, input *struct {
+	StoreGroupIdentifier int                   `path:"storeGroupIdentifier" doc:"the ID of the group to update"`
+	Body                 restmodels.StoreGroup `body:""`
+}) (*struct {
+	Body restmodels.Group
+}, error) {
+	result, err := app.persistence.UpdateStoreGroup(ctx, input.StoreGroupIdentifier, input.Body)
+	if err != nil {
+		return nil, err
+	}
+	app.publishMembershipChange(ctx, result)
+	group, err := app.getGroup(ctx, input.StoreGroupIdentifier)
+	if err != nil {
+		return nil, err
+	}
+	return &struct{ Body restmodels.Group }{Body: group}, nil
+}
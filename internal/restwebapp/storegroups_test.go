@@ -0,0 +1,111 @@
+package restwebapp
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/Kaese72/device-store/eventmodels"
+	"github.com/Kaese72/device-store/internal/persistence/intermediaries"
+	"github.com/Kaese72/device-store/restmodels"
+)
+
+// storeGroupPersistence creates store managed groups with the ID of group
+type storeGroupPersistence struct {
+	fakePersistence
+	group restmodels.Group
+}
+
+func (p *storeGroupPersistence) CreateStoreGroup(ctx context.Context, group restmodels.StoreGroup) (intermediaries.GroupPostResult, error) {
+	p.group.Name = group.Name
+	p.group.DeviceIds = group.DeviceIds
+	p.group.GroupIds = group.GroupIds
+	return intermediaries.GroupPostResult{GroupID: p.group.ID, Created: true, AddedDeviceIDs: group.DeviceIds, AddedGroupIDs: group.GroupIds}, nil
+}
+
+func (p *storeGroupPersistence) UpdateStoreGroup(ctx context.Context, storeIdentifier int, group restmodels.StoreGroup) (intermediaries.GroupPostResult, error) {
+	result := intermediaries.GroupPostResult{GroupID: storeIdentifier}
+	for _, deviceId := range group.DeviceIds {
+		if !slices.Contains(p.group.DeviceIds, deviceId) {
+			result.AddedDeviceIDs = append(result.AddedDeviceIDs, deviceId)
+		}
+	}
+	for _, deviceId := range p.group.DeviceIds {
+		if !slices.Contains(group.DeviceIds, deviceId) {
+			result.RemovedDeviceIDs = append(result.RemovedDeviceIDs, deviceId)
+		}
+	}
+	p.group.Name = group.Name
+	p.group.DeviceIds = group.DeviceIds
+	return result, nil
+}
+
+// GetMemberAttributeNames reports every member as having an "on" attribute
+func (p *storeGroupPersistence) GetMemberAttributeNames(ctx context.Context, deviceIds []int, groupIds []int) ([]string, error) {
+	return []string{"on"}, nil
+}
+
+func (p *storeGroupPersistence) AggregateGroupAttributes(ctx context.Context, deviceIds []int, groupIds []int, names []string) (map[int][]restmodels.GroupAttribute, error) {
+	attributes := []restmodels.GroupAttribute{}
+	for _, name := range names {
+		attributes = append(attributes, restmodels.GroupAttribute{Name: name, Members: len(p.group.DeviceIds)})
+	}
+	return map[int][]restmodels.GroupAttribute{p.group.ID: attributes}, nil
+}
+
+func (p *storeGroupPersistence) GetGroups(ctx context.Context, filters []restmodels.Filter) ([]restmodels.Group, error) {
+	return []restmodels.Group{p.group}, nil
+}
+
+func TestStoreGroups(t *testing.T) {
+	p := &storeGroupPersistence{group: restmodels.Group{ID: 3, StoreManaged: true}}
+	app := webApp{persistence: p, storeEventsChan: make(chan eventmodels.StoreEvent, 10)}
+	ctx := context.Background()
+
+	created, err := app.CreateStoreGroup(ctx, &struct {
+		Body restmodels.StoreGroup `body:""`
+	}{Body: restmodels.StoreGroup{Name: "downstairs", DeviceIds: []int{10, 11}, GroupIds: []int{2}}})
+	if err != nil {
+		t.Fatalf("expected the group to be created, got %v", err)
+	}
+	if created.Body.ID != 3 || !created.Body.StoreManaged {
+		t.Errorf("expected store managed group 3, got %+v", created.Body)
+	}
+	if event, ok := (<-app.storeEventsChan).(eventmodels.GroupCreated); !ok || event.GroupID != 3 || event.Name != "downstairs" {
+		t.Errorf("expected group 3 to be published as created, got %+v", event)
+	}
+	if event, ok := (<-app.storeEventsChan).(eventmodels.GroupMembershipChanged); !ok || !slices.Equal(event.AddedDeviceIDs, []int{10, 11}) || !slices.Equal(event.AddedGroupIDs, []int{2}) {
+		t.Errorf("expected the members of group 3 to be published, got %+v", event)
+	}
+	if event, ok := (<-app.storeEventsChan).(eventmodels.GroupAttributeUpdate); !ok || event.GroupID != 3 || len(event.Attributes) != 1 || event.Attributes[0].Name != "on" {
+		t.Errorf("expected the aggregated attributes of group 3 to be published, got %+v", event)
+	}
+
+	updated, err := app.UpdateStoreGroup(ctx, &struct {
+		StoreGroupIdentifier int                   `path:"storeGroupIdentifier" doc:"the ID of the group to update"`
+		Body                 restmodels.StoreGroup `body:""`
+	}{StoreGroupIdentifier: 3, Body: restmodels.StoreGroup{Name: "downstairs", DeviceIds: []int{11, 12}}})
+	if err != nil {
+		t.Fatalf("expected the group to be updated, got %v", err)
+	}
+	if !slices.Equal(updated.Body.DeviceIds, []int{11, 12}) {
+		t.Errorf("expected devices 11 and 12, got %v", updated.Body.DeviceIds)
+	}
+	if event, ok := (<-app.storeEventsChan).(eventmodels.GroupMembershipChanged); !ok || !slices.Equal(event.AddedDeviceIDs, []int{12}) || !slices.Equal(event.RemovedDeviceIDs, []int{10}) {
+		t.Errorf("expected the membership change of group 3 to be published, got %+v", event)
+	}
+	if event, ok := (<-app.storeEventsChan).(eventmodels.GroupAttributeUpdate); !ok || event.GroupID != 3 || event.Attributes[0].Members != 2 {
+		t.Errorf("expected the aggregated attributes of group 3 to be published, got %+v", event)
+	}
+
+	// Leaving the members as they are publishes nothing
+	if _, err := app.UpdateStoreGroup(ctx, &struct {
+		StoreGroupIdentifier int                   `path:"storeGroupIdentifier" doc:"the ID of the group to update"`
+		Body                 restmodels.StoreGroup `body:""`
+	}{StoreGroupIdentifier: 3, Body: restmodels.StoreGroup{Name: "ground floor", DeviceIds: []int{11, 12}}}); err != nil {
+		t.Fatalf("expected the group to be updated, got %v", err)
+	}
+	if len(app.storeEventsChan) != 0 {
+		t.Errorf("expected no membership change to be published, got %+v", <-app.storeEventsChan)
+	}
+}
@@ -0,0 +1,89 @@
+package restwebapp
+
+import (
+	"context"
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Kaese72/device-store/restmodels"
+	"github.com/danielgtaylor/huma/v2"
+)
+
+// syncTokenOverlap is subtracted from the snapshot time of every sync token. Changes are committed
+// with the timestamp of when their transaction started, so a change may become visible slightly after
+// the snapshot was taken. Overlapping consecutive syncs makes sure such changes are not missed, at the
+// cost of clients occasionally receiving the same device or group twice.
+const syncTokenOverlap = 5 * time.Second
+
+const syncTokenPrefix = "v1:"
+
+func encodeSyncToken(since time.Time) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(syncTokenPrefix + strconv.FormatInt(since.Unix(), 10)))
+}
+
+func decodeSyncToken(token string) (time.Time, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || !strings.HasPrefix(string(decoded), syncTokenPrefix) {
+		return time.Time{}, huma.Error400BadRequest("malformed sync token")
+	}
+	seconds, err := strconv.ParseInt(strings.TrimPrefix(string(decoded), syncTokenPrefix), 10, 64)
+	if err != nil {
+		return time.Time{}, huma.Error400BadRequest("malformed sync token")
+	}
+	return time.Unix(seconds, 0).UTC(), nil
+}
+
+// Sync returns the devices and groups changed since the passed token along with tombstones of
+// the ones deleted, and a new token to continue from. Without a token the entire store is returned.
+func (app webApp) Sync(ctx context.Context, input *struct {
+	Since string `query:"since" doc:"the token returned by the previous sync, leave out for a full sync"`
+}) (*struct {
+	Body restmodels.SyncResponse
+}, error) {
+	snapshot, err := app.persistence.GetSyncSnapshotTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+	response := restmodels.SyncResponse{
+		Full:           input.Since == "",
+		Devices:        []restmodels.Device{},
+		Groups:         []restmodels.Group{},
+		DeletedDevices: []restmodels.Tombstone{},
+		DeletedGroups:  []restmodels.Tombstone{},
+		Token:          encodeSyncToken(snapshot.Add(-syncTokenOverlap)),
+	}
+	filters := []restmodels.Filter{}
+	if input.Since != "" {
+		since, err := decodeSyncToken(input.Since)
+		if err != nil {
+			return nil, err
+		}
+		response.DeletedDevices, response.DeletedGroups, err = app.persistence.GetTombstones(ctx, since)
+		if err != nil {
+			return nil, err
+		}
+		// Timestamps have second precision, so this includes everything updated at or after since
+		filters = append(filters, restmodels.Filter{
+			Key:      "updated",
+			Operator: "gt",
+			Value:    since.Add(-time.Second).Format("2006-01-02 15:04:05"),
+		})
+	}
+	devices, err := app.persistence.GetDevices(ctx, filters)
+	if err != nil {
+		return nil, err
+	}
+	if devices != nil {
+		response.Devices = devices
+	}
+	groups, err := app.persistence.GetGroups(ctx, filters)
+	if err != nil {
+		return nil, err
+	}
+	if groups != nil {
+		response.Groups = groups
+	}
+	return &struct{ Body restmodels.SyncResponse }{Body: response}, nil
+}
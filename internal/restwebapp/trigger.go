@@ -0,0 +1,391 @@
+package restwebapp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/Kaese72/device-store/internal/arguments"
+	"github.com/Kaese72/device-store/internal/logging"
+	"github.com/Kaese72/device-store/internal/outcomes"
+	"github.com/Kaese72/device-store/internal/persistence/intermediaries"
+	"github.com/Kaese72/device-store/restmodels"
+	"github.com/danielgtaylor/huma/v2"
+)
+
+// handleDeviceTrigger validates the arguments of a device capability trigger and triggers it, or queues it to
+// be triggered in the background if async is set. If verify is set, the trigger is not done until the device was
+// verified to report the expected outcome of the capability. It returns the status and body to respond with, and
+// the outcome if the trigger was verified without being queued.
+func (app webApp) handleDeviceTrigger(ctx context.Context, deviceId int, capabilityName string, async bool, coalesce bool, verify bool, capArgs restmodels.DeviceCapabilityArgs) (int, *restmodels.TriggerJob, string, error) {
+	capability, err := app.persistence.GetDeviceCapabilityForActivation(ctx, deviceId, capabilityName)
+	if err != nil {
+		return 0, nil, "", err
+	}
+	capArgs, err = arguments.Validate(capability.ArgumentSpecs, capability.ArgumentJSONSchema, capArgs)
+	if err != nil {
+		return 0, nil, "", err
+	}
+	if async {
+		job, err := app.runInBackground(ctx, restmodels.TriggerJob{DeviceID: &capability.DeviceId, Name: capability.Name, Arguments: capArgs}, func(ctx context.Context) (int, *restmodels.GroupTriggerResult, error) {
+			awaitOutcome := func(context.Context) string { return "" }
+			if verify {
+				ctx, awaitOutcome = withOutcome(ctx)
+			}
+			auditId, err := app.triggerDevice(ctx, capability, capArgs, coalesce)
+			awaitOutcome(ctx)
+			return auditId, nil, err
+		})
+		if err != nil {
+			return 0, nil, "", err
+		}
+		return http.StatusAccepted, &job, "", nil
+	}
+	awaitOutcome := func(context.Context) string { return "" }
+	if verify {
+		ctx, awaitOutcome = withOutcome(ctx)
+	}
+	if _, err := app.triggerDevice(ctx, capability, capArgs, coalesce); err != nil {
+		return 0, nil, "", err
+	}
+	logging.Info("Capability seemingly successfully triggered", ctx)
+	return http.StatusNoContent, nil, awaitOutcome(ctx), nil
+}
+
+// handleGroupTrigger validates the arguments of a group capability trigger and triggers it, or queues it to be
+// triggered in the background if async is set. It returns the status and body to respond with.
+func (app webApp) handleGroupTrigger(ctx context.Context, groupId int, capabilityName string, fanOut bool, async bool, capArgs restmodels.DeviceCapabilityArgs) (int, restmodels.GroupTriggerResult, error) {
+	run, capArgs, err := app.prepareGroupTrigger(ctx, groupId, capabilityName, fanOut, capArgs)
+	if err != nil {
+		return 0, restmodels.GroupTriggerResult{}, err
+	}
+	if async {
+		job, err := app.runInBackground(ctx, restmodels.TriggerJob{GroupID: &groupId, Name: capabilityName, Arguments: capArgs}, run)
+		if err != nil {
+			return 0, restmodels.GroupTriggerResult{}, err
+		}
+		return http.StatusAccepted, restmodels.GroupTriggerResult{Job: &job}, nil
+	}
+	_, result, err := run(ctx)
+	if err != nil {
+		return 0, restmodels.GroupTriggerResult{}, err
+	}
+	if result == nil {
+		logging.Info("Capability seemingly successfully triggered", ctx)
+		result = &restmodels.GroupTriggerResult{}
+	}
+	return http.StatusOK, *result, nil
+}
+
+// prepareGroupTrigger validates the arguments of a group capability trigger and returns how to trigger it, along
+// with the validated arguments. Groups without the capability of their own have it fanned out over their members
+// if fanOut is set, as store managed groups always do.
+func (app webApp) prepareGroupTrigger(ctx context.Context, groupId int, capabilityName string, fanOut bool, capArgs restmodels.DeviceCapabilityArgs) (triggerRun, restmodels.DeviceCapabilityArgs, error) {
+	fanOutMembers := false
+	capability, err := app.persistence.GetGroupCapabilityForActivation(ctx, groupId, capabilityName)
+	if err != nil {
+		var statusErr huma.StatusError
+		if !fanOut || !errors.As(err, &statusErr) || statusErr.GetStatus() != http.StatusNotFound {
+			return nil, nil, err
+		}
+		// The group does not have the capability itself, but its members might
+		fanOutMembers = true
+	}
+	if fanOutMembers || capability.StoreManaged {
+		plan, err := app.prepareFanOut(ctx, groupId, capabilityName, capArgs)
+		if err != nil {
+			return nil, nil, err
+		}
+		return func(ctx context.Context) (int, *restmodels.GroupTriggerResult, error) {
+			result, auditId, err := app.runFanOut(ctx, plan)
+			return auditId, &result, err
+		}, capArgs, nil
+	}
+	capArgs, err = arguments.Validate(capability.ArgumentSpecs, capability.ArgumentJSONSchema, capArgs)
+	if err != nil {
+		return nil, nil, err
+	}
+	return func(ctx context.Context) (int, *restmodels.GroupTriggerResult, error) {
+		auditId, err := app.triggerGroup(ctx, capability, capArgs)
+		return auditId, nil, err
+	}, capArgs, nil
+}
+
+// triggerDevice triggers a capability of a device once the triggers queued before it for the device are done and
+// the rate limits of the device and its adapter allow it. If coalesce is set, it replaces a coalescing trigger of
+// the same capability still waiting in the queue. It returns the ID of the audit row, which is zero if the trigger
+// was never sent.
+func (app webApp) triggerDevice(ctx context.Context, capability intermediaries.DeviceCapabilityIntermediaryActivation, capArgs restmodels.DeviceCapabilityArgs, coalesce bool) (int, error) {
+	return app.commands.Run(ctx, capability.DeviceId, capability.AdapterId, capability.Name, coalesce, func(ctx context.Context) (int, error) {
+		return app.sendDeviceTrigger(ctx, capability, capArgs)
+	})
+}
+
+// sendDeviceTrigger triggers a capability of a device through its adapter and audits the outcome, failing fast if
+// the circuit breaker of the adapter is open. It returns the ID of the audit row, which is zero if the adapter could
+// not be looked up.
+func (app webApp) sendDeviceTrigger(ctx context.Context, capability intermediaries.DeviceCapabilityIntermediaryActivation, capArgs restmodels.DeviceCapabilityArgs) (int, error) {
+	adapter, err := app.attendant.GetAdapterAddress(ctx, capability.AdapterId)
+	if err != nil {
+		return 0, err
+	}
+	release, err := acquireAdapter(ctx, capability.AdapterId)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+	argsJSON, _ := json.Marshal(capArgs)
+	probe, err := app.breakers.Allow(capability.AdapterId)
+	if err != nil {
+		errMsg := err.Error()
+		auditId, _ := app.persistence.WriteCapabilityTriggerAudit(ctx, capability.DeviceId, capability.Name, false, &errMsg, string(argsJSON), nil, nil)
+		return auditId, err
+	}
+	// Updates reported while the adapter handles the trigger must not be missed
+	watch := app.verifier.Watch(capability.DeviceId, outcomes.Expect(capability.ExpectedOutcome, capArgs))
+	attempts, sysErr := app.adapters.TriggerDeviceCapability(ctx, adapter, capability.BridgeIdentifier, capability.Name, capArgs)
+	app.breakers.Record(capability.AdapterId, probe, sysErr)
+	if sysErr != nil {
+		watch.Close()
+		errMsg := sysErr.Error()
+		auditId, _ := app.persistence.WriteCapabilityTriggerAudit(ctx, capability.DeviceId, capability.Name, false, &errMsg, string(argsJSON), attempts, nil)
+		return auditId, sysErr
+	}
+	if watch == nil {
+		auditId, _ := app.persistence.WriteCapabilityTriggerAudit(ctx, capability.DeviceId, capability.Name, true, nil, string(argsJSON), attempts, nil)
+		return auditId, nil
+	}
+	pending := restmodels.TriggerOutcomePending
+	auditId, _ := app.persistence.WriteCapabilityTriggerAudit(ctx, capability.DeviceId, capability.Name, true, nil, string(argsJSON), attempts, &pending)
+	// Verifying does not hold up the triggers queued after this one
+	verified := app.verifier.Verify(context.WithoutCancel(ctx), auditId, watch)
+	if slot, ok := ctx.Value(outcomeKey{}).(*outcomeSlot); ok {
+		slot.verified = verified
+	}
+	return auditId, nil
+}
+
+// triggerGroup triggers a capability of a group through its adapter once its rate limit allows it and audits the
+// outcome, failing fast if the circuit breaker of the adapter is open. It returns the ID of the audit row, which is
+// zero if the trigger was never sent.
+func (app webApp) triggerGroup(ctx context.Context, capability intermediaries.GroupCapabilityIntermediaryActivation, capArgs restmodels.DeviceCapabilityArgs) (int, error) {
+	adapter, err := app.attendant.GetAdapterAddress(ctx, capability.AdapterId)
+	if err != nil {
+		return 0, err
+	}
+	if err := app.commands.WaitAdapter(ctx, capability.AdapterId); err != nil {
+		return 0, err
+	}
+	release, err := acquireAdapter(ctx, capability.AdapterId)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+	argsJSON, _ := json.Marshal(capArgs)
+	probe, err := app.breakers.Allow(capability.AdapterId)
+	if err != nil {
+		errMsg := err.Error()
+		auditId, _ := app.persistence.WriteGroupCapabilityTriggerAudit(ctx, capability.GroupId, capability.Name, false, &errMsg, string(argsJSON), nil)
+		return auditId, err
+	}
+	attempts, sysErr := app.adapters.TriggerGroupCapability(ctx, adapter, capability.BridgeIdentifier, capability.Name, capArgs)
+	app.breakers.Record(capability.AdapterId, probe, sysErr)
+	if sysErr != nil {
+		errMsg := sysErr.Error()
+		auditId, _ := app.persistence.WriteGroupCapabilityTriggerAudit(ctx, capability.GroupId, capability.Name, false, &errMsg, string(argsJSON), attempts)
+		return auditId, sysErr
+	}
+	auditId, _ := app.persistence.WriteGroupCapabilityTriggerAudit(ctx, capability.GroupId, capability.Name, true, nil, string(argsJSON), attempts)
+	return auditId, nil
+}
+
+type outcomeKey struct{}
+
+// outcomeSlot receives the verification of a device trigger
+type outcomeSlot struct {
+	verified <-chan string
+}
+
+// withOutcome makes a device trigger done with the returned context hand over the verification of its outcome,
+// which the returned function waits for. The function returns an empty outcome if the trigger was not verified, as
+// when the capability declares no expected outcome, or if ctx is done first.
+func withOutcome(ctx context.Context) (context.Context, func(context.Context) string) {
+	slot := &outcomeSlot{}
+	return context.WithValue(ctx, outcomeKey{}, slot), func(ctx context.Context) string {
+		if slot.verified == nil {
+			return ""
+		}
+		select {
+		case outcome := <-slot.verified:
+			return outcome
+		case <-ctx.Done():
+			return ""
+		}
+	}
+}
+
+type adapterLimiterKey struct{}
+
+// adapterLimiter bounds the number of capabilities triggered through the same adapter at the same time
+type adapterLimiter struct {
+	limit int
+	lock  sync.Mutex
+	// slots is keyed by adapter ID
+	slots map[int]chan struct{}
+}
+
+// withAdapterLimiter bounds the number of capabilities triggered through the same adapter at the same time
+// by everything done with the returned context
+func withAdapterLimiter(ctx context.Context, limit int) context.Context {
+	return context.WithValue(ctx, adapterLimiterKey{}, &adapterLimiter{limit: limit, slots: map[int]chan struct{}{}})
+}
+
+// acquireAdapter waits for the adapter to have a free slot if ctx bounds the number of capabilities triggered
+// through it at the same time, and returns a function freeing the slot again
+func acquireAdapter(ctx context.Context, adapterId int) (func(), error) {
+	limiter, ok := ctx.Value(adapterLimiterKey{}).(*adapterLimiter)
+	if !ok {
+		return func() {}, nil
+	}
+	limiter.lock.Lock()
+	slots, ok := limiter.slots[adapterId]
+	if !ok {
+		slots = make(chan struct{}, limiter.limit)
+		limiter.slots[adapterId] = slots
+	}
+	limiter.lock.Unlock()
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// fanOut is a group trigger resolved into the contained groups and member devices to trigger,
+// each with its arguments validated against its own argument specs
+type fanOut struct {
+	groupId        int
+	capabilityName string
+	capArgs        restmodels.DeviceCapabilityArgs
+	targets        intermediaries.GroupTriggerTargets
+	groupArgs      []restmodels.DeviceCapabilityArgs
+	deviceArgs     []restmodels.DeviceCapabilityArgs
+}
+
+// prepareFanOut resolves what to trigger when fanning out a capability over the members of a group. Arguments are
+// validated against the specs of every member before anything is triggered, since members may declare different
+// argument specs and defaults for the same capability.
+func (app webApp) prepareFanOut(ctx context.Context, groupId int, capabilityName string, capArgs restmodels.DeviceCapabilityArgs) (fanOut, error) {
+	targets, err := app.persistence.GetGroupTriggerTargets(ctx, groupId, capabilityName)
+	if err != nil {
+		return fanOut{}, err
+	}
+	plan := fanOut{
+		groupId:        groupId,
+		capabilityName: capabilityName,
+		capArgs:        capArgs,
+		targets:        targets,
+		groupArgs:      make([]restmodels.DeviceCapabilityArgs, len(targets.Groups)),
+		deviceArgs:     make([]restmodels.DeviceCapabilityArgs, len(targets.Devices)),
+	}
+	details := []error{}
+	for index, group := range targets.Groups {
+		plan.groupArgs[index], err = arguments.Validate(group.ArgumentSpecs, group.ArgumentJSONSchema, capArgs)
+		if details, err = appendValidationDetails(details, fmt.Sprintf("group %d", group.GroupId), err); err != nil {
+			return fanOut{}, err
+		}
+	}
+	for index, device := range targets.Devices {
+		plan.deviceArgs[index], err = arguments.Validate(device.ArgumentSpecs, device.ArgumentJSONSchema, capArgs)
+		if details, err = appendValidationDetails(details, fmt.Sprintf("device %d", device.DeviceId), err); err != nil {
+			return fanOut{}, err
+		}
+	}
+	if len(details) > 0 {
+		return fanOut{}, huma.Error422UnprocessableEntity("invalid capability arguments for some members", details...)
+	}
+	return plan, nil
+}
+
+// runFanOut triggers a prepared fan out on every member concurrently. Every member trigger is audited on the
+// member, and the trigger as a whole is audited on the group, whose audit row ID is returned. It only fails
+// when the capability could not be triggered on any member.
+func (app webApp) runFanOut(ctx context.Context, plan fanOut) (restmodels.GroupTriggerResult, int, error) {
+	targets := plan.targets
+	logging.Info(fmt.Sprintf("Triggering capability '%s' on %d groups and %d devices of group '%d'", plan.capabilityName, len(targets.Groups), len(targets.Devices), plan.groupId), ctx)
+	results := make([]restmodels.GroupMemberTriggerResult, len(targets.Groups)+len(targets.Devices))
+	var wg sync.WaitGroup
+	for index, group := range targets.Groups {
+		wg.Go(func() {
+			results[index] = restmodels.GroupMemberTriggerResult{GroupID: group.GroupId, Success: true}
+			if _, err := app.triggerGroup(ctx, group, plan.groupArgs[index]); err != nil {
+				errMsg := err.Error()
+				results[index].Success = false
+				results[index].ErrorMessage = &errMsg
+			}
+		})
+	}
+	for index, device := range targets.Devices {
+		wg.Go(func() {
+			resultIndex := len(targets.Groups) + index
+			results[resultIndex] = restmodels.GroupMemberTriggerResult{DeviceID: device.DeviceId, Success: true}
+			if _, err := app.triggerDevice(ctx, device, plan.deviceArgs[index], false); err != nil {
+				errMsg := err.Error()
+				results[resultIndex].Success = false
+				results[resultIndex].ErrorMessage = &errMsg
+			}
+		})
+	}
+	wg.Wait()
+
+	failures := []error{}
+	for index, result := range results {
+		if !result.Success {
+			var value any = result.DeviceID
+			if result.GroupID != 0 {
+				value = result.GroupID
+			}
+			failures = append(failures, &huma.ErrorDetail{
+				Message:  *result.ErrorMessage,
+				Location: fmt.Sprintf("members[%d]", index),
+				Value:    value,
+			})
+		}
+	}
+	argsJSON, _ := json.Marshal(plan.capArgs)
+	if len(failures) == 0 {
+		auditId, _ := app.persistence.WriteGroupCapabilityTriggerAudit(ctx, plan.groupId, plan.capabilityName, true, nil, string(argsJSON), nil)
+		return restmodels.GroupTriggerResult{FannedOut: true, Members: results}, auditId, nil
+	}
+	errMsg := fmt.Sprintf("capability failed on %d of %d members", len(failures), len(results))
+	auditId, _ := app.persistence.WriteGroupCapabilityTriggerAudit(ctx, plan.groupId, plan.capabilityName, false, &errMsg, string(argsJSON), nil)
+	if len(failures) == len(results) {
+		return restmodels.GroupTriggerResult{FannedOut: true, Members: results}, auditId, huma.Error502BadGateway(errMsg, failures...)
+	}
+	return restmodels.GroupTriggerResult{FannedOut: true, Members: results}, auditId, nil
+}
+
+// appendValidationDetails adds the details of an argument validation error of a member, prefixing their messages
+// with the member so that it is clear which member refused the arguments. Any other error, such as a stored
+// schema that can no longer be parsed, is returned as is.
+func appendValidationDetails(details []error, member string, err error) ([]error, error) {
+	if err == nil {
+		return details, nil
+	}
+	var model *huma.ErrorModel
+	if !errors.As(err, &model) || model.Status != http.StatusUnprocessableEntity {
+		return details, err
+	}
+	for _, detail := range model.Errors {
+		details = append(details, &huma.ErrorDetail{
+			Message:  fmt.Sprintf("%s: %s", member, detail.Message),
+			Location: detail.Location,
+			Value:    detail.Value,
+		})
+	}
+	return details, nil
+}
@@ -0,0 +1,267 @@
+package restwebapp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Kaese72/device-store/eventmodels"
+	"github.com/Kaese72/device-store/internal/adapterattendant"
+	"github.com/Kaese72/device-store/internal/adapters"
+	"github.com/Kaese72/device-store/internal/commands"
+	"github.com/Kaese72/device-store/internal/config"
+	"github.com/Kaese72/device-store/internal/outcomes"
+	"github.com/Kaese72/device-store/internal/persistence"
+	"github.com/Kaese72/device-store/internal/persistence/intermediaries"
+	"github.com/Kaese72/device-store/restmodels"
+	"github.com/danielgtaylor/huma/v2"
+)
+
+// fakeAdapter is the adapter attendant and a single adapter in one. Triggers of the bridge identifiers in failing
+// fail with 500, and every trigger takes delay.
+type fakeAdapter struct {
+	server  *httptest.Server
+	failing []string
+	delay   time.Duration
+	lock    sync.Mutex
+	// running and maxRunning count the triggers handled at the same time
+	running    int
+	maxRunning int
+}
+
+func newFakeAdapter(t *testing.T, failing ...string) *fakeAdapter {
+	adapter := &fakeAdapter{failing: failing}
+	adapter.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			json.NewEncoder(w).Encode(map[string]string{"address": adapter.server.URL})
+			return
+		}
+		adapter.lock.Lock()
+		adapter.running++
+		adapter.maxRunning = max(adapter.maxRunning, adapter.running)
+		adapter.lock.Unlock()
+		time.Sleep(adapter.delay)
+		adapter.lock.Lock()
+		adapter.running--
+		adapter.lock.Unlock()
+		// Paths are devices/{bridgeIdentifier}/capabilities/{name} and likewise for groups
+		target, _, _ := strings.Cut(r.URL.Path, "/capabilities/")
+		_, bridgeIdentifier, _ := strings.Cut(strings.TrimPrefix(target, "/"), "/")
+		if slices.Contains(adapter.failing, bridgeIdentifier) {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("lamp on fire"))
+		}
+	}))
+	t.Cleanup(adapter.server.Close)
+	return adapter
+}
+
+type groupAudit struct {
+	groupId      int
+	success      bool
+	errorMessage *string
+}
+
+// fakePersistence knows the capabilities of devices and groups, all through adapter 1, and records audits
+type fakePersistence struct {
+	persistence.RestPersistenceDB
+	devices map[int]intermediaries.DeviceCapabilityIntermediaryActivation
+	groups  map[int]intermediaries.GroupCapabilityIntermediaryActivation
+	targets map[int]intermediaries.GroupTriggerTargets
+	scenes  map[int]restmodels.Scene
+	lock    sync.Mutex
+	// deviceAudits are the devices triggered, successfully or not
+	deviceAudits []int
+	groupAudits  []groupAudit
+	activations  []restmodels.SceneActivation
+}
+
+func (p *fakePersistence) GetDeviceCapabilityForActivation(ctx context.Context, deviceId int, capabilityName string) (intermediaries.DeviceCapabilityIntermediaryActivation, error) {
+	capability, ok := p.devices[deviceId]
+	if !ok || capability.Name != capabilityName {
+		return capability, huma.Error404NotFound(fmt.Sprintf("capability %s of device %d not found", capabilityName, deviceId))
+	}
+	return capability, nil
+}
+
+func (p *fakePersistence) GetGroupCapabilityForActivation(ctx context.Context, groupId int, capabilityName string) (intermediaries.GroupCapabilityIntermediaryActivation, error) {
+	capability, ok := p.groups[groupId]
+	if !ok || capability.Name != capabilityName {
+		return capability, huma.Error404NotFound(fmt.Sprintf("capability %s of group %d not found", capabilityName, groupId))
+	}
+	return capability, nil
+}
+
+func (p *fakePersistence) GetGroupTriggerTargets(ctx context.Context, groupId int, capabilityName string) (intermediaries.GroupTriggerTargets, error) {
+	return p.targets[groupId], nil
+}
+
+func (p *fakePersistence) WriteCapabilityTriggerAudit(ctx context.Context, deviceId int, capabilityName string, success bool, errorMessage *string, arguments string, attempts []restmodels.TriggerAttempt, outcome *string) (int, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.deviceAudits = append(p.deviceAudits, deviceId)
+	return len(p.deviceAudits), nil
+}
+
+func (p *fakePersistence) WriteGroupCapabilityTriggerAudit(ctx context.Context, groupId int, capabilityName string, success bool, errorMessage *string, arguments string, attempts []restmodels.TriggerAttempt) (int, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.groupAudits = append(p.groupAudits, groupAudit{groupId: groupId, success: success, errorMessage: errorMessage})
+	return len(p.groupAudits), nil
+}
+
+func (p *fakePersistence) GetScene(ctx context.Context, sceneId int) (restmodels.Scene, error) {
+	scene, ok := p.scenes[sceneId]
+	if !ok {
+		return scene, huma.Error404NotFound(fmt.Sprintf("scene %d not found", sceneId))
+	}
+	return scene, nil
+}
+
+func (p *fakePersistence) WriteSceneActivationAudit(ctx context.Context, activation restmodels.SceneActivation) (restmodels.SceneActivation, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.activations = append(p.activations, activation)
+	activation.ID = len(p.activations)
+	return activation, nil
+}
+
+// levelSpecs accepts levels up to maximum
+func levelSpecs(maximum float32) []restmodels.ArgumentSpec {
+	return []restmodels.ArgumentSpec{{Name: "level", ArgumentKind: restmodels.ArgumentKind{Numeric: &restmodels.NumericArgumentSpec{Max: maximum}}}}
+}
+
+func newTestApp(t *testing.T, p *fakePersistence, adapter *fakeAdapter, batch config.BatchConfig) webApp {
+	t.Helper()
+	app := webApp{
+		persistence:     p,
+		attendant:       adapterattendant.NewAdapterTrigger(config.AdapterAttendantConfig{URL: adapter.server.URL}),
+		adapters:        adapters.NewAdapterClient(config.AdapterRetryConfig{Attempts: 1, Multiplier: 1}),
+		breakers:        adapters.NewBreakers(config.CircuitBreakerConfig{}),
+		storeEventsChan: make(chan eventmodels.StoreEvent, 10),
+		batch:           batch,
+		commands:        commands.NewQueue(config.CommandsConfig{MaxQueued: 10}),
+		verifier:        outcomes.NewVerifier(nil, config.OutcomesConfig{}),
+	}
+	// The address of the adapter is cached before triggers look it up concurrently
+	if _, err := app.attendant.GetAdapterAddress(context.Background(), 1); err != nil {
+		t.Fatalf("failed to look up the adapter: %v", err)
+	}
+	return app
+}
+
+func TestGroupFanOut(t *testing.T) {
+	device := func(deviceId int, bridgeIdentifier string, maximum float32) intermediaries.DeviceCapabilityIntermediaryActivation {
+		return intermediaries.DeviceCapabilityIntermediaryActivation{DeviceId: deviceId, BridgeIdentifier: bridgeIdentifier, Name: "dim", AdapterId: 1, ArgumentSpecs: levelSpecs(maximum)}
+	}
+	// Store managed group 1 holds devices 10 and 11 and group 2, which has the capability itself. Group 3 is an
+	// adapter group without the capability, holding devices 10 and 12, group 4 holds failing devices only and
+	// group 5 is an adapter group with the capability. Group 6 holds device 10 and device 13, whose stored
+	// argument schema can no longer be parsed.
+	p := &fakePersistence{
+		groups: map[int]intermediaries.GroupCapabilityIntermediaryActivation{
+			1: {GroupId: 1, Name: "dim", StoreManaged: true},
+			5: {GroupId: 5, BridgeIdentifier: "groups/9", Name: "dim", AdapterId: 1},
+		},
+		targets: map[int]intermediaries.GroupTriggerTargets{
+			1: {
+				Groups:  []intermediaries.GroupCapabilityIntermediaryActivation{{GroupId: 2, BridgeIdentifier: "groups/5", Name: "dim", AdapterId: 1}},
+				Devices: []intermediaries.DeviceCapabilityIntermediaryActivation{device(10, "lights/1", 100), device(11, "lights/2", 100)},
+			},
+			3: {Devices: []intermediaries.DeviceCapabilityIntermediaryActivation{device(10, "lights/1", 100), device(12, "lights/3", 50)}},
+			4: {Devices: []intermediaries.DeviceCapabilityIntermediaryActivation{device(11, "lights/2", 100)}},
+			6: {Devices: []intermediaries.DeviceCapabilityIntermediaryActivation{
+				device(10, "lights/1", 100),
+				{DeviceId: 13, BridgeIdentifier: "lights/4", Name: "dim", AdapterId: 1, ArgumentJSONSchema: map[string]any{"type": "string"}},
+			}},
+		},
+	}
+	app := newTestApp(t, p, newFakeAdapter(t, "lights/2"), config.BatchConfig{AdapterParallelism: 4})
+	ctx := context.Background()
+
+	t.Run("Store managed groups fan out and report every member", func(t *testing.T) {
+		status, result, err := app.handleGroupTrigger(ctx, 1, "dim", false, false, restmodels.DeviceCapabilityArgs{"level": 80})
+		if err != nil || status != http.StatusOK {
+			t.Fatalf("expected the trigger to succeed on some members, got %d and %v", status, err)
+		}
+		if !result.FannedOut || len(result.Members) != 3 {
+			t.Fatalf("expected the outcome of 3 members, got %+v", result)
+		}
+		succeeded := []bool{}
+		for _, member := range result.Members {
+			succeeded = append(succeeded, member.Success)
+		}
+		if result.Members[0].GroupID != 2 || result.Members[1].DeviceID != 10 || result.Members[2].DeviceID != 11 || !slices.Equal(succeeded, []bool{true, true, false}) {
+			t.Errorf("expected group 2 and device 10 to succeed and device 11 to fail, got %+v", result.Members)
+		}
+		audit := p.groupAudits[len(p.groupAudits)-1]
+		if audit.groupId != 1 || audit.success || audit.errorMessage == nil || *audit.errorMessage != "capability failed on 1 of 3 members" {
+			t.Errorf("expected the group to be audited as partly failed, got %+v", audit)
+		}
+	})
+
+	t.Run("Arguments are validated for every member first", func(t *testing.T) {
+		before := len(p.deviceAudits)
+		_, _, err := app.handleGroupTrigger(ctx, 3, "dim", true, false, restmodels.DeviceCapabilityArgs{"level": 80})
+		var model *huma.ErrorModel
+		if !errors.As(err, &model) || model.Status != http.StatusUnprocessableEntity || len(model.Errors) != 1 || model.Errors[0].Location != "body.level" {
+			t.Fatalf("expected a 422 with a single detail, got %v", err)
+		}
+		if !strings.HasPrefix(model.Errors[0].Message, "device 12: ") {
+			t.Errorf("expected the detail to name device 12, got %+v", model.Errors[0])
+		}
+		if len(p.deviceAudits) != before {
+			t.Errorf("expected no member to be triggered")
+		}
+	})
+
+	t.Run("Members with broken argument schemas fail the trigger", func(t *testing.T) {
+		before := len(p.deviceAudits)
+		_, _, err := app.handleGroupTrigger(ctx, 6, "dim", true, false, restmodels.DeviceCapabilityArgs{"level": 80})
+		var model *huma.ErrorModel
+		if err == nil || (errors.As(err, &model) && model.Status == http.StatusUnprocessableEntity) {
+			t.Fatalf("expected the schema error to be returned as is, got %v", err)
+		}
+		if len(p.deviceAudits) != before {
+			t.Errorf("expected no member to be triggered")
+		}
+	})
+
+	t.Run("Adapter groups only fan out when asked to", func(t *testing.T) {
+		var statusErr huma.StatusError
+		if _, _, err := app.handleGroupTrigger(ctx, 3, "dim", false, false, restmodels.DeviceCapabilityArgs{"level": 20}); !errors.As(err, &statusErr) || statusErr.GetStatus() != http.StatusNotFound {
+			t.Fatalf("expected a 404 without fanning out, got %v", err)
+		}
+		status, result, err := app.handleGroupTrigger(ctx, 3, "dim", true, false, restmodels.DeviceCapabilityArgs{"level": 20})
+		if err != nil || status != http.StatusOK || len(result.Members) != 2 {
+			t.Fatalf("expected both members to be triggered, got %d, %+v and %v", status, result, err)
+		}
+	})
+
+	t.Run("Failing every member fails the trigger", func(t *testing.T) {
+		_, _, err := app.handleGroupTrigger(ctx, 4, "dim", true, false, restmodels.DeviceCapabilityArgs{})
+		var model *huma.ErrorModel
+		if !errors.As(err, &model) || model.Status != http.StatusBadGateway || len(model.Errors) != 1 || model.Errors[0].Location != "members[0]" {
+			t.Fatalf("expected a 502 locating the failed member, got %v", err)
+		}
+	})
+
+	t.Run("Groups having the capability are triggered on the adapter", func(t *testing.T) {
+		before := len(p.deviceAudits)
+		status, result, err := app.handleGroupTrigger(ctx, 5, "dim", true, false, restmodels.DeviceCapabilityArgs{"level": 80})
+		if err != nil || status != http.StatusOK || result.FannedOut {
+			t.Fatalf("expected the group to be triggered itself, got %d, %+v and %v", status, result, err)
+		}
+		if len(p.deviceAudits) != before {
+			t.Errorf("expected no member to be triggered")
+		}
+	})
+}
@@ -1,55 +1,130 @@
 package restwebapp
 
 import (
+	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
+	"time"
 
+	"github.com/Kaese72/device-store/eventmodels"
 	"github.com/Kaese72/device-store/internal/adapterattendant"
 	"github.com/Kaese72/device-store/internal/adapters"
+	"github.com/Kaese72/device-store/internal/aggregation"
+	"github.com/Kaese72/device-store/internal/archive"
+	"github.com/Kaese72/device-store/internal/commands"
+	"github.com/Kaese72/device-store/internal/config"
 	"github.com/Kaese72/device-store/internal/events"
+	"github.com/Kaese72/device-store/internal/jobs"
 	"github.com/Kaese72/device-store/internal/logging"
+	"github.com/Kaese72/device-store/internal/outcomes"
 	"github.com/Kaese72/device-store/internal/persistence"
+	"github.com/Kaese72/device-store/internal/schedule"
 	"github.com/Kaese72/device-store/restmodels"
 	"github.com/danielgtaylor/huma/v2"
+	"github.com/danielgtaylor/huma/v2/conditional"
 	"github.com/danielgtaylor/huma/v2/sse"
 )
 
 type webApp struct {
 	persistence persistence.RestPersistenceDB
 	attendant   adapterattendant.AdapterTriggerClient
+	adapters    adapters.AdapterClient
+	breakers    *adapters.Breakers
 	events      *events.DeviceSubscriptions
+	storeEvents *events.StoreEventSubscriptions
+	// storeEventsChan publishes lifecycle events caused through the REST API
+	storeEventsChan chan eventmodels.StoreEvent
+	// jobs runs capability triggers requested to run in the background
+	jobs    *jobs.Pool
+	batch   config.BatchConfig
+	planner schedule.Planner
+	// commands orders and paces the capabilities triggered on devices
+	commands *commands.Queue
+	verifier *outcomes.Verifier
 }
 
-func NewWebApp(persistence persistence.RestPersistenceDB, attendant adapterattendant.AdapterTriggerClient, events *events.DeviceSubscriptions) webApp {
+func NewWebApp(persistence persistence.RestPersistenceDB, attendant adapterattendant.AdapterTriggerClient, adapterClient adapters.AdapterClient, breakers *adapters.Breakers, events *events.DeviceSubscriptions, storeEvents *events.StoreEventSubscriptions, storeEventsChan chan eventmodels.StoreEvent, jobs *jobs.Pool, batch config.BatchConfig, planner schedule.Planner, commands *commands.Queue, verifier *outcomes.Verifier) webApp {
 	return webApp{
-		persistence: persistence,
-		attendant:   attendant,
-		events:      events,
+		persistence:     persistence,
+		attendant:       attendant,
+		adapters:        adapterClient,
+		breakers:        breakers,
+		events:          events,
+		storeEvents:     storeEvents,
+		storeEventsChan: storeEventsChan,
+		jobs:            jobs,
+		batch:           batch,
+		planner:         planner,
+		commands:        commands,
+		verifier:        verifier,
 	}
 }
 
+// getDevices returns the current devices, or the devices as they were at a point in time if at is set
+func (app webApp) getDevices(ctx context.Context, filters []restmodels.Filter, at time.Time) ([]restmodels.Device, error) {
+	if at.IsZero() {
+		return app.persistence.GetDevices(ctx, filters)
+	}
+	return app.persistence.GetDevicesAt(ctx, filters, at)
+}
+
 // GetDevices returns all devices in the database
 func (app webApp) GetDevices(ctx context.Context, input *struct {
-	Filters string `query:"filters" doc:"a string JSON array of objects containting key, op, and value for filtering"`
+	conditional.Params
+	Filters string    `query:"filters" doc:"a string JSON array of objects containting key, op, and value for filtering"`
+	At      time.Time `query:"at" doc:"reconstruct attribute values as they were at this point in time"`
 }) (*struct {
+	ETag         string    `header:"ETag"`
+	LastModified time.Time `header:"Last-Modified"`
 	Body []restmodels.Device
 }, error) {
 	filters, err := restmodels.ParseQueryIntoFilters(input.Filters)
 	if err != nil {
 		return nil, err
 	}
-	restDevices, err := app.persistence.GetDevices(ctx, filters)
+	restDevices, err := app.getDevices(ctx, filters, input.At)
+	if err != nil {
+		return nil, err
+	}
+	updated := []time.Time{}
+	// Devices as they were at a point in time are reconstructed from audits, which do not tell when the
+	// reconstruction last changed, so they are only validated by their ETag
+	if input.At.IsZero() {
+		// Deleted devices leave the list without any of the remaining devices being updated
+		lastDeletion, err := app.persistence.GetLastDeletion(ctx, "device")
+		if err != nil {
+			return nil, err
+		}
+		updated = append(updated, lastDeletion)
+		for _, device := range restDevices {
+			updated = append(updated, device.Updated)
+		}
+	}
+	validators, err := newCacheValidators(restDevices, updated...)
+	if err != nil {
+		return nil, err
+	}
+	if err := validators.notModified(input.Params); err != nil {
+		return nil, err
+	}
 	return &struct {
+		ETag         string    `header:"ETag"`
+		LastModified time.Time `header:"Last-Modified"`
 		Body []restmodels.Device
 	}{
-		Body: restDevices,
-	}, err
+		ETag:         validators.ETag,
+		LastModified: validators.LastModified,
+		Body:         restDevices,
+	}, nil
 }
 
 func (app webApp) GetDevice(ctx context.Context, input *struct {
-	StoreDeviceIdentifier string `path:"storeDeviceIdentifier" doc:"the ID of the device to retrieve"`
+	conditional.Params
+	StoreDeviceIdentifier string    `path:"storeDeviceIdentifier" doc:"the ID of the device to retrieve"`
+	At                    time.Time `query:"at" doc:"reconstruct attribute values as they were at this point in time"`
 }) (*struct {
+	ETag         string    `header:"ETag"`
+	LastModified time.Time `header:"Last-Modified"`
 	Body restmodels.Device
 }, error) {
 	// Create a filter for the deviceId and use the GetDevices method
@@ -60,18 +135,33 @@ func (app webApp) GetDevice(ctx context.Context, input *struct {
 			Operator: "eq",
 		},
 	}
-	restDevices, err := app.persistence.GetDevices(ctx, filter)
+	restDevices, err := app.getDevices(ctx, filter, input.At)
 	if err != nil {
 		return nil, err
 	}
 	if len(restDevices) == 0 {
 		return nil, huma.Error404NotFound("device not found")
 	}
+	updated := []time.Time{}
+	if input.At.IsZero() {
+		updated = append(updated, restDevices[0].Updated)
+	}
+	validators, err := newCacheValidators(restDevices[0], updated...)
+	if err != nil {
+		return nil, err
+	}
+	if err := validators.notModified(input.Params); err != nil {
+		return nil, err
+	}
 	return &struct {
+		ETag         string    `header:"ETag"`
+		LastModified time.Time `header:"Last-Modified"`
 		Body restmodels.Device
 	}{
-		Body: restDevices[0],
-	}, err
+		ETag:         validators.ETag,
+		LastModified: validators.LastModified,
+		Body:         restDevices[0],
+	}, nil
 }
 
 func (app webApp) DeleteDevice(ctx context.Context, input *struct {
@@ -81,6 +171,21 @@ func (app webApp) DeleteDevice(ctx context.Context, input *struct {
 	if err != nil {
 		return nil, err
 	}
+	app.storeEventsChan <- eventmodels.DeviceDeleted{DeviceID: input.StoreDeviceIdentifier}
+	// The device no longer counts towards the aggregated attributes of its groups
+	aggregation.PublishMemberChanges(ctx, app.persistence, app.storeEventsChan, []int{input.StoreDeviceIdentifier}, nil, []int{input.StoreDeviceIdentifier}, nil)
+	return &struct{}{}, nil
+}
+
+func (app webApp) RestoreDevice(ctx context.Context, input *struct {
+	StoreDeviceIdentifier int `path:"storeDeviceIdentifier" doc:"the ID of the deleted device to restore"`
+}) (*struct{}, error) {
+	err := app.persistence.RestoreDevice(ctx, input.StoreDeviceIdentifier)
+	if err != nil {
+		return nil, err
+	}
+	app.storeEventsChan <- eventmodels.DeviceRestored{DeviceID: input.StoreDeviceIdentifier}
+	aggregation.PublishMemberChanges(ctx, app.persistence, app.storeEventsChan, []int{input.StoreDeviceIdentifier}, nil, []int{input.StoreDeviceIdentifier}, nil)
 	return &struct{}{}, nil
 }
 
@@ -101,11 +206,26 @@ func (app webApp) GetAttributeAudits(ctx context.Context, input *struct {
 }
 
 // StreamDeviceUpdates is a SSE endpoint that sends updates from
+// devices, as well as lifecycle events of devices and groups
 func (app webApp) StreamDeviceUpdates(ctx context.Context, input *struct{}, send sse.Sender) {
 	// writer.Header().Set("Access-Control-Allow-Origin", "*")
 	deviceUpdates := app.events.Subscribe(ctx)
-	for update := range deviceUpdates {
-		if err := send.Data(update); err != nil {
+	storeEvents := app.storeEvents.Subscribe(ctx)
+	for {
+		var data any
+		select {
+		case update, ok := <-deviceUpdates:
+			if !ok {
+				return
+			}
+			data = update
+		case event, ok := <-storeEvents:
+			if !ok {
+				return
+			}
+			data = event
+		}
+		if err := send.Data(data); err != nil {
 			// Even though this is a critical error, we continue
 			logging.ErrorErr(err, ctx)
 			continue
@@ -116,31 +236,43 @@ func (app webApp) StreamDeviceUpdates(ctx context.Context, input *struct{}, send
 func (app webApp) TriggerDeviceCapability(ctx context.Context, input *struct {
 	StoreDeviceIdentifier int                              `path:"storeDeviceIdentifier" doc:"the ID of the device to trigger capability for"`
 	CapabilityID          string                           `path:"capabilityID" doc:"the capability to trigger"`
+	Async                 bool                             `query:"async" doc:"trigger the capability in the background and respond with the job tracking it"`
+	Coalesce              bool                             `query:"coalesce" doc:"replace a trigger of the same capability that was also coalescing and is still waiting for the device"`
+	Verify                bool                             `query:"verify" doc:"wait for the device to report the expected outcome of the capability before responding, or before finishing the job"`
+	IdempotencyKey        string                           `header:"Idempotency-Key" maxLength:"255" doc:"a key unique to this trigger, repeated requests with the same key get the response of the first request"`
 	Body                  *restmodels.DeviceCapabilityArgs `body:""`
-}) (*struct{}, error) {
+}) (*struct {
+	Status  int
+	Outcome string `header:"Trigger-Outcome" doc:"whether the device reported the expected outcome of the capability, set when verifying a trigger that is not run in the background or replayed"`
+	Body    *restmodels.TriggerJob
+}, error) {
 	logging.Info(fmt.Sprintf("Triggering capability '%s' of device '%d'", input.CapabilityID, input.StoreDeviceIdentifier), ctx)
-	capability, err := app.persistence.GetDeviceCapabilityForActivation(ctx, input.StoreDeviceIdentifier, input.CapabilityID)
-	if err != nil {
-		return nil, err
+	capArgs := restmodels.DeviceCapabilityArgs{}
+	if input.Body != nil {
+		capArgs = *input.Body
 	}
-	adapter, err := app.attendant.GetAdapterAddress(ctx, capability.AdapterId)
+	request := []any{"device", input.StoreDeviceIdentifier, input.CapabilityID, input.Async, input.Coalesce, input.Verify, capArgs}
+	var outcome string
+	status, job, replayed, err := idempotent(ctx, app, input.IdempotencyKey, request, func() (status int, job *restmodels.TriggerJob, err error) {
+		status, job, outcome, err = app.handleDeviceTrigger(ctx, input.StoreDeviceIdentifier, input.CapabilityID, input.Async, input.Coalesce, input.Verify, capArgs)
+		return status, job, err
+	})
 	if err != nil {
 		return nil, err
 	}
-	capArg := restmodels.DeviceCapabilityArgs{}
-	if input.Body != nil {
-		capArg = *input.Body
-	}
-	argsJSON, _ := json.Marshal(capArg)
-	sysErr := adapters.TriggerDeviceCapability(ctx, adapter, capability.BridgeIdentifier, capability.Name, capArg)
-	if sysErr != nil {
-		errMsg := sysErr.Error()
-		_ = app.persistence.WriteCapabilityTriggerAudit(ctx, input.StoreDeviceIdentifier, input.CapabilityID, false, &errMsg, string(argsJSON))
-		return nil, sysErr
+	if replayed && job != nil {
+		// The job has likely made progress since it was first responded with
+		refreshed, err := app.persistence.GetTriggerJob(ctx, job.ID)
+		if err != nil {
+			return nil, err
+		}
+		job = &refreshed
 	}
-	_ = app.persistence.WriteCapabilityTriggerAudit(ctx, input.StoreDeviceIdentifier, input.CapabilityID, true, nil, string(argsJSON))
-	logging.Info("Capability seemingly successfully triggered", ctx)
-	return &struct{}{}, nil
+	return &struct {
+		Status  int
+		Outcome string `header:"Trigger-Outcome" doc:"whether the device reported the expected outcome of the capability, set when verifying a trigger that is not run in the background or replayed"`
+		Body    *restmodels.TriggerJob
+	}{Status: status, Outcome: outcome, Body: job}, nil
 }
 
 func (app webApp) GetDeviceCapabilityTriggerAudits(ctx context.Context, input *struct {
@@ -165,37 +297,56 @@ func (app webApp) DeleteGroup(ctx context.Context, input *struct {
 	if err != nil {
 		return nil, err
 	}
+	app.storeEventsChan <- eventmodels.GroupDeleted{GroupID: input.StoreGroupIdentifier}
+	return &struct{}{}, nil
+}
+
+func (app webApp) RestoreGroup(ctx context.Context, input *struct {
+	StoreGroupIdentifier int `path:"storeGroupIdentifier" doc:"the ID of the deleted group to restore"`
+}) (*struct{}, error) {
+	err := app.persistence.RestoreGroup(ctx, input.StoreGroupIdentifier)
+	if err != nil {
+		return nil, err
+	}
+	app.storeEventsChan <- eventmodels.GroupRestored{GroupID: input.StoreGroupIdentifier}
 	return &struct{}{}, nil
 }
 
 func (app webApp) TriggerGroupCapability(ctx context.Context, input *struct {
 	StoreGroupIdentifier int                              `path:"storeGroupIdentifier" doc:"the ID of the group to trigger capability for"`
 	CapabilityID         string                           `path:"capabilityID" doc:"the capability to trigger"`
+	FanOut               bool                             `query:"fan-out" doc:"trigger the capability on each member device having it if the group does not have it itself"`
+	Async                bool                             `query:"async" doc:"trigger the capability in the background and respond with the job tracking it"`
+	IdempotencyKey       string                           `header:"Idempotency-Key" maxLength:"255" doc:"a key unique to this trigger, repeated requests with the same key get the response of the first request"`
 	Body                 *restmodels.DeviceCapabilityArgs `body:""`
-}) (*struct{}, error) {
+}) (*struct {
+	Status int
+	Body   restmodels.GroupTriggerResult
+}, error) {
 	logging.Info(fmt.Sprintf("Triggering capability '%s' of group '%d'", input.CapabilityID, input.StoreGroupIdentifier), ctx)
-	capability, err := app.persistence.GetGroupCapabilityForActivation(ctx, input.StoreGroupIdentifier, input.CapabilityID)
-	if err != nil {
-		return nil, err
-	}
-	adapter, err := app.attendant.GetAdapterAddress(ctx, capability.AdapterId)
-	if err != nil {
-		return nil, err
-	}
 	capArgs := restmodels.DeviceCapabilityArgs{}
 	if input.Body != nil {
 		capArgs = *input.Body
 	}
-	argsJSON, _ := json.Marshal(capArgs)
-	sysErr := adapters.TriggerGroupCapability(ctx, adapter, capability.BridgeIdentifier, capability.Name, capArgs)
-	if sysErr != nil {
-		errMsg := sysErr.Error()
-		_ = app.persistence.WriteGroupCapabilityTriggerAudit(ctx, input.StoreGroupIdentifier, input.CapabilityID, false, &errMsg, string(argsJSON))
-		return nil, sysErr
+	request := []any{"group", input.StoreGroupIdentifier, input.CapabilityID, input.FanOut, input.Async, capArgs}
+	status, result, replayed, err := idempotent(ctx, app, input.IdempotencyKey, request, func() (int, restmodels.GroupTriggerResult, error) {
+		return app.handleGroupTrigger(ctx, input.StoreGroupIdentifier, input.CapabilityID, input.FanOut, input.Async, capArgs)
+	})
+	if err != nil {
+		return nil, err
 	}
-	_ = app.persistence.WriteGroupCapabilityTriggerAudit(ctx, input.StoreGroupIdentifier, input.CapabilityID, true, nil, string(argsJSON))
-	logging.Info("Capability seemingly successfully triggered", ctx)
-	return &struct{}{}, nil
+	if replayed && result.Job != nil {
+		// The job has likely made progress since it was first responded with
+		refreshed, err := app.persistence.GetTriggerJob(ctx, result.Job.ID)
+		if err != nil {
+			return nil, err
+		}
+		result.Job = &refreshed
+	}
+	return &struct {
+		Status int
+		Body   restmodels.GroupTriggerResult
+	}{Status: status, Body: result}, nil
 }
 
 func (app webApp) GetGroupCapabilityTriggerAudits(ctx context.Context, input *struct {
@@ -213,9 +364,25 @@ func (app webApp) GetGroupCapabilityTriggerAudits(ctx context.Context, input *st
 	return &struct{ Body []restmodels.GroupCapabilityTriggerAudit }{Body: audits}, nil
 }
 
+// groupUpdated returns when a group, and the attributes and capabilities aggregated from its members, were updated,
+// as those change without the group itself being updated
+func groupUpdated(group restmodels.Group) []time.Time {
+	updated := []time.Time{group.Updated}
+	for _, attribute := range group.Attributes {
+		updated = append(updated, attribute.Updated)
+	}
+	for _, capability := range group.Capabilities {
+		updated = append(updated, capability.Updated)
+	}
+	return updated
+}
+
 func (app webApp) GetGroups(ctx context.Context, input *struct {
+	conditional.Params
 	Filters string `query:"filters" doc:"a string JSON array of objects containing key, op, and value for filtering"`
 }) (*struct {
+	ETag         string    `header:"ETag"`
+	LastModified time.Time `header:"Last-Modified"`
 	Body []restmodels.Group
 }, error) {
 	filters, err := restmodels.ParseQueryIntoFilters(input.Filters)
@@ -226,12 +393,35 @@ func (app webApp) GetGroups(ctx context.Context, input *struct {
 	if err != nil {
 		return nil, err
 	}
-	return &struct{ Body []restmodels.Group }{Body: restGroups}, nil
+	// Deleted groups leave the list without any of the remaining groups being updated
+	lastDeletion, err := app.persistence.GetLastDeletion(ctx, "group")
+	if err != nil {
+		return nil, err
+	}
+	updated := []time.Time{lastDeletion}
+	for _, group := range restGroups {
+		updated = append(updated, groupUpdated(group)...)
+	}
+	validators, err := newCacheValidators(restGroups, updated...)
+	if err != nil {
+		return nil, err
+	}
+	if err := validators.notModified(input.Params); err != nil {
+		return nil, err
+	}
+	return &struct {
+		ETag         string    `header:"ETag"`
+		LastModified time.Time `header:"Last-Modified"`
+		Body []restmodels.Group
+	}{ETag: validators.ETag, LastModified: validators.LastModified, Body: restGroups}, nil
 }
 
 func (app webApp) GetGroup(ctx context.Context, input *struct {
+	conditional.Params
 	StoreGroupIdentifier string `path:"storeGroupIdentifier" doc:"the ID of the group to retrieve"`
 }) (*struct {
+	ETag         string    `header:"ETag"`
+	LastModified time.Time `header:"Last-Modified"`
 	Body restmodels.Group
 }, error) {
 	// Create a filter for the groupId and use the GetGroups method
@@ -249,5 +439,70 @@ func (app webApp) GetGroup(ctx context.Context, input *struct {
 	if len(restGroups) == 0 {
 		return nil, huma.Error404NotFound("group not found")
 	}
-	return &struct{ Body restmodels.Group }{Body: restGroups[0]}, nil
+	validators, err := newCacheValidators(restGroups[0], groupUpdated(restGroups[0])...)
+	if err != nil {
+		return nil, err
+	}
+	if err := validators.notModified(input.Params); err != nil {
+		return nil, err
+	}
+	return &struct {
+		ETag         string    `header:"ETag"`
+		LastModified time.Time `header:"Last-Modified"`
+		Body restmodels.Group
+	}{ETag: validators.ETag, LastModified: validators.LastModified, Body: restGroups[0]}, nil
+}
+
+// GetAuditRetentionStatus reports the size of every audit table along with the last time it was pruned
+func (app webApp) GetAuditRetentionStatus(ctx context.Context, input *struct{}) (*struct {
+	Body []restmodels.AuditTableStatus
+}, error) {
+	statuses, err := app.persistence.GetAuditTableStatus(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &struct{ Body []restmodels.AuditTableStatus }{Body: statuses}, nil
+}
+
+// ExportArchive returns a versioned copy of all devices and groups, optionally including audits
+func (app webApp) ExportArchive(ctx context.Context, input *struct {
+	Format        string `query:"format" enum:"json,ndjson" default:"json" doc:"the archive format"`
+	IncludeAudits bool   `query:"include-audits" doc:"whether to include attribute and capability trigger audits"`
+}) (*struct {
+	ContentType string `header:"Content-Type"`
+	Body        []byte
+}, error) {
+	storeArchive, err := app.persistence.ExportArchive(ctx, input.IncludeAudits)
+	if err != nil {
+		return nil, err
+	}
+	buffer := bytes.Buffer{}
+	if err := archive.Encode(&buffer, storeArchive, input.Format); err != nil {
+		return nil, err
+	}
+	return &struct {
+		ContentType string `header:"Content-Type"`
+		Body        []byte
+	}{
+		ContentType: archive.ContentType(input.Format),
+		Body:        buffer.Bytes(),
+	}, nil
+}
+
+// ImportArchive restores a JSON or NDJSON archive produced by ExportArchive
+func (app webApp) ImportArchive(ctx context.Context, input *struct {
+	DryRun  bool   `query:"dry-run" doc:"report what the import would do without changing anything"`
+	RawBody []byte `contentType:"application/json"`
+}) (*struct {
+	Body restmodels.ImportReport
+}, error) {
+	storeArchive, err := archive.Decode(bytes.NewReader(input.RawBody))
+	if err != nil {
+		return nil, err
+	}
+	report, err := app.persistence.ImportArchive(ctx, storeArchive, input.DryRun)
+	if err != nil {
+		return nil, err
+	}
+	return &struct{ Body restmodels.ImportReport }{Body: report}, nil
 }
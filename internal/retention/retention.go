@@ -0,0 +1,123 @@
+package retention
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Kaese72/device-store/internal/config"
+	"github.com/Kaese72/device-store/internal/logging"
+	"github.com/Kaese72/device-store/internal/persistence"
+	"github.com/Kaese72/device-store/internal/persistence/intermediaries"
+	"github.com/Kaese72/device-store/restmodels"
+)
+
+// Pruner periodically removes audit rows that fall outside of the configured retention policies
+type Pruner struct {
+	persistence    persistence.RetentionPersistenceDB
+	conf           config.RetentionConfig
+	softDeleteConf config.SoftDeleteConfig
+}
+
+func NewPruner(persistence persistence.RetentionPersistenceDB, conf config.RetentionConfig, softDeleteConf config.SoftDeleteConfig) Pruner {
+	return Pruner{
+		persistence:    persistence,
+		conf:           conf,
+		softDeleteConf: softDeleteConf,
+	}
+}
+
+func (pruner Pruner) policy(table intermediaries.AuditTable) config.AuditRetentionPolicy {
+	switch table {
+	case intermediaries.AttributeAudits:
+		return pruner.conf.AttributeAudit
+	case intermediaries.DeviceCapabilityTriggerAudits:
+		return pruner.conf.DeviceCapabilityTriggerAudit
+	case intermediaries.GroupCapabilityTriggerAudits:
+		return pruner.conf.GroupCapabilityTriggerAudit
+	case intermediaries.SceneActivationAudits:
+		return pruner.conf.SceneActivationAudit
+	case intermediaries.ScheduleRuns:
+		return pruner.conf.ScheduleRuns
+	case intermediaries.RuleFirings:
+		return pruner.conf.RuleFirings
+	case intermediaries.Tombstones:
+		return pruner.conf.Tombstones
+	case intermediaries.IdempotencyKeys:
+		return pruner.conf.IdempotencyKeys
+	case intermediaries.TriggerJobs:
+		return pruner.conf.TriggerJobs
+	}
+	return config.AuditRetentionPolicy{}
+}
+
+// Run prunes all audit tables once per configured interval until ctx is cancelled
+func (pruner Pruner) Run(ctx context.Context) {
+	ticker := time.NewTicker(pruner.conf.Interval)
+	defer ticker.Stop()
+	for {
+		pruner.PruneOnce(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// PruneOnce applies the retention policy of every audit table and records the outcome as a prune run.
+// Soft deleted devices and groups older than the purge delay are removed for good as well.
+func (pruner Pruner) PruneOnce(ctx context.Context) {
+	if pruner.softDeleteConf.PurgeDelay > 0 {
+		purged, err := pruner.persistence.PurgeSoftDeleted(ctx, time.Now().UTC().Add(-pruner.softDeleteConf.PurgeDelay), pruner.conf.BatchSize)
+		if err != nil {
+			logging.Error(fmt.Sprintf("failed to purge soft deleted devices and groups: %s", err.Error()), ctx)
+		} else if purged > 0 {
+			logging.Info(fmt.Sprintf("purged %d soft deleted devices and groups", purged), ctx)
+		}
+	}
+	for _, table := range intermediaries.AuditTables {
+		policy := pruner.policy(table)
+		if policy.MaxAge == 0 && policy.MaxRows == 0 {
+			continue
+		}
+		run := restmodels.AuditPruneRun{
+			Table:   string(table),
+			Started: time.Now().UTC(),
+		}
+		deleted, err := pruner.pruneTable(ctx, table, policy, &run)
+		run.Finished = time.Now().UTC()
+		run.DeletedRows = deleted
+		if err != nil {
+			logging.Error(fmt.Sprintf("failed to prune %s: %s", table, err.Error()), ctx)
+			errMsg := err.Error()
+			run.ErrorMessage = &errMsg
+		} else if deleted > 0 {
+			logging.Info(fmt.Sprintf("pruned %d rows from %s", deleted, table), ctx)
+		}
+		if err := pruner.persistence.WriteAuditPruneRun(ctx, run); err != nil {
+			logging.ErrorErr(err, ctx)
+		}
+	}
+}
+
+func (pruner Pruner) pruneTable(ctx context.Context, table intermediaries.AuditTable, policy config.AuditRetentionPolicy, run *restmodels.AuditPruneRun) (int64, error) {
+	var deleted int64
+	if policy.MaxAge > 0 {
+		cutoff := time.Now().UTC().Add(-policy.MaxAge)
+		run.Cutoff = &cutoff
+		ageDeleted, err := pruner.persistence.PruneAuditsOlderThan(ctx, table, cutoff, pruner.conf.BatchSize)
+		deleted += ageDeleted
+		if err != nil {
+			return deleted, err
+		}
+	}
+	if policy.MaxRows > 0 {
+		rowsDeleted, err := pruner.persistence.PruneAuditsExceedingRows(ctx, table, policy.MaxRows, pruner.conf.BatchSize)
+		deleted += rowsDeleted
+		if err != nil {
+			return deleted, err
+		}
+	}
+	return deleted, nil
+}
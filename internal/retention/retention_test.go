@@ -0,0 +1,121 @@
+package retention
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Kaese72/device-store/internal/config"
+	"github.com/Kaese72/device-store/internal/persistence/intermediaries"
+	"github.com/Kaese72/device-store/restmodels"
+)
+
+// fakeAuditTables deletes rows of every table, failing for the tables in failing, and records what was asked of it
+type fakeAuditTables struct {
+	failing    []intermediaries.AuditTable
+	byAge      map[intermediaries.AuditTable]time.Time
+	byRows     map[intermediaries.AuditTable]int
+	purged     []time.Time
+	runs       []restmodels.AuditPruneRun
+	batchSizes []int
+}
+
+func (p *fakeAuditTables) PruneAuditsOlderThan(ctx context.Context, table intermediaries.AuditTable, cutoff time.Time, batchSize int) (int64, error) {
+	p.byAge[table] = cutoff
+	p.batchSizes = append(p.batchSizes, batchSize)
+	if slices.Contains(p.failing, table) {
+		return 1, errors.New("lock wait timeout exceeded")
+	}
+	return 3, nil
+}
+
+func (p *fakeAuditTables) PruneAuditsExceedingRows(ctx context.Context, table intermediaries.AuditTable, maxRows int, batchSize int) (int64, error) {
+	p.byRows[table] = maxRows
+	p.batchSizes = append(p.batchSizes, batchSize)
+	return 2, nil
+}
+
+func (p *fakeAuditTables) WriteAuditPruneRun(ctx context.Context, run restmodels.AuditPruneRun) error {
+	p.runs = append(p.runs, run)
+	return nil
+}
+
+func (p *fakeAuditTables) PurgeSoftDeleted(ctx context.Context, cutoff time.Time, batchSize int) (int64, error) {
+	p.purged = append(p.purged, cutoff)
+	p.batchSizes = append(p.batchSizes, batchSize)
+	return 1, nil
+}
+
+func TestPruneOnce(t *testing.T) {
+	conf := config.RetentionConfig{
+		BatchSize:                    50,
+		AttributeAudit:               config.AuditRetentionPolicy{MaxAge: time.Hour},
+		DeviceCapabilityTriggerAudit: config.AuditRetentionPolicy{MaxRows: 10},
+		GroupCapabilityTriggerAudit:  config.AuditRetentionPolicy{MaxAge: 2 * time.Hour, MaxRows: 5},
+		Tombstones:                   config.AuditRetentionPolicy{MaxAge: 24 * time.Hour},
+	}
+	tests := []struct {
+		name           string
+		softDeleteConf config.SoftDeleteConfig
+		failing        []intermediaries.AuditTable
+		expectedPurges int
+	}{
+		{name: "Prunes by age and row count", softDeleteConf: config.SoftDeleteConfig{PurgeDelay: 720 * time.Hour}, expectedPurges: 1},
+		{name: "Keeps soft deleted devices and groups without a purge delay", expectedPurges: 0},
+		{name: "Records failures and carries on", failing: []intermediaries.AuditTable{intermediaries.AttributeAudits}, expectedPurges: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &fakeAuditTables{failing: tt.failing, byAge: map[intermediaries.AuditTable]time.Time{}, byRows: map[intermediaries.AuditTable]int{}}
+			before := time.Now().UTC()
+			NewPruner(p, conf, tt.softDeleteConf).PruneOnce(context.Background())
+			after := time.Now().UTC()
+			// ago reports whether cutoff is age before the prune ran
+			ago := func(cutoff time.Time, age time.Duration) bool {
+				return !cutoff.Before(before.Add(-age)) && !cutoff.After(after.Add(-age))
+			}
+
+			if len(p.purged) != tt.expectedPurges {
+				t.Fatalf("expected %d purges of soft deleted devices and groups, got %d", tt.expectedPurges, len(p.purged))
+			}
+			if tt.expectedPurges > 0 && !ago(p.purged[0], tt.softDeleteConf.PurgeDelay) {
+				t.Errorf("expected soft deleted devices and groups to be purged after the purge delay, got cutoff %v", p.purged[0])
+			}
+			if len(p.byAge) != 3 || !ago(p.byAge[intermediaries.AttributeAudits], time.Hour) || !ago(p.byAge[intermediaries.GroupCapabilityTriggerAudits], 2*time.Hour) || !ago(p.byAge[intermediaries.Tombstones], 24*time.Hour) {
+				t.Errorf("expected the tables with a max age to be pruned before it, got %v", p.byAge)
+			}
+			if len(p.byRows) != 2 || p.byRows[intermediaries.DeviceCapabilityTriggerAudits] != 10 || p.byRows[intermediaries.GroupCapabilityTriggerAudits] != 5 {
+				t.Errorf("expected the tables with max rows to be pruned down to them, got %v", p.byRows)
+			}
+			if slices.ContainsFunc(p.batchSizes, func(batchSize int) bool { return batchSize != 50 }) {
+				t.Errorf("expected every deletion to use the batch size, got %v", p.batchSizes)
+			}
+
+			// Every table with a policy records a run for the admin report, in the order of the tables
+			tables := []string{}
+			for _, run := range p.runs {
+				tables = append(tables, run.Table)
+			}
+			expectedTables := []string{"attribute-audits", "device-capability-trigger-audits", "group-capability-trigger-audits", "tombstones"}
+			if !slices.Equal(tables, expectedTables) {
+				t.Fatalf("expected runs of %v, got %v", expectedTables, tables)
+			}
+			attributeRun, deviceRun, groupRun := p.runs[0], p.runs[1], p.runs[2]
+			if deviceRun.Cutoff != nil || deviceRun.DeletedRows != 2 || deviceRun.ErrorMessage != nil {
+				t.Errorf("expected a row count run without cutoff, got %+v", deviceRun)
+			}
+			if groupRun.Cutoff == nil || groupRun.DeletedRows != 5 || groupRun.Finished.Before(groupRun.Started) {
+				t.Errorf("expected the rows deleted by age and row count to be added up, got %+v", groupRun)
+			}
+			if slices.Contains(tt.failing, intermediaries.AttributeAudits) {
+				if attributeRun.ErrorMessage == nil || attributeRun.DeletedRows != 1 {
+					t.Errorf("expected the failure to be recorded along with the rows deleted before it, got %+v", attributeRun)
+				}
+			} else if attributeRun.ErrorMessage != nil || attributeRun.DeletedRows != 3 || attributeRun.Cutoff == nil {
+				t.Errorf("expected an age run with its cutoff, got %+v", attributeRun)
+			}
+		})
+	}
+}
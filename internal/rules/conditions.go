@@ -0,0 +1,147 @@
+// Package rules fires automation rules when conditions over device attributes become true
+package rules
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/Kaese72/device-store/internal/persistence/intermediaries"
+	"github.com/Kaese72/device-store/restmodels"
+)
+
+// conditionHolds reports whether a condition holds for an attribute, which is nil if the device does not have it
+func conditionHolds(condition restmodels.RuleCondition, attribute *restmodels.Attribute) bool {
+	if attribute == nil {
+		return false
+	}
+	var comparison int
+	switch {
+	case condition.Boolean != nil:
+		if attribute.Boolean == nil {
+			return false
+		}
+		if *attribute.Boolean != *condition.Boolean {
+			comparison = 1
+		}
+	case condition.Numeric != nil:
+		if attribute.Numeric == nil {
+			return false
+		}
+		if *attribute.Numeric < *condition.Numeric {
+			comparison = -1
+		} else if *attribute.Numeric > *condition.Numeric {
+			comparison = 1
+		}
+	case condition.Text != nil:
+		if attribute.Text == nil {
+			return false
+		}
+		if *attribute.Text != *condition.Text {
+			comparison = 1
+		}
+	default:
+		return false
+	}
+	switch condition.Operator {
+	case "eq":
+		return comparison == 0
+	case "ne":
+		return comparison != 0
+	case "lt":
+		return comparison < 0
+	case "le":
+		return comparison <= 0
+	case "gt":
+		return comparison > 0
+	case "ge":
+		return comparison >= 0
+	}
+	return false
+}
+
+// Observe returns the attributes of the conditions of a rule, and whether all conditions hold
+func Observe(conditions []restmodels.RuleCondition, attributes map[int][]restmodels.Attribute) ([]restmodels.RuleObservation, bool) {
+	observed := []restmodels.RuleObservation{}
+	satisfied := true
+	for _, condition := range conditions {
+		var attribute *restmodels.Attribute
+		index := slices.IndexFunc(attributes[condition.DeviceID], func(attribute restmodels.Attribute) bool {
+			return attribute.Name == condition.Attribute
+		})
+		if index >= 0 {
+			attribute = &attributes[condition.DeviceID][index]
+			observed = append(observed, restmodels.RuleObservation{DeviceID: condition.DeviceID, Attribute: *attribute})
+		}
+		if !conditionHolds(condition, attribute) {
+			satisfied = false
+		}
+	}
+	return observed, satisfied
+}
+
+var weekdays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+func minuteOfDay(clock string) int {
+	var hour, minute int
+	fmt.Sscanf(clock, "%d:%d", &hour, &minute)
+	return hour*60 + minute
+}
+
+// InWindows reports whether t, in the location the windows are in, falls within any of the windows. Without
+// windows it is always within.
+func InWindows(windows []restmodels.RuleTimeWindow, t time.Time) bool {
+	if len(windows) == 0 {
+		return true
+	}
+	minute := t.Hour()*60 + t.Minute()
+	today := weekdays[t.Weekday()]
+	yesterday := weekdays[(t.Weekday()+6)%7]
+	onDay := func(window restmodels.RuleTimeWindow, day string) bool {
+		return len(window.Days) == 0 || slices.Contains(window.Days, day)
+	}
+	for _, window := range windows {
+		start, end := minuteOfDay(window.Start), minuteOfDay(window.End)
+		switch {
+		case start == end:
+			if onDay(window, today) {
+				return true
+			}
+		case start < end:
+			if onDay(window, today) && minute >= start && minute < end {
+				return true
+			}
+		default:
+			// Continues past midnight, belonging to the day it started
+			if (onDay(window, today) && minute >= start) || (onDay(window, yesterday) && minute < end) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Transition returns the state of a rule after observing whether its conditions hold at now, and whether the
+// rule fires. A rule fires once its conditions have held for its for-duration, if that happens within its windows
+// in location.
+func Transition(rule restmodels.Rule, satisfied bool, now time.Time, location *time.Location) (intermediaries.RuleState, bool) {
+	state := intermediaries.RuleState{SatisfiedSince: rule.SatisfiedSince, Fired: rule.Fired, LastFired: rule.LastFired}
+	if !satisfied {
+		state.SatisfiedSince = nil
+		state.Fired = false
+		return state, false
+	}
+	if state.SatisfiedSince == nil {
+		state.SatisfiedSince = &now
+		state.Fired = false
+	}
+	if state.Fired || now.Sub(*state.SatisfiedSince) < time.Duration(rule.ForSeconds)*time.Second {
+		return state, false
+	}
+	state.Fired = true
+	if !InWindows(rule.Windows, now.In(location)) {
+		return state, false
+	}
+	state.LastFired = &now
+	return state, true
+}
@@ -0,0 +1,128 @@
+package rules
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Kaese72/device-store/restmodels"
+)
+
+func TestObserve(t *testing.T) {
+	on, level, mode := true, float32(40), "heat"
+	attributes := map[int][]restmodels.Attribute{
+		1: {{Name: "on", Boolean: &on}, {Name: "level", Numeric: &level}},
+		2: {{Name: "mode", Text: &mode}},
+	}
+	boolean := func(value bool) *bool { return &value }
+	numeric := func(value float32) *float32 { return &value }
+	text := func(value string) *string { return &value }
+	tests := []struct {
+		name      string
+		condition restmodels.RuleCondition
+		expected  bool
+	}{
+		{"boolean equal", restmodels.RuleCondition{DeviceID: 1, Attribute: "on", Operator: "eq", Boolean: boolean(true)}, true},
+		{"boolean not equal", restmodels.RuleCondition{DeviceID: 1, Attribute: "on", Operator: "ne", Boolean: boolean(true)}, false},
+		{"numeric less", restmodels.RuleCondition{DeviceID: 1, Attribute: "level", Operator: "lt", Numeric: numeric(50)}, true},
+		{"numeric less or equal", restmodels.RuleCondition{DeviceID: 1, Attribute: "level", Operator: "le", Numeric: numeric(40)}, true},
+		{"numeric greater", restmodels.RuleCondition{DeviceID: 1, Attribute: "level", Operator: "gt", Numeric: numeric(40)}, false},
+		{"numeric greater or equal", restmodels.RuleCondition{DeviceID: 1, Attribute: "level", Operator: "ge", Numeric: numeric(40)}, true},
+		{"text equal", restmodels.RuleCondition{DeviceID: 2, Attribute: "mode", Operator: "eq", Text: text("heat")}, true},
+		{"state of another kind", restmodels.RuleCondition{DeviceID: 1, Attribute: "on", Operator: "eq", Numeric: numeric(1)}, false},
+		{"missing attribute", restmodels.RuleCondition{DeviceID: 2, Attribute: "on", Operator: "ne", Boolean: boolean(true)}, false},
+		{"missing device", restmodels.RuleCondition{DeviceID: 3, Attribute: "on", Operator: "ne", Boolean: boolean(true)}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, satisfied := Observe([]restmodels.RuleCondition{test.condition}, attributes)
+			if satisfied != test.expected {
+				t.Errorf("expected %t, got %t", test.expected, satisfied)
+			}
+		})
+	}
+
+	observed, satisfied := Observe([]restmodels.RuleCondition{
+		{DeviceID: 1, Attribute: "on", Operator: "eq", Boolean: boolean(true)},
+		{DeviceID: 2, Attribute: "mode", Operator: "eq", Text: text("cool")},
+	}, attributes)
+	if satisfied {
+		t.Error("expected all conditions to be required")
+	}
+	if len(observed) != 2 || observed[0].DeviceID != 1 || observed[1].Attribute.Name != "mode" {
+		t.Errorf("expected both attributes to be observed, got %+v", observed)
+	}
+}
+
+func TestInWindows(t *testing.T) {
+	// 2024-01-05 is a Friday
+	at := func(day, hour, minute int) time.Time { return time.Date(2024, 1, day, hour, minute, 0, 0, time.UTC) }
+	evening := restmodels.RuleTimeWindow{Start: "18:00", End: "22:30"}
+	night := restmodels.RuleTimeWindow{Start: "22:00", End: "06:00", Days: []string{"fri"}}
+	weekend := restmodels.RuleTimeWindow{Start: "00:00", End: "00:00", Days: []string{"sat", "sun"}}
+	tests := []struct {
+		name     string
+		windows  []restmodels.RuleTimeWindow
+		t        time.Time
+		expected bool
+	}{
+		{"no windows", nil, at(5, 12, 0), true},
+		{"within", []restmodels.RuleTimeWindow{evening}, at(5, 18, 0), true},
+		{"end excluded", []restmodels.RuleTimeWindow{evening}, at(5, 22, 30), false},
+		{"before", []restmodels.RuleTimeWindow{evening}, at(5, 17, 59), false},
+		{"past midnight on the day it started", []restmodels.RuleTimeWindow{night}, at(6, 3, 0), true},
+		{"before midnight on the day it started", []restmodels.RuleTimeWindow{night}, at(5, 23, 0), true},
+		{"past midnight on another day", []restmodels.RuleTimeWindow{night}, at(5, 3, 0), false},
+		{"whole day", []restmodels.RuleTimeWindow{weekend}, at(7, 15, 0), true},
+		{"whole day on another day", []restmodels.RuleTimeWindow{weekend}, at(8, 15, 0), false},
+		{"any window", []restmodels.RuleTimeWindow{evening, weekend}, at(6, 12, 0), true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if within := InWindows(test.windows, test.t); within != test.expected {
+				t.Errorf("expected %t, got %t", test.expected, within)
+			}
+		})
+	}
+}
+
+func TestTransition(t *testing.T) {
+	now := time.Date(2024, 1, 5, 12, 0, 0, 0, time.UTC)
+	earlier := now.Add(-time.Minute)
+	tests := []struct {
+		name           string
+		rule           restmodels.Rule
+		satisfied      bool
+		expectedSince  *time.Time
+		expectedFired  bool
+		expectedFiring bool
+	}{
+		{"not satisfied", restmodels.Rule{}, false, nil, false, false},
+		{"stops being satisfied", restmodels.Rule{SatisfiedSince: &earlier, Fired: true}, false, nil, false, false},
+		{"becomes satisfied", restmodels.Rule{}, true, &now, true, true},
+		{"stays satisfied after firing", restmodels.Rule{SatisfiedSince: &earlier, Fired: true}, true, &earlier, true, false},
+		{"waits for the duration", restmodels.Rule{RuleSpec: restmodels.RuleSpec{ForSeconds: 120}, SatisfiedSince: &earlier}, true, &earlier, false, false},
+		{"satisfied for the duration", restmodels.Rule{RuleSpec: restmodels.RuleSpec{ForSeconds: 60}, SatisfiedSince: &earlier}, true, &earlier, true, true},
+		{
+			"due outside of its windows",
+			restmodels.Rule{RuleSpec: restmodels.RuleSpec{Windows: []restmodels.RuleTimeWindow{{Start: "18:00", End: "22:00"}}}},
+			true, &now, true, false,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			state, fire := Transition(test.rule, test.satisfied, now, time.UTC)
+			if !sameTime(state.SatisfiedSince, test.expectedSince) {
+				t.Errorf("expected satisfied since %v, got %v", test.expectedSince, state.SatisfiedSince)
+			}
+			if state.Fired != test.expectedFired {
+				t.Errorf("expected fired %t, got %t", test.expectedFired, state.Fired)
+			}
+			if fire != test.expectedFiring {
+				t.Errorf("expected firing %t, got %t", test.expectedFiring, fire)
+			}
+			if fire && !sameTime(state.LastFired, &now) {
+				t.Errorf("expected last fired to be now, got %v", state.LastFired)
+			}
+		})
+	}
+}
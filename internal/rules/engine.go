@@ -0,0 +1,199 @@
+package rules
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/Kaese72/device-store/eventmodels"
+	"github.com/Kaese72/device-store/internal/config"
+	"github.com/Kaese72/device-store/internal/logging"
+	"github.com/Kaese72/device-store/internal/persistence"
+	"github.com/Kaese72/device-store/internal/persistence/intermediaries"
+	"github.com/Kaese72/device-store/restmodels"
+)
+
+// Invoker performs what rules fire
+type Invoker interface {
+	// InvokeCapability triggers a capability of a device or group, like an item of a batch
+	InvokeCapability(ctx context.Context, item restmodels.CapabilityInvocation) restmodels.CapabilityInvocationResult
+	// RunScene activates a scene and returns its activation audit
+	RunScene(ctx context.Context, sceneId int) (restmodels.SceneActivation, error)
+}
+
+// Engine evaluates rules as the attributes of their devices are updated, and periodically for rules waiting for
+// their conditions to have held long enough. Every replica of the store evaluates every update, and the state of a
+// rule is only changed by the replica that manages to do so first, which is the replica firing the rule.
+type Engine struct {
+	persistence persistence.RulesPersistenceDB
+	invoker     Invoker
+	location    *time.Location
+	conf        config.RulesConfig
+}
+
+func NewEngine(persistence persistence.RulesPersistenceDB, invoker Invoker, location *time.Location, conf config.RulesConfig) Engine {
+	return Engine{
+		persistence: persistence,
+		invoker:     invoker,
+		location:    location,
+		conf:        conf,
+	}
+}
+
+// pendingDevices are the devices whose attributes were updated since their rules were last evaluated
+type pendingDevices struct {
+	lock    sync.Mutex
+	devices map[int]struct{}
+	wake    chan struct{}
+}
+
+func newPendingDevices() *pendingDevices {
+	return &pendingDevices{devices: map[int]struct{}{}, wake: make(chan struct{}, 1)}
+}
+
+func (pending *pendingDevices) add(deviceId int) {
+	pending.lock.Lock()
+	pending.devices[deviceId] = struct{}{}
+	pending.lock.Unlock()
+	select {
+	case pending.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (pending *pendingDevices) take() []int {
+	pending.lock.Lock()
+	defer pending.lock.Unlock()
+	deviceIds := slices.Sorted(maps.Keys(pending.devices))
+	clear(pending.devices)
+	return deviceIds
+}
+
+// Run evaluates rules until ctx is cancelled or updates is closed. Updates are only noted on the receive loop so
+// that the database never holds up the other subscribers to them, and the rules of a device are evaluated once
+// however many of its updates arrived while the previous evaluation was running.
+func (engine Engine) Run(ctx context.Context, updates <-chan eventmodels.DeviceAttributeUpdate) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	pending := newPendingDevices()
+	go engine.work(ctx, pending)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case update, ok := <-updates:
+			if !ok {
+				return
+			}
+			pending.add(update.DeviceID)
+		}
+	}
+}
+
+func (engine Engine) work(ctx context.Context, pending *pendingDevices) {
+	ticker := time.NewTicker(engine.conf.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-pending.wake:
+			for _, deviceId := range pending.take() {
+				rules, err := engine.persistence.GetRulesForDevice(ctx, deviceId)
+				if err != nil {
+					logging.Error(fmt.Sprintf("failed to get rules of device %d: %s", deviceId, err.Error()), ctx)
+					continue
+				}
+				engine.evaluate(ctx, rules)
+			}
+		case <-ticker.C:
+			rules, err := engine.persistence.GetPendingRules(ctx)
+			if err != nil {
+				logging.Error(fmt.Sprintf("failed to get pending rules: %s", err.Error()), ctx)
+				continue
+			}
+			engine.evaluate(ctx, rules)
+		}
+	}
+}
+
+func sameTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+func (engine Engine) evaluate(ctx context.Context, rules []restmodels.Rule) {
+	// Timestamps are stored with second precision
+	now := time.Now().UTC().Truncate(time.Second)
+	for _, rule := range rules {
+		deviceIds := []int{}
+		for _, condition := range rule.Conditions {
+			if !slices.Contains(deviceIds, condition.DeviceID) {
+				deviceIds = append(deviceIds, condition.DeviceID)
+			}
+		}
+		attributes, err := engine.persistence.GetDeviceAttributes(ctx, deviceIds)
+		if err != nil {
+			logging.Error(fmt.Sprintf("failed to get attributes for rule %d: %s", rule.ID, err.Error()), ctx)
+			continue
+		}
+		observed, satisfied := Observe(rule.Conditions, attributes)
+		state, fire := Transition(rule, satisfied, now, engine.location)
+		if sameTime(state.SatisfiedSince, rule.SatisfiedSince) && state.Fired == rule.Fired {
+			continue
+		}
+		changed, err := engine.persistence.UpdateRuleState(ctx, rule.ID, rule.Version, state)
+		if err != nil {
+			logging.Error(fmt.Sprintf("failed to update state of rule %d: %s", rule.ID, err.Error()), ctx)
+			continue
+		}
+		if !changed || !fire {
+			// Changed by another replica, or by the rule being updated, since it was read
+			continue
+		}
+		go engine.fire(ctx, rule, observed)
+	}
+}
+
+func (engine Engine) fire(ctx context.Context, rule restmodels.Rule, observed []restmodels.RuleObservation) {
+	logging.Info(fmt.Sprintf("Firing rule '%d'", rule.ID), ctx)
+	firing := restmodels.RuleFiring{RuleID: rule.ID, Status: restmodels.RuleFiringSucceeded, Observed: observed}
+	fail := func(message string) {
+		firing.Status = restmodels.RuleFiringFailed
+		firing.ErrorMessage = &message
+	}
+	if rule.SceneID != nil {
+		activation, err := engine.invoker.RunScene(ctx, *rule.SceneID)
+		if err != nil {
+			fail(err.Error())
+		} else {
+			firing.SceneActivationID = &activation.ID
+			if activation.Failed > 0 {
+				fail(fmt.Sprintf("%d of %d scene items failed", activation.Failed, activation.Failed+activation.Succeeded))
+			}
+		}
+	} else {
+		result := engine.invoker.InvokeCapability(ctx, *rule.Invocation)
+		firing.Result = &result
+		if result.Error != nil {
+			fail(result.Error.Detail)
+		}
+	}
+	if err := engine.persistence.WriteRuleFiring(ctx, firing); err != nil {
+		logging.Error(fmt.Sprintf("failed to audit firing of rule %d: %s", rule.ID, err.Error()), ctx)
+	}
+}
+
+// InitialState returns the state of a rule as it is saved. A rule whose conditions already hold when saved does
+// not fire until they have stopped holding and become true again.
+func InitialState(satisfied bool, now time.Time) intermediaries.RuleState {
+	if !satisfied {
+		return intermediaries.RuleState{}
+	}
+	return intermediaries.RuleState{SatisfiedSince: &now, Fired: true}
+}
@@ -0,0 +1,208 @@
+package rules
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Kaese72/device-store/eventmodels"
+	"github.com/Kaese72/device-store/internal/config"
+	"github.com/Kaese72/device-store/internal/persistence/intermediaries"
+	"github.com/Kaese72/device-store/restmodels"
+)
+
+type fakePersistence struct {
+	lock       sync.Mutex
+	rules      map[int][]restmodels.Rule
+	attributes map[int][]restmodels.Attribute
+	versions   map[int]int
+	states     map[int]intermediaries.RuleState
+	firings    chan restmodels.RuleFiring
+	// gets receives the device of every GetRulesForDevice, which then waits for release if set
+	gets    chan int
+	release chan struct{}
+}
+
+func (p *fakePersistence) GetRulesForDevice(ctx context.Context, deviceId int) ([]restmodels.Rule, error) {
+	if p.gets != nil {
+		select {
+		case p.gets <- deviceId:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	if p.release != nil {
+		select {
+		case <-p.release:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return p.rules[deviceId], nil
+}
+
+func (p *fakePersistence) GetPendingRules(ctx context.Context) ([]restmodels.Rule, error) {
+	return nil, nil
+}
+
+func (p *fakePersistence) GetDeviceAttributes(ctx context.Context, deviceIds []int) (map[int][]restmodels.Attribute, error) {
+	return p.attributes, nil
+}
+
+func (p *fakePersistence) UpdateRuleState(ctx context.Context, ruleId int, version int, state intermediaries.RuleState) (bool, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.versions[ruleId] != version {
+		return false, nil
+	}
+	p.versions[ruleId]++
+	p.states[ruleId] = state
+	return true, nil
+}
+
+func (p *fakePersistence) WriteRuleFiring(ctx context.Context, firing restmodels.RuleFiring) error {
+	p.firings <- firing
+	return nil
+}
+
+type fakeInvoker struct {
+	activation restmodels.SceneActivation
+}
+
+func (invoker fakeInvoker) InvokeCapability(ctx context.Context, item restmodels.CapabilityInvocation) restmodels.CapabilityInvocationResult {
+	return restmodels.CapabilityInvocationResult{}
+}
+
+func (invoker fakeInvoker) RunScene(ctx context.Context, sceneId int) (restmodels.SceneActivation, error) {
+	return invoker.activation, nil
+}
+
+func TestEvaluate(t *testing.T) {
+	on, off := true, false
+	sceneId := 3
+	earlier := time.Now().UTC().Add(-time.Minute)
+	rule := restmodels.Rule{
+		ID: 1,
+		RuleSpec: restmodels.RuleSpec{
+			Conditions: []restmodels.RuleCondition{{DeviceID: 7, Attribute: "active", Operator: "eq", Boolean: &on}},
+			SceneID:    &sceneId,
+		},
+		Version: 4,
+	}
+	fired := rule
+	fired.SatisfiedSince = &earlier
+	fired.Fired = true
+	tests := []struct {
+		name           string
+		rule           restmodels.Rule
+		active         bool
+		version        int
+		activation     restmodels.SceneActivation
+		expectedChange bool
+		expectedStatus string
+	}{
+		{"fires when the conditions become true", rule, true, 4, restmodels.SceneActivation{ID: 9, Succeeded: 2}, true, restmodels.RuleFiringSucceeded},
+		{"audits failing scene items", rule, true, 4, restmodels.SceneActivation{ID: 9, Succeeded: 1, Failed: 1}, true, restmodels.RuleFiringFailed},
+		{"leaves unchanged rules alone", rule, false, 4, restmodels.SceneActivation{}, false, ""},
+		{"does not fire again", fired, true, 4, restmodels.SceneActivation{}, false, ""},
+		{"leaves rules changed by another replica", rule, true, 5, restmodels.SceneActivation{}, false, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			active := &off
+			if test.active {
+				active = &on
+			}
+			persistence := &fakePersistence{
+				attributes: map[int][]restmodels.Attribute{7: {{Name: "active", Boolean: active}}},
+				versions:   map[int]int{1: test.version},
+				states:     map[int]intermediaries.RuleState{},
+				firings:    make(chan restmodels.RuleFiring, 1),
+			}
+			engine := NewEngine(persistence, fakeInvoker{activation: test.activation}, time.UTC, config.RulesConfig{Interval: time.Hour})
+			engine.evaluate(context.Background(), []restmodels.Rule{test.rule})
+			persistence.lock.Lock()
+			_, changed := persistence.states[1]
+			persistence.lock.Unlock()
+			if changed != test.expectedChange {
+				t.Errorf("expected state changed %t, got %t", test.expectedChange, changed)
+			}
+			if test.expectedStatus == "" {
+				select {
+				case firing := <-persistence.firings:
+					t.Fatalf("expected no firing, got %+v", firing)
+				case <-time.After(50 * time.Millisecond):
+				}
+				return
+			}
+			select {
+			case firing := <-persistence.firings:
+				if firing.Status != test.expectedStatus {
+					t.Errorf("expected status %s, got %s", test.expectedStatus, firing.Status)
+				}
+				if firing.SceneActivationID == nil || *firing.SceneActivationID != test.activation.ID {
+					t.Errorf("expected scene activation %d, got %v", test.activation.ID, firing.SceneActivationID)
+				}
+				if len(firing.Observed) != 1 {
+					t.Errorf("expected 1 observed attribute, got %d", len(firing.Observed))
+				}
+			case <-time.After(time.Second):
+				t.Fatal("expected the rule to fire")
+			}
+		})
+	}
+}
+
+func TestPendingDevices(t *testing.T) {
+	pending := newPendingDevices()
+	for _, deviceId := range []int{2, 1, 2, 1, 1} {
+		pending.add(deviceId)
+	}
+	select {
+	case <-pending.wake:
+	default:
+		t.Fatal("expected to be woken")
+	}
+	if deviceIds := pending.take(); !slices.Equal(deviceIds, []int{1, 2}) {
+		t.Errorf("expected devices [1 2], got %v", deviceIds)
+	}
+	if deviceIds := pending.take(); len(deviceIds) != 0 {
+		t.Errorf("expected no devices, got %v", deviceIds)
+	}
+}
+
+func TestRunReceivesWhileEvaluating(t *testing.T) {
+	persistence := &fakePersistence{
+		gets:    make(chan int),
+		release: make(chan struct{}),
+	}
+	engine := NewEngine(persistence, fakeInvoker{}, time.UTC, config.RulesConfig{Interval: time.Hour})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	updates := make(chan eventmodels.DeviceAttributeUpdate)
+	go engine.Run(ctx, updates)
+
+	updates <- eventmodels.DeviceAttributeUpdate{DeviceID: 1}
+	<-persistence.gets
+	// Evaluating device 1 is held up, which must not hold up receiving updates
+	for _, deviceId := range []int{1, 2, 1, 2, 1} {
+		select {
+		case updates <- eventmodels.DeviceAttributeUpdate{DeviceID: deviceId}:
+		case <-time.After(time.Second):
+			t.Fatal("updates are not received while evaluating")
+		}
+	}
+	persistence.release <- struct{}{}
+	read := map[int]bool{}
+	for !read[1] || !read[2] {
+		select {
+		case deviceId := <-persistence.gets:
+			read[deviceId] = true
+			persistence.release <- struct{}{}
+		case <-time.After(time.Second):
+			t.Fatalf("expected rules of devices 1 and 2 to be read, got %v", read)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+// Package schedule computes when schedules run and runs them when due
+package schedule
+
+import (
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cron is a parsed five field cron expression: minute, hour, day of month, month and day of week
+type Cron struct {
+	minutes, hours, daysOfMonth, months, daysOfWeek uint64
+	// When both days of month and days of week are restricted a day matching either is run, like cron does
+	daysOfMonthAny, daysOfWeekAny bool
+}
+
+type cronField struct {
+	name     string
+	min, max int
+	names    map[string]int
+}
+
+var (
+	minuteField     = cronField{name: "minute", min: 0, max: 59}
+	hourField       = cronField{name: "hour", min: 0, max: 23}
+	dayOfMonthField = cronField{name: "day of month", min: 1, max: 31}
+	monthField      = cronField{name: "month", min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6, "jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	// Sunday is both 0 and 7
+	dayOfWeekField = cronField{name: "day of week", min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// ParseCron parses a five field cron expression. Fields are lists of values, ranges and steps, like "*/15",
+// "1-5" or "mon,wed,fri". Months and days of week may be named, and @yearly, @monthly, @weekly, @daily and
+// @hourly may be used in place of the fields.
+func ParseCron(expression string) (Cron, error) {
+	if macro, ok := cronMacros[strings.ToLower(strings.TrimSpace(expression))]; ok {
+		expression = macro
+	}
+	fields := strings.Fields(expression)
+	if len(fields) != 5 {
+		return Cron{}, fmt.Errorf("expected 5 fields in cron expression, got %d", len(fields))
+	}
+	cron := Cron{}
+	var err error
+	if cron.minutes, err = minuteField.parse(fields[0]); err != nil {
+		return Cron{}, err
+	}
+	if cron.hours, err = hourField.parse(fields[1]); err != nil {
+		return Cron{}, err
+	}
+	if cron.daysOfMonth, err = dayOfMonthField.parse(fields[2]); err != nil {
+		return Cron{}, err
+	}
+	if cron.months, err = monthField.parse(fields[3]); err != nil {
+		return Cron{}, err
+	}
+	if cron.daysOfWeek, err = dayOfWeekField.parse(fields[4]); err != nil {
+		return Cron{}, err
+	}
+	if cron.daysOfWeek&(1<<7) != 0 {
+		cron.daysOfWeek |= 1
+	}
+	cron.daysOfMonthAny = strings.HasPrefix(fields[2], "*")
+	cron.daysOfWeekAny = strings.HasPrefix(fields[4], "*")
+	return cron, nil
+}
+
+// parse returns the values of a field as a bit set
+func (field cronField) parse(expression string) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(expression, ",") {
+		rangeExpression, stepExpression, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepExpression)
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step '%s' in %s field", stepExpression, field.name)
+			}
+		}
+		first, last := field.min, field.max
+		if rangeExpression != "*" {
+			lowExpression, highExpression, isRange := strings.Cut(rangeExpression, "-")
+			var err error
+			if first, err = field.value(lowExpression); err != nil {
+				return 0, err
+			}
+			last = first
+			if isRange {
+				if last, err = field.value(highExpression); err != nil {
+					return 0, err
+				}
+				if last < first {
+					return 0, fmt.Errorf("invalid range '%s' in %s field", rangeExpression, field.name)
+				}
+			} else if hasStep {
+				// "5/15" steps from 5 to the end of the field
+				last = field.max
+			}
+		}
+		for value := first; value <= last; value += step {
+			set |= 1 << value
+		}
+	}
+	return set, nil
+}
+
+func (field cronField) value(expression string) (int, error) {
+	if value, ok := field.names[strings.ToLower(expression)]; ok {
+		return value, nil
+	}
+	value, err := strconv.Atoi(expression)
+	if err != nil || value < field.min || value > field.max {
+		return 0, fmt.Errorf("invalid value '%s' in %s field, must be within %d and %d", expression, field.name, field.min, field.max)
+	}
+	return value, nil
+}
+
+func (cron Cron) matchesDay(t time.Time) bool {
+	dayOfMonth := cron.daysOfMonth&(1<<t.Day()) != 0
+	dayOfWeek := cron.daysOfWeek&(1<<int(t.Weekday())) != 0
+	if cron.daysOfMonthAny || cron.daysOfWeekAny {
+		return dayOfMonth && dayOfWeek
+	}
+	return dayOfMonth || dayOfWeek
+}
+
+// Next returns the first time after after matching the expression, in the location of after. Wall clock times
+// skipped by a daylight saving time change are not run. It reports false if nothing matches within five years,
+// which happens for days that do not exist like the 30th of February.
+func (cron Cron) Next(after time.Time) (time.Time, bool) {
+	location := after.Location()
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if cron.months&(1<<int(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, location)
+			continue
+		}
+		if !cron.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, location)
+			continue
+		}
+		if cron.hours&(1<<t.Hour()) == 0 {
+			next := time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, location)
+			if !next.After(t) {
+				// Repeated wall clock hour when daylight saving time ends
+				next = t.Truncate(time.Hour).Add(time.Hour)
+			}
+			t = next
+			continue
+		}
+		if cron.minutes&(1<<t.Minute()) == 0 {
+			// Jump straight to the next matching minute of this hour, if any
+			remaining := cron.minutes >> (t.Minute() + 1)
+			if remaining == 0 {
+				t = t.Add(time.Duration(60-t.Minute()) * time.Minute)
+			} else {
+				t = t.Add(time.Duration(bits.TrailingZeros64(remaining)+1) * time.Minute)
+			}
+			continue
+		}
+		return t, true
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,79 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	stockholm, err := time.LoadLocation("Europe/Stockholm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		expression string
+		after      time.Time
+		expected   time.Time
+	}{
+		{"every minute", "* * * * *", time.Date(2024, 1, 1, 10, 0, 30, 0, time.UTC), time.Date(2024, 1, 1, 10, 1, 0, 0, time.UTC)},
+		{"every quarter", "*/15 * * * *", time.Date(2024, 1, 1, 10, 15, 0, 0, time.UTC), time.Date(2024, 1, 1, 10, 30, 0, 0, time.UTC)},
+		{"next hour", "5 * * * *", time.Date(2024, 1, 1, 10, 30, 0, 0, time.UTC), time.Date(2024, 1, 1, 11, 5, 0, 0, time.UTC)},
+		{"step from start", "10/20 * * * *", time.Date(2024, 1, 1, 10, 31, 0, 0, time.UTC), time.Date(2024, 1, 1, 10, 50, 0, 0, time.UTC)},
+		{"next day", "30 6 * * *", time.Date(2024, 1, 1, 7, 0, 0, 0, time.UTC), time.Date(2024, 1, 2, 6, 30, 0, 0, time.UTC)},
+		{"weekdays", "0 7 * * mon-fri", time.Date(2024, 1, 5, 8, 0, 0, 0, time.UTC), time.Date(2024, 1, 8, 7, 0, 0, 0, time.UTC)},
+		{"sunday as seven", "0 9 * * 7", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 1, 7, 9, 0, 0, 0, time.UTC)},
+		{"named months", "0 0 1 jun,dec *", time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 12, 1, 0, 0, 0, 0, time.UTC)},
+		{"day of month or day of week", "0 0 15 * mon", time.Date(2024, 1, 9, 0, 0, 0, 0, time.UTC), time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)},
+		{"leap day", "0 0 29 2 *", time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"macro", "@daily", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{"local time", "0 7 * * *", time.Date(2024, 6, 1, 8, 0, 0, 0, stockholm), time.Date(2024, 6, 2, 7, 0, 0, 0, stockholm)},
+		{"skipped by daylight saving time", "30 2 * * *", time.Date(2024, 3, 31, 0, 0, 0, 0, stockholm), time.Date(2024, 4, 1, 2, 30, 0, 0, stockholm)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cron, err := ParseCron(test.expression)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			next, ok := cron.Next(test.after)
+			if !ok {
+				t.Fatal("expected a next run")
+			}
+			if !next.Equal(test.expected) {
+				t.Errorf("expected %s, got %s", test.expected, next)
+			}
+		})
+	}
+}
+
+func TestCronNeverMatching(t *testing.T) {
+	cron, err := ParseCron("0 0 30 2 *")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if next, ok := cron.Next(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)); ok {
+		t.Errorf("expected no next run, got %s", next)
+	}
+}
+
+func TestParseCronInvalid(t *testing.T) {
+	for _, expression := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"* * * foo *",
+		"a * * * *",
+	} {
+		if _, err := ParseCron(expression); err == nil {
+			t.Errorf("expected '%s' to be invalid", expression)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package schedule
+
+import (
+	"errors"
+	"time"
+
+	"github.com/Kaese72/device-store/internal/config"
+	"github.com/Kaese72/device-store/restmodels"
+	"github.com/danielgtaylor/huma/v2"
+)
+
+// Planner computes when schedules run, in the configured time zone and at the configured coordinates
+type Planner struct {
+	location *time.Location
+	// coordinates is nil when not configured, in which case sunrise and sunset schedules are refused
+	coordinates *[2]float64
+}
+
+// NewPlanner returns a planner for a validated scheduler configuration
+func NewPlanner(conf config.SchedulerConfig) Planner {
+	location, err := time.LoadLocation(conf.Timezone)
+	if err != nil {
+		location = time.UTC
+	}
+	planner := Planner{location: location}
+	if conf.Latitude != nil && conf.Longitude != nil {
+		planner.coordinates = &[2]float64{*conf.Latitude, *conf.Longitude}
+	}
+	return planner
+}
+
+// Location is the time zone schedules are planned in
+func (planner Planner) Location() *time.Location {
+	return planner.location
+}
+
+// Validate checks that the planner can compute when a schedule runs, failing with 422 otherwise
+func (planner Planner) Validate(spec restmodels.ScheduleSpec, now time.Time) error {
+	switch {
+	case spec.Cron != nil:
+		cron, err := ParseCron(*spec.Cron)
+		if err != nil {
+			return huma.Error422UnprocessableEntity("invalid cron expression", &huma.ErrorDetail{Message: err.Error(), Location: "body.cron", Value: *spec.Cron})
+		}
+		if _, ok := cron.Next(now.In(planner.location)); !ok {
+			return huma.Error422UnprocessableEntity("invalid cron expression", &huma.ErrorDetail{Message: "the expression never matches", Location: "body.cron", Value: *spec.Cron})
+		}
+	case spec.At != nil:
+		if planner.Next(spec, now) == nil {
+			return huma.Error422UnprocessableEntity("invalid schedule", &huma.ErrorDetail{Message: "must be in the future", Location: "body.at", Value: *spec.At})
+		}
+	case spec.Sun != nil:
+		if planner.coordinates == nil {
+			return huma.Error422UnprocessableEntity("invalid schedule", &huma.ErrorDetail{Message: "sunrise and sunset schedules require the store to be configured with a latitude and longitude", Location: "body.sun", Value: *spec.Sun})
+		}
+	default:
+		return errors.New("schedule without cron, at or sun")
+	}
+	return nil
+}
+
+// Next returns when a schedule runs next after after, or nil if it will not run again
+func (planner Planner) Next(spec restmodels.ScheduleSpec, after time.Time) *time.Time {
+	after = after.In(planner.location)
+	var next time.Time
+	switch {
+	case spec.Cron != nil:
+		cron, err := ParseCron(*spec.Cron)
+		if err != nil {
+			return nil
+		}
+		var ok bool
+		if next, ok = cron.Next(after); !ok {
+			return nil
+		}
+	case spec.At != nil:
+		next = spec.At.Truncate(time.Second)
+		if !next.After(after) {
+			return nil
+		}
+	case spec.Sun != nil:
+		if planner.coordinates == nil {
+			return nil
+		}
+		found := false
+		offset := time.Duration(spec.Sun.OffsetMinutes) * time.Minute
+		// Start the day before, as a negative offset may move an event of the next day to before midnight.
+		// Close to the poles the sun may neither rise nor set for months.
+		for day := -1; day <= 366 && !found; day++ {
+			date := time.Date(after.Year(), after.Month(), after.Day()+day, 12, 0, 0, 0, planner.location)
+			event, ok := SunEvent(spec.Sun.Event, date, planner.coordinates[0], planner.coordinates[1])
+			if ok && event.Add(offset).After(after) {
+				next, found = event.Add(offset), true
+			}
+		}
+		if !found {
+			return nil
+		}
+	default:
+		return nil
+	}
+	// Runs are claimed by comparing the next run, which is stored with second precision
+	next = next.UTC().Truncate(time.Second)
+	return &next
+}
+
+// NextRuns returns up to count upcoming runs of a schedule after after
+func (planner Planner) NextRuns(spec restmodels.ScheduleSpec, after time.Time, count int) []time.Time {
+	runs := []time.Time{}
+	for len(runs) < count {
+		next := planner.Next(spec, after)
+		if next == nil {
+			break
+		}
+		runs = append(runs, *next)
+		after = *next
+	}
+	return runs
+}
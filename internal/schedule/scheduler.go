@@ -0,0 +1,118 @@
+package schedule
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Kaese72/device-store/internal/config"
+	"github.com/Kaese72/device-store/internal/logging"
+	"github.com/Kaese72/device-store/internal/persistence"
+	"github.com/Kaese72/device-store/restmodels"
+)
+
+// Invoker performs what schedules run
+type Invoker interface {
+	// InvokeCapability triggers a capability of a device or group, like an item of a batch
+	InvokeCapability(ctx context.Context, item restmodels.CapabilityInvocation) restmodels.CapabilityInvocationResult
+	// RunScene activates a scene and returns its activation audit
+	RunScene(ctx context.Context, sceneId int) (restmodels.SceneActivation, error)
+}
+
+// Scheduler periodically runs the schedules that are due. Every replica of the store runs a scheduler, and a run
+// is only performed by the replica that manages to move the next run of the schedule forward first.
+type Scheduler struct {
+	persistence persistence.SchedulerPersistenceDB
+	invoker     Invoker
+	planner     Planner
+	conf        config.SchedulerConfig
+}
+
+func NewScheduler(persistence persistence.SchedulerPersistenceDB, invoker Invoker, planner Planner, conf config.SchedulerConfig) Scheduler {
+	return Scheduler{
+		persistence: persistence,
+		invoker:     invoker,
+		planner:     planner,
+		conf:        conf,
+	}
+}
+
+// Run runs due schedules once per configured interval until ctx is cancelled
+func (scheduler Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(scheduler.conf.Interval)
+	defer ticker.Stop()
+	for {
+		scheduler.RunOnce(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce claims and runs every schedule that is due, in parallel, and records the outcome of each run.
+// Runs that are due longer ago than the misfire grace are recorded as missed instead of being run late.
+// A schedule that was due several times since it last ran is only run once.
+func (scheduler Scheduler) RunOnce(ctx context.Context) {
+	now := time.Now().UTC()
+	due, err := scheduler.persistence.GetDueSchedules(ctx, now)
+	if err != nil {
+		logging.Error(fmt.Sprintf("failed to get due schedules: %s", err.Error()), ctx)
+		return
+	}
+	wg := sync.WaitGroup{}
+	for _, schedule := range due {
+		nextRun := scheduler.planner.Next(schedule.ScheduleSpec, now)
+		claimed, err := scheduler.persistence.ClaimScheduleRun(ctx, schedule.ID, *schedule.NextRun, nextRun)
+		if err != nil {
+			logging.Error(fmt.Sprintf("failed to claim run of schedule %d: %s", schedule.ID, err.Error()), ctx)
+			continue
+		}
+		if !claimed {
+			// Run by another replica
+			continue
+		}
+		wg.Go(func() {
+			run := scheduler.run(ctx, schedule, now)
+			if err := scheduler.persistence.WriteScheduleRun(ctx, run); err != nil {
+				logging.Error(fmt.Sprintf("failed to record run of schedule %d: %s", schedule.ID, err.Error()), ctx)
+			}
+		})
+	}
+	wg.Wait()
+}
+
+func (scheduler Scheduler) run(ctx context.Context, schedule restmodels.Schedule, now time.Time) restmodels.ScheduleRun {
+	run := restmodels.ScheduleRun{ScheduleID: schedule.ID, ScheduledFor: *schedule.NextRun, Status: restmodels.ScheduleRunSucceeded}
+	fail := func(message string) restmodels.ScheduleRun {
+		run.Status = restmodels.ScheduleRunFailed
+		run.ErrorMessage = &message
+		return run
+	}
+	if late := now.Sub(*schedule.NextRun); late > scheduler.conf.MisfireGrace {
+		message := fmt.Sprintf("not run as it was due %s ago", late.Truncate(time.Second))
+		run.Status = restmodels.ScheduleRunMissed
+		run.ErrorMessage = &message
+		return run
+	}
+	logging.Info(fmt.Sprintf("Running schedule '%d' due at %s", schedule.ID, schedule.NextRun.Format(time.RFC3339)), ctx)
+	if schedule.SceneID != nil {
+		activation, err := scheduler.invoker.RunScene(ctx, *schedule.SceneID)
+		if err != nil {
+			return fail(err.Error())
+		}
+		run.SceneActivationID = &activation.ID
+		if activation.Failed > 0 {
+			return fail(fmt.Sprintf("%d of %d scene items failed", activation.Failed, activation.Failed+activation.Succeeded))
+		}
+		return run
+	}
+	result := scheduler.invoker.InvokeCapability(ctx, *schedule.Invocation)
+	run.Result = &result
+	if result.Error != nil {
+		return fail(result.Error.Detail)
+	}
+	return run
+}
@@ -0,0 +1,61 @@
+package schedule
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	Sunrise = "sunrise"
+	Sunset  = "sunset"
+)
+
+// zenith of the sun at sunrise and sunset, accounting for refraction and the radius of the sun
+const sunZenith = 90.833
+
+func degreesSin(degrees float64) float64 { return math.Sin(degrees * math.Pi / 180) }
+func degreesCos(degrees float64) float64 { return math.Cos(degrees * math.Pi / 180) }
+
+func normalize(value, max float64) float64 {
+	value = math.Mod(value, max)
+	if value < 0 {
+		value += max
+	}
+	return value
+}
+
+// SunEvent returns when the sun rises or sets on a date at the given coordinates, using the algorithm of the
+// Almanac for Computers, which is accurate to a couple of minutes. Only the year, month and day of date are used.
+// It reports false if the sun does not rise or set that day, close to the poles.
+func SunEvent(event string, date time.Time, latitude, longitude float64) (time.Time, bool) {
+	midnight := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)
+	dayOfYear := float64(midnight.YearDay())
+	longitudeHours := longitude / 15
+	approximate := 18.0
+	if event == Sunrise {
+		approximate = 6
+	}
+	t := dayOfYear + (approximate-longitudeHours)/24
+
+	meanAnomaly := 0.9856*t - 3.289
+	trueLongitude := normalize(meanAnomaly+1.916*degreesSin(meanAnomaly)+0.020*degreesSin(2*meanAnomaly)+282.634, 360)
+	rightAscension := normalize(math.Atan(0.91764*math.Tan(trueLongitude*math.Pi/180))*180/math.Pi, 360)
+	// Right ascension is in the same quadrant as the true longitude
+	rightAscension += math.Floor(trueLongitude/90)*90 - math.Floor(rightAscension/90)*90
+	rightAscension /= 15
+
+	sinDeclination := 0.39782 * degreesSin(trueLongitude)
+	cosDeclination := math.Cos(math.Asin(sinDeclination))
+	cosHourAngle := (degreesCos(sunZenith) - sinDeclination*degreesSin(latitude)) / (cosDeclination * degreesCos(latitude))
+	if cosHourAngle > 1 || cosHourAngle < -1 {
+		return time.Time{}, false
+	}
+	hourAngle := math.Acos(cosHourAngle) * 180 / math.Pi
+	if event == Sunrise {
+		hourAngle = 360 - hourAngle
+	}
+	localMeanTime := normalize(hourAngle/15+rightAscension-0.06571*t-6.622, 24)
+	// Not wrapped around midnight, so that the event stays on the same solar day far from Greenwich
+	universalTime := localMeanTime - longitudeHours
+	return midnight.Add(time.Duration(universalTime * float64(time.Hour))).Truncate(time.Second), true
+}
@@ -0,0 +1,43 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSunEvent(t *testing.T) {
+	tests := []struct {
+		name                string
+		event               string
+		date                time.Time
+		latitude, longitude float64
+		expected            time.Time
+	}{
+		{"london midsummer sunrise", Sunrise, time.Date(2024, 6, 21, 0, 0, 0, 0, time.UTC), 51.5074, -0.1278, time.Date(2024, 6, 21, 3, 43, 0, 0, time.UTC)},
+		{"london midsummer sunset", Sunset, time.Date(2024, 6, 21, 0, 0, 0, 0, time.UTC), 51.5074, -0.1278, time.Date(2024, 6, 21, 20, 21, 0, 0, time.UTC)},
+		{"new york winter sunrise", Sunrise, time.Date(2024, 12, 21, 0, 0, 0, 0, time.UTC), 40.7128, -74.0060, time.Date(2024, 12, 21, 12, 16, 0, 0, time.UTC)},
+		{"new york winter sunset", Sunset, time.Date(2024, 12, 21, 0, 0, 0, 0, time.UTC), 40.7128, -74.0060, time.Date(2024, 12, 21, 21, 32, 0, 0, time.UTC)},
+		{"sydney sunrise", Sunrise, time.Date(2024, 3, 20, 0, 0, 0, 0, time.UTC), -33.8688, 151.2093, time.Date(2024, 3, 19, 19, 59, 0, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			event, ok := SunEvent(test.event, test.date, test.latitude, test.longitude)
+			if !ok {
+				t.Fatal("expected the sun to rise and set")
+			}
+			if difference := event.Sub(test.expected).Abs(); difference > 3*time.Minute {
+				t.Errorf("expected about %s, got %s", test.expected, event)
+			}
+		})
+	}
+}
+
+func TestSunEventPolar(t *testing.T) {
+	midsummer := time.Date(2024, 6, 21, 0, 0, 0, 0, time.UTC)
+	if event, ok := SunEvent(Sunset, midsummer, 69.6492, 18.9553); ok {
+		t.Errorf("expected the midnight sun not to set in Tromsø, got %s", event)
+	}
+	if event, ok := SunEvent(Sunrise, midsummer, -77.8419, 166.6863); ok {
+		t.Errorf("expected the sun not to rise in the antarctic winter, got %s", event)
+	}
+}
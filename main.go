@@ -8,12 +8,22 @@ import (
 
 	"github.com/Kaese72/device-store/eventmodels"
 	"github.com/Kaese72/device-store/internal/adapterattendant"
+	"github.com/Kaese72/device-store/internal/adapters"
+	"github.com/Kaese72/device-store/internal/archive"
+	"github.com/Kaese72/device-store/internal/commands"
 	"github.com/Kaese72/device-store/internal/config"
 	"github.com/Kaese72/device-store/internal/events"
 	"github.com/Kaese72/device-store/internal/ingestwebapp"
+	"github.com/Kaese72/device-store/internal/jobs"
 	"github.com/Kaese72/device-store/internal/logging"
+	"github.com/Kaese72/device-store/internal/outcomes"
+	"github.com/Kaese72/device-store/internal/persistence/intermediaries"
 	"github.com/Kaese72/device-store/internal/persistence/mariadb"
 	"github.com/Kaese72/device-store/internal/restwebapp"
+	"github.com/Kaese72/device-store/internal/retention"
+	"github.com/Kaese72/device-store/internal/rules"
+	"github.com/Kaese72/device-store/internal/schedule"
+	"github.com/Kaese72/device-store/restmodels"
 	"github.com/Kaese72/huemie-lib/middleware"
 	"github.com/danielgtaylor/huma/v2"
 	"github.com/danielgtaylor/huma/v2/adapters/humamux"
@@ -24,6 +34,9 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
+	}
 	if err := config.Loaded.Validate(); err != nil {
 		logging.Error(err.Error(), context.TODO())
 		os.Exit(1)
@@ -45,6 +58,11 @@ func main() {
 		logging.Error(err.Error(), context.Background())
 		os.Exit(1)
 	}
+	storeEvents, err := eventsHandler.StoreEvents(context.Background())
+	if err != nil {
+		logging.Error(err.Error(), context.Background())
+		os.Exit(1)
+	}
 
 	eventsProducer, err := events.NewEventsProducer(config.Loaded.Event)
 	if err != nil {
@@ -57,10 +75,32 @@ func main() {
 		logging.Error(err.Error(), context.Background())
 		os.Exit(1)
 	}
+	storeEventChan, err := eventsProducer.ProduceStoreEvents()
+	if err != nil {
+		logging.Error(err.Error(), context.Background())
+		os.Exit(1)
+	}
+
+	if config.Loaded.Retention.Enabled {
+		go retention.NewPruner(dbPersistence, config.Loaded.Retention, config.Loaded.SoftDelete).Run(context.Background())
+	}
+
+	triggerJobs := jobs.NewPool(context.Background(), dbPersistence, config.Loaded.Jobs)
 
 	adapterTrigger := adapterattendant.NewAdapterTrigger(config.Loaded.AdapterAttendant)
-	restWebapp := restwebapp.NewWebApp(dbPersistence, adapterTrigger, deviceUpdates)
-	ingestWebapp := ingestwebapp.NewWebApp(dbPersistence, deviceUpdateChan)
+	adapterClient := adapters.NewAdapterClient(config.Loaded.AdapterRetry)
+	breakers := adapters.NewBreakers(config.Loaded.CircuitBreaker)
+	planner := schedule.NewPlanner(config.Loaded.Scheduler)
+	verifier := outcomes.NewVerifier(dbPersistence, config.Loaded.Outcomes)
+	go verifier.Run(context.Background(), deviceUpdates.Subscribe(context.Background()))
+	restWebapp := restwebapp.NewWebApp(dbPersistence, adapterTrigger, adapterClient, breakers, deviceUpdates, storeEvents, storeEventChan, triggerJobs, config.Loaded.Batch, planner, commands.NewQueue(config.Loaded.Commands), verifier)
+	if config.Loaded.Scheduler.Enabled {
+		go schedule.NewScheduler(dbPersistence, restWebapp, planner, config.Loaded.Scheduler).Run(context.Background())
+	}
+	if config.Loaded.Rules.Enabled {
+		go rules.NewEngine(dbPersistence, restWebapp, planner.Location(), config.Loaded.Rules).Run(context.Background(), deviceUpdates.Subscribe(context.Background()))
+	}
+	ingestWebapp := ingestwebapp.NewWebApp(dbPersistence, deviceUpdateChan, storeEventChan, intermediaries.DeletedIngestBehaviour(config.Loaded.SoftDelete.IngestBehaviour))
 
 	pubKey, err := middleware.LoadPublicKeyFromFile(config.Loaded.Auth.RSAPublicKeyPath)
 	if err != nil {
@@ -80,24 +120,84 @@ func main() {
 	huma.Get(publicAPI, "/device-store/v0/devices", restWebapp.GetDevices)
 	huma.Get(publicAPI, "/device-store/v0/devices/{storeDeviceIdentifier:[0-9]+}", restWebapp.GetDevice)
 	huma.Delete(publicAPI, "/device-store/v0/devices/{storeDeviceIdentifier:[0-9]+}", restWebapp.DeleteDevice)
-	huma.Post(publicAPI, "/device-store/v0/devices/{storeDeviceIdentifier:[0-9]+}/capabilities/{capabilityID}", restWebapp.TriggerDeviceCapability)
+	huma.Post(publicAPI, "/device-store/v0/devices/{storeDeviceIdentifier:[0-9]+}/restore", restWebapp.RestoreDevice)
+	huma.Post(publicAPI, "/device-store/v0/devices/{storeDeviceIdentifier:[0-9]+}/capabilities/{capabilityID}", restWebapp.TriggerDeviceCapability, deviceTriggerResponses)
+	huma.Get(publicAPI, "/device-store/v0/devices/{storeDeviceIdentifier:[0-9]+}/capabilities/{capabilityID}/schema", restWebapp.GetDeviceCapabilitySchema)
 
+	sseEvents := map[string]any{
+		"update": eventmodels.DeviceAttributeUpdate{},
+	}
+	for name, event := range eventmodels.StoreEvents {
+		sseEvents[name] = event
+	}
 	sse.Register(publicAPI, huma.Operation{
 		OperationID: "device_updates",
 		Method:      http.MethodGet,
 		Path:        "/device-store/v0/devices/events",
-		Summary:     "Server sent events for devices",
-	}, map[string]any{
-		"update": eventmodels.DeviceAttributeUpdate{},
-	}, restWebapp.StreamDeviceUpdates)
+		Summary:     "Server sent events for devices and groups",
+	}, sseEvents, restWebapp.StreamDeviceUpdates)
 
 	huma.Get(publicAPI, "/device-store/v0/audits/attributes", restWebapp.GetAttributeAudits)
 	huma.Get(publicAPI, "/device-store/v0/devices/{storeDeviceIdentifier:[0-9]+}/capability-trigger-audits", restWebapp.GetDeviceCapabilityTriggerAudits)
+	huma.Get(publicAPI, "/device-store/v0/admin/audit-retention", restWebapp.GetAuditRetentionStatus)
+	huma.Get(publicAPI, "/device-store/v0/admin/export", restWebapp.ExportArchive)
+	huma.Register(publicAPI, huma.Operation{
+		OperationID:  "import-archive",
+		Method:       http.MethodPost,
+		Path:         "/device-store/v0/admin/import",
+		Summary:      "Import an archive produced by export",
+		MaxBodyBytes: 1 << 30,
+		// The JSON content type is documented from the body of the handler, NDJSON archives are accepted as well
+		RequestBody: &huma.RequestBody{Content: map[string]*huma.MediaType{
+			archive.ContentType(archive.FormatNDJSON): {Schema: &huma.Schema{Type: "string", Format: "binary"}},
+		}},
+	}, restWebapp.ImportArchive)
+
+	huma.Get(publicAPI, "/device-store/v0/sync", restWebapp.Sync)
+	huma.Post(publicAPI, "/device-store/v0/triggers:batch", restWebapp.TriggerBatch)
+	huma.Get(publicAPI, "/device-store/v0/jobs/{jobID:[0-9]+}", restWebapp.GetTriggerJob)
+
+	huma.Get(publicAPI, "/device-store/v0/scenes", restWebapp.GetScenes)
+	huma.Post(publicAPI, "/device-store/v0/scenes", restWebapp.CreateScene, func(o *huma.Operation) {
+		o.DefaultStatus = http.StatusCreated
+	})
+	huma.Get(publicAPI, "/device-store/v0/scenes/{sceneID:[0-9]+}", restWebapp.GetScene)
+	huma.Put(publicAPI, "/device-store/v0/scenes/{sceneID:[0-9]+}", restWebapp.UpdateScene)
+	huma.Delete(publicAPI, "/device-store/v0/scenes/{sceneID:[0-9]+}", restWebapp.DeleteScene)
+	huma.Post(publicAPI, "/device-store/v0/scenes/{sceneID:[0-9]+}/activate", restWebapp.ActivateScene)
+	huma.Get(publicAPI, "/device-store/v0/scenes/{sceneID:[0-9]+}/activation-audits", restWebapp.GetSceneActivationAudits)
+
+	huma.Get(publicAPI, "/device-store/v0/schedules", restWebapp.GetSchedules)
+	huma.Post(publicAPI, "/device-store/v0/schedules", restWebapp.CreateSchedule, func(o *huma.Operation) {
+		o.DefaultStatus = http.StatusCreated
+	})
+	huma.Post(publicAPI, "/device-store/v0/schedules:preview", restWebapp.PreviewSchedule)
+	huma.Get(publicAPI, "/device-store/v0/schedules/{scheduleID:[0-9]+}", restWebapp.GetSchedule)
+	huma.Put(publicAPI, "/device-store/v0/schedules/{scheduleID:[0-9]+}", restWebapp.UpdateSchedule)
+	huma.Delete(publicAPI, "/device-store/v0/schedules/{scheduleID:[0-9]+}", restWebapp.DeleteSchedule)
+	huma.Get(publicAPI, "/device-store/v0/schedules/{scheduleID:[0-9]+}/next-runs", restWebapp.GetScheduleNextRuns)
+	huma.Get(publicAPI, "/device-store/v0/schedules/{scheduleID:[0-9]+}/runs", restWebapp.GetScheduleRuns)
+
+	huma.Get(publicAPI, "/device-store/v0/rules", restWebapp.GetRules)
+	huma.Post(publicAPI, "/device-store/v0/rules", restWebapp.CreateRule, func(o *huma.Operation) {
+		o.DefaultStatus = http.StatusCreated
+	})
+	huma.Get(publicAPI, "/device-store/v0/rules/{ruleID:[0-9]+}", restWebapp.GetRule)
+	huma.Put(publicAPI, "/device-store/v0/rules/{ruleID:[0-9]+}", restWebapp.UpdateRule)
+	huma.Delete(publicAPI, "/device-store/v0/rules/{ruleID:[0-9]+}", restWebapp.DeleteRule)
+	huma.Get(publicAPI, "/device-store/v0/rules/{ruleID:[0-9]+}/firings", restWebapp.GetRuleFirings)
+	huma.Get(publicAPI, "/device-store/v0/adapters", restWebapp.GetAdapters)
 
 	huma.Get(publicAPI, "/device-store/v0/groups", restWebapp.GetGroups)
+	huma.Post(publicAPI, "/device-store/v0/groups", restWebapp.CreateStoreGroup, func(o *huma.Operation) {
+		o.DefaultStatus = http.StatusCreated
+	})
+	huma.Put(publicAPI, "/device-store/v0/groups/{storeGroupIdentifier:[0-9]+}", restWebapp.UpdateStoreGroup)
 	huma.Get(publicAPI, "/device-store/v0/groups/{storeGroupIdentifier:[0-9]+}", restWebapp.GetGroup)
 	huma.Delete(publicAPI, "/device-store/v0/groups/{storeGroupIdentifier:[0-9]+}", restWebapp.DeleteGroup)
-	huma.Post(publicAPI, "/device-store/v0/groups/{storeGroupIdentifier:[0-9]+}/capabilities/{capabilityID}", restWebapp.TriggerGroupCapability)
+	huma.Post(publicAPI, "/device-store/v0/groups/{storeGroupIdentifier:[0-9]+}/restore", restWebapp.RestoreGroup)
+	huma.Post(publicAPI, "/device-store/v0/groups/{storeGroupIdentifier:[0-9]+}/capabilities/{capabilityID}", restWebapp.TriggerGroupCapability, groupTriggerResponses)
+	huma.Get(publicAPI, "/device-store/v0/groups/{storeGroupIdentifier:[0-9]+}/capabilities/{capabilityID}/schema", restWebapp.GetGroupCapabilitySchema)
 	huma.Get(publicAPI, "/device-store/v0/groups/{storeGroupIdentifier:[0-9]+}/capability-trigger-audits", restWebapp.GetGroupCapabilityTriggerAudits)
 
 	huma.Post(publicAPI, "/device-ingest/v0/devices", ingestWebapp.PostDevice)
@@ -108,8 +208,9 @@ func main() {
 	internalAPI := humamux.New(internalRouter, huma.DefaultConfig("device-store-internal", "1.0.0"))
 
 	huma.Get(internalAPI, "/device-store-internal/v0/devices/{storeDeviceIdentifier:[0-9]+}", restWebapp.GetDevice)
-	huma.Post(internalAPI, "/device-store-internal/v0/devices/{storeDeviceIdentifier:[0-9]+}/capabilities/{capabilityID}", restWebapp.TriggerDeviceCapability)
-	huma.Post(internalAPI, "/device-store-internal/v0/groups/{storeGroupIdentifier:[0-9]+}/capabilities/{capabilityID}", restWebapp.TriggerGroupCapability)
+	huma.Post(internalAPI, "/device-store-internal/v0/devices/{storeDeviceIdentifier:[0-9]+}/capabilities/{capabilityID}", restWebapp.TriggerDeviceCapability, deviceTriggerResponses)
+	huma.Post(internalAPI, "/device-store-internal/v0/groups/{storeGroupIdentifier:[0-9]+}/capabilities/{capabilityID}", restWebapp.TriggerGroupCapability, groupTriggerResponses)
+	huma.Get(internalAPI, "/device-store-internal/v0/jobs/{jobID:[0-9]+}", restWebapp.GetTriggerJob)
 
 	go func() {
 		if err := http.ListenAndServe(fmt.Sprintf(":%d", config.Loaded.InternalPort), internalRouter); err != nil {
@@ -122,3 +223,29 @@ func main() {
 		logging.Error(err.Error(), context.TODO())
 	}
 }
+
+// deviceTriggerResponses documents that device capability triggers respond with the job when run in the background
+// and without a body otherwise
+func deviceTriggerResponses(o *huma.Operation) {
+	o.DefaultStatus = http.StatusAccepted
+	if o.Responses == nil {
+		o.Responses = map[string]*huma.Response{}
+	}
+	o.Responses["204"] = &huma.Response{
+		Description: "The capability was triggered",
+		Headers: map[string]*huma.Header{
+			"Trigger-Outcome": {
+				Description: "whether the device reported the expected outcome of the capability, set when verifying the trigger",
+				Schema:      &huma.Schema{Type: huma.TypeString, Enum: []any{restmodels.TriggerOutcomeConfirmed, restmodels.TriggerOutcomeUnconfirmed}},
+			},
+		},
+	}
+}
+
+// groupTriggerResponses documents that group capability triggers respond with only the job when run in the background
+func groupTriggerResponses(o *huma.Operation) {
+	if o.Responses == nil {
+		o.Responses = map[string]*huma.Response{}
+	}
+	o.Responses["202"] = &huma.Response{Description: "The capability is triggered in the background, see the job"}
+}
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS auditPruneRuns (
+    id SERIAL PRIMARY KEY,
+    auditTable VARCHAR(255) NOT NULL,
+    started TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    finished TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    deletedRows BIGINT UNSIGNED NOT NULL DEFAULT 0,
+    errorMessage TEXT,
+    INDEX auditPruneRuns_table_finished (auditTable, finished)
+);
+
+CREATE INDEX IF NOT EXISTS deviceAttributeAudit_timestamp ON deviceAttributeAudit (timestamp);
+CREATE INDEX IF NOT EXISTS deviceCapabilityTriggerAudit_timestamp ON deviceCapabilityTriggerAudit (timestamp);
+CREATE INDEX IF NOT EXISTS groupCapabilityTriggerAudit_timestamp ON groupCapabilityTriggerAudit (timestamp);
@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS deviceAttributeAudit_device_name_timestamp ON deviceAttributeAudit (deviceId, name, timestamp);
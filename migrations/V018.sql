@@ -0,0 +1,32 @@
+CREATE TABLE IF NOT EXISTS tombstones (
+    id SERIAL PRIMARY KEY,
+    kind VARCHAR(16) NOT NULL,
+    objectId BIGINT UNSIGNED NOT NULL,
+    timestamp TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    INDEX tombstones_timestamp (timestamp)
+);
+
+CREATE TRIGGER devices_tombstone_delete
+AFTER DELETE ON devices
+FOR EACH ROW
+INSERT INTO tombstones (kind, objectId) VALUES ('device', OLD.id);
+
+CREATE TRIGGER groups_tombstone_delete
+AFTER DELETE ON groups
+FOR EACH ROW
+INSERT INTO tombstones (kind, objectId) VALUES ('group', OLD.id);
+
+CREATE INDEX IF NOT EXISTS devices_updated ON devices (updated);
+CREATE INDEX IF NOT EXISTS groups_updated ON groups (updated);
+
+ALTER TABLE auditPruneRuns ADD COLUMN cutoff TIMESTAMP NULL DEFAULT NULL;
+
+CREATE TRIGGER groupDevices_touch_device_insert
+AFTER INSERT ON groupDevices
+FOR EACH ROW
+UPDATE devices SET updated = CURRENT_TIMESTAMP WHERE id = NEW.deviceId;
+
+CREATE TRIGGER groupDevices_touch_device_delete
+AFTER DELETE ON groupDevices
+FOR EACH ROW
+UPDATE devices SET updated = CURRENT_TIMESTAMP WHERE id = OLD.deviceId;
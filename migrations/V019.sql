@@ -0,0 +1,4 @@
+ALTER TABLE devices ADD COLUMN deleted TIMESTAMP NULL DEFAULT NULL;
+ALTER TABLE groups ADD COLUMN deleted TIMESTAMP NULL DEFAULT NULL;
+CREATE INDEX IF NOT EXISTS devices_deleted ON devices (deleted);
+CREATE INDEX IF NOT EXISTS groups_deleted ON groups (deleted);
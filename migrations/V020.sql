@@ -0,0 +1 @@
+ALTER TABLE groups ADD COLUMN storeManaged BOOLEAN NOT NULL DEFAULT FALSE;
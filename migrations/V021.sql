@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS groupGroups (
+    id SERIAL PRIMARY KEY,
+    parentGroupId BIGINT UNSIGNED NOT NULL,
+    childGroupId BIGINT UNSIGNED NOT NULL,
+    FOREIGN KEY (parentGroupId) REFERENCES groups(id) ON DELETE CASCADE,
+    FOREIGN KEY (childGroupId) REFERENCES groups(id) ON DELETE CASCADE,
+    CONSTRAINT unique_group_in_group UNIQUE (parentGroupId, childGroupId),
+    INDEX groupGroups_child (childGroupId)
+);
+
+CREATE TRIGGER groupGroups_touch_group_insert
+AFTER INSERT ON groupGroups
+FOR EACH ROW
+UPDATE groups SET updated = CURRENT_TIMESTAMP WHERE id = NEW.parentGroupId;
+
+CREATE TRIGGER groupGroups_touch_group_delete
+AFTER DELETE ON groupGroups
+FOR EACH ROW
+UPDATE groups SET updated = CURRENT_TIMESTAMP WHERE id = OLD.parentGroupId;
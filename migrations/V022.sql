@@ -0,0 +1,2 @@
+ALTER TABLE deviceCapabilities ADD COLUMN argumentStandardSchema JSON;
+ALTER TABLE groupCapabilities ADD COLUMN argumentStandardSchema JSON;
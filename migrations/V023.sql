@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS triggerJobs (
+    id SERIAL PRIMARY KEY,
+    deviceId BIGINT UNSIGNED,
+    groupId BIGINT UNSIGNED,
+    name VARCHAR(255) NOT NULL,
+    arguments TEXT,
+    status ENUM('queued', 'running', 'succeeded', 'failed') NOT NULL DEFAULT 'queued',
+    errorMessage TEXT,
+    -- auditId is the row in deviceCapabilityTriggerAudit or groupCapabilityTriggerAudit recording the outcome
+    auditId BIGINT UNSIGNED,
+    groupResult JSON,
+    created TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    started TIMESTAMP NULL,
+    finished TIMESTAMP NULL,
+    FOREIGN KEY (deviceId) REFERENCES devices(id) ON DELETE CASCADE,
+    FOREIGN KEY (groupId) REFERENCES groups(id) ON DELETE CASCADE,
+    INDEX triggerJobs_status (status)
+);
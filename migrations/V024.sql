@@ -0,0 +1,15 @@
+-- Every attempt at sending a trigger to the adapter, retries included
+ALTER TABLE deviceCapabilityTriggerAudit ADD COLUMN attempts JSON;
+ALTER TABLE groupCapabilityTriggerAudit ADD COLUMN attempts JSON;
+
+CREATE TABLE IF NOT EXISTS triggerIdempotencyKeys (
+    id SERIAL PRIMARY KEY,
+    idempotencyKey VARCHAR(255) NOT NULL UNIQUE,
+    -- request is a hash of the request the key was first used for
+    request CHAR(64) NOT NULL,
+    -- status and response are NULL until the first request has finished
+    status INT,
+    response JSON,
+    timestamp TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    INDEX triggerIdempotencyKeys_timestamp (timestamp)
+);
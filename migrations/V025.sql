@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS scenes (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    -- items is the list of capability invocations, validated against the capabilities when the scene was saved
+    items JSON NOT NULL,
+    created TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS sceneActivationAudit (
+    id SERIAL PRIMARY KEY,
+    sceneId BIGINT UNSIGNED NOT NULL,
+    timestamp TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    succeeded INT NOT NULL,
+    failed INT NOT NULL,
+    results JSON NOT NULL,
+    FOREIGN KEY (sceneId) REFERENCES scenes(id) ON DELETE CASCADE,
+    INDEX sceneActivationAudit_timestamp (timestamp)
+);
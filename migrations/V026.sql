@@ -0,0 +1,26 @@
+CREATE TABLE IF NOT EXISTS schedules (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    enabled BOOLEAN NOT NULL,
+    -- spec is when to run what, validated when the schedule was saved
+    spec JSON NOT NULL,
+    -- nextRun is NULL when the schedule will not run again. Replicas claim a run by moving it forward.
+    nextRun TIMESTAMP NULL,
+    lastRun TIMESTAMP NULL,
+    created TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    INDEX schedules_due (enabled, nextRun)
+);
+
+CREATE TABLE IF NOT EXISTS scheduleRuns (
+    id SERIAL PRIMARY KEY,
+    scheduleId BIGINT UNSIGNED NOT NULL,
+    scheduledFor TIMESTAMP NOT NULL,
+    timestamp TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    status VARCHAR(16) NOT NULL,
+    errorMessage TEXT NULL,
+    result JSON NULL,
+    sceneActivationId BIGINT UNSIGNED NULL,
+    FOREIGN KEY (scheduleId) REFERENCES schedules(id) ON DELETE CASCADE,
+    INDEX scheduleRuns_timestamp (timestamp)
+);
@@ -0,0 +1,38 @@
+CREATE TABLE IF NOT EXISTS rules (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    enabled BOOLEAN NOT NULL,
+    -- spec holds the conditions, windows and what to run, validated when the rule was saved
+    spec JSON NOT NULL,
+    satisfiedSince TIMESTAMP NULL,
+    fired BOOLEAN NOT NULL DEFAULT FALSE,
+    lastFired TIMESTAMP NULL,
+    -- version is incremented on every change, replicas only change the state of a rule they have seen
+    version INT NOT NULL DEFAULT 0,
+    created TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    INDEX rules_pending (enabled, fired, satisfiedSince)
+);
+
+-- ruleDevices lists the devices the conditions of a rule refer to, to find the rules to evaluate on an update.
+-- Devices are not referenced by a foreign key, conditions on deleted devices simply do not hold.
+CREATE TABLE IF NOT EXISTS ruleDevices (
+    ruleId BIGINT UNSIGNED NOT NULL,
+    deviceId BIGINT UNSIGNED NOT NULL,
+    PRIMARY KEY (ruleId, deviceId),
+    FOREIGN KEY (ruleId) REFERENCES rules(id) ON DELETE CASCADE,
+    INDEX ruleDevices_deviceId (deviceId)
+);
+
+CREATE TABLE IF NOT EXISTS ruleFirings (
+    id SERIAL PRIMARY KEY,
+    ruleId BIGINT UNSIGNED NOT NULL,
+    timestamp TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    status VARCHAR(16) NOT NULL,
+    errorMessage TEXT NULL,
+    observed JSON NOT NULL,
+    result JSON NULL,
+    sceneActivationId BIGINT UNSIGNED NULL,
+    FOREIGN KEY (ruleId) REFERENCES rules(id) ON DELETE CASCADE,
+    INDEX ruleFirings_timestamp (timestamp)
+);
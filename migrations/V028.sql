@@ -0,0 +1,4 @@
+-- The attribute states a device reports once a capability took effect, as declared by the adapter
+ALTER TABLE deviceCapabilities ADD COLUMN expectedOutcome JSON;
+-- outcome is NULL for capabilities declaring no expected outcome, and pending until the trigger is verified
+ALTER TABLE deviceCapabilityTriggerAudit ADD COLUMN outcome VARCHAR(16);
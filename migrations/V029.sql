@@ -0,0 +1,3 @@
+-- leaseExpires is renewed by the replica running a job for as long as it runs. Unfinished jobs whose lease expired
+-- belong to a replica that stopped, and NULL for jobs created before leases.
+ALTER TABLE triggerJobs ADD COLUMN leaseExpires TIMESTAMP NULL;
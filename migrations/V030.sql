@@ -0,0 +1,5 @@
+-- created is when the store first saw a device. Devices created before the column existed get their oldest attribute
+-- audit row, or NULL if there is none, as they may have existed at any point before.
+ALTER TABLE devices ADD COLUMN created TIMESTAMP NULL DEFAULT NULL;
+UPDATE devices SET created = (SELECT MIN(timestamp) FROM deviceAttributeAudit WHERE deviceAttributeAudit.deviceId = devices.id);
+ALTER TABLE devices MODIFY COLUMN created TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP;
@@ -0,0 +1,2 @@
+-- Finished trigger jobs are pruned by the age of their finished timestamp
+CREATE INDEX IF NOT EXISTS triggerJobs_finished ON triggerJobs (finished);
@@ -0,0 +1,24 @@
+package restmodels
+
+import "time"
+
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half-open"
+)
+
+// AdapterHealth is the state of the circuit breaker of an adapter, tracked from the outcome of capability triggers
+type AdapterHealth struct {
+	AdapterID int    `json:"adapter-id"`
+	State     string `json:"state" enum:"closed,open,half-open" doc:"open breakers fail triggers fast, half-open breakers let a single probing trigger through"`
+	// RecentTriggers is the number of triggers the failure rate is calculated over
+	RecentTriggers int        `json:"recent-triggers"`
+	RecentFailures int        `json:"recent-failures"`
+	FailureRate    float64    `json:"failure-rate"`
+	LastFailure    *time.Time `json:"last-failure,omitempty"`
+	LastError      *string    `json:"last-error,omitempty"`
+	OpenedAt       *time.Time `json:"opened-at,omitempty"`
+	// RetryAt is when an open breaker lets a probing trigger through
+	RetryAt *time.Time `json:"retry-at,omitempty"`
+}
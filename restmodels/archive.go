@@ -0,0 +1,90 @@
+package restmodels
+
+import "time"
+
+// ArchiveVersion is the version of the archive format produced by exports.
+// Imports accept archives of this version or older.
+const ArchiveVersion = 1
+
+type ArchiveHeader struct {
+	Version  int       `json:"version"`
+	Exported time.Time `json:"exported"`
+}
+
+type ArchiveDevice struct {
+	// ID is the ID of the device in the exporting store. It is only used to
+	// resolve references within the archive and is remapped on import.
+	ID               int                `json:"id"`
+	BridgeIdentifier string             `json:"bridge-identifier"`
+	AdapterId        int                `json:"adapter-id"`
+	Updated          time.Time          `json:"updated"`
+	Attributes       []Attribute        `json:"attributes"`
+	Capabilities     []DeviceCapability `json:"capabilities"`
+	// Deleted is set for devices soft deleted but not yet purged when exported
+	Deleted *time.Time `json:"deleted,omitempty"`
+}
+
+type ArchiveGroup struct {
+	// ID is the ID of the group in the exporting store. It is only used to
+	// resolve references within the archive and is remapped on import.
+	ID               int               `json:"id"`
+	Name             string            `json:"name"`
+	AdapterId        int               `json:"adapter-id"`
+	BridgeIdentifier string            `json:"bridge-identifier"`
+	Capabilities     []GroupCapability `json:"capabilities"`
+	DeviceIds        []int             `json:"device-ids"`
+	StoreManaged     bool              `json:"store-managed,omitempty"`
+	GroupIds         []int             `json:"group-ids,omitempty"`
+	// Deleted is set for groups soft deleted but not yet purged when exported
+	Deleted *time.Time `json:"deleted,omitempty"`
+}
+
+type ArchiveAudits struct {
+	Attributes               []AttributeAudit              `json:"attributes"`
+	DeviceCapabilityTriggers []CapabilityTriggerAudit      `json:"device-capability-triggers"`
+	GroupCapabilityTriggers  []GroupCapabilityTriggerAudit `json:"group-capability-triggers"`
+}
+
+// Archive is a complete copy of the device store
+type Archive struct {
+	ArchiveHeader
+	Devices []ArchiveDevice `json:"devices"`
+	Groups  []ArchiveGroup  `json:"groups"`
+	Audits  *ArchiveAudits  `json:"audits,omitempty"`
+}
+
+// ArchiveRecord is a single line of an NDJSON archive. The first record is always
+// the header, and exactly one of the other fields is set depending on Kind.
+type ArchiveRecord struct {
+	Kind                         string                       `json:"kind" enum:"header,device,group,attribute-audit,device-capability-trigger-audit,group-capability-trigger-audit"`
+	Header                       *ArchiveHeader               `json:"header,omitempty"`
+	Device                       *ArchiveDevice               `json:"device,omitempty"`
+	Group                        *ArchiveGroup                `json:"group,omitempty"`
+	AttributeAudit               *AttributeAudit              `json:"attribute-audit,omitempty"`
+	DeviceCapabilityTriggerAudit *CapabilityTriggerAudit      `json:"device-capability-trigger-audit,omitempty"`
+	GroupCapabilityTriggerAudit  *GroupCapabilityTriggerAudit `json:"group-capability-trigger-audit,omitempty"`
+}
+
+type ArchiveIdMapping struct {
+	ArchiveID int `json:"archive-id"`
+	StoreID   int `json:"store-id"`
+}
+
+// ImportReport describes what an import did, or would have done in the case of a dry run
+type ImportReport struct {
+	DryRun                        bool               `json:"dry-run"`
+	DevicesCreated                int                `json:"devices-created"`
+	DevicesUpdated                int                `json:"devices-updated"`
+	GroupsCreated                 int                `json:"groups-created"`
+	GroupsUpdated                 int                `json:"groups-updated"`
+	Attributes                    int                `json:"attributes"`
+	DeviceCapabilities            int                `json:"device-capabilities"`
+	GroupCapabilities             int                `json:"group-capabilities"`
+	Memberships                   int                `json:"memberships"`
+	AttributeAudits               int                `json:"attribute-audits"`
+	DeviceCapabilityTriggerAudits int                `json:"device-capability-trigger-audits"`
+	GroupCapabilityTriggerAudits  int                `json:"group-capability-trigger-audits"`
+	DeviceIds                     []ArchiveIdMapping `json:"device-ids"`
+	GroupIds                      []ArchiveIdMapping `json:"group-ids"`
+	Warnings                      []string           `json:"warnings"`
+}
@@ -8,6 +8,8 @@ type Attribute struct {
 	Numeric *float32 `json:"numeric-state,omitempty"`
 	Text    *string  `json:"string-state,omitempty"`
 	Updated time.Time `json:"updated"`
+	// Unknown is set when reconstructing historical state and there was no recorded value at that time
+	Unknown bool `json:"unknown,omitempty"`
 }
 
 // func exclusiveNil(pointer1, pointer2 interface{}) bool {
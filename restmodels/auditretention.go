@@ -0,0 +1,23 @@
+package restmodels
+
+import "time"
+
+type AuditPruneRun struct {
+	Table       string    `json:"table"`
+	Started     time.Time `json:"started"`
+	Finished    time.Time `json:"finished"`
+	DeletedRows int64     `json:"deleted-rows"`
+	// Cutoff is the timestamp before which rows were pruned by age, if age based pruning is enabled
+	Cutoff       *time.Time `json:"cutoff,omitempty"`
+	ErrorMessage *string    `json:"error-message,omitempty"`
+}
+
+type AuditTableStatus struct {
+	Table string `json:"table"`
+	// Rows is the exact number of rows currently in the table
+	Rows int64 `json:"rows"`
+	// Bytes is the estimated size of the table, including indexes, as reported by the database
+	Bytes           int64          `json:"bytes"`
+	OldestTimestamp *time.Time     `json:"oldest-timestamp,omitempty"`
+	LastPruneRun    *AuditPruneRun `json:"last-prune-run,omitempty"`
+}
@@ -0,0 +1,10 @@
+package restmodels
+
+type BatchTriggerRequest struct {
+	Items []CapabilityInvocation `json:"items" minItems:"1"`
+}
+
+// BatchTriggerResult lists the outcome of every item of a batch, in the order of the items
+type BatchTriggerResult struct {
+	Items []CapabilityInvocationResult `json:"items"`
+}
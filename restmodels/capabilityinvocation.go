@@ -0,0 +1,32 @@
+package restmodels
+
+import "github.com/danielgtaylor/huma/v2"
+
+// CapabilityInvocation is a capability to trigger on a device or a group, as part of a batch or a scene.
+// Exactly one of DeviceID and GroupID is set.
+type CapabilityInvocation struct {
+	DeviceID   *int                 `json:"device-id,omitempty"`
+	GroupID    *int                 `json:"group-id,omitempty"`
+	Capability string               `json:"capability" minLength:"1"`
+	FanOut     bool                 `json:"fan-out,omitempty" doc:"trigger the capability on each member of a group not having it itself, like the fan-out query parameter"`
+	Arguments  DeviceCapabilityArgs `json:"arguments,omitempty"`
+}
+
+func (item CapabilityInvocation) Resolve(ctx huma.Context, prefix *huma.PathBuffer) []error {
+	if (item.DeviceID == nil) == (item.GroupID == nil) {
+		return []error{&huma.ErrorDetail{
+			Message:  "exactly one of device-id and group-id must be set",
+			Location: prefix.String(),
+			Value:    item,
+		}}
+	}
+	return nil
+}
+
+// CapabilityInvocationResult is the outcome of a capability invocation, as it would have been responded with
+// had the capability been triggered on its own
+type CapabilityInvocationResult struct {
+	Status      int                 `json:"status"`
+	Error       *huma.ErrorModel    `json:"error,omitempty"`
+	GroupResult *GroupTriggerResult `json:"group-result,omitempty" doc:"the outcome for every member when a group trigger was fanned out"`
+}
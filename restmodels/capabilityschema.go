@@ -16,11 +16,54 @@ type TextArgumentSpec struct {
 	Max     *int    `json:"max,omitempty"`
 }
 
+// EnumArgumentSpec is a text argument limited to a set of values
+type EnumArgumentSpec struct {
+	Values  []string `json:"values" minItems:"1"`
+	Default *string  `json:"default,omitempty"`
+}
+
+// IntegerArgumentSpec is a whole number argument, optionally limited to multiples of Step
+type IntegerArgumentSpec struct {
+	Min     *int `json:"min,omitempty"`
+	Max     *int `json:"max,omitempty"`
+	Step    *int `json:"step,omitempty" minimum:"1"`
+	Default *int `json:"default,omitempty"`
+}
+
+// ColorArgumentSpec is a color argument written as a hex string, "#rrggbb"
+type ColorArgumentSpec struct {
+	Default *string `json:"default,omitempty" pattern:"^#[0-9a-fA-F]{6}$"`
+}
+
+// DurationArgumentSpec is a duration argument, such as a transition time, in milliseconds
+type DurationArgumentSpec struct {
+	Min     *int `json:"min,omitempty" minimum:"0"`
+	Max     *int `json:"max,omitempty"`
+	Default *int `json:"default,omitempty" minimum:"0"`
+}
+
+// ArrayArgumentSpec is a list argument whose items are all of the same kind
+type ArrayArgumentSpec struct {
+	Items    ArgumentKind `json:"items"`
+	MinItems *int         `json:"min-items,omitempty" minimum:"0"`
+	MaxItems *int         `json:"max-items,omitempty" minimum:"0"`
+}
+
+// ArgumentKind describes the kind of value of an argument. Exactly one of the kinds is set.
+type ArgumentKind struct {
+	Boolean  *BooleanArgumentSpec  `json:"boolean,omitempty"`
+	Numeric  *NumericArgumentSpec  `json:"numeric,omitempty"`
+	Text     *TextArgumentSpec     `json:"text,omitempty"`
+	Enum     *EnumArgumentSpec     `json:"enum,omitempty"`
+	Integer  *IntegerArgumentSpec  `json:"integer,omitempty"`
+	Color    *ColorArgumentSpec    `json:"color,omitempty"`
+	Duration *DurationArgumentSpec `json:"duration,omitempty"`
+	Array    *ArrayArgumentSpec    `json:"array,omitempty"`
+}
+
 type ArgumentSpec struct {
-	Name    string               `json:"name"`
-	Boolean *BooleanArgumentSpec `json:"boolean,omitempty"`
-	Numeric *NumericArgumentSpec `json:"numeric,omitempty"`
-	Text    *TextArgumentSpec    `json:"text,omitempty"`
+	Name string `json:"name"`
+	ArgumentKind
 }
 
 // func (i ArgumentSpec) Resolve(ctx huma.Context, prefix *huma.PathBuffer) []error {
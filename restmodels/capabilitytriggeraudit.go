@@ -2,6 +2,12 @@ package restmodels
 
 import "time"
 
+const (
+	TriggerOutcomePending     = "pending"
+	TriggerOutcomeConfirmed   = "confirmed"
+	TriggerOutcomeUnconfirmed = "unconfirmed"
+)
+
 type CapabilityTriggerAudit struct {
 	ID           int       `json:"id"`
 	DeviceID     int       `json:"device-id"`
@@ -10,4 +16,17 @@ type CapabilityTriggerAudit struct {
 	ErrorMessage *string   `json:"error-message,omitempty"`
 	Timestamp    time.Time `json:"timestamp"`
 	Arguments    string    `json:"arguments"`
+	// Attempts lists every time the trigger was sent to the adapter, retries included
+	Attempts []TriggerAttempt `json:"attempts,omitempty"`
+	// Outcome is whether the device reported the expected outcome of the capability, unset if it declares none
+	Outcome *string `json:"outcome,omitempty" enum:"pending,confirmed,unconfirmed" doc:"whether the device reported the expected outcome of the capability in time, unset if the capability declares none"`
+}
+
+// TriggerAttempt is one attempt at sending a capability trigger to an adapter
+type TriggerAttempt struct {
+	Attempt int       `json:"attempt"`
+	Started time.Time `json:"started"`
+	// StatusCode is the status the adapter responded with, if it responded at all
+	StatusCode   *int    `json:"status-code,omitempty"`
+	ErrorMessage *string `json:"error-message,omitempty"`
 }
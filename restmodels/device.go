@@ -10,4 +10,5 @@ type Device struct {
 	Attributes       []Attribute        `json:"attributes"`
 	Capabilities     []DeviceCapability `json:"capabilities"`
 	GroupIds         []int              `json:"group-ids"`
+	Deleted          *time.Time         `json:"deleted,omitempty"`
 }
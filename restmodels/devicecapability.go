@@ -5,7 +5,23 @@ import "time"
 type DeviceCapability struct {
 	Name          string         `json:"name"`
 	ArgumentSpecs []ArgumentSpec `json:"argument-specs"`
-	Updated       time.Time      `json:"updated"`
+	// ArgumentJSONSchema is the JSON Schema supplied by the adapter, if any
+	ArgumentJSONSchema map[string]any `json:"argument-json-schema,omitempty"`
+	// ExpectedOutcome is what the adapter declared the device reports once the capability took effect, if anything
+	ExpectedOutcome []ExpectedAttribute `json:"expected-outcome,omitempty"`
+	Updated         time.Time           `json:"updated"`
 }
 
 type DeviceCapabilityArgs map[string]any
+
+// ExpectedAttribute is an attribute state a device reports once a capability took effect. Exactly one of the states
+// and Argument is set.
+type ExpectedAttribute struct {
+	Name    string   `json:"name"`
+	Boolean *bool    `json:"boolean-state,omitempty"`
+	Numeric *float32 `json:"numeric-state,omitempty"`
+	Text    *string  `json:"string-state,omitempty"`
+	// Argument names the argument of the trigger whose value the attribute takes
+	Argument  *string `json:"argument,omitempty"`
+	Tolerance float32 `json:"tolerance,omitempty" doc:"how far a numeric state may be from the expected one"`
+}
@@ -9,5 +9,14 @@ type Group struct {
 	BridgeIdentifier string            `json:"bridge-identifier"`
 	Updated          time.Time         `json:"updated"`
 	Capabilities     []GroupCapability `json:"capabilities"`
-	DeviceIds        []int             `json:"device-ids"`
+	// Attributes are aggregated from the attributes of the member devices
+	Attributes []GroupAttribute `json:"attributes"`
+	DeviceIds  []int            `json:"device-ids"`
+	// GroupIds are the groups contained in this group
+	GroupIds []int `json:"group-ids"`
+	// EffectiveDeviceIds are the devices of this group and of all groups contained in it
+	EffectiveDeviceIds []int      `json:"effective-device-ids"`
+	Deleted            *time.Time `json:"deleted,omitempty"`
+	// StoreManaged groups are created through the store, not by an adapter, and have adapter ID 0
+	StoreManaged bool `json:"store-managed"`
 }
@@ -0,0 +1,22 @@
+package restmodels
+
+import "time"
+
+// GroupAttribute is an attribute aggregated over the member devices of a group having it.
+// Which aggregates are set depends on the kind of values the members report.
+type GroupAttribute struct {
+	Name string `json:"name"`
+	// Members is the number of member devices having the attribute
+	Members int `json:"members"`
+	// Any and All are set for boolean attributes
+	Any *bool `json:"any,omitempty"`
+	All *bool `json:"all,omitempty"`
+	// Min, Max and Avg are set for numeric attributes
+	Min *float32 `json:"min,omitempty"`
+	Max *float32 `json:"max,omitempty"`
+	Avg *float32 `json:"avg,omitempty"`
+	// Majority is the most common value of text attributes. Ties go to the lexically smallest value.
+	Majority *string `json:"majority,omitempty"`
+	// Updated is when the attribute was last updated on any member
+	Updated time.Time `json:"updated"`
+}
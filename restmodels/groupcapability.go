@@ -5,7 +5,9 @@ import "time"
 type GroupCapability struct {
 	Name          string         `json:"name"`
 	ArgumentSpecs []ArgumentSpec `json:"argument-specs"`
-	Updated       time.Time      `json:"updated"`
+	// ArgumentJSONSchema is the JSON Schema supplied by the adapter, if any
+	ArgumentJSONSchema map[string]any `json:"argument-json-schema,omitempty"`
+	Updated            time.Time      `json:"updated"`
 }
 
 type GroupCapabilityArgs map[string]any
@@ -10,4 +10,7 @@ type GroupCapabilityTriggerAudit struct {
 	ErrorMessage *string   `json:"error-message,omitempty"`
 	Timestamp    time.Time `json:"timestamp"`
 	Arguments    string    `json:"arguments"`
+	// Attempts lists every time the trigger was sent to the adapter, retries included.
+	// Triggers fanned out over the members are audited on the members instead.
+	Attempts []TriggerAttempt `json:"attempts,omitempty"`
 }
@@ -0,0 +1,115 @@
+package restmodels
+
+import (
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+)
+
+const (
+	RuleFiringSucceeded = "succeeded"
+	RuleFiringFailed    = "failed"
+)
+
+// RuleCondition holds when an attribute of a device compares to exactly one of the boolean, numeric and string
+// states. Only numeric states may be compared with lt, le, gt and ge. An attribute the device does not have never
+// holds.
+type RuleCondition struct {
+	DeviceID  int      `json:"device-id"`
+	Attribute string   `json:"attribute" minLength:"1"`
+	Operator  string   `json:"op" enum:"eq,ne,lt,le,gt,ge"`
+	Boolean   *bool    `json:"boolean-state,omitempty"`
+	Numeric   *float32 `json:"numeric-state,omitempty"`
+	Text      *string  `json:"string-state,omitempty"`
+}
+
+func (condition RuleCondition) Resolve(ctx huma.Context, prefix *huma.PathBuffer) []error {
+	states := 0
+	for _, set := range []bool{condition.Boolean != nil, condition.Numeric != nil, condition.Text != nil} {
+		if set {
+			states++
+		}
+	}
+	if states != 1 {
+		return []error{&huma.ErrorDetail{
+			Message:  "exactly one of boolean-state, numeric-state and string-state must be set",
+			Location: prefix.String(),
+			Value:    condition,
+		}}
+	}
+	if condition.Numeric == nil && condition.Operator != "eq" && condition.Operator != "ne" {
+		return []error{&huma.ErrorDetail{
+			Message:  "only numeric states may be compared with " + condition.Operator,
+			Location: prefix.With("op"),
+			Value:    condition.Operator,
+		}}
+	}
+	return nil
+}
+
+// RuleTimeWindow is a time of day, in the time zone of the store, during which a rule may fire. A window ending
+// before it starts continues past midnight, and belongs to the day it starts.
+type RuleTimeWindow struct {
+	Start string   `json:"start" pattern:"^([01][0-9]|2[0-3]):[0-5][0-9]$" doc:"HH:MM"`
+	End   string   `json:"end" pattern:"^([01][0-9]|2[0-3]):[0-5][0-9]$" doc:"HH:MM, the same as start for the whole day"`
+	Days  []string `json:"days,omitempty" enum:"mon,tue,wed,thu,fri,sat,sun" doc:"days the window applies to, every day if left out"`
+}
+
+// RuleSpec is what to run when the conditions of a rule become true, as created or updated through the store.
+// Exactly one of Invocation and SceneID says what.
+type RuleSpec struct {
+	Name       string                `json:"name" minLength:"1" maxLength:"255"`
+	Enabled    *bool                 `json:"enabled,omitempty" default:"true"`
+	Conditions []RuleCondition       `json:"conditions" minItems:"1" doc:"the rule fires when all conditions become true"`
+	ForSeconds int                   `json:"for-seconds,omitempty" minimum:"0" doc:"how long the conditions must stay true before the rule fires"`
+	Windows    []RuleTimeWindow      `json:"windows,omitempty" doc:"the rule only fires within one of these windows. Conditions becoming true outside of them do not fire the rule later."`
+	Invocation *CapabilityInvocation `json:"invocation,omitempty" doc:"capability to trigger"`
+	SceneID    *int                  `json:"scene-id,omitempty" doc:"scene to activate"`
+}
+
+// IsEnabled reports whether the rule is evaluated, which it is unless disabled
+func (spec RuleSpec) IsEnabled() bool {
+	return spec.Enabled == nil || *spec.Enabled
+}
+
+func (spec RuleSpec) Resolve(ctx huma.Context, prefix *huma.PathBuffer) []error {
+	if (spec.Invocation == nil) == (spec.SceneID == nil) {
+		return []error{&huma.ErrorDetail{
+			Message:  "exactly one of invocation and scene-id must be set",
+			Location: prefix.String(),
+			Value:    spec,
+		}}
+	}
+	return nil
+}
+
+type Rule struct {
+	ID int `json:"id"`
+	RuleSpec
+	SatisfiedSince *time.Time `json:"satisfied-since" doc:"since when all conditions are true, null if they are not"`
+	Fired          bool       `json:"fired" doc:"whether the rule fired, or was outside of its windows, since its conditions became true"`
+	LastFired      *time.Time `json:"last-fired"`
+	Created        time.Time  `json:"created"`
+	Updated        time.Time  `json:"updated"`
+	// Version is incremented on every change, so that replicas only change the state of a rule they have seen
+	Version int `json:"-"`
+}
+
+// RuleObservation is the state of an attribute of a condition when a rule fired
+type RuleObservation struct {
+	DeviceID  int       `json:"device-id"`
+	Attribute Attribute `json:"attribute"`
+}
+
+// RuleFiring is the audit of a rule firing. A firing triggering a capability records the outcome of the trigger,
+// and a firing activating a scene refers to the activation audit of the scene.
+type RuleFiring struct {
+	ID                int                         `json:"id"`
+	RuleID            int                         `json:"rule-id"`
+	Timestamp         time.Time                   `json:"timestamp"`
+	Status            string                      `json:"status" enum:"succeeded,failed"`
+	ErrorMessage      *string                     `json:"error-message,omitempty"`
+	Observed          []RuleObservation           `json:"observed"`
+	Result            *CapabilityInvocationResult `json:"result,omitempty"`
+	SceneActivationID *int                        `json:"scene-activation-id,omitempty"`
+}
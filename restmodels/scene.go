@@ -0,0 +1,29 @@
+package restmodels
+
+import "time"
+
+// SceneSpec is a named list of capabilities to trigger together, as created or updated through the store.
+// Every item is validated against the current capabilities of its device or group when saved.
+type SceneSpec struct {
+	Name  string                 `json:"name" minLength:"1" maxLength:"255"`
+	Items []CapabilityInvocation `json:"items" minItems:"1"`
+}
+
+type Scene struct {
+	ID      int                    `json:"id"`
+	Name    string                 `json:"name"`
+	Items   []CapabilityInvocation `json:"items"`
+	Created time.Time              `json:"created"`
+	Updated time.Time              `json:"updated"`
+}
+
+// SceneActivation is the audit of activating a scene, listing the outcome of every item of the scene in order.
+// Every item is audited as a capability trigger of its device or group as well.
+type SceneActivation struct {
+	ID        int                          `json:"id"`
+	SceneID   int                          `json:"scene-id"`
+	Timestamp time.Time                    `json:"timestamp"`
+	Succeeded int                          `json:"succeeded"`
+	Failed    int                          `json:"failed"`
+	Items     []CapabilityInvocationResult `json:"items"`
+}
@@ -0,0 +1,85 @@
+package restmodels
+
+import (
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+)
+
+const (
+	ScheduleRunSucceeded = "succeeded"
+	ScheduleRunFailed    = "failed"
+	// ScheduleRunMissed is a run that was skipped because it was due longer ago than the misfire grace,
+	// for example because the store was down
+	ScheduleRunMissed = "missed"
+)
+
+// SunSchedule runs at sunrise or sunset every day, computed from the coordinates the store is configured with
+type SunSchedule struct {
+	Event         string `json:"event" enum:"sunrise,sunset"`
+	OffsetMinutes int    `json:"offset-minutes,omitempty" minimum:"-720" maximum:"720" doc:"minutes before (negative) or after the event to run"`
+}
+
+// ScheduleSpec is when to run what, as created or updated through the store. Exactly one of Cron, At and Sun
+// says when, and exactly one of Invocation and SceneID says what.
+type ScheduleSpec struct {
+	Name       string                `json:"name" minLength:"1" maxLength:"255"`
+	Enabled    *bool                 `json:"enabled,omitempty" default:"true"`
+	Cron       *string               `json:"cron,omitempty" doc:"five field cron expression: minute, hour, day of month, month and day of week, evaluated in the time zone of the store"`
+	At         *time.Time            `json:"at,omitempty" doc:"run once at this time"`
+	Sun        *SunSchedule          `json:"sun,omitempty"`
+	Invocation *CapabilityInvocation `json:"invocation,omitempty" doc:"capability to trigger"`
+	SceneID    *int                  `json:"scene-id,omitempty" doc:"scene to activate"`
+}
+
+// IsEnabled reports whether the schedule runs, which it does unless disabled
+func (spec ScheduleSpec) IsEnabled() bool {
+	return spec.Enabled == nil || *spec.Enabled
+}
+
+func (spec ScheduleSpec) Resolve(ctx huma.Context, prefix *huma.PathBuffer) []error {
+	errs := []error{}
+	when := 0
+	for _, set := range []bool{spec.Cron != nil, spec.At != nil, spec.Sun != nil} {
+		if set {
+			when++
+		}
+	}
+	if when != 1 {
+		errs = append(errs, &huma.ErrorDetail{
+			Message:  "exactly one of cron, at and sun must be set",
+			Location: prefix.String(),
+			Value:    spec,
+		})
+	}
+	if (spec.Invocation == nil) == (spec.SceneID == nil) {
+		errs = append(errs, &huma.ErrorDetail{
+			Message:  "exactly one of invocation and scene-id must be set",
+			Location: prefix.String(),
+			Value:    spec,
+		})
+	}
+	return errs
+}
+
+type Schedule struct {
+	ID int `json:"id"`
+	ScheduleSpec
+	NextRun *time.Time `json:"next-run" doc:"when the schedule runs next, null if it will not run again"`
+	LastRun *time.Time `json:"last-run" doc:"when the schedule was last due, null if it never was"`
+	Created time.Time  `json:"created"`
+	Updated time.Time  `json:"updated"`
+}
+
+// ScheduleRun is the history of a schedule being due. A run triggering a capability records the outcome of the
+// trigger, and a run activating a scene refers to the activation audit of the scene.
+type ScheduleRun struct {
+	ID                int                         `json:"id"`
+	ScheduleID        int                         `json:"schedule-id"`
+	ScheduledFor      time.Time                   `json:"scheduled-for"`
+	Timestamp         time.Time                   `json:"timestamp"`
+	Status            string                      `json:"status" enum:"succeeded,failed,missed"`
+	ErrorMessage      *string                     `json:"error-message,omitempty"`
+	Result            *CapabilityInvocationResult `json:"result,omitempty"`
+	SceneActivationID *int                        `json:"scene-activation-id,omitempty"`
+}
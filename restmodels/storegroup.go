@@ -0,0 +1,28 @@
+package restmodels
+
+// StoreGroup is a group created through the store rather than by an adapter. Its capabilities
+// are the ones of its member devices, and triggering one triggers it on every member having it.
+// Store groups may contain other groups, whose members are then members of the store group as well.
+type StoreGroup struct {
+	Name      string `json:"name" minLength:"1"`
+	DeviceIds []int  `json:"device-ids"`
+	GroupIds  []int  `json:"group-ids,omitempty"`
+}
+
+// GroupMemberTriggerResult is the outcome of triggering a capability on one member of a group.
+// Members are devices, or child groups having the capability themselves.
+type GroupMemberTriggerResult struct {
+	DeviceID     int     `json:"device-id,omitempty"`
+	GroupID      int     `json:"group-id,omitempty"`
+	Success      bool    `json:"success"`
+	ErrorMessage *string `json:"error-message,omitempty"`
+}
+
+// GroupTriggerResult is the outcome of triggering a group capability. When the capability was triggered
+// on each member device rather than on the group itself, the outcome for every member is listed.
+// When the trigger runs in the background only Job is set.
+type GroupTriggerResult struct {
+	FannedOut bool                       `json:"fanned-out"`
+	Members   []GroupMemberTriggerResult `json:"members,omitempty"`
+	Job       *TriggerJob                `json:"job,omitempty"`
+}
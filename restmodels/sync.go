@@ -0,0 +1,20 @@
+package restmodels
+
+import "time"
+
+type Tombstone struct {
+	ID      int       `json:"id"`
+	Deleted time.Time `json:"deleted"`
+}
+
+type SyncResponse struct {
+	// Full is set when the response contains the entire store rather than changes since a token,
+	// in which case the client should discard any local copy
+	Full           bool        `json:"full"`
+	Devices        []Device    `json:"devices"`
+	Groups         []Group     `json:"groups"`
+	DeletedDevices []Tombstone `json:"deleted-devices"`
+	DeletedGroups  []Tombstone `json:"deleted-groups"`
+	// Token is passed as since in the next sync request to continue from this one
+	Token string `json:"token"`
+}
@@ -0,0 +1,26 @@
+package restmodels
+
+import "time"
+
+const (
+	TriggerJobQueued    = "queued"
+	TriggerJobRunning   = "running"
+	TriggerJobSucceeded = "succeeded"
+	TriggerJobFailed    = "failed"
+)
+
+// TriggerJob is a capability trigger running in the background. Exactly one of DeviceID and GroupID is set.
+type TriggerJob struct {
+	ID           int                  `json:"id"`
+	DeviceID     *int                 `json:"device-id,omitempty"`
+	GroupID      *int                 `json:"group-id,omitempty"`
+	Name         string               `json:"name" doc:"the capability triggered"`
+	Arguments    DeviceCapabilityArgs `json:"arguments"`
+	Status       string               `json:"status" enum:"queued,running,succeeded,failed"`
+	ErrorMessage *string              `json:"error-message,omitempty"`
+	AuditID      *int                 `json:"audit-id,omitempty" doc:"the capability trigger audit of the device or group recording the outcome"`
+	GroupResult  *GroupTriggerResult  `json:"group-result,omitempty" doc:"the outcome for every member when a group trigger was fanned out"`
+	Created      time.Time            `json:"created"`
+	Started      *time.Time           `json:"started,omitempty"`
+	Finished     *time.Time           `json:"finished,omitempty"`
+}